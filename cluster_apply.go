@@ -0,0 +1,405 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dchest/uniuri"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdApplyCluster = &cobra.Command{
+		Short: "Create or update a cluster from a spec file",
+		Long:  "Create or update a cluster such that it matches the given spec file. Instances are created or destroyed as needed.",
+		Use:   "apply",
+		Run:   applyCluster,
+	}
+
+	applyClusterFlags struct {
+		SpecPath string
+	}
+)
+
+func init() {
+	cmdApplyCluster.Flags().StringVarP(&applyClusterFlags.SpecPath, "file", "s", "", "Path of the cluster spec file (YAML or JSON)")
+	addDryRunFlag(cmdApplyCluster)
+	addKeepOnFailureFlag(cmdApplyCluster)
+	addConfirmClusterFlag(cmdApplyCluster)
+	cmdCluster.AddCommand(cmdApplyCluster)
+}
+
+func applyCluster(cmd *cobra.Command, args []string) {
+	if applyClusterFlags.SpecPath == "" {
+		Exitf("Please specify a spec file\n")
+	}
+	spec, err := loadClusterSpec(applyClusterFlags.SpecPath)
+	if err != nil {
+		Exitf("Cannot load spec: %v\n", err)
+	}
+
+	// The spec determines the provider, unless overridden on the commandline
+	if provider == "" {
+		provider = spec.Provider
+	} else if spec.Provider != "" && spec.Provider != provider {
+		Exitf("Provider '%s' conflicts with provider '%s' in spec\n", provider, spec.Provider)
+	}
+	provider := newProvider(providers.CapabilityCluster)
+
+	options := provider.CreateClusterDefaults(spec.CreateClusterOptions())
+	if options.ID == "" {
+		options.ID = strings.ToLower(uniuri.NewLen(40))
+	} else {
+		options.ID = strings.ToLower(options.ID)
+	}
+	if err := options.Validate(); err != nil {
+		Exitf("Invalid spec: %s\n", err.Error())
+	}
+
+	// Compare spec with the existing instances
+	instances, err := provider.GetInstances(options.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to query existing instances: %v\n", err)
+	}
+	ctx, tracker := newContext()
+	state, err := newClusterApplyState(ctx, provider, spec, instances)
+	if err != nil {
+		Exitf("Failed to compare cluster with spec: %v\n", err)
+	}
+	if state.IsEmpty() {
+		Infof("Cluster %s matches the spec, nothing to do.\n", options.ClusterInfo)
+		return
+	}
+
+	// Show plan & confirm
+	if dryRun {
+		plan, err := applyClusterPlan(provider, spec, options, instances, state)
+		if err != nil {
+			Exitf("Failed to create plan: %v\n", err)
+		}
+		printPlan(plan)
+		return
+	}
+	Infof("Plan for %s:\n", options.ClusterInfo)
+	for _, g := range state.Groups {
+		if g.Missing > 0 {
+			Infof("  + create %d instances in group '%s' (%s)\n", g.Missing, g.Group.Name, g.Config)
+		}
+	}
+	for _, v := range state.Victims {
+		Infof("  - destroy instance %s (%s)\n", v.Name, state.Reasons[v.Name])
+	}
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to apply this plan to %s?", options.ClusterInfo), options.ClusterInfo); err != nil {
+		Exitf("%v\n", err)
+	}
+
+	// Create the cluster with the first group
+	if len(instances) == 0 {
+		first := state.Groups[0]
+		clusterOptions := options
+		clusterOptions.InstanceCount = first.Missing
+		if err := createNewCluster(ctx, provider, clusterOptions); err != nil {
+			rollbackOrReport(tracker)
+			Exitf("Failed to create new cluster: %v\n", err)
+		}
+		state.Groups[0].Missing = 0
+		Infof("Cluster created with ID: %s\n", options.ID)
+		if instances, err = provider.GetInstances(options.ClusterInfo); err != nil {
+			Exitf("Failed to query existing instances: %v\n", err)
+		}
+	}
+
+	// Add missing instances, before removing any instance
+	for _, g := range state.Groups {
+		if g.Missing == 0 {
+			continue
+		}
+		instanceOptions, err := groupInstanceOptions(provider, options, g)
+		if err != nil {
+			Exitf("Failed to create instance options: %v\n", err)
+		}
+		created, err := growCluster(ctx, provider, instanceOptions, instances, g.Missing)
+		if err != nil {
+			removed, _ := rollbackOrReport(tracker)
+			updateMembersAfterRollback(provider, options.ClusterInfo, removed)
+			Exitf("Failed to create new instances: %v\n", err)
+		}
+		for _, i := range created {
+			Infof("Created instance %s in group '%s'\n", i.Name, g.Group.Name)
+		}
+		instances = append(instances, created...)
+	}
+
+	// Remove instances
+	if err := shrinkCluster(ctx, provider, options.ClusterInfo, state.Victims, false); err != nil {
+		Exitf("Failed to destroy instance: %v\n", err)
+	}
+	for _, v := range state.Victims {
+		Infof("Destroyed instance %s\n", v.Name)
+	}
+}
+
+// clusterApplyState is the result of comparing the existing instances of a cluster with a spec.
+type clusterApplyState struct {
+	Groups  []instanceGroupState
+	Victims providers.ClusterInstanceList // Instances to destroy
+	Reasons map[string]string             // Reason for destroying each victim, keyed by instance name
+}
+
+// instanceGroupState holds the existing instances that match an instance group of a spec.
+type instanceGroupState struct {
+	Group   InstanceGroupSpec
+	Config  providers.InstanceConfig      // Configuration of the group, including provider defaults
+	Members providers.ClusterInstanceList // Existing instances that match the roles & configuration of the group
+	Missing int                           // Number of instances to create
+}
+
+// IsEmpty returns true if there are no instances to create or destroy.
+func (s clusterApplyState) IsEmpty() bool {
+	for _, g := range s.Groups {
+		if g.Missing > 0 {
+			return false
+		}
+	}
+	return len(s.Victims) == 0
+}
+
+// addVictim adds the given instance to the instances to destroy.
+func (s *clusterApplyState) addVictim(i providers.ClusterInstance, reason string) {
+	s.Victims = append(s.Victims, i)
+	s.Reasons[i.Name] = reason
+}
+
+// applyInstance holds the roles & configuration of an existing instance.
+type applyInstance struct {
+	Instance  providers.ClusterInstance
+	Core      bool
+	LB        bool
+	EtcdProxy bool
+	RegionID  string
+}
+
+// matchesRoles returns true if the instance has exactly the roles of the given group.
+func (a applyInstance) matchesRoles(g InstanceGroupSpec) bool {
+	return a.Core == g.HasRole(roleCore) && a.LB == g.HasRole(roleLB) && a.EtcdProxy == g.HasRole(roleEtcdProxy)
+}
+
+// drift returns a description of the differences between the configuration of the instance and the given one.
+// Type & image are only compared when the provider reports them. It returns an empty string when there are no differences.
+func (a applyInstance) drift(config providers.InstanceConfig) string {
+	diffs := []string{}
+	if config.RegionID != "" && a.RegionID != config.RegionID {
+		diffs = append(diffs, fmt.Sprintf("region %s, expected %s", a.RegionID, config.RegionID))
+	}
+	if config.TypeID != "" && a.Instance.TypeID != "" && a.Instance.TypeID != config.TypeID {
+		diffs = append(diffs, fmt.Sprintf("type %s, expected %s", a.Instance.TypeID, config.TypeID))
+	}
+	if config.ImageID != "" && a.Instance.ImageID != "" && a.Instance.ImageID != config.ImageID {
+		diffs = append(diffs, fmt.Sprintf("image %s, expected %s", a.Instance.ImageID, config.ImageID))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// newClusterApplyState compares the given instances with the instance groups of the given spec.
+// Instances are matched with a group by their fleet metadata (core & lb roles), their etcd proxy setting
+// and their region, type & image. Instances that have the roles of a group but a different configuration
+// are replaced, surplus instances of a group are destroyed newest first, but never the last load-balancer instance.
+func newClusterApplyState(ctx context.Context, provider providers.CloudProvider, spec ClusterSpec, instances providers.ClusterInstanceList) (clusterApplyState, error) {
+	state := clusterApplyState{Reasons: make(map[string]string)}
+	for _, g := range spec.InstanceGroups {
+		state.Groups = append(state.Groups, instanceGroupState{
+			Group:  g,
+			Config: groupInstanceConfig(provider, spec, g),
+		})
+	}
+	existing, err := newApplyInstances(ctx, instances)
+	if err != nil {
+		return state, maskAny(err)
+	}
+
+	// Assign instances that match both roles & configuration first
+	var unassigned []applyInstance
+	for _, a := range existing {
+		assigned := false
+		for idx, g := range state.Groups {
+			if a.matchesRoles(g.Group) && a.drift(g.Config) == "" {
+				state.Groups[idx].Members = append(g.Members, a.Instance)
+				assigned = true
+				break
+			}
+		}
+		if !assigned {
+			unassigned = append(unassigned, a)
+		}
+	}
+	for _, a := range unassigned {
+		reason := "its roles match no instance group"
+		for _, g := range state.Groups {
+			if a.matchesRoles(g.Group) {
+				reason = fmt.Sprintf("%s in group '%s'", a.drift(g.Config), g.Group.Name)
+				break
+			}
+		}
+		state.addVictim(a.Instance, reason)
+	}
+
+	// Remove surplus instances & count missing instances
+	for idx, g := range state.Groups {
+		surplus := len(g.Members) - g.Group.Count
+		if surplus > 0 {
+			victims := g.Members
+			if surplus < len(g.Members) {
+				if victims, err = selectScaleVictims(ctx, g.Members, surplus); err != nil {
+					return state, maskAny(err)
+				}
+			}
+			for _, v := range victims {
+				state.addVictim(v, fmt.Sprintf("surplus instance in group '%s'", g.Group.Name))
+				g.Members = removeFromList(g.Members, v.Name)
+			}
+		}
+		g.Missing = g.Group.Count - len(g.Members)
+		state.Groups[idx] = g
+	}
+	return state, nil
+}
+
+// newApplyInstances fetches the roles & region of all given instances from fleet.
+func newApplyInstances(ctx context.Context, instances providers.ClusterInstanceList) ([]applyInstance, error) {
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	machines, err := listFleetMachines(ctx, instances)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	sorted := append(providers.ClusterInstanceList{}, instances...)
+	sort.Sort(sortByName(sorted))
+	result := []applyInstance{}
+	for _, i := range sorted {
+		machineID, err := i.GetMachineID(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		m, found := providers.FindFleetMachine(machines, machineID)
+		if !found {
+			return nil, maskAny(fmt.Errorf("cannot determine the roles of %s, is it registered in fleet?", i.Name))
+		}
+		etcdProxy, err := i.IsEtcdProxy(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, applyInstance{
+			Instance:  i,
+			Core:      m.HasMetadata("core", "true"),
+			LB:        m.HasMetadata("lb", "true"),
+			EtcdProxy: etcdProxy,
+			RegionID:  m.Metadata["region"],
+		})
+	}
+	return result, nil
+}
+
+// groupInstanceOptions creates the options for adding instances of the given group to the cluster.
+func groupInstanceOptions(provider providers.CloudProvider, options providers.CreateClusterOptions, g instanceGroupState) (providers.CreateInstanceOptions, error) {
+	instanceOptions, err := options.NewCreateInstanceOptions(g.Group.HasRole(roleCore), g.Group.HasRole(roleLB), 1)
+	if err != nil {
+		return providers.CreateInstanceOptions{}, maskAny(err)
+	}
+	instanceOptions.InstanceConfig = g.Config
+	instanceOptions.MinOSVersion = options.MinOSVersion
+	instanceOptions.EtcdProxy = g.Group.HasRole(roleEtcdProxy)
+	return provider.CreateInstanceDefaults(instanceOptions), nil
+}
+
+// applyClusterPlan builds the plan for bringing the given instances in line with the given spec.
+func applyClusterPlan(provider providers.CloudProvider, spec ClusterSpec, options providers.CreateClusterOptions, instances providers.ClusterInstanceList, state clusterApplyState) (providers.Plan, error) {
+	plan := providers.Plan{}
+	groups := append([]instanceGroupState{}, state.Groups...)
+	if len(instances) == 0 {
+		clusterOptions := options
+		clusterOptions.InstanceCount = groups[0].Missing
+		created, err := createClusterPlan(&plan, clusterOptions)
+		if err != nil {
+			return plan, maskAny(err)
+		}
+		instances = created
+		groups[0].Missing = 0
+	}
+	missing := 0
+	for _, g := range groups {
+		missing += g.Missing
+	}
+	indexes := instances.FreeInstanceIndexes(missing)
+	for _, g := range groups {
+		if g.Missing == 0 {
+			continue
+		}
+		instanceOptions, err := groupInstanceOptions(provider, options, g)
+		if err != nil {
+			return plan, maskAny(err)
+		}
+		for n := 0; n < g.Missing; n++ {
+			o := instanceOptions
+			o.SetupIndex(indexes[0])
+			o.SetupNames("", options.Name, options.Domain)
+			indexes = indexes[1:]
+			addInstancePlan(&plan, o, instances)
+			instances = append(instances, providers.ClusterInstance{Name: o.InstanceName})
+		}
+	}
+	for _, v := range state.Victims {
+		info := providers.ClusterInstanceInfo{
+			ClusterInfo: options.ClusterInfo,
+			Prefix:      strings.SplitN(v.Name, ".", 2)[0],
+		}
+		if err := removeInstancePlan(&plan, info, instances); err != nil {
+			return plan, maskAny(err)
+		}
+		instances = removeFromList(instances, v.Name)
+	}
+	return plan, nil
+}
+
+// removeFromList returns a copy of the given list without the instance with given name.
+func removeFromList(instances providers.ClusterInstanceList, name string) providers.ClusterInstanceList {
+	result := providers.ClusterInstanceList{}
+	for _, i := range instances {
+		if i.Name != name {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// groupInstanceConfig returns the instance configuration for the given group, including provider defaults.
+func groupInstanceConfig(provider providers.CloudProvider, spec ClusterSpec, group InstanceGroupSpec) providers.InstanceConfig {
+	options := providers.CreateInstanceOptions{
+		InstanceConfig: group.InstanceConfig(spec.MinOSVersion),
+	}
+	return provider.CreateInstanceDefaults(options).InstanceConfig
+}
+
+type sortByName providers.ClusterInstanceList
+
+func (l sortByName) Len() int           { return len(l) }
+func (l sortByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l sortByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdCopyCluster = &cobra.Command{
+		Short: "Copy files to or from the instances of a cluster",
+		Long: "Copy files to or from all (or a selection of) instances of a cluster in parallel. " +
+			"The remote side is written as <name.domain>:<path>. " +
+			"Files copied from the instances are stored in a sub-directory of the destination per instance.",
+		Use: "cp",
+		Run: copyCluster,
+	}
+
+	copyClusterFlags struct {
+		providers.CopyOptions
+		Roles    []string
+		Prefixes []string
+	}
+)
+
+func init() {
+	cmdCopyCluster.Flags().BoolVarP(&copyClusterFlags.Recursive, "recursive", "r", false, "Copy directories recursively")
+	cmdCopyCluster.Flags().BoolVar(&copyClusterFlags.Preserve, "preserve", false, "Preserve modification times and ownership")
+	cmdCopyCluster.Flags().BoolVar(&copyClusterFlags.Sudo, "sudo", false, "Access the files on the instances as root")
+	cmdCopyCluster.Flags().StringSliceVar(&copyClusterFlags.Roles, "role", nil, fmt.Sprintf("Only copy to/from instances with one of these roles (%s|%s|%s)", roleCore, roleLB, roleEtcdProxy))
+	cmdCopyCluster.Flags().StringSliceVar(&copyClusterFlags.Prefixes, "prefix", nil, "Only copy to/from instances with one of these prefixes")
+	cmdCluster.AddCommand(cmdCopyCluster)
+}
+
+func copyCluster(cmd *cobra.Command, args []string) {
+	host, localPath, remotePath, upload := parseCopyArgs(args)
+	if !strings.Contains(host, ".") {
+		Exitf("Please specify a cluster as <name.domain>:<path>\n")
+	}
+	var info providers.ClusterInfo
+	clusterInfoFromArgs(&info, []string{host})
+
+	provider := newProvider()
+	info = provider.ClusterDefaults(info)
+	instances, err := provider.GetInstances(info)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	ctx, _ := newContext()
+	selected, err := selectInstances(ctx, instances, copyClusterFlags.Roles, copyClusterFlags.Prefixes)
+	if err != nil {
+		Exitf("Failed to select instances: %v\n", err)
+	}
+	if len(selected) == 0 {
+		Exitf("No instances selected\n")
+	}
+
+	var results []providers.CopyResult
+	if upload {
+		results = selected.CopyTo(ctx, log, localPath, remotePath, copyClusterFlags.CopyOptions)
+	} else {
+		results = selected.CopyFrom(ctx, log, remotePath, localPath, copyClusterFlags.CopyOptions)
+	}
+	failed := []string{}
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.Instance.Name, r.Err))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Copy failed on %d of %d instances:\n  %s\n", len(failed), len(results), strings.Join(failed, "\n  "))
+		os.Exit(1)
+	}
+	Infof("Copy succeeded on %d instances\n", len(results))
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/dchest/uniuri"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 )
@@ -51,13 +52,15 @@ func init() {
 	cmdCreateCluster.Flags().StringVar(&createClusterFlags.SSHKeyGithubAccount, "ssh-key-github-account", defaultSshKeyGithubAccount(), "Github account name used to fetch SSH keys (to add to instances)")
 	cmdCreateCluster.Flags().StringVar(&createClusterFlags.VaultAddress, "vault-addr", defaultVaultAddr(), "URL of the vault used in this cluster")
 	cmdCreateCluster.Flags().StringVar(&createClusterFlags.VaultCertificatePath, "vault-cacert", defaultVaultCACert(), "Path of the CA certificate of the vault used in this cluster")
+	addDryRunFlag(cmdCreateCluster)
+	addKeepOnFailureFlag(cmdCreateCluster)
 	cmdCluster.AddCommand(cmdCreateCluster)
 }
 
 func createCluster(cmd *cobra.Command, args []string) {
 	clusterInfoFromArgs(&createClusterFlags.ClusterInfo, args)
 
-	provider := newProvider()
+	provider := newProvider(providers.CapabilityCluster)
 	createClusterFlags = provider.CreateClusterDefaults(createClusterFlags)
 
 	// Create cluster ID if needed
@@ -81,22 +84,80 @@ func createCluster(cmd *cobra.Command, args []string) {
 		Exitf("Cluster %s.%s already exists.\n", createClusterFlags.Name, createClusterFlags.Domain)
 	}
 
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		if _, err := createClusterPlan(&plan, createClusterFlags); err != nil {
+			Exitf("Failed to create plan: %v\n", err)
+		}
+		printPlan(plan)
+		return
+	}
+
 	// Confirm
 	if err := confirm(fmt.Sprintf("Are you sure you want to create a %d instance cluster of %s?", createClusterFlags.InstanceCount, createClusterFlags.InstanceConfig)); err != nil {
 		Exitf("%v\n", err)
 	}
 
-	// Create
-	err = provider.CreateCluster(log, createClusterFlags, newDnsProvider())
-	if err != nil {
+	// Create (instance names are fixed up front, so the operation can be resumed)
+	createClusterFlags.SetupInstancePrefixes()
+	op := newOperation(providers.OperationCreateCluster)
+	op.Cluster = &createClusterFlags
+	if err := op.Save(); err != nil {
+		Exitf("Failed to save operation journal: %v\n", err)
+	}
+	ctx, tracker := newContext()
+	ctx = providers.WithOperation(ctx, op)
+	if err := createNewCluster(ctx, provider, createClusterFlags); err != nil {
+		failOperation(op, provider, tracker, err)
 		Exitf("Failed to create new cluster: %v\n", err)
 	}
+	finishOperation(op)
+
+	Infof("Cluster created with ID: %s\n", createClusterFlags.ID)
+}
+
+// createNewCluster creates all instances of a new cluster and updates the cluster members on all of them.
+func createNewCluster(ctx context.Context, provider providers.CloudProvider, options providers.CreateClusterOptions) error {
+	clusterName := options.ClusterInfo.String()
+
+	// Host keys pinned for an earlier cluster with the same name are no longer valid
+	if err := providers.RunStep(ctx, log, clusterName, "forget-host-keys", func() error {
+		return maskAny(knownHosts.ForgetCluster(options.ClusterInfo))
+	}); err != nil {
+		return maskAny(err)
+	}
+	if err := provider.CreateCluster(ctx, log, options, newDnsProvider()); err != nil {
+		return maskAny(err)
+	}
 
-	// Update all members
-	reboot := true
-	if err := providers.UpdateClusterMembers(log, createClusterFlags.ClusterInfo, reboot, nil, provider); err != nil {
-		Exitf("Failed to update cluster members: %v\n", err)
+	// Update all members, then reboot them a few at a time to start etcd & fleet
+	if err := providers.RunStep(ctx, log, clusterName, "update-members", func() error {
+		return maskAny(providers.UpdateClusterMembers(ctx, log, options.ClusterInfo, providers.RebootBootstrap, nil, provider))
+	}); err != nil {
+		return maskAny(err)
 	}
+	return nil
+}
 
-	Infof("Cluster created with ID: %s\n", createClusterFlags.ID)
+// createClusterPlan adds all actions needed to create a cluster with given options to the given plan.
+// It returns the planned instances.
+func createClusterPlan(plan *providers.Plan, options providers.CreateClusterOptions) (providers.ClusterInstanceList, error) {
+	instances := providers.ClusterInstanceList{}
+	for i := 1; i <= options.InstanceCount; i++ {
+		isCore := true
+		isLB := true
+		instanceOptions, err := options.NewCreateInstanceOptions(isCore, isLB, i)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		iso := providers.InitialSetupOptions{
+			FleetMetadata: instanceOptions.CreateFleetMetadata(i),
+		}
+		plan.AddCreateInstance(instanceOptions, iso)
+		plan.Add(providers.PlanKindEtcd, instanceOptions.InstanceName, "Join as initial member")
+		instances = append(instances, providers.ClusterInstance{Name: instanceOptions.InstanceName})
+	}
+	plan.AddUpdateClusterMembers(instances, providers.RebootBootstrap)
+	return instances, nil
 }
@@ -36,13 +36,15 @@ var (
 func init() {
 	cmdDestroyCluster.Flags().StringVar(&destroyClusterFlags.Domain, "domain", defaultDomain(), "Cluster domain")
 	cmdDestroyCluster.Flags().StringVar(&destroyClusterFlags.Name, "name", "", "Cluster name")
+	addDryRunFlag(cmdDestroyCluster)
+	addConfirmClusterFlag(cmdDestroyCluster)
 	cmdCluster.AddCommand(cmdDestroyCluster)
 }
 
 func destroyCluster(cmd *cobra.Command, args []string) {
 	clusterInfoFromArgs(&destroyClusterFlags, args)
 
-	provider := newProvider()
+	provider := newProvider(providers.CapabilityCluster)
 	destroyClusterFlags = provider.ClusterDefaults(destroyClusterFlags)
 
 	if destroyClusterFlags.Domain == "" {
@@ -51,11 +53,29 @@ func destroyCluster(cmd *cobra.Command, args []string) {
 	if destroyClusterFlags.Name == "" {
 		Exitf("Please specify a name\n")
 	}
-	if err := confirm(fmt.Sprintf("Are you sure you want to destroy %s?", destroyClusterFlags.String())); err != nil {
+	if dryRun {
+		instances, err := provider.GetInstances(destroyClusterFlags)
+		if err != nil {
+			Exitf("Failed to query existing instances: %v\n", err)
+		}
+		plan := providers.Plan{}
+		for _, i := range instances {
+			plan.AddDeleteInstance(i)
+		}
+		printPlan(plan)
+		return
+	}
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to destroy %s?", destroyClusterFlags.String()), destroyClusterFlags); err != nil {
 		Exitf("%v\n", err)
 	}
-	err := provider.DeleteCluster(destroyClusterFlags, newDnsProvider())
+	instances, err := provider.GetInstances(destroyClusterFlags)
 	if err != nil {
+		Exitf("Failed to query existing instances: %v\n", err)
+	}
+	ctx, _ := newContext()
+	if err := provider.DeleteCluster(ctx, destroyClusterFlags, newDnsProvider()); err != nil {
+		reportRemainingInstances(provider, destroyClusterFlags)
 		Exitf("Failed to destroy cluster: %v\n", err)
 	}
+	forgetHostKeys(destroyClusterFlags, instances)
 }
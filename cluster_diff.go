@@ -0,0 +1,176 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterDiff = &cobra.Command{
+		Use:   "diff",
+		Short: "Show where the files & DNS records of a cluster differ from what quark would generate",
+		Run:   showClusterDiff,
+	}
+
+	clusterDiffFlags clusterDiffOptions
+)
+
+// clusterDiffOptions selects a cluster and overrides the cluster wide settings that are found on most of its instances.
+type clusterDiffOptions struct {
+	providers.ClusterInfo
+	VaultAddress         string
+	VaultCertificatePath string
+}
+
+func init() {
+	addClusterDiffFlags(cmdClusterDiff)
+	cmdCluster.AddCommand(cmdClusterDiff)
+}
+
+// addClusterDiffFlags adds the flags of the diff & repair commands to the given command.
+func addClusterDiffFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&clusterDiffFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmd.Flags().StringVar(&clusterDiffFlags.Name, "name", "", "Cluster name")
+	cmd.Flags().StringVar(&clusterDiffFlags.ID, "cluster-id", "", "Cluster ID (default the ID found on most instances)")
+	cmd.Flags().StringVar(&clusterDiffFlags.VaultAddress, "vault-addr", defaultVaultAddr(), "URL of the vault used in this cluster (default the address found on most instances)")
+	cmd.Flags().StringVar(&clusterDiffFlags.VaultCertificatePath, "vault-cacert", defaultVaultCACert(), "Path of the CA certificate of the vault used in this cluster (default the certificate found on most instances)")
+}
+
+func showClusterDiff(cmd *cobra.Command, args []string) {
+	instances := clusterDiffInstances(args)
+	ctx, _ := newContext()
+	drift, err := diffCluster(ctx, instances, clusterDiffFlags)
+	if err != nil {
+		Exitf("Failed to compare cluster: %v\n", err)
+	}
+
+	printDrift(drift.Drifts)
+	if len(drift.Drifts) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d differences, use `quark cluster repair` to fix them\n", len(drift.Drifts))
+		os.Exit(1)
+	}
+	Infof("No differences found\n")
+}
+
+// clusterDiffInstances loads the instances of the cluster selected with the diff flags & args.
+func clusterDiffInstances(args []string) providers.ClusterInstanceList {
+	clusterInfoFromArgs(&clusterDiffFlags.ClusterInfo, args)
+
+	provider := newProvider()
+	clusterDiffFlags.ClusterInfo = provider.ClusterDefaults(clusterDiffFlags.ClusterInfo)
+
+	if clusterDiffFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	instances, err := provider.GetInstances(clusterDiffFlags.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", clusterDiffFlags.ClusterInfo)
+	}
+	return instances
+}
+
+// clusterDrift is the result of comparing a cluster with the state quark would generate for it.
+type clusterDrift struct {
+	State          providers.ClusterState
+	IsLoadBalancer func(providers.ClusterInstance) bool
+	Drifts         []providers.Drift
+}
+
+// diffCluster compares the files & DNS records of the cluster formed by the given instances with those
+// quark would generate for it.
+func diffCluster(ctx context.Context, instances providers.ClusterInstanceList, options clusterDiffOptions) (clusterDrift, error) {
+	state, err := instances.DesiredClusterState(ctx, log)
+	if err != nil {
+		return clusterDrift{}, maskAny(err)
+	}
+	if options.ID != "" {
+		state.ClusterID = options.ID
+	}
+	if options.VaultAddress != "" {
+		state.VaultAddress = options.VaultAddress
+	}
+	if options.VaultCertificatePath != "" {
+		raw, err := ioutil.ReadFile(options.VaultCertificatePath)
+		if err != nil {
+			return clusterDrift{}, maskAny(err)
+		}
+		state.VaultCertificate = string(raw)
+	}
+
+	// Load-balancers are registered under the cluster name in DNS
+	docs, err := fetchInstanceDocuments(ctx, instances)
+	if err != nil {
+		return clusterDrift{}, maskAny(err)
+	}
+	lb := make(map[string]bool)
+	for _, d := range docs {
+		if len(d.Roles) == 0 {
+			return clusterDrift{}, maskAny(fmt.Errorf("cannot determine the roles of %s, is it registered in fleet?", d.Name))
+		}
+		lb[d.Name] = d.HasRole("lb")
+	}
+	isLoadBalancer := func(i providers.ClusterInstance) bool { return lb[i.Name] }
+
+	fileDrifts, err := instances.DiffFiles(ctx, log, state)
+	if err != nil {
+		return clusterDrift{}, maskAny(err)
+	}
+	dnsDrifts, err := providers.DiffDnsRecords(newDnsProvider(), options.ClusterInfo, instances, isLoadBalancer)
+	if err != nil {
+		return clusterDrift{}, maskAny(err)
+	}
+	return clusterDrift{
+		State:          state,
+		IsLoadBalancer: isLoadBalancer,
+		Drifts:         append(fileDrifts, dnsDrifts...),
+	}, nil
+}
+
+// printDrift prints the given drifts.
+func printDrift(drifts []providers.Drift) {
+	docs := []driftDocument{}
+	for _, d := range drifts {
+		docs = append(docs, driftDocument{Kind: d.Kind, Target: d.Target, Item: d.Item, Difference: d.Description()})
+	}
+	printOutput(docs, func() []string {
+		lines := []string{"Kind | Target | Item | Difference"}
+		for _, d := range docs {
+			// The pipe separates columns
+			difference := strings.Replace(d.Difference, "|", "/", -1)
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s", d.Kind, d.Target, d.Item, difference))
+		}
+		return lines
+	})
+}
+
+// driftDocument is the machine readable representation of a difference between the desired & actual state of a cluster.
+type driftDocument struct {
+	Kind       string `json:"kind" yaml:"kind"`
+	Target     string `json:"target" yaml:"target"`
+	Item       string `json:"item" yaml:"item"`
+	Difference string `json:"difference" yaml:"difference"`
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdExecCluster = &cobra.Command{
+		Short: "Execute a command on the instances of a cluster",
+		Long:  "Execute the command given after -- on all (or a selection of) instances of a cluster in parallel",
+		Use:   "exec",
+		Run:   execCluster,
+	}
+
+	execClusterFlags struct {
+		providers.ClusterInfo
+		Roles    []string
+		Prefixes []string
+	}
+)
+
+func init() {
+	cmdExecCluster.Flags().StringVar(&execClusterFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdExecCluster.Flags().StringVar(&execClusterFlags.Name, "name", "", "Cluster name")
+	cmdExecCluster.Flags().StringSliceVar(&execClusterFlags.Roles, "role", nil, fmt.Sprintf("Only execute on instances with one of these roles (%s|%s|%s)", roleCore, roleLB, roleEtcdProxy))
+	cmdExecCluster.Flags().StringSliceVar(&execClusterFlags.Prefixes, "prefix", nil, "Only execute on instances with one of these prefixes")
+	cmdCluster.AddCommand(cmdExecCluster)
+}
+
+// execDocument is the machine readable result of a command executed on a single instance.
+type execDocument struct {
+	Name       string `json:"name" yaml:"name"`
+	Stdout     string `json:"stdout" yaml:"stdout"`
+	Stderr     string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	ExitStatus int    `json:"exit-status" yaml:"exit-status"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func execCluster(cmd *cobra.Command, args []string) {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 || dash == len(args) {
+		Exitf("Please specify a command after --\n")
+	}
+	command := strings.Join(args[dash:], " ")
+	clusterInfoFromArgs(&execClusterFlags.ClusterInfo, args[:dash])
+
+	provider := newProvider()
+	execClusterFlags.ClusterInfo = provider.ClusterDefaults(execClusterFlags.ClusterInfo)
+
+	if execClusterFlags.Domain == "" {
+		Exitf("Please specify a domain\n")
+	}
+	if execClusterFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	instances, err := provider.GetInstances(execClusterFlags.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	ctx, _ := newContext()
+	selected, err := selectInstances(ctx, instances, execClusterFlags.Roles, execClusterFlags.Prefixes)
+	if err != nil {
+		Exitf("Failed to select instances: %v\n", err)
+	}
+	if len(selected) == 0 {
+		Exitf("No instances selected\n")
+	}
+
+	results := selected.Exec(ctx, log, command)
+	docs := []execDocument{}
+	failed := []string{}
+	for _, r := range results {
+		doc := execDocument{Name: r.Instance.Name, Stdout: r.Stdout, Stderr: r.Stderr}
+		if r.Err != nil {
+			doc.Error = r.Err.Error()
+			doc.ExitStatus = -1
+			if cmdErr, ok := errgo.Cause(r.Err).(*providers.RemoteCommandError); ok {
+				doc.ExitStatus = cmdErr.ExitStatus
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Instance.Name, doc.Error))
+		}
+		docs = append(docs, doc)
+	}
+
+	if isMachineOutput() {
+		printOutput(docs, nil)
+	} else {
+		for _, d := range docs {
+			printPrefixed(d.Name, d.Stdout)
+			printPrefixed(d.Name, d.Stderr)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Command failed on %d of %d instances:\n  %s\n", len(failed), len(docs), strings.Join(failed, "\n  "))
+		os.Exit(1)
+	}
+	Infof("Command succeeded on %d instances\n", len(docs))
+}
+
+// selectInstances returns those instances that have one of the given roles and one of the given prefixes.
+// An empty list of roles or prefixes selects all instances.
+func selectInstances(ctx context.Context, instances providers.ClusterInstanceList, roles, prefixes []string) (providers.ClusterInstanceList, error) {
+	var docs []instanceDocument
+	if len(roles) > 0 {
+		var err error
+		docs, err = fetchInstanceDocuments(ctx, instances)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+	selected := providers.ClusterInstanceList{}
+	for idx, i := range instances {
+		if len(prefixes) > 0 && !containsString(prefixes, strings.SplitN(i.Name, ".", 2)[0]) {
+			continue
+		}
+		if len(roles) > 0 {
+			hasRole := false
+			for _, role := range roles {
+				hasRole = hasRole || docs[idx].HasRole(role)
+			}
+			if !hasRole {
+				continue
+			}
+		}
+		selected = append(selected, i)
+	}
+	return selected, nil
+}
+
+// printPrefixed prints every line of the given output, prefixed with the given instance name.
+func printPrefixed(name, output string) {
+	output = strings.TrimSuffix(output, "\n")
+	if output == "" {
+		return
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fmt.Printf("%s: %s\n", name, line)
+	}
+}
+
+// containsString returns true if the given list contains the given value.
+func containsString(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterGluonUpgrade = &cobra.Command{
+		Use:   "gluon-upgrade",
+		Short: "Upgrade gluon on all instances of a cluster, one at a time",
+		Long: "Upgrade gluon on all instances of a cluster, one at a time. " +
+			"Gluon is downloaded from the given image and `gluon setup` is run again with the arguments of its previous run. " +
+			"The next instance is upgraded when gluon.service is active again.",
+		Run: upgradeClusterGluon,
+	}
+
+	clusterGluonUpgradeFlags struct {
+		providers.ClusterInfo
+		providers.GluonSetupOptions
+	}
+)
+
+func init() {
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.Name, "name", "", "Cluster name")
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.GluonImage, "gluon-image", "", "Image containing gluon (required)")
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.PrivateRegistryUrl, "private-registry-url", defaultPrivateRegistryUrl(), "URL of private docker registry (used for instances without saved gluon arguments)")
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.PrivateRegistryUserName, "private-registry-username", defaultPrivateRegistryUserName(), "Username for private registry (used for instances without saved gluon arguments)")
+	cmdClusterGluonUpgrade.Flags().StringVar(&clusterGluonUpgradeFlags.PrivateRegistryPassword, "private-registry-password", defaultPrivateRegistryPassword(), "Password for private registry (used for instances without saved gluon arguments)")
+	addDryRunFlag(cmdClusterGluonUpgrade)
+	addConfirmClusterFlag(cmdClusterGluonUpgrade)
+	cmdCluster.AddCommand(cmdClusterGluonUpgrade)
+}
+
+func upgradeClusterGluon(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&clusterGluonUpgradeFlags.ClusterInfo, args)
+
+	provider := newProvider()
+	clusterGluonUpgradeFlags.ClusterInfo = provider.ClusterDefaults(clusterGluonUpgradeFlags.ClusterInfo)
+	info := clusterGluonUpgradeFlags.ClusterInfo
+	options := clusterGluonUpgradeFlags.GluonSetupOptions
+
+	if info.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	if options.GluonImage == "" {
+		Exitf("Please specify a gluon-image\n")
+	}
+	instances, err := provider.GetInstances(info)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", info)
+	}
+	sort.Sort(sortByName(instances))
+
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		upgradeGluonPlan(&plan, instances, options.GluonImage)
+		printPlan(plan)
+		return
+	}
+
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to upgrade gluon on all %d instances of %s to %s, one at a time?", len(instances), info, options.GluonImage), info); err != nil {
+		Exitf("%v\n", err)
+	}
+	ctx, _ := newContext()
+	versions, err := upgradeGluon(ctx, instances, options)
+
+	doc := newGluonVersionsDocument(versions)
+	printOutput(doc, func() []string {
+		lines := []string{"Name | Gluon version"}
+		for _, v := range doc.Instances {
+			lines = append(lines, fmt.Sprintf("%s | %s", v.Name, v.Version))
+		}
+		return lines
+	})
+	if err != nil {
+		Exitf("Failed to upgrade gluon: %v\n", err)
+	}
+	Infof("Upgraded gluon on %d instances\n", len(versions))
+}
+
+// gluonVersion is the version of gluon running on an instance.
+type gluonVersion struct {
+	Instance providers.ClusterInstance
+	Version  string
+}
+
+// upgradeGluon upgrades gluon on the given instances, one at a time, using the given image.
+// Instances without saved `gluon setup` arguments get arguments created from the given options & their fleet metadata.
+// It returns the gluon version of all instances upgraded so far, and stops at the first instance that fails.
+func upgradeGluon(ctx context.Context, instances providers.ClusterInstanceList, options providers.GluonSetupOptions) ([]gluonVersion, error) {
+	var machines []providers.FleetMachine
+	result := []gluonVersion{}
+	for _, i := range instances {
+		args, found := i.GetGluonSetupArgs(ctx, log)
+		if !found {
+			log.Warningf("No saved gluon arguments on %s, using fleet metadata & registry settings", i)
+			if machines == nil {
+				var err error
+				if machines, err = listFleetMachines(ctx, instances); err != nil {
+					return result, maskAny(err)
+				}
+			}
+			machineID, err := i.GetMachineID(ctx, log)
+			if err != nil {
+				return result, maskAny(err)
+			}
+			m, found := providers.FindFleetMachine(machines, machineID)
+			if !found {
+				return result, maskAny(fmt.Errorf("cannot determine the fleet metadata of %s, is it registered in fleet?", i.Name))
+			}
+			o := options
+			o.FleetMetadata = m.MetadataString()
+			args = i.GluonSetupArgs(o)
+		}
+		if err := i.UpgradeGluon(ctx, log, options.GluonImage, args); err != nil {
+			return result, maskAny(err)
+		}
+		version, err := i.GetGluonVersion(ctx, log)
+		if err != nil {
+			return result, maskAny(err)
+		}
+		result = append(result, gluonVersion{Instance: i, Version: version})
+	}
+	return result, nil
+}
+
+// upgradeGluonPlan adds all actions performed by upgradeGluon to the given plan.
+func upgradeGluonPlan(plan *providers.Plan, instances providers.ClusterInstanceList, gluonImage string) {
+	for _, i := range instances {
+		plan.Add(providers.PlanKindSSH, i.Name, "Download gluon from %s", gluonImage)
+		plan.Add(providers.PlanKindSSH, i.Name, "Run gluon setup with the arguments of its previous run")
+		plan.Add(providers.PlanKindSSH, i.Name, "Restart gluon & wait until gluon.service is active")
+	}
+}
+
+// gluonVersionsDocument is the machine readable representation of the gluon versions of a cluster.
+type gluonVersionsDocument struct {
+	Instances []instanceGluonVersionDocument `json:"instances" yaml:"instances"`
+}
+
+// instanceGluonVersionDocument is the machine readable representation of the gluon version of an instance.
+type instanceGluonVersionDocument struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version" yaml:"version"`
+}
+
+// newGluonVersionsDocument creates a document from the given gluon versions.
+func newGluonVersionsDocument(versions []gluonVersion) gluonVersionsDocument {
+	doc := gluonVersionsDocument{Instances: []instanceGluonVersionDocument{}}
+	for _, v := range versions {
+		doc.Instances = append(doc.Instances, instanceGluonVersionDocument{Name: v.Instance.Name, Version: v.Version})
+	}
+	return doc
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterHealth = &cobra.Command{
+		Use:   "health",
+		Short: "Check the health of all instances of a cluster",
+		Run:   showClusterHealth,
+	}
+
+	clusterHealthFlags struct {
+		providers.ClusterInfo
+		MinOSVersion string
+	}
+)
+
+func init() {
+	cmdClusterHealth.Flags().StringVar(&clusterHealthFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdClusterHealth.Flags().StringVar(&clusterHealthFlags.Name, "name", "", "Cluster name")
+	cmdClusterHealth.Flags().StringVar(&clusterHealthFlags.MinOSVersion, "min-os-version", defaultMinOSVersion, "Minimum version of the OS")
+	cmdCluster.AddCommand(cmdClusterHealth)
+}
+
+func showClusterHealth(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&clusterHealthFlags.ClusterInfo, args)
+
+	provider := newProvider()
+	clusterHealthFlags.ClusterInfo = provider.ClusterDefaults(clusterHealthFlags.ClusterInfo)
+
+	if clusterHealthFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	minOSVersion, err := semver.NewVersion(clusterHealthFlags.MinOSVersion)
+	if err != nil {
+		Exitf("Invalid min-os-version: %v\n", err)
+	}
+	instances, err := provider.GetInstances(clusterHealthFlags.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", clusterHealthFlags.ClusterInfo)
+	}
+	ctx, _ := newContext()
+	health, err := instances.CheckHealth(ctx, log, *minOSVersion)
+	if err != nil {
+		Exitf("Failed to check health: %v\n", err)
+	}
+
+	docs := []healthDocument{}
+	unhealthy := []string{}
+	for _, h := range health {
+		docs = append(docs, newHealthDocument(h))
+		if !h.Healthy() {
+			unhealthy = append(unhealthy, h.Instance.Name)
+		}
+	}
+	printOutput(docs, func() []string { return healthTable(health) })
+	if len(unhealthy) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d instances are unhealthy:\n  %s\n", len(unhealthy), len(health), strings.Join(unhealthy, "\n  "))
+		os.Exit(1)
+	}
+	Infof("All %d instances are healthy\n", len(health))
+}
+
+// healthTable creates the lines of a table showing the results of all checks of all instances.
+// Succeeded checks are shown in green, failed checks in red and prefixed with FAIL.
+func healthTable(health []providers.InstanceHealth) []string {
+	header := []string{"Name"}
+	for _, name := range providers.HealthChecks {
+		header = append(header, colorize(name, colorDefault))
+	}
+	lines := []string{strings.Join(header, " | ")}
+	for _, h := range health {
+		cells := []string{h.Instance.Name}
+		for _, name := range providers.HealthChecks {
+			c, _ := h.Check(name)
+			// The pipe separates columns
+			message := strings.Replace(c.Message, "|", "/", -1)
+			if c.OK {
+				cells = append(cells, colorize(message, colorGreen))
+			} else {
+				cells = append(cells, colorize("FAIL: "+message, colorRed))
+			}
+		}
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+	return lines
+}
+
+// healthDocument is the machine readable representation of the health of an instance.
+type healthDocument struct {
+	Name    string                `json:"name" yaml:"name"`
+	Healthy bool                  `json:"healthy" yaml:"healthy"`
+	Checks  []healthCheckDocument `json:"checks" yaml:"checks"`
+}
+
+// healthCheckDocument is the machine readable representation of a single health check.
+type healthCheckDocument struct {
+	Name    string `json:"name" yaml:"name"`
+	OK      bool   `json:"ok" yaml:"ok"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// newHealthDocument creates a health document from the given instance health.
+func newHealthDocument(h providers.InstanceHealth) healthDocument {
+	doc := healthDocument{
+		Name:    h.Instance.Name,
+		Healthy: h.Healthy(),
+		Checks:  []healthCheckDocument{},
+	}
+	for _, c := range h.Checks {
+		doc.Checks = append(doc.Checks, healthCheckDocument{Name: c.Name, OK: c.OK, Message: c.Message})
+	}
+	return doc
+}
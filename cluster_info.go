@@ -17,7 +17,6 @@ package main
 import (
 	"fmt"
 
-	"github.com/ryanuber/columnize"
 	"github.com/spf13/cobra"
 
 	"github.com/pulcy/quark/providers"
@@ -51,15 +50,37 @@ func showClusterInfo(cmd *cobra.Command, args []string) {
 	if err != nil {
 		Exitf("Failed to list instances: %v\n", err)
 	}
-	clusterMembers, err := instances.AsClusterMemberList(log, nil)
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", clusterInfoFlags)
+	}
+	ctx, _ := newContext()
+	clusterMembers, err := instances.AsClusterMemberList(ctx, log, nil)
 	if err != nil {
 		Exitf("Failed to fetch instance member data: %v\n", err)
 	}
 
-	lines := []string{
-		fmt.Sprintf("ID | %s", clusterMembers[0].ClusterID),
-		fmt.Sprintf("#Instances | %d", len(instances)),
+	doc := clusterDocument{
+		ID:            clusterMembers[0].ClusterID,
+		Name:          clusterInfoFlags.String(),
+		InstanceCount: len(instances),
+	}
+	for _, cm := range clusterMembers {
+		if !cm.EtcdProxy {
+			doc.EtcdMemberCount++
+		}
 	}
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
+	printOutput(doc, func() []string {
+		return []string{
+			fmt.Sprintf("ID | %s", doc.ID),
+			fmt.Sprintf("#Instances | %d", doc.InstanceCount),
+		}
+	})
+}
+
+// clusterDocument is the machine readable representation of a cluster.
+type clusterDocument struct {
+	ID              string `json:"id" yaml:"id"`
+	Name            string `json:"name" yaml:"name"`
+	InstanceCount   int    `json:"instances" yaml:"instances"`
+	EtcdMemberCount int    `json:"etcd-members" yaml:"etcd-members"`
 }
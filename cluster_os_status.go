@@ -0,0 +1,137 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterOSStatus = &cobra.Command{
+		Use:   "os-status",
+		Short: "Show the OS version, update status & reboot lock of all instances of a cluster",
+		Run:   showClusterOSStatus,
+	}
+
+	clusterOSStatusFlags providers.ClusterInfo
+)
+
+func init() {
+	cmdClusterOSStatus.Flags().StringVar(&clusterOSStatusFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdClusterOSStatus.Flags().StringVar(&clusterOSStatusFlags.Name, "name", "", "Cluster name")
+	cmdCluster.AddCommand(cmdClusterOSStatus)
+}
+
+func showClusterOSStatus(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&clusterOSStatusFlags, args)
+
+	provider := newProvider()
+	clusterOSStatusFlags = provider.ClusterDefaults(clusterOSStatusFlags)
+
+	if clusterOSStatusFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	instances, err := provider.GetInstances(clusterOSStatusFlags)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", clusterOSStatusFlags)
+	}
+	ctx, _ := newContext()
+	status, locks, err := instances.GetOSStatus(ctx, log)
+	if err != nil {
+		Exitf("Failed to fetch OS status: %v\n", err)
+	}
+
+	doc := newOSStatusDocument(status, locks)
+	printOutput(doc, func() []string {
+		lines := []string{"Name | OS version | Update status | New version | Reboot strategy | Reboot window | Reboot lock"}
+		for _, s := range doc.Instances {
+			if s.Error != "" {
+				// The pipe separates columns
+				lines = append(lines, fmt.Sprintf("%s | %s | - | - | - | - | -", s.Name, colorize("FAIL: "+strings.Replace(s.Error, "|", "/", -1), colorRed)))
+				continue
+			}
+			lock := "-"
+			if s.RebootLock {
+				lock = "held"
+			}
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s | %s | %s | %s", s.Name, s.Version, s.UpdateStatus,
+				orDash(s.NewVersion), orDash(s.RebootStrategy), orDash(s.RebootWindow), lock))
+		}
+		return lines
+	})
+	Infof("%d of %d reboot locks available\n", locks.Available, locks.Max)
+}
+
+// orDash returns the given value, or "-" when it is empty.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// osStatusDocument is the machine readable representation of the OS status of a cluster.
+type osStatusDocument struct {
+	Instances      []instanceOSStatusDocument `json:"instances" yaml:"instances"`
+	LocksAvailable int                        `json:"locks-available" yaml:"locks-available"`
+	LocksMax       int                        `json:"locks-max" yaml:"locks-max"`
+}
+
+// instanceOSStatusDocument is the machine readable representation of the OS status of an instance.
+type instanceOSStatusDocument struct {
+	Name           string `json:"name" yaml:"name"`
+	Version        string `json:"version,omitempty" yaml:"version,omitempty"`
+	UpdateStatus   string `json:"update-status,omitempty" yaml:"update-status,omitempty"`
+	NewVersion     string `json:"new-version,omitempty" yaml:"new-version,omitempty"`
+	RebootStrategy string `json:"reboot-strategy,omitempty" yaml:"reboot-strategy,omitempty"`
+	RebootWindow   string `json:"reboot-window,omitempty" yaml:"reboot-window,omitempty"`
+	RebootLock     bool   `json:"reboot-lock" yaml:"reboot-lock"`
+	Error          string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// newOSStatusDocument creates an OS status document from the given status of all instances & the locksmith status.
+func newOSStatusDocument(status []providers.OSStatus, locks providers.LocksmithStatus) osStatusDocument {
+	doc := osStatusDocument{
+		Instances:      []instanceOSStatusDocument{},
+		LocksAvailable: locks.Available,
+		LocksMax:       locks.Max,
+	}
+	for _, s := range status {
+		d := instanceOSStatusDocument{Name: s.Instance.Name}
+		if s.Err != nil {
+			d.Error = s.Err.Error()
+		} else if s.Skipped {
+			d.Version = "n/a"
+			d.UpdateStatus = "not CoreOS"
+		} else {
+			d.Version = s.Version
+			d.UpdateStatus = s.UpdateEngine.State()
+			d.NewVersion = s.UpdateEngine.NewVersion
+			d.RebootStrategy = s.UpdateConfig.RebootStrategy
+			d.RebootWindow = s.UpdateConfig.Window()
+			d.RebootLock = locks.IsHolder(s.MachineID)
+		}
+		doc.Instances = append(doc.Instances, d)
+	}
+	return doc
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterOSUpdate = &cobra.Command{
+		Use:   "os-update",
+		Short: "Update the OS of all instances of a cluster, one at a time",
+		Long: "Update the OS of all instances of a cluster, one at a time. " +
+			"An instance that has downloaded an update is rebooted once it holds a locksmith reboot lock, " +
+			"the next instance is updated when it has rejoined etcd & fleet. " +
+			"The reboot strategy & maintenance window of all instances can be changed at the same time. " +
+			"Instances that do not run CoreOS are skipped.",
+		Run: updateClusterOS,
+	}
+
+	clusterOSUpdateFlags struct {
+		providers.ClusterInfo
+		providers.UpdateConfig
+		ConfigOnly bool
+	}
+)
+
+func init() {
+	cmdClusterOSUpdate.Flags().StringVar(&clusterOSUpdateFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdClusterOSUpdate.Flags().StringVar(&clusterOSUpdateFlags.Name, "name", "", "Cluster name")
+	cmdClusterOSUpdate.Flags().StringVar(&clusterOSUpdateFlags.RebootStrategy, "reboot-strategy", "", fmt.Sprintf("CoreOS reboot strategy [%s] (default unchanged)", strings.Join(providers.RebootStrategies, "|")))
+	cmdClusterOSUpdate.Flags().StringVar(&clusterOSUpdateFlags.RebootWindowStart, "reboot-window-start", "", "Start of the maintenance window in which locksmith reboots instances, e.g. 'Thu 04:00' (default unchanged)")
+	cmdClusterOSUpdate.Flags().StringVar(&clusterOSUpdateFlags.RebootWindowLength, "reboot-window-length", "", "Length of the maintenance window, e.g. '1h' (default unchanged)")
+	cmdClusterOSUpdate.Flags().BoolVar(&clusterOSUpdateFlags.ConfigOnly, "config-only", false, "If set, only the reboot strategy & maintenance window are changed, no updates are triggered")
+	addDryRunFlag(cmdClusterOSUpdate)
+	addConfirmClusterFlag(cmdClusterOSUpdate)
+	cmdCluster.AddCommand(cmdClusterOSUpdate)
+}
+
+func updateClusterOS(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&clusterOSUpdateFlags.ClusterInfo, args)
+
+	provider := newProvider()
+	clusterOSUpdateFlags.ClusterInfo = provider.ClusterDefaults(clusterOSUpdateFlags.ClusterInfo)
+	info := clusterOSUpdateFlags.ClusterInfo
+	config := clusterOSUpdateFlags.UpdateConfig
+
+	if info.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	if config.RebootStrategy != "" && !providers.IsValidRebootStrategy(config.RebootStrategy) {
+		Exitf("Invalid reboot-strategy '%s', use one of %s\n", config.RebootStrategy, strings.Join(providers.RebootStrategies, ", "))
+	}
+	if (config.RebootWindowStart == "") != (config.RebootWindowLength == "") {
+		Exitf("Please specify both reboot-window-start and reboot-window-length\n")
+	}
+	if clusterOSUpdateFlags.ConfigOnly && config.IsEmpty() {
+		Exitf("Please specify a reboot-strategy or reboot window to change\n")
+	}
+	instances, err := provider.GetInstances(info)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", info)
+	}
+	sort.Sort(sortByName(instances))
+	coreOS := coreOSInstances(instances)
+	if len(coreOS) == 0 {
+		Exitf("Cluster %s has no CoreOS instances, the OS of other instances cannot be updated by quark.\n", info)
+	}
+
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		updateOSPlan(&plan, instances, config, clusterOSUpdateFlags.ConfigOnly)
+		printPlan(plan)
+		return
+	}
+
+	question := fmt.Sprintf("Are you sure you want to update the OS of %d CoreOS instances of %s, rebooting them one at a time?", len(coreOS), info)
+	if clusterOSUpdateFlags.ConfigOnly {
+		question = fmt.Sprintf("Are you sure you want to change the reboot settings of %d CoreOS instances of %s?", len(coreOS), info)
+	}
+	if err := confirmCluster(question, info); err != nil {
+		Exitf("%v\n", err)
+	}
+	ctx, _ := newContext()
+	rebooted, err := updateOS(ctx, provider, instances, config, clusterOSUpdateFlags.ConfigOnly)
+	if err != nil {
+		Exitf("Failed to update OS: %v\n", err)
+	}
+	if !clusterOSUpdateFlags.ConfigOnly {
+		Infof("Updated & rebooted %d of %d instances\n", rebooted, len(coreOS))
+	}
+}
+
+// coreOSInstances returns the instances of the given list that run CoreOS.
+func coreOSInstances(instances providers.ClusterInstanceList) providers.ClusterInstanceList {
+	result := providers.ClusterInstanceList{}
+	for _, i := range instances {
+		if i.OS == providers.OSNameCoreOS {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// updateOS writes the non-empty settings of the given config on all given instances and, unless configOnly is set,
+// updates their OS one at a time. It returns the number of rebooted instances.
+// Instances that do not run CoreOS are skipped, they have no update engine & locksmith.
+func updateOS(ctx context.Context, provider providers.CloudProvider, instances providers.ClusterInstanceList, config providers.UpdateConfig, configOnly bool) (int, error) {
+	for _, i := range instances {
+		if i.OS != providers.OSNameCoreOS {
+			log.Warningf("Skipping %s, it does not run CoreOS", i)
+		}
+	}
+	instances = coreOSInstances(instances)
+	if !config.IsEmpty() {
+		for _, i := range instances {
+			if err := i.SetUpdateConfig(ctx, log, config); err != nil {
+				return 0, maskAny(err)
+			}
+		}
+	}
+	if configOnly {
+		return 0, nil
+	}
+	rebooted := 0
+	for _, i := range instances {
+		etcdProxy, err := i.IsEtcdProxy(ctx, log)
+		if err != nil {
+			return rebooted, maskAny(err)
+		}
+		ok, err := i.UpdateOS(ctx, log, etcdProxy, provider)
+		if ok {
+			rebooted++
+		}
+		if err != nil {
+			return rebooted, maskAny(err)
+		}
+	}
+	return rebooted, nil
+}
+
+// updateOSPlan adds all actions performed by updateOS to the given plan.
+func updateOSPlan(plan *providers.Plan, instances providers.ClusterInstanceList, config providers.UpdateConfig, configOnly bool) {
+	instances = coreOSInstances(instances)
+	if !config.IsEmpty() {
+		settings := []string{}
+		if config.RebootStrategy != "" {
+			settings = append(settings, "REBOOT_STRATEGY="+config.RebootStrategy)
+		}
+		if config.RebootWindowStart != "" {
+			settings = append(settings, "REBOOT_WINDOW_START="+config.RebootWindowStart, "REBOOT_WINDOW_LENGTH="+config.RebootWindowLength)
+		}
+		for _, i := range instances {
+			plan.Add(providers.PlanKindSSH, i.Name, "Set %s in /etc/coreos/update.conf & restart locksmithd", strings.Join(settings, ", "))
+		}
+	}
+	if configOnly {
+		return
+	}
+	for _, i := range instances {
+		plan.Add(providers.PlanKindSSH, i.Name, "Check for OS update")
+		plan.Add(providers.PlanKindServer, i.Name, "If an update is downloaded: take a reboot lock, reboot & wait until it rejoined etcd & fleet")
+	}
+}
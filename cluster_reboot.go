@@ -0,0 +1,101 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterReboot = &cobra.Command{
+		Use:   "reboot",
+		Short: "Reboot all instances of a running cluster, a few at a time",
+		Long: "Reboot all instances of a running cluster, a few at a time (see --reboot-parallelism). " +
+			"The next instances are rebooted when the previous ones have rejoined etcd & fleet. " +
+			"The reboot is refused when it would make etcd lose its quorum, unless --force is given.",
+		Run: rebootCluster,
+	}
+
+	clusterRebootFlags struct {
+		providers.ClusterInfo
+		Force bool
+	}
+)
+
+func init() {
+	cmdClusterReboot.Flags().StringVar(&clusterRebootFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdClusterReboot.Flags().StringVar(&clusterRebootFlags.Name, "name", "", "Cluster name")
+	cmdClusterReboot.Flags().BoolVar(&clusterRebootFlags.Force, "force", false, "If set, instances are rebooted even when etcd loses its quorum")
+	addDryRunFlag(cmdClusterReboot)
+	addConfirmClusterFlag(cmdClusterReboot)
+	cmdCluster.AddCommand(cmdClusterReboot)
+}
+
+func rebootCluster(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&clusterRebootFlags.ClusterInfo, args)
+
+	provider := newProvider()
+	clusterRebootFlags.ClusterInfo = provider.ClusterDefaults(clusterRebootFlags.ClusterInfo)
+	info := clusterRebootFlags.ClusterInfo
+
+	if info.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	instances, err := provider.GetInstances(info)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", info)
+	}
+	sort.Sort(sortByName(instances))
+
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		plan.AddRollingReboot(instances)
+		printPlan(plan)
+		return
+	}
+
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to reboot all %d instances of %s?", len(instances), info), info); err != nil {
+		Exitf("%v\n", err)
+	}
+	ctx, _ := newContext()
+	if err := rollingRebootCluster(ctx, provider, instances, clusterRebootFlags.Force); err != nil {
+		Exitf("Failed to reboot cluster: %v\n", err)
+	}
+	Infof("Rebooted %d instances\n", len(instances))
+}
+
+// rollingRebootCluster reboots the given instances of a running cluster a few at a time.
+// The etcd members & proxies are taken from the cluster-members file of the first instance.
+// If force is set, the instances are rebooted even when etcd loses its quorum.
+func rollingRebootCluster(ctx context.Context, provider providers.CloudProvider, instances providers.ClusterInstanceList, force bool) error {
+	clusterMembers, err := instances[0].GetClusterMembers(ctx, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := instances.RollingReboot(ctx, log, clusterMembers, provider, force); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
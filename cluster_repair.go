@@ -0,0 +1,76 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdClusterRepair = &cobra.Command{
+		Use:   "repair",
+		Short: "Re-apply the files & DNS records of a cluster that differ from what quark would generate",
+		Run:   repairCluster,
+	}
+)
+
+func init() {
+	addClusterDiffFlags(cmdClusterRepair)
+	addDryRunFlag(cmdClusterRepair)
+	addConfirmClusterFlag(cmdClusterRepair)
+	cmdCluster.AddCommand(cmdClusterRepair)
+}
+
+func repairCluster(cmd *cobra.Command, args []string) {
+	instances := clusterDiffInstances(args)
+	ctx, _ := newContext()
+	drift, err := diffCluster(ctx, instances, clusterDiffFlags)
+	if err != nil {
+		Exitf("Failed to compare cluster: %v\n", err)
+	}
+	repairable := 0
+	for _, d := range drift.Drifts {
+		if d.Repairable() {
+			repairable++
+		}
+	}
+	if repairable == 0 {
+		if len(drift.Drifts) > 0 {
+			printDrift(drift.Drifts)
+		}
+		Infof("Nothing to repair\n")
+		return
+	}
+
+	plan := providers.Plan{}
+	plan.AddRepair(drift.Drifts)
+	if dryRun {
+		printPlan(plan)
+		return
+	}
+
+	printDrift(drift.Drifts)
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to repair %d differences of %s?", repairable, clusterDiffFlags.ClusterInfo), clusterDiffFlags.ClusterInfo); err != nil {
+		Exitf("%v\n", err)
+	}
+	if err := instances.Repair(ctx, log, drift.State, drift.Drifts, newDnsProvider(), clusterDiffFlags.ClusterInfo, drift.IsLoadBalancer); err != nil {
+		Exitf("Failed to repair cluster: %v\n", err)
+	}
+	Infof("Repaired %d differences\n", repairable)
+}
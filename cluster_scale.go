@@ -0,0 +1,348 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdScaleCluster = &cobra.Command{
+		Short: "Grow or shrink a cluster to a given number of instances",
+		Long: "Grow or shrink a cluster to a given number of instances. " +
+			"New instances are created in parallel and join the cluster one at a time. " +
+			"When shrinking, the newest instances are destroyed first, but never the last load-balancer instance.",
+		Use: "scale",
+		Run: scaleCluster,
+	}
+
+	scaleClusterFlags struct {
+		providers.CreateInstanceOptions
+		InstanceCount int
+		Force         bool
+	}
+)
+
+func init() {
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.Name, "name", "", "Cluster name")
+	cmdScaleCluster.Flags().IntVar(&scaleClusterFlags.InstanceCount, "instance-count", 0, "Desired number of instances in cluster")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.ImageID, "image", "", "OS image to run on new instances")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.RegionID, "region", "", "Region to create the instances in")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.TypeID, "type", "", "Type of the new instances")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.MinOSVersion, "min-os-version", defaultMinOSVersion, "Minimum version of the OS")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.GluonImage, "gluon-image", defaultGluonImage, "Image containing gluon")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.RebootStrategy, "reboot-strategy", defaultRebootStrategy, "CoreOS reboot strategy")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.PrivateRegistryUrl, "private-registry-url", defaultPrivateRegistryUrl(), "URL of private docker registry")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.PrivateRegistryUserName, "private-registry-username", defaultPrivateRegistryUserName(), "Username for private registry")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.PrivateRegistryPassword, "private-registry-password", defaultPrivateRegistryPassword(), "Password for private registry")
+	cmdScaleCluster.Flags().StringSliceVar(&scaleClusterFlags.SSHKeyNames, "ssh-key", defaultSshKeys(), "Names of SSH keys to add to new instances")
+	cmdScaleCluster.Flags().StringVar(&scaleClusterFlags.SSHKeyGithubAccount, "ssh-key-github-account", defaultSshKeyGithubAccount(), "Github account name used to fetch SSH keys (to add to instances)")
+	cmdScaleCluster.Flags().BoolVar(&scaleClusterFlags.EtcdProxy, "etcd-proxy", false, "If set, the new instances will be ETCD proxies")
+	cmdScaleCluster.Flags().BoolVar(&scaleClusterFlags.RoleCore, "role-core", false, "If set, the new instances will get `core=true` metadata")
+	cmdScaleCluster.Flags().BoolVar(&scaleClusterFlags.RoleLoadBalancer, "role-lb", false, "If set, the new instances will get `lb=true` metadata and register with cluster name in DNS")
+	cmdScaleCluster.Flags().BoolVar(&scaleClusterFlags.PrivateOnly, "private-only", false, "If set, the new instances get no public IP address and are reached through a load-balancer instance or the jump host")
+	cmdScaleCluster.Flags().BoolVar(&scaleClusterFlags.Force, "force", false, "Destroy instances even when they cannot be drained or etcd would lose its quorum")
+	addDryRunFlag(cmdScaleCluster)
+	addKeepOnFailureFlag(cmdScaleCluster)
+	addConfirmClusterFlag(cmdScaleCluster)
+	cmdCluster.AddCommand(cmdScaleCluster)
+}
+
+func scaleCluster(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&scaleClusterFlags.ClusterInfo, args)
+
+	required := []providers.Capability{providers.CapabilityInstance}
+	if scaleClusterFlags.PrivateOnly {
+		required = append(required, providers.CapabilityPrivateOnly)
+	}
+	provider := newProvider(required...)
+	options := provider.CreateInstanceDefaults(scaleClusterFlags.CreateInstanceOptions)
+	options.ClusterInfo = provider.ClusterDefaults(options.ClusterInfo)
+
+	if options.Domain == "" {
+		Exitf("Please specify a domain\n")
+	}
+	if options.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	if scaleClusterFlags.InstanceCount < 1 {
+		Exitf("Please specify a valid instance count\n")
+	}
+
+	// Compare the desired count with the existing instances
+	instances, err := provider.GetInstances(options.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to query existing instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", options.ClusterInfo)
+	}
+	current := len(instances)
+	desired := scaleClusterFlags.InstanceCount
+	if current == desired {
+		Infof("Cluster %s has %d instances, nothing to do.\n", options.ClusterInfo, current)
+		return
+	}
+
+	ctx, tracker := newContext()
+	var victims providers.ClusterInstanceList
+	if current > desired {
+		victims, err = selectScaleVictims(ctx, instances, current-desired)
+		if err != nil {
+			Exitf("Failed to select instances to destroy: %v\n", err)
+		}
+	}
+
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		if err := scaleClusterPlan(&plan, options, instances, desired, victims); err != nil {
+			Exitf("Failed to create plan: %v\n", err)
+		}
+		printPlan(plan)
+		return
+	}
+
+	if current < desired {
+		if err := confirm(fmt.Sprintf("Are you sure you want to add %d instances (%s) to %s?", desired-current, options.InstanceConfig, options.ClusterInfo)); err != nil {
+			Exitf("%v\n", err)
+		}
+		created, err := growCluster(ctx, provider, options, instances, desired-current)
+		if err != nil {
+			removed, _ := rollbackOrReport(tracker)
+			updateMembersAfterRollback(provider, options.ClusterInfo, removed)
+			Exitf("Failed to grow cluster: %v\n", err)
+		}
+		for _, i := range created {
+			Infof("Created instance %s\n", i.Name)
+		}
+		return
+	}
+
+	Infof("Plan for %s:\n", options.ClusterInfo)
+	for _, v := range victims {
+		Infof("  - destroy instance %s\n", v.Name)
+	}
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to destroy %d instances of %s?", len(victims), options.ClusterInfo), options.ClusterInfo); err != nil {
+		Exitf("%v\n", err)
+	}
+	if err := shrinkCluster(ctx, provider, options.ClusterInfo, victims, scaleClusterFlags.Force); err != nil {
+		Exitf("Failed to shrink cluster: %v\n", err)
+	}
+}
+
+// growCluster adds count new instances to the cluster formed by the given (existing) instances.
+// The instances are created in parallel, then they join the cluster one at a time, such that
+// only a single new ETCD member is added at the same time.
+// The resources of an instance are committed once it has joined, so a failure of a later instance
+// only rolls back the instances that have not joined.
+func growCluster(ctx context.Context, provider providers.CloudProvider, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList, count int) (providers.ClusterInstanceList, error) {
+	options, err := clusterInstanceOptions(ctx, options, instances)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	// Every instance records its resources separately, uncommitted ones are moved to the tracker of ctx
+	allOptions := newScaleInstanceOptions(options, instances, count)
+	contexts := make([]context.Context, len(allOptions))
+	trackers := make([]*providers.ResourceTracker, len(allOptions))
+	for idx := range allOptions {
+		trackers[idx] = &providers.ResourceTracker{}
+		contexts[idx] = providers.WithResourceTracker(ctx, trackers[idx])
+	}
+	defer func() {
+		for _, t := range trackers {
+			providers.AdoptResources(ctx, t)
+		}
+	}()
+
+	// Create all instances in parallel
+	created := make(providers.ClusterInstanceList, len(allOptions))
+	wg := sync.WaitGroup{}
+	errorChannel := make(chan error, len(allOptions))
+	for idx, o := range allOptions {
+		wg.Add(1)
+		go func(idx int, o providers.CreateInstanceOptions) {
+			defer wg.Done()
+			instance, err := createClusterInstance(contexts[idx], provider, o)
+			if err != nil {
+				errorChannel <- maskAny(err)
+				return
+			}
+			created[idx] = instance
+		}(idx, o)
+	}
+	wg.Wait()
+	close(errorChannel)
+	for err := range errorChannel {
+		return nil, maskAny(err)
+	}
+
+	// Join them one at a time
+	newIPs := make(map[string]bool)
+	isEtcdProxy := func(i providers.ClusterInstance) bool {
+		return options.EtcdProxy && newIPs[i.ClusterIP]
+	}
+	members := append(providers.ClusterInstanceList{}, instances...)
+	for idx, instance := range created {
+		log.Infof("Joining %s to the cluster", instance.Name)
+		if err := joinInstance(contexts[idx], provider, allOptions[idx], instance, members, isEtcdProxy); err != nil {
+			return created, maskAny(err)
+		}
+		// Never roll back an instance once it has joined
+		providers.CommitResources(contexts[idx])
+		members = append(members, instance)
+		newIPs[instance.ClusterIP] = true
+	}
+	return created, nil
+}
+
+// newScaleInstanceOptions creates the options for count new instances with unused indexes.
+func newScaleInstanceOptions(options providers.CreateInstanceOptions, instances providers.ClusterInstanceList, count int) []providers.CreateInstanceOptions {
+	result := []providers.CreateInstanceOptions{}
+	for _, index := range instances.FreeInstanceIndexes(count) {
+		o := options
+		o.SetupIndex(index)
+		o.SetupNames("", options.Name, options.Domain)
+		result = append(result, o)
+	}
+	return result
+}
+
+// shrinkCluster destroys the given instances one at a time, draining each of them first.
+func shrinkCluster(ctx context.Context, provider providers.CloudProvider, info providers.ClusterInfo, victims providers.ClusterInstanceList, force bool) error {
+	for _, v := range victims {
+		instanceInfo := providers.ClusterInstanceInfo{
+			ClusterInfo: info,
+			Prefix:      strings.SplitN(v.Name, ".", 2)[0],
+		}
+		if err := removeInstance(ctx, provider, instanceInfo, force); err != nil {
+			return maskAny(err)
+		}
+		log.Infof("Destroyed instance %s", instanceInfo)
+	}
+	return nil
+}
+
+// selectScaleVictims selects count instances to remove from the given list.
+// The newest instances are selected first, but the last instance with `lb=true` fleet
+// metadata is never selected.
+func selectScaleVictims(ctx context.Context, instances providers.ClusterInstanceList, count int) (providers.ClusterInstanceList, error) {
+	machines, err := listFleetMachines(ctx, instances)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	candidates := []scaleCandidate{}
+	loadBalancers := 0
+	for _, i := range instances {
+		machineID, err := i.GetMachineID(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		createdAt, err := i.GetCreatedAt(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		m, _ := providers.FindFleetMachine(machines, machineID)
+		c := scaleCandidate{Instance: i, CreatedAt: createdAt, LoadBalancer: m.HasMetadata("lb", "true")}
+		if c.LoadBalancer {
+			loadBalancers++
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Sort(newestFirst(candidates))
+
+	victims := providers.ClusterInstanceList{}
+	for _, c := range candidates {
+		if len(victims) == count {
+			break
+		}
+		if c.LoadBalancer {
+			if loadBalancers == 1 {
+				continue
+			}
+			loadBalancers--
+		}
+		victims = append(victims, c.Instance)
+	}
+	if len(victims) < count {
+		return nil, maskAny(fmt.Errorf("cannot remove %d instances without removing the last load-balancer instance", count))
+	}
+	return victims, nil
+}
+
+// listFleetMachines lists the fleet machines on the first of the given instances that can reach fleet.
+func listFleetMachines(ctx context.Context, instances providers.ClusterInstanceList) ([]providers.FleetMachine, error) {
+	for _, i := range instances {
+		machines, err := i.ListFleetMachines(ctx, log)
+		if err != nil {
+			log.Warningf("Cannot list fleet machines on %s: %v", i, err)
+			continue
+		}
+		return machines, nil
+	}
+	return nil, maskAny(fmt.Errorf("cannot list fleet machines on any instance"))
+}
+
+// scaleClusterPlan adds all actions performed by growCluster or shrinkCluster to the given plan.
+func scaleClusterPlan(plan *providers.Plan, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList, desired int, victims providers.ClusterInstanceList) error {
+	if desired > len(instances) {
+		members := append(providers.ClusterInstanceList{}, instances...)
+		for _, o := range newScaleInstanceOptions(options, instances, desired-len(instances)) {
+			addInstancePlan(plan, o, members)
+			members = append(members, providers.ClusterInstance{Name: o.InstanceName})
+		}
+		return nil
+	}
+	for _, v := range victims {
+		info := providers.ClusterInstanceInfo{
+			ClusterInfo: options.ClusterInfo,
+			Prefix:      strings.SplitN(v.Name, ".", 2)[0],
+		}
+		if err := removeInstancePlan(plan, info, instances); err != nil {
+			return maskAny(err)
+		}
+		instances = removeFromList(instances, v.Name)
+	}
+	return nil
+}
+
+type scaleCandidate struct {
+	Instance     providers.ClusterInstance
+	CreatedAt    time.Time
+	LoadBalancer bool
+}
+
+type newestFirst []scaleCandidate
+
+func (l newestFirst) Len() int      { return len(l) }
+func (l newestFirst) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l newestFirst) Less(i, j int) bool {
+	if !l[i].CreatedAt.Equal(l[j].CreatedAt) {
+		return l[i].CreatedAt.After(l[j].CreatedAt)
+	}
+	return l[i].Instance.Name > l[j].Instance.Name
+}
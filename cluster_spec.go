@@ -0,0 +1,185 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/pulcy/quark/providers"
+)
+
+const (
+	clusterSpecVersion = 1
+
+	roleCore      = "core"
+	roleLB        = "lb"
+	roleEtcdProxy = "etcd-proxy"
+)
+
+// ClusterSpec is a declarative description of a cluster, loaded from a YAML or JSON file.
+type ClusterSpec struct {
+	Version        int                 `yaml:"version" json:"version"`
+	Provider       string              `yaml:"provider" json:"provider"`
+	Domain         string              `yaml:"domain" json:"domain"`
+	Name           string              `yaml:"name" json:"name"`
+	ID             string              `yaml:"cluster-id,omitempty" json:"cluster-id,omitempty"`
+	MinOSVersion   string              `yaml:"min-os-version,omitempty" json:"min-os-version,omitempty"`
+	GluonImage     string              `yaml:"gluon-image,omitempty" json:"gluon-image,omitempty"`
+	RebootStrategy string              `yaml:"reboot-strategy,omitempty" json:"reboot-strategy,omitempty"`
+	Registry       RegistrySpec        `yaml:"registry" json:"registry"`
+	Vault          VaultSpec           `yaml:"vault" json:"vault"`
+	SSH            SSHSpec             `yaml:"ssh" json:"ssh"`
+	InstanceGroups []InstanceGroupSpec `yaml:"instance-groups" json:"instance-groups"`
+}
+
+// RegistrySpec describes the private docker registry used by a cluster.
+type RegistrySpec struct {
+	URL      string `yaml:"url" json:"url"`
+	UserName string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// VaultSpec describes the vault used by a cluster.
+type VaultSpec struct {
+	Address    string `yaml:"address" json:"address"`
+	CACertPath string `yaml:"cacert" json:"cacert"`
+}
+
+// SSHSpec describes the SSH keys installed on all instances of a cluster.
+type SSHSpec struct {
+	KeyNames      []string `yaml:"keys" json:"keys"`
+	GithubAccount string   `yaml:"github-account" json:"github-account"`
+}
+
+// InstanceGroupSpec describes a number of identical instances in a cluster.
+type InstanceGroupSpec struct {
+	Name     string   `yaml:"name" json:"name"`
+	Count    int      `yaml:"count" json:"count"`
+	ImageID  string   `yaml:"image,omitempty" json:"image,omitempty"`
+	RegionID string   `yaml:"region,omitempty" json:"region,omitempty"`
+	TypeID   string   `yaml:"type,omitempty" json:"type,omitempty"`
+	Roles    []string `yaml:"roles" json:"roles"`
+}
+
+// loadClusterSpec reads a cluster spec from the file with given path.
+// Since YAML is a superset of JSON, both formats are accepted.
+func loadClusterSpec(path string) (ClusterSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ClusterSpec{}, maskAny(err)
+	}
+	var spec ClusterSpec
+	if err := yaml.Unmarshal(raw, &spec); err != nil {
+		return ClusterSpec{}, maskAny(err)
+	}
+	if err := spec.Validate(); err != nil {
+		return ClusterSpec{}, maskAny(err)
+	}
+	return spec, nil
+}
+
+// Validate checks the spec for structural errors.
+// Option values are validated later by CreateClusterOptions.Validate and CreateInstanceOptions.Validate.
+func (s ClusterSpec) Validate() error {
+	if s.Version != clusterSpecVersion {
+		return maskAny(fmt.Errorf("Unsupported spec version %d, expected %d", s.Version, clusterSpecVersion))
+	}
+	if len(s.InstanceGroups) == 0 {
+		return errors.New("Please specify at least one instance group")
+	}
+	for i, g := range s.InstanceGroups {
+		if g.Count < 0 {
+			return maskAny(fmt.Errorf("Invalid count in instance group '%s'", g.Name))
+		}
+		for _, r := range g.Roles {
+			switch r {
+			case roleCore, roleLB, roleEtcdProxy:
+			default:
+				return maskAny(fmt.Errorf("Unknown role '%s' in instance group '%s'", r, g.Name))
+			}
+		}
+		if i == 0 && (!g.HasRole(roleCore) || !g.HasRole(roleLB) || g.Count < 1) {
+			return maskAny(fmt.Errorf("First instance group '%s' must have at least 1 instance with roles '%s' and '%s'", g.Name, roleCore, roleLB))
+		}
+	}
+	return nil
+}
+
+// InstanceCount returns the total number of instances in all groups.
+func (s ClusterSpec) InstanceCount() int {
+	count := 0
+	for _, g := range s.InstanceGroups {
+		count += g.Count
+	}
+	return count
+}
+
+// CreateClusterOptions converts the spec into options for creating the cluster.
+// The instance configuration is taken from the first instance group.
+func (s ClusterSpec) CreateClusterOptions() providers.CreateClusterOptions {
+	first := s.InstanceGroups[0]
+	options := providers.CreateClusterOptions{
+		ClusterInfo: providers.ClusterInfo{
+			ID:     s.ID,
+			Domain: s.Domain,
+			Name:   s.Name,
+		},
+		InstanceConfig:          first.InstanceConfig(s.MinOSVersion),
+		SSHKeyNames:             s.SSH.KeyNames,
+		SSHKeyGithubAccount:     s.SSH.GithubAccount,
+		InstanceCount:           s.InstanceCount(),
+		GluonImage:              s.GluonImage,
+		RebootStrategy:          s.RebootStrategy,
+		PrivateRegistryUrl:      s.Registry.URL,
+		PrivateRegistryUserName: s.Registry.UserName,
+		PrivateRegistryPassword: s.Registry.Password,
+		VaultAddress:            s.Vault.Address,
+		VaultCertificatePath:    s.Vault.CACertPath,
+	}
+	if options.MinOSVersion == "" {
+		options.MinOSVersion = defaultMinOSVersion
+	}
+	if options.GluonImage == "" {
+		options.GluonImage = defaultGluonImage
+	}
+	if options.RebootStrategy == "" {
+		options.RebootStrategy = defaultRebootStrategy
+	}
+	return options
+}
+
+// InstanceConfig returns the instance configuration of the group.
+func (g InstanceGroupSpec) InstanceConfig(minOSVersion string) providers.InstanceConfig {
+	return providers.InstanceConfig{
+		ImageID:      g.ImageID,
+		RegionID:     g.RegionID,
+		TypeID:       g.TypeID,
+		MinOSVersion: minOSVersion,
+	}
+}
+
+// HasRole returns true if the given role is listed in the roles of the group.
+func (g InstanceGroupSpec) HasRole(role string) bool {
+	for _, r := range g.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
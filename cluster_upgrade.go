@@ -0,0 +1,262 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdUpgradeCluster = &cobra.Command{
+		Short: "Replace all instances of a cluster, one at a time",
+		Long: "Replace all instances of a cluster, one at a time, e.g. to move it to a new image, instance type or region. " +
+			"Every new instance gets the index, roles & tinc address of the instance it replaces. " +
+			"When the tinc address is the cluster IP, both instances are part of the cluster at the same time, " +
+			"so the new instance gets the lowest unused index of the same kind (odd or even) and its tinc address instead. " +
+			"Once it has joined etcd & fleet, the load-balancer DNS records are moved to it and the old instance is drained and destroyed.",
+		Use: "upgrade",
+		Run: upgradeCluster,
+	}
+
+	upgradeClusterFlags struct {
+		providers.CreateInstanceOptions
+		Force bool
+		Pause bool
+	}
+)
+
+func init() {
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.Name, "name", "", "Cluster name")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.ImageID, "image", "", "OS image to run on new instances")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.RegionID, "region", "", "Region to create the instances in (default the region of the replaced instance)")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.TypeID, "type", "", "Type of the new instances")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.MinOSVersion, "min-os-version", defaultMinOSVersion, "Minimum version of the OS")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.GluonImage, "gluon-image", defaultGluonImage, "Image containing gluon")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.RebootStrategy, "reboot-strategy", defaultRebootStrategy, "CoreOS reboot strategy")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.PrivateRegistryUrl, "private-registry-url", defaultPrivateRegistryUrl(), "URL of private docker registry")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.PrivateRegistryUserName, "private-registry-username", defaultPrivateRegistryUserName(), "Username for private registry")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.PrivateRegistryPassword, "private-registry-password", defaultPrivateRegistryPassword(), "Password for private registry")
+	cmdUpgradeCluster.Flags().StringSliceVar(&upgradeClusterFlags.SSHKeyNames, "ssh-key", defaultSshKeys(), "Names of SSH keys to add to new instances")
+	cmdUpgradeCluster.Flags().StringVar(&upgradeClusterFlags.SSHKeyGithubAccount, "ssh-key-github-account", defaultSshKeyGithubAccount(), "Github account name used to fetch SSH keys (to add to instances)")
+	cmdUpgradeCluster.Flags().BoolVar(&upgradeClusterFlags.Force, "force", false, "Destroy replaced instances even when they cannot be drained")
+	cmdUpgradeCluster.Flags().BoolVar(&upgradeClusterFlags.Pause, "pause", false, "If set, stop after every replaced instance, continue with 'quark resume'")
+	addDryRunFlag(cmdUpgradeCluster)
+	addKeepOnFailureFlag(cmdUpgradeCluster)
+	addConfirmClusterFlag(cmdUpgradeCluster)
+	cmdCluster.AddCommand(cmdUpgradeCluster)
+}
+
+func upgradeCluster(cmd *cobra.Command, args []string) {
+	clusterInfoFromArgs(&upgradeClusterFlags.ClusterInfo, args)
+
+	provider := newProvider(providers.CapabilityInstance)
+	options := upgradeClusterFlags.CreateInstanceOptions
+	options.ClusterInfo = provider.ClusterDefaults(options.ClusterInfo)
+
+	if options.Domain == "" {
+		Exitf("Please specify a domain\n")
+	}
+	if options.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+
+	instances, err := provider.GetInstances(options.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to query existing instances: %v\n", err)
+	}
+	if len(instances) == 0 {
+		Exitf("Cluster %s does not exist.\n", options.ClusterInfo)
+	}
+
+	ctx, tracker := newContext()
+	replacements, err := newReplacements(ctx, provider, options, instances)
+	if err != nil {
+		Exitf("Failed to prepare upgrade: %v\n", err)
+	}
+
+	// Show plan only
+	if dryRun {
+		plan := providers.Plan{}
+		if err := upgradeClusterPlan(&plan, replacements, instances); err != nil {
+			Exitf("Failed to create plan: %v\n", err)
+		}
+		printPlan(plan)
+		return
+	}
+
+	Infof("Plan for %s:\n", options.ClusterInfo)
+	for _, r := range replacements {
+		Infof("  - replace instance %s by %s (%s, index %d, tinc address %s)\n", r.Instance, r.Options.InstanceName, r.Options.InstanceConfig, r.Options.InstanceIndex, r.Options.TincIpv4)
+	}
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to replace all %d instances of %s?", len(replacements), options.ClusterInfo), options.ClusterInfo); err != nil {
+		Exitf("%v\n", err)
+	}
+
+	op := newOperation(providers.OperationUpgradeCluster)
+	op.Upgrade = &providers.UpgradeOptions{
+		Replacements: replacements,
+		Force:        upgradeClusterFlags.Force,
+		Pause:        upgradeClusterFlags.Pause,
+	}
+	if err := op.Save(); err != nil {
+		Exitf("Failed to save operation journal: %v\n", err)
+	}
+	ctx = providers.WithOperation(ctx, op)
+	completed, err := replaceInstances(ctx, provider, *op.Upgrade)
+	if err != nil {
+		failOperation(op, provider, tracker, err)
+		Exitf("Failed to upgrade cluster: %v\n", err)
+	}
+	if !completed {
+		Infof("Upgrade paused, continue with: %s resume %s\n", projectName, op.ID)
+		return
+	}
+	finishOperation(op)
+
+	Infof("Cluster %s upgraded\n", options.ClusterInfo)
+}
+
+// newReplacements creates the replacements of all given instances, in order of their names.
+// The settings of the new instances are taken from the given options and the provider defaults,
+// their roles are taken from fleet.
+func newReplacements(ctx context.Context, provider providers.CloudProvider, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList) ([]providers.Replacement, error) {
+	machines, err := listFleetMachines(ctx, instances)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	sorted := append(providers.ClusterInstanceList{}, instances...)
+	sort.Sort(sortByName(sorted))
+
+	result := []providers.Replacement{}
+	cluster := instances // The cluster as it is after the replacements created so far
+	for _, i := range sorted {
+		machineID, err := i.GetMachineID(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		m, found := providers.FindFleetMachine(machines, machineID)
+		if !found {
+			return nil, maskAny(fmt.Errorf("cannot determine the roles of %s, is it registered in fleet?", i.Name))
+		}
+		etcdProxy, err := i.IsEtcdProxy(ctx, log)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		r := providers.NewReplacement(i, m, etcdProxy, options, cluster)
+		r.Options = provider.CreateInstanceDefaults(r.Options)
+		if err := r.Options.InstanceConfig.Validate(); err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, r)
+		cluster = r.Apply(cluster)
+	}
+	return result, nil
+}
+
+// replaceInstances replaces the instances of the given upgrade one at a time, skipping those replaced before.
+// It returns false when the upgrade stopped because it has to pause before the next instance.
+func replaceInstances(ctx context.Context, provider providers.CloudProvider, upgrade providers.UpgradeOptions) (bool, error) {
+	replaced := 0
+	for _, r := range upgrade.Replacements {
+		if upgrade.Pause && replaced > 0 {
+			return false, nil
+		}
+		skipped := true
+		if err := providers.RunStep(ctx, log, r.Instance, "replace", func() error {
+			skipped = false
+			return maskAny(replaceInstance(ctx, provider, r, upgrade.Force))
+		}); err != nil {
+			return false, maskAny(err)
+		}
+		if !skipped {
+			// Never roll back a replacement once its old instance is gone
+			providers.CommitResources(ctx)
+			replaced++
+		}
+	}
+	return true, nil
+}
+
+// replaceInstance creates the new instance of the given replacement, joins it to the cluster, moves the
+// load-balancer DNS records to it and drains & destroys the old instance.
+func replaceInstance(ctx context.Context, provider providers.CloudProvider, r providers.Replacement, force bool) error {
+	info := providers.ClusterInstanceInfo{
+		ClusterInfo: r.Options.ClusterInfo,
+		Prefix:      strings.SplitN(r.Instance, ".", 2)[0],
+	}
+	instances, err := provider.GetInstances(r.Options.ClusterInfo)
+	if err != nil {
+		return maskAny(err)
+	}
+	// The new instance may already exist, it is not part of the existing cluster yet
+	instances = removeFromList(instances, r.Options.InstanceName)
+	if len(instances) == 0 {
+		return maskAny(fmt.Errorf("cluster %s has no instances left", r.Options.ClusterInfo))
+	}
+	instance, err := addInstance(ctx, provider, r.Options, instances)
+	if err != nil {
+		return maskAny(err)
+	}
+	// Never roll back the new instance once it has joined
+	providers.CommitResources(ctx)
+
+	if r.Options.DelayClusterDNS {
+		// The new instance is healthy, so it can take load-balancer traffic
+		if err := providers.RunStep(ctx, log, instance.Name, "register-cluster-dns", func() error {
+			return maskAny(providers.RegisterInstance(ctx, log, newDnsProvider(), r.Options, instance.Name, true, instance.LoadBalancerIPv4, instance.LoadBalancerIPv6))
+		}); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	if err := providers.RunStep(ctx, log, r.Instance, "destroy", func() error {
+		return maskAny(removeInstance(ctx, provider, info, force))
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// upgradeClusterPlan adds all actions performed by replaceInstances to the given plan.
+func upgradeClusterPlan(plan *providers.Plan, replacements []providers.Replacement, instances providers.ClusterInstanceList) error {
+	for _, r := range replacements {
+		info := providers.ClusterInstanceInfo{
+			ClusterInfo: r.Options.ClusterInfo,
+			Prefix:      strings.SplitN(r.Instance, ".", 2)[0],
+		}
+		addInstancePlan(plan, r.Options, instances)
+		if r.Options.DelayClusterDNS {
+			for _, rec := range providers.RegisterInstanceRecords(r.Options, true, providers.PlanPublicIPv4, providers.PlanPublicIPv6) {
+				if rec.Name == r.Options.ClusterName {
+					plan.Add(providers.PlanKindDNS, rec.Name, "Create %s record -> %s", rec.Type, rec.Data)
+				}
+			}
+		}
+		instances = append(instances, providers.ClusterInstance{Name: r.Options.InstanceName})
+		if err := removeInstancePlan(plan, info, instances); err != nil {
+			return maskAny(err)
+		}
+		instances = removeFromList(instances, r.Instance)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pulcy/quark/providers"
+)
+
+const (
+	configFileMode = os.FileMode(0600)
+	configDirMode  = os.FileMode(0700)
+)
+
+// configKey describes a setting that can be stored in a profile.
+// The name of a key is equal to the name of the commandline flag it provides a value for.
+type configKey struct {
+	Name   string // Name of the key (and flag)
+	EnvVar string // Environment variable that overrides the profile value (can be empty)
+	Secret bool   // If set, the value is never shown
+}
+
+var (
+	// configKeys lists all settings that can be stored in a profile.
+	// The credentials of all registered providers are added in init.
+	configKeys = []configKey{
+		{Name: "provider"},
+		{Name: "dns-provider"},
+		{Name: "domain", EnvVar: "QUARK_DOMAIN"},
+		{Name: "private-registry-url", EnvVar: "QUARK_REGISTRY_URL"},
+		{Name: "private-registry-username", EnvVar: "QUARK_REGISTRY_USERNAME"},
+		{Name: "private-registry-password", EnvVar: "QUARK_REGISTRY_PASSWORD", Secret: true},
+		{Name: "vault-addr", EnvVar: "VAULT_ADDR"},
+		{Name: "vault-cacert", EnvVar: "VAULT_CACERT"},
+		{Name: "ssh-key", EnvVar: "QUARK_SSH_KEY"},
+		{Name: "ssh-key-github-account", EnvVar: "QUARK_SSH_KEY_GITHUB_ACCOUNT"},
+		{Name: "ssh-identity", EnvVar: "QUARK_SSH_IDENTITY"},
+		{Name: "ssh-forward-agent"},
+		{Name: "ssh-known-hosts", EnvVar: "QUARK_SSH_KNOWN_HOSTS"},
+		{Name: "ssh-jump-host", EnvVar: "QUARK_SSH_JUMP_HOST"},
+		{Name: "state-dir", EnvVar: "QUARK_STATE_DIR"},
+		{Name: "create-timeout"},
+		{Name: "ssh-timeout"},
+		{Name: "health-timeout"},
+		{Name: "reboot-parallelism"},
+	}
+)
+
+func init() {
+	for _, info := range providers.RegisteredProviders() {
+		for _, c := range info.Credentials {
+			configKeys = append(configKeys, configKey{Name: c.Name, EnvVar: c.EnvVar, Secret: c.Secret})
+		}
+	}
+}
+
+// Config is the content of the quark configuration file.
+type Config struct {
+	CurrentProfile string             `yaml:"current-profile,omitempty"`
+	Profiles       map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// Profile is a named set of settings (key=flag name, value=flag value).
+type Profile map[string]string
+
+// findConfigKey looks up a config key by name.
+func findConfigKey(name string) (configKey, bool) {
+	for _, k := range configKeys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return configKey{}, false
+}
+
+// defaultConfigPath returns the path of the configuration file.
+// It is ~/.config/quark/config.yaml, unless XDG_CONFIG_HOME is set.
+func defaultConfigPath() string {
+	if p := os.Getenv("QUARK_CONFIG"); p != "" {
+		return p
+	}
+	return configDirPath("config.yaml")
+}
+
+// defaultKnownHostsDir returns the directory holding the pinned SSH host keys.
+// It is ~/.config/quark/known_hosts, unless XDG_CONFIG_HOME is set.
+func defaultKnownHostsDir() string {
+	if p := os.Getenv("QUARK_SSH_KNOWN_HOSTS"); p != "" {
+		return p
+	}
+	return configDirPath("known_hosts")
+}
+
+// defaultStateDir returns the directory holding the journals of resumable operations.
+// It is ~/.local/state/quark, unless XDG_STATE_HOME is set.
+func defaultStateDir() string {
+	if p := os.Getenv("QUARK_STATE_DIR"); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "quark")
+	}
+	dir, err := homedir.Expand("~/.local/state/quark")
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// configDirPath returns the path of the given name in the quark configuration directory.
+func configDirPath(name string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "quark", name)
+	}
+	dir, err := homedir.Expand("~/.config/quark")
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// loadConfig reads the configuration file from the given path.
+// A missing file results in an empty configuration.
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, maskAny(err)
+	}
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return cfg, maskAny(err)
+	}
+	return cfg, nil
+}
+
+// Save writes the configuration to the given path.
+// Since the file contains credentials, it is only accessible by the current user.
+func (cfg Config) Save(path string) error {
+	raw, err := yaml.Marshal(cfg)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), configDirMode); err != nil {
+		return maskAny(err)
+	}
+	if err := ioutil.WriteFile(path, raw, configFileMode); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ProfileName returns the name of the profile to use.
+// An explicitly given name takes precedence over the current profile of the configuration.
+func (cfg Config) ProfileName(name string) string {
+	if name != "" {
+		return name
+	}
+	return cfg.CurrentProfile
+}
+
+// Profile returns the profile with given name.
+func (cfg Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, maskAny(fmt.Errorf("Profile '%s' not found", name))
+	}
+	return p, nil
+}
+
+// Validate checks that the profile only contains known keys.
+func (p Profile) Validate() error {
+	for name := range p {
+		if _, ok := findConfigKey(name); !ok {
+			return maskAny(fmt.Errorf("Unknown key '%s'", name))
+		}
+	}
+	return nil
+}
+
+// Keys returns the sorted names of all keys set in the profile.
+func (p Profile) Keys() []string {
+	keys := []string{}
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Apply sets the values of the profile on all flags that have not been set on the commandline
+// and are not overridden by an environment variable.
+// This results in the following precedence: flag > env > profile > provider default.
+func (p Profile) Apply(flags *pflag.FlagSet) error {
+	for _, name := range p.Keys() {
+		f := flags.Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if key, ok := findConfigKey(name); ok && key.EnvVar != "" && os.Getenv(key.EnvVar) != "" {
+			continue
+		}
+		// Set the value directly, so the flag is not marked as changed on the commandline
+		if err := f.Value.Set(p[name]); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// effectiveValue returns the value of the given key and its source (flag, env or profile).
+func effectiveValue(flags *pflag.FlagSet, key configKey, p Profile) (string, string) {
+	if f := flags.Lookup(key.Name); f != nil && f.Changed {
+		return f.Value.String(), "flag"
+	}
+	if key.EnvVar != "" {
+		if v := os.Getenv(key.EnvVar); v != "" {
+			return v, "env"
+		}
+	}
+	if v, ok := p[key.Name]; ok {
+		return v, "profile"
+	}
+	return "", ""
+}
+
+// maskSecret hides the given value if the given key is a secret.
+func maskSecret(key configKey, value string) string {
+	if !key.Secret || value == "" {
+		return value
+	}
+	return "********"
+}
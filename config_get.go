@@ -0,0 +1,62 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdConfigGet = &cobra.Command{
+		Short: "Show the effective value of a configuration key",
+		Long:  "Show the effective value of a configuration key. Secrets are masked unless --show-secrets is given.",
+		Use:   "get <key>",
+		Run:   getConfig,
+	}
+
+	configGetFlags struct {
+		ShowSecrets bool
+	}
+)
+
+func init() {
+	cmdConfigGet.Flags().BoolVar(&configGetFlags.ShowSecrets, "show-secrets", false, "If set, secret values are shown as is")
+	cmdConfig.AddCommand(cmdConfigGet)
+}
+
+func getConfig(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Exitf("Please specify a key\n")
+	}
+	key, ok := findConfigKey(args[0])
+	if !ok {
+		Exitf("Unknown key '%s'\n", args[0])
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		Exitf("Cannot load config from %s: %v\n", configPath, err)
+	}
+	profile, err := cfg.Profile(cfg.ProfileName(profileName))
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+	value, _ := effectiveValue(cmd.Flags(), key, profile)
+	if !configGetFlags.ShowSecrets {
+		value = maskSecret(key, value)
+	}
+	fmt.Println(value)
+}
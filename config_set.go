@@ -0,0 +1,76 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+const (
+	currentProfileKey = "current-profile"
+)
+
+var (
+	cmdConfigSet = &cobra.Command{
+		Short: "Set a configuration key in a profile",
+		Long:  "Set a configuration key in the selected profile. An empty value removes the key. Use 'current-profile' as key to select the default profile.",
+		Use:   "set <key> <value>",
+		Run:   setConfig,
+	}
+)
+
+func init() {
+	cmdConfig.AddCommand(cmdConfigSet)
+}
+
+func setConfig(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		Exitf("Please specify a key and a value\n")
+	}
+	keyName, value := args[0], args[1]
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		Exitf("Cannot load config from %s: %v\n", configPath, err)
+	}
+
+	if keyName == currentProfileKey {
+		cfg.CurrentProfile = value
+	} else {
+		if _, ok := findConfigKey(keyName); !ok {
+			Exitf("Unknown key '%s'\n", keyName)
+		}
+		name := cfg.ProfileName(profileName)
+		if name == "" {
+			Exitf("Please specify a profile\n")
+		}
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]Profile)
+		}
+		profile := cfg.Profiles[name]
+		if profile == nil {
+			profile = Profile{}
+		}
+		if value == "" {
+			delete(profile, keyName)
+		} else {
+			profile[keyName] = value
+		}
+		cfg.Profiles[name] = profile
+	}
+
+	if err := cfg.Save(configPath); err != nil {
+		Exitf("Cannot save config to %s: %v\n", configPath, err)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdConfigShow = &cobra.Command{
+		Short: "Show the effective configuration",
+		Long:  "Show the effective configuration and where each value comes from. Secrets are masked.",
+		Use:   "show",
+		Run:   showConfig,
+	}
+)
+
+func init() {
+	cmdConfig.AddCommand(cmdConfigShow)
+}
+
+func showConfig(cmd *cobra.Command, args []string) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		Exitf("Cannot load config from %s: %v\n", configPath, err)
+	}
+	name := cfg.ProfileName(profileName)
+	profile, err := cfg.Profile(name)
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+
+	doc := configDocument{
+		Path:    configPath,
+		Profile: name,
+	}
+	for _, key := range configKeys {
+		value, source := effectiveValue(cmd.Flags(), key, profile)
+		doc.Values = append(doc.Values, configValueDocument{
+			Key:    key.Name,
+			Value:  maskSecret(key, value),
+			Source: source,
+		})
+	}
+
+	printOutput(doc, func() []string {
+		lines := []string{
+			fmt.Sprintf("Config | %s", doc.Path),
+			fmt.Sprintf("Profile | %s", doc.Profile),
+			"",
+			"Key | Value | Source",
+		}
+		for _, v := range doc.Values {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s", v.Key, v.Value, v.Source))
+		}
+		return lines
+	})
+}
+
+// configDocument is the machine readable representation of the effective configuration.
+type configDocument struct {
+	Path    string                `json:"path" yaml:"path"`
+	Profile string                `json:"profile" yaml:"profile"`
+	Values  []configValueDocument `json:"values" yaml:"values"`
+}
+
+type configValueDocument struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
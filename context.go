@@ -0,0 +1,168 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+// newContext creates the context for a single command.
+// The context is canceled when the user presses Ctrl-C, so the in-flight operation stops cleanly.
+// All resources created with the context are recorded in the returned tracker.
+func newContext() (context.Context, *providers.ResourceTracker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := &providers.ResourceTracker{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		// A second Ctrl-C terminates quark immediately
+		signal.Stop(signals)
+		Infof("Interrupted, stopping...\n")
+		cancel()
+	}()
+	return providers.WithResourceTracker(ctx, tracker), tracker
+}
+
+var (
+	keepOnFailure bool
+)
+
+// addKeepOnFailureFlag adds the --keep-on-failure flag to the given (creating) command.
+func addKeepOnFailureFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&keepOnFailure, "keep-on-failure", false, "If set, resources created by a failed operation are kept (for debugging) instead of removed")
+}
+
+// rollbackOrReport is called when an operation failed or was interrupted.
+// Unless --keep-on-failure is set, it removes all resources recorded in the given tracker.
+// It prints the resources that are removed and those that still exist.
+func rollbackOrReport(tracker *providers.ResourceTracker) (removed, remaining []providers.Resource) {
+	if !keepOnFailure && len(tracker.Resources()) > 0 {
+		// The context of the operation may have been canceled, use a new one.
+		// A second Ctrl-C still terminates quark immediately.
+		removed, _ = tracker.Rollback(context.Background(), log)
+		if len(removed) > 0 {
+			Infof("The following resources have been created and are removed again:\n")
+			for _, r := range removed {
+				Infof("- %s %s (%s)\n", r.Kind, r.Name, r.ID)
+			}
+		}
+	}
+	reportLeftBehind(tracker)
+	return removed, tracker.Resources()
+}
+
+// newOperation creates the journal of a resumable operation of the given kind in the state directory.
+func newOperation(kind string) *providers.Operation {
+	op := providers.NewOperation(stateDir, kind)
+	op.Provider = provider
+	op.DnsProvider = dnsProvider
+	return op
+}
+
+// updateMembersAfterRollback rewrites the cluster members of all instances of the given cluster when the given
+// rolled back resources include a server, since the remaining instances may still list it as a member.
+func updateMembersAfterRollback(provider providers.CloudProvider, info providers.ClusterInfo, removed []providers.Resource) {
+	rolledBack := false
+	for _, r := range removed {
+		rolledBack = rolledBack || r.Kind == providers.ResourceServer
+	}
+	if !rolledBack {
+		return
+	}
+	ctx := context.Background()
+	instances, err := provider.GetInstances(info)
+	if err == nil && len(instances) == 0 {
+		return
+	}
+	if err == nil {
+		var state providers.ClusterState
+		if state, err = instances.DesiredClusterState(ctx, log); err == nil {
+			Infof("Updating the cluster members of the remaining instances\n")
+			err = instances.UpdateClusterMembers(ctx, log, state.Members, providers.RebootNone, provider)
+		}
+	}
+	if err != nil {
+		Infof("Failed to update the cluster members of the remaining instances, fix them with `%s cluster repair`: %v\n", projectName, err)
+	}
+}
+
+// failOperation is called when a journaled operation failed or was interrupted.
+// It records the error in the journal and rolls back the created resources (see rollbackOrReport).
+// When instances of an existing cluster have been rolled back, the cluster members of the remaining
+// instances are updated. The journal is kept when the operation can be resumed, otherwise it is removed.
+func failOperation(op *providers.Operation, provider providers.CloudProvider, tracker *providers.ResourceTracker, err error) {
+	if err := op.Fail(err); err != nil {
+		log.Warningf("Failed to save operation journal: %v", err)
+	}
+	removed, remaining := rollbackOrReport(tracker)
+	switch {
+	case op.Instance != nil:
+		updateMembersAfterRollback(provider, op.Instance.ClusterInfo, removed)
+	case op.Upgrade != nil && len(op.Upgrade.Replacements) > 0:
+		updateMembersAfterRollback(provider, op.Upgrade.Replacements[0].Options.ClusterInfo, removed)
+	}
+	for _, r := range removed {
+		if r.Kind == providers.ResourceServer {
+			// Steps completed on a removed instance have to be done again
+			op.Forget(r.Name)
+		}
+	}
+	// An upgrade cannot start over once it has replaced instances
+	if keepOnFailure || len(remaining) > 0 || op.Resumed() || op.Kind == providers.OperationUpgradeCluster {
+		Infof("Resume with: %s resume %s\n", projectName, op.ID)
+		return
+	}
+	finishOperation(op)
+}
+
+// finishOperation removes the journal of a resumable operation that has completed (or has been rolled back).
+func finishOperation(op *providers.Operation) {
+	if err := op.Remove(); err != nil {
+		log.Warningf("Failed to remove operation journal: %v", err)
+	}
+}
+
+// reportLeftBehind prints all resources recorded in the given tracker.
+// It is called when an operation failed or was interrupted, so these resources can be cleaned up.
+func reportLeftBehind(tracker *providers.ResourceTracker) {
+	resources := tracker.Resources()
+	if len(resources) == 0 {
+		return
+	}
+	Infof("The following resources have been created and are left behind:\n")
+	for _, r := range resources {
+		Infof("- %s %s (%s)\n", r.Kind, r.Name, r.ID)
+	}
+}
+
+// reportRemainingInstances prints all instances of the given cluster that still exist.
+// It is called when destroying a cluster failed or was interrupted.
+func reportRemainingInstances(provider providers.CloudProvider, info providers.ClusterInfo) {
+	instances, err := provider.GetInstances(info)
+	if err != nil || len(instances) == 0 {
+		return
+	}
+	Infof("The following instances have not been destroyed:\n")
+	for _, i := range instances {
+		Infof("- %s %s (%s)\n", providers.ResourceServer, i.Name, i.ID)
+	}
+}
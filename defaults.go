@@ -16,6 +16,8 @@ package main
 
 import (
 	"os"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -23,12 +25,22 @@ const (
 	defaultGluonImage     = "pulcy/gluon:0.14.7"
 	defaultRebootStrategy = "etcd-lock"
 	defaultMinOSVersion   = "835.13.0"
+	defaultDnsProvider    = "cloudflare"
 )
 
 func defaultDomain() string {
 	return os.Getenv("QUARK_DOMAIN")
 }
 
+func defaultProfile() string {
+	return os.Getenv("QUARK_PROFILE")
+}
+
+func defaultAssumeYes() bool {
+	yes, _ := strconv.ParseBool(os.Getenv("QUARK_ASSUME_YES"))
+	return yes
+}
+
 func defaultPrivateRegistryUrl() string {
 	return os.Getenv("QUARK_REGISTRY_URL")
 }
@@ -45,12 +57,19 @@ func defaultSshKeys() []string {
 	return []string{os.Getenv("QUARK_SSH_KEY")}
 }
 
-func defaultSshKeyGithubAccount() string {
-	return os.Getenv("QUARK_SSH_KEY_GITHUB_ACCOUNT")
+func defaultSshIdentity() []string {
+	if id := os.Getenv("QUARK_SSH_IDENTITY"); id != "" {
+		return strings.Split(id, ",")
+	}
+	return nil
+}
+
+func defaultSshJumpHost() string {
+	return os.Getenv("QUARK_SSH_JUMP_HOST")
 }
 
-func defaultVagrantFolder() string {
-	return os.Getenv("QUARK_VAGRANT_FOLDER")
+func defaultSshKeyGithubAccount() string {
+	return os.Getenv("QUARK_SSH_KEY_GITHUB_ACCOUNT")
 }
 
 func defaultVaultAddr() string {
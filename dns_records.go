@@ -15,6 +15,9 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/spf13/cobra"
 )
 
@@ -39,8 +42,25 @@ func showDnsRecords(cmd *cobra.Command, args []string) {
 		Exitf("Please specify a domain\n")
 	}
 	provider := newDnsProvider()
-	err := provider.ShowDomainRecords(dnsFlags.Domain)
+	records, err := provider.ListDnsRecords(dnsFlags.Domain)
 	if err != nil {
 		Exitf("Failed to show dns records: %v\n", err)
 	}
+
+	printOutput(records, func() []string {
+		lines := []string{}
+		for _, r := range records {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %d", r.Type, trimLength(r.Name, 20), trimLength(r.Data, 60), r.TTL))
+		}
+		sort.Strings(lines)
+		lines = append([]string{"Type | Name | Data | TTL"}, lines...)
+		return lines
+	})
+}
+
+func trimLength(s string, maxLen int) string {
+	if len(s) > maxLen {
+		return s[:maxLen] + "..."
+	}
+	return s
 }
@@ -0,0 +1,1247 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+	"github.com/pulcy/quark/providers/fake"
+)
+
+const (
+	testDnsProviderName = "fake-dns"
+)
+
+var (
+	testDNS                  *fake.DnsProvider
+	testVaultCertificatePath string
+)
+
+func init() {
+	logging.SetLevel(logging.CRITICAL, projectName)
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name:         testDnsProviderName,
+		Capabilities: []providers.Capability{providers.CapabilityDNS},
+		NewDnsProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.DnsProvider, error) {
+			return testDNS, nil
+		},
+	})
+}
+
+// setupFake installs a fake executor & DNS provider and returns a fake cloud provider.
+// The returned function restores the original state and must be called at the end of the test.
+func setupFake() (*fake.Executor, *fake.Provider, func()) {
+	executor := fake.NewExecutor()
+	previous := providers.SetRemoteExecutor(executor)
+	previousDnsProvider := dnsProvider
+	testDNS = fake.NewDnsProvider()
+	dnsProvider = testDnsProviderName
+	restore := func() {
+		providers.SetRemoteExecutor(previous)
+		dnsProvider = previousDnsProvider
+		os.Remove(testVaultCertificatePath)
+	}
+	return executor, fake.NewProvider(log, executor), restore
+}
+
+// testClusterOptions returns valid options for creating a cluster with given number of instances.
+func testClusterOptions(t *testing.T, provider providers.CloudProvider, instanceCount int) providers.CreateClusterOptions {
+	f, err := ioutil.TempFile("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp file: %v", err)
+	}
+	f.WriteString("test-ca-cert")
+	f.Close()
+	testVaultCertificatePath = f.Name()
+
+	options := provider.CreateClusterDefaults(providers.CreateClusterOptions{
+		ClusterInfo: providers.ClusterInfo{
+			ID:     "testclusterid",
+			Domain: "example.com",
+			Name:   "c1",
+		},
+		InstanceConfig:          providers.InstanceConfig{MinOSVersion: defaultMinOSVersion},
+		SSHKeyNames:             []string{"test"},
+		SSHKeyGithubAccount:     "test",
+		InstanceCount:           instanceCount,
+		GluonImage:              defaultGluonImage,
+		RebootStrategy:          defaultRebootStrategy,
+		PrivateRegistryUrl:      "https://registry.example.com",
+		PrivateRegistryUserName: "user",
+		PrivateRegistryPassword: "secret",
+		VaultAddress:            "https://vault.example.com:8200",
+		VaultCertificatePath:    f.Name(),
+	})
+	if err := options.Validate(); err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+	return options
+}
+
+// testInstanceOptions returns options for adding an instance, like `quark instance create` creates them.
+func testInstanceOptions(provider providers.CloudProvider, cluster providers.CreateClusterOptions, etcdProxy bool) providers.CreateInstanceOptions {
+	options := provider.CreateInstanceDefaults(providers.CreateInstanceOptions{
+		ClusterInfo:             providers.ClusterInfo{Domain: cluster.Domain, Name: cluster.Name},
+		InstanceConfig:          cluster.InstanceConfig,
+		SSHKeyNames:             cluster.SSHKeyNames,
+		SSHKeyGithubAccount:     cluster.SSHKeyGithubAccount,
+		GluonImage:              cluster.GluonImage,
+		RebootStrategy:          cluster.RebootStrategy,
+		PrivateRegistryUrl:      cluster.PrivateRegistryUrl,
+		PrivateRegistryUserName: cluster.PrivateRegistryUserName,
+		PrivateRegistryPassword: cluster.PrivateRegistryPassword,
+		EtcdProxy:               etcdProxy,
+		InstanceIndex:           4,
+	})
+	options.SetupNames("", cluster.Name, cluster.Domain)
+	return options
+}
+
+func createTestCluster(t *testing.T, provider providers.CloudProvider, instanceCount int) providers.CreateClusterOptions {
+	options := testClusterOptions(t, provider, instanceCount)
+	if err := createNewCluster(context.Background(), provider, options); err != nil {
+		t.Fatalf("createNewCluster failed: %v", err)
+	}
+	return options
+}
+
+func getInstances(t *testing.T, provider providers.CloudProvider, info providers.ClusterInfo) providers.ClusterInstanceList {
+	instances, err := provider.GetInstances(info)
+	if err != nil {
+		t.Fatalf("GetInstances failed: %v", err)
+	}
+	return instances
+}
+
+func TestClusterCreate(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 3)
+
+	instances := getInstances(t, provider, options.ClusterInfo)
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 3 {
+			t.Errorf("Expected 3 cluster members on %s, got %d", i.Name, members)
+		}
+		if m.Reboots != 1 {
+			t.Errorf("Expected %s to be rebooted once, got %d", i.Name, m.Reboots)
+		}
+		if !m.IsActive("etcd2.service") || !m.IsActive("fleet.service") {
+			t.Errorf("Expected etcd & fleet to run on %s after the reboot", i.Name)
+		}
+	}
+	// Instances are rebooted one at a time, the next one only when the previous one is back
+	rebooting := ""
+	for _, cmd := range executor.Commands() {
+		parts := strings.SplitN(cmd, ": ", 2)
+		switch {
+		case parts[1] == "sudo shutdown -r now":
+			if rebooting != "" {
+				t.Errorf("%s rebooted before %s was back", parts[0], rebooting)
+			}
+			rebooting = parts[0]
+		case parts[0] == rebooting && (parts[1] == "systemctl is-active etcd2.service" || strings.HasPrefix(parts[1], "fleetctl list-machines")):
+			rebooting = ""
+		}
+	}
+	if records, _ := testDNS.ListDnsRecords("example.com"); len(records) != 6 {
+		t.Errorf("Expected 6 DNS records, got %d", len(records))
+	}
+}
+
+func TestClusterReboot(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, options.ClusterInfo)
+
+	if err := rollingRebootCluster(context.Background(), provider, instances, false); err != nil {
+		t.Fatalf("rollingRebootCluster failed: %v", err)
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if m.Reboots != 2 {
+			t.Errorf("Expected %s to be rebooted twice (create & reboot), got %d", i.Name, m.Reboots)
+		}
+		if !m.IsActive("etcd2.service") || !m.IsActive("fleet.service") {
+			t.Errorf("Expected etcd & fleet to run on %s after the reboot", i.Name)
+		}
+	}
+}
+
+func TestClusterRebootSmallCluster(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 2)
+	instances := getInstances(t, provider, options.ClusterInfo)
+
+	// Every reboot of a member of a 2 member cluster loses the quorum
+	err := rollingRebootCluster(context.Background(), provider, instances, false)
+	if !providers.IsQuorumError(err) {
+		t.Fatalf("Expected a quorum error, got %v", err)
+	}
+	for _, i := range instances {
+		if m, _ := executor.Machine(i.Name); m.Reboots != 1 {
+			t.Errorf("Expected %s not to be rebooted, got %d reboots", i.Name, m.Reboots-1)
+		}
+	}
+
+	if err := rollingRebootCluster(context.Background(), provider, instances, true); err != nil {
+		t.Fatalf("rollingRebootCluster with force failed: %v", err)
+	}
+	for _, i := range instances {
+		if m, _ := executor.Machine(i.Name); m.Reboots != 2 {
+			t.Errorf("Expected %s to be rebooted with force, got %d reboots", i.Name, m.Reboots-1)
+		}
+	}
+}
+
+func TestClusterCreateRollback(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := testClusterOptions(t, provider, 3)
+	executor.FailOn("systemctl restart gluon.service", errors.New("gluon failed"))
+
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithResourceTracker(context.Background(), tracker)
+	if err := createNewCluster(ctx, provider, options); err == nil {
+		t.Fatal("Expected createNewCluster to fail")
+	}
+	if len(tracker.Resources()) == 0 {
+		t.Fatal("Expected created resources to be recorded")
+	}
+	rollbackOrReport(tracker)
+
+	if instances := getInstances(t, provider, options.ClusterInfo); len(instances) != 0 {
+		t.Errorf("Expected all instances to be removed, got %d", len(instances))
+	}
+	if records, _ := testDNS.ListDnsRecords("example.com"); len(records) != 0 {
+		t.Errorf("Expected all DNS records to be removed, got %v", records)
+	}
+	if resources := tracker.Resources(); len(resources) != 0 {
+		t.Errorf("Expected nothing to be left behind, got %v", resources)
+	}
+}
+
+func TestClusterCreateKeepOnFailure(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := testClusterOptions(t, provider, 3)
+	executor.FailOn("systemctl restart gluon.service", errors.New("gluon failed"))
+	keepOnFailure = true
+	defer func() { keepOnFailure = false }()
+
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithResourceTracker(context.Background(), tracker)
+	if err := createNewCluster(ctx, provider, options); err == nil {
+		t.Fatal("Expected createNewCluster to fail")
+	}
+	rollbackOrReport(tracker)
+
+	if instances := getInstances(t, provider, options.ClusterInfo); len(instances) != 3 {
+		t.Errorf("Expected all instances to be kept, got %d", len(instances))
+	}
+	if resources := tracker.Resources(); len(resources) != 9 {
+		t.Errorf("Expected 3 servers and 6 DNS records to be left behind, got %v", resources)
+	}
+}
+
+func TestClusterCreateResume(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := testClusterOptions(t, provider, 3)
+	options.SetupInstancePrefixes()
+	dir, err := ioutil.TempDir("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	executor.FailOn("systemctl restart gluon.service", errors.New("gluon failed"))
+	keepOnFailure = true
+	defer func() { keepOnFailure = false }()
+
+	op := providers.NewOperation(dir, providers.OperationCreateCluster)
+	op.Cluster = &options
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithOperation(providers.WithResourceTracker(context.Background(), tracker), op)
+	err = createNewCluster(ctx, provider, options)
+	if err == nil {
+		t.Fatal("Expected createNewCluster to fail")
+	}
+	failOperation(op, provider, tracker, err)
+
+	// Resume after the failure has been fixed
+	executor.ClearFailures()
+	resumed, err := providers.LoadOperation(dir, op.ID)
+	if err != nil {
+		t.Fatalf("Expected journal of failed operation to be kept: %v", err)
+	}
+	ctx = providers.WithOperation(context.Background(), resumed)
+	if _, err := resumeOperation(ctx, provider, resumed); err != nil {
+		t.Fatalf("resumeOperation failed: %v", err)
+	}
+	finishOperation(resumed)
+
+	instances := getInstances(t, provider, options.ClusterInfo)
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if len(m.GluonSetups) != 1 {
+			t.Errorf("Expected gluon setup to run once on %s, got %d", i.Name, len(m.GluonSetups))
+		}
+		if m.Reboots != 1 {
+			t.Errorf("Expected %s to be rebooted once, got %d", i.Name, m.Reboots)
+		}
+		if !m.IsActive("etcd2.service") || !m.IsActive("fleet.service") {
+			t.Errorf("Expected etcd & fleet to run on %s after the reboot", i.Name)
+		}
+	}
+	// Instances are rebooted one at a time, the next one only when the previous one is back
+	rebooting := ""
+	for _, cmd := range executor.Commands() {
+		parts := strings.SplitN(cmd, ": ", 2)
+		switch {
+		case parts[1] == "sudo shutdown -r now":
+			if rebooting != "" {
+				t.Errorf("%s rebooted before %s was back", parts[0], rebooting)
+			}
+			rebooting = parts[0]
+		case parts[0] == rebooting && (parts[1] == "systemctl is-active etcd2.service" || strings.HasPrefix(parts[1], "fleetctl list-machines")):
+			rebooting = ""
+		}
+	}
+	if records, _ := testDNS.ListDnsRecords("example.com"); len(records) != 6 {
+		t.Errorf("Expected 6 DNS records, got %v", records)
+	}
+	if members := executor.EtcdMembers(options.ClusterInfo.String()); len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %v", members)
+	}
+	if ops, _ := providers.ListOperations(dir); len(ops) != 0 {
+		t.Errorf("Expected journal to be removed, got %v", ops)
+	}
+}
+
+func TestInstanceCreate(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+
+	options := testInstanceOptions(provider, cluster, false)
+	instance, err := addInstance(context.Background(), provider, options, existing)
+	if err != nil {
+		t.Fatalf("addInstance failed: %v", err)
+	}
+
+	// The new instance is taken from the existing cluster
+	m, ok := executor.Machine(instance.Name)
+	if !ok {
+		t.Fatalf("No machine for new instance %s", instance.Name)
+	}
+	if m.Files["/etc/pulcy/vault.crt"] != "test-ca-cert" {
+		t.Errorf("Expected vault.crt to be copied from existing instance, got %q", m.Files["/etc/pulcy/vault.crt"])
+	}
+	if !strings.Contains(m.Files["/etc/pulcy/vault.env"], "VAULT_ADDR=https://vault.example.com:8200") {
+		t.Errorf("Expected vault address to be copied from existing instance, got %q", m.Files["/etc/pulcy/vault.env"])
+	}
+	if m.Reboots != 1 {
+		t.Errorf("Expected new instance to be rebooted once, got %d", m.Reboots)
+	}
+
+	// ETCD & cluster members are updated
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 4 {
+		t.Errorf("Expected 4 etcd members, got %d", len(members))
+	}
+	for _, i := range append(existing, instance) {
+		m, _ := executor.Machine(i.Name)
+		members := m.Files["/etc/pulcy/cluster-members"]
+		if strings.Count(members, "\n") != 4 || !strings.Contains(members, "="+instance.ClusterIP+"\n") {
+			t.Errorf("Expected 4 cluster members including the new instance on %s, got %q", i.Name, members)
+		}
+	}
+
+	records, _ := testDNS.ListDnsRecords("example.com")
+	found := false
+	for _, r := range records {
+		if r.Type == "A" && r.Name == instance.Name && r.Data == instance.LoadBalancerIPv4 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected A record for %s, got %v", instance.Name, records)
+	}
+}
+
+func TestInstanceCreateEtcdProxy(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+
+	options := testInstanceOptions(provider, cluster, true)
+	instance, err := addInstance(context.Background(), provider, options, existing)
+	if err != nil {
+		t.Fatalf("addInstance failed: %v", err)
+	}
+
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 3 {
+		t.Errorf("Expected etcd proxy not to be added to etcd, got %d members", len(members))
+	}
+	for _, i := range append(existing, instance) {
+		m, _ := executor.Machine(i.Name)
+		members := m.Files["/etc/pulcy/cluster-members"]
+		if !strings.Contains(members, "="+instance.ClusterIP+" etcd-proxy\n") {
+			t.Errorf("Expected new instance to be an etcd-proxy in cluster-members of %s, got %q", i.Name, members)
+		}
+	}
+}
+
+func TestInstanceDestroy(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	victim := instances[0]
+
+	info := providers.ClusterInstanceInfo{
+		ClusterInfo: cluster.ClusterInfo,
+		Prefix:      strings.SplitN(victim.Name, ".", 2)[0],
+	}
+	executor.AddFleetUnit(victim.Name, "web@1.service")
+	if err := removeInstance(context.Background(), provider, info, false); err != nil {
+		t.Fatalf("removeInstance failed: %v", err)
+	}
+
+	remaining := getInstances(t, provider, cluster.ClusterInfo)
+	if len(remaining) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(remaining))
+	}
+	if _, ok := executor.Machine(victim.Name); ok {
+		t.Errorf("Expected machine %s to be removed", victim.Name)
+	}
+	members := executor.EtcdMembers(cluster.ClusterInfo.String())
+	if len(members) != 2 {
+		t.Errorf("Expected 2 etcd members, got %d", len(members))
+	}
+	for _, member := range members {
+		if member.Name == victim.Name {
+			t.Errorf("Expected %s to be removed from etcd", victim.Name)
+		}
+	}
+	units := 0
+	for _, i := range remaining {
+		m, _ := executor.Machine(i.Name)
+		units += len(m.FleetUnits)
+	}
+	if units != 1 {
+		t.Errorf("Expected fleet unit to move to a remaining instance, got %d units", units)
+	}
+	for _, i := range remaining {
+		m, _ := executor.Machine(i.Name)
+		members := m.Files["/etc/pulcy/cluster-members"]
+		if strings.Count(members, "\n") != 2 || strings.Contains(members, "="+victim.ClusterIP) {
+			t.Errorf("Expected 2 cluster members without %s on %s, got %q", victim.Name, i.Name, members)
+		}
+	}
+	records, _ := testDNS.ListDnsRecords("example.com")
+	for _, r := range records {
+		if r.Name == victim.Name || r.Data == victim.LoadBalancerIPv4 {
+			t.Errorf("Expected DNS record %v to be removed", r)
+		}
+	}
+	if len(records) != 4 {
+		t.Errorf("Expected 4 DNS records, got %d", len(records))
+	}
+}
+
+func TestInstanceDestroyQuorum(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	victim := instances[0]
+	executor.SetEtcdMemberHealthy(cluster.ClusterInfo.String(), instances[1].Name, false)
+
+	info := providers.ClusterInstanceInfo{
+		ClusterInfo: cluster.ClusterInfo,
+		Prefix:      strings.SplitN(victim.Name, ".", 2)[0],
+	}
+	err := removeInstance(context.Background(), provider, info, false)
+	if !providers.IsQuorumError(err) {
+		t.Fatalf("Expected quorum error, got %v", err)
+	}
+	if len(getInstances(t, provider, cluster.ClusterInfo)) != 3 {
+		t.Errorf("Expected instance not to be deleted")
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %d", len(members))
+	}
+
+	if err := removeInstance(context.Background(), provider, info, true); err != nil {
+		t.Fatalf("removeInstance with force failed: %v", err)
+	}
+	if len(getInstances(t, provider, cluster.ClusterInfo)) != 2 {
+		t.Errorf("Expected instance to be deleted")
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 2 {
+		t.Errorf("Expected 2 etcd members, got %d", len(members))
+	}
+}
+
+func TestClusterScale(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+
+	// Grow
+	options := testInstanceOptions(provider, cluster, false)
+	created, err := growCluster(context.Background(), provider, options, existing, 2)
+	if err != nil {
+		t.Fatalf("growCluster failed: %v", err)
+	}
+	if len(created) != 2 {
+		t.Fatalf("Expected 2 new instances, got %d", len(created))
+	}
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 5 {
+		t.Fatalf("Expected 5 instances, got %d", len(instances))
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 5 {
+		t.Errorf("Expected 5 etcd members, got %d", len(members))
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 5 {
+			t.Errorf("Expected 5 cluster members on %s, got %d", i.Name, members)
+		}
+	}
+	for idx, i := range created {
+		m, _ := executor.Machine(i.Name)
+		if m.Reboots != 1 {
+			t.Errorf("Expected %s to be rebooted once, got %d", i.Name, m.Reboots)
+		}
+		// Indexes 4 & 5 follow the existing instances
+		expected := []string{"even=true", "odd=true"}[idx]
+		if !strings.Contains(m.FleetMetadata, expected) {
+			t.Errorf("Expected %s in fleet metadata of %s, got %q", expected, i.Name, m.FleetMetadata)
+		}
+	}
+
+	// Select victims: newest first, never the last load-balancer
+	victims, err := selectScaleVictims(context.Background(), instances, 4)
+	if err != nil {
+		t.Fatalf("selectScaleVictims failed: %v", err)
+	}
+	// The new instances are created in parallel, so their order is undefined
+	newNames := map[string]bool{created[0].Name: true, created[1].Name: true}
+	if !newNames[victims[0].Name] || !newNames[victims[1].Name] {
+		t.Errorf("Expected new instances to be selected first, got %v", victims)
+	}
+	if victims[2].Name != existing[2].Name || victims[3].Name != existing[1].Name {
+		t.Errorf("Expected newest existing instances to be selected next, got %v", victims)
+	}
+	if _, err := selectScaleVictims(context.Background(), instances, 5); err == nil {
+		t.Error("Expected removing the last load-balancer to fail")
+	}
+
+	// Shrink
+	victims, _ = selectScaleVictims(context.Background(), instances, 2)
+	if err := shrinkCluster(context.Background(), provider, cluster.ClusterInfo, victims, false); err != nil {
+		t.Fatalf("shrinkCluster failed: %v", err)
+	}
+	remaining := getInstances(t, provider, cluster.ClusterInfo)
+	if len(remaining) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(remaining))
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %d", len(members))
+	}
+	for _, i := range remaining {
+		for _, v := range victims {
+			if i.Name == v.Name {
+				t.Errorf("Expected %s to be destroyed", v.Name)
+			}
+		}
+	}
+}
+
+func TestClusterScaleRollback(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+
+	// The second new instance fails to join
+	executor.FailOnAfter("etcdctl member add", 1, errors.New("etcd failed"))
+	options := testInstanceOptions(provider, cluster, false)
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithResourceTracker(context.Background(), tracker)
+	created, err := growCluster(ctx, provider, options, existing, 2)
+	if err == nil {
+		t.Fatal("Expected growCluster to fail")
+	}
+	removed, _ := rollbackOrReport(tracker)
+	updateMembersAfterRollback(provider, cluster.ClusterInfo, removed)
+
+	// Only the instance that did not join is rolled back
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 4 {
+		t.Fatalf("Expected 4 instances, got %d", len(instances))
+	}
+	if _, ok := executor.Machine(created[0].Name); !ok {
+		t.Errorf("Expected joined instance %s to be kept", created[0].Name)
+	}
+	if _, ok := executor.Machine(created[1].Name); ok {
+		t.Errorf("Expected instance %s to be rolled back", created[1].Name)
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 4 {
+		t.Errorf("Expected 4 etcd members, got %d", len(members))
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 4 {
+			t.Errorf("Expected 4 cluster members on %s, got %d", i.Name, members)
+		}
+	}
+}
+
+func TestDryRunDoesNotTouchAnything(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := testClusterOptions(t, provider, 3)
+
+	plan := providers.Plan{}
+	if _, err := createClusterPlan(&plan, options); err != nil {
+		t.Fatalf("createClusterPlan failed: %v", err)
+	}
+	if len(plan.Actions) == 0 {
+		t.Error("Expected a non-empty plan")
+	}
+	if instances := getInstances(t, provider, options.ClusterInfo); len(instances) != 0 {
+		t.Errorf("Expected no instances, got %d", len(instances))
+	}
+	if commands := executor.Commands(); len(commands) != 0 {
+		t.Errorf("Expected no remote commands, got %v", commands)
+	}
+	if records, _ := testDNS.ListDnsRecords("example.com"); len(records) != 0 {
+		t.Errorf("Expected no DNS records, got %v", records)
+	}
+}
+
+func TestClusterExec(t *testing.T) {
+	_, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, options.ClusterInfo)
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		roles, prefixes []string
+		expected        int
+	}{
+		{nil, nil, 3},
+		{[]string{roleCore}, nil, 3},
+		{[]string{roleEtcdProxy}, nil, 0},
+		{nil, []string{strings.SplitN(instances[1].Name, ".", 2)[0]}, 1},
+		{[]string{roleLB}, []string{strings.SplitN(instances[2].Name, ".", 2)[0]}, 1},
+	} {
+		selected, err := selectInstances(ctx, instances, test.roles, test.prefixes)
+		if err != nil {
+			t.Fatalf("selectInstances failed: %v", err)
+		}
+		if len(selected) != test.expected {
+			t.Errorf("Expected %d instances for roles %v and prefixes %v, got %d", test.expected, test.roles, test.prefixes, len(selected))
+		}
+	}
+
+	for idx, r := range instances.Exec(ctx, log, "cat /etc/pulcy/cluster-id") {
+		if r.Instance.Name != instances[idx].Name {
+			t.Errorf("Expected result %d for %s, got %s", idx, instances[idx].Name, r.Instance.Name)
+		}
+		if r.Err != nil || r.Stdout != options.ID {
+			t.Errorf("Expected cluster ID on %s, got '%s' (%v)", r.Instance.Name, r.Stdout, r.Err)
+		}
+	}
+	for _, r := range instances.Exec(ctx, log, "cat /etc/no-such-file") {
+		if r.Err == nil {
+			t.Errorf("Expected command to fail on %s", r.Instance.Name)
+		}
+	}
+}
+
+func TestClusterCopy(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, options.ClusterInfo)
+	ctx := context.Background()
+
+	tmp, err := ioutil.TempDir("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	localPath := filepath.Join(tmp, "extra.env")
+	if err := ioutil.WriteFile(localPath, []byte("FOO=bar"), 0600); err != nil {
+		t.Fatalf("Cannot write %s: %v", localPath, err)
+	}
+
+	host, local, remote, upload := parseCopyArgs([]string{localPath, options.ClusterInfo.String() + ":/etc/pulcy"})
+	if host != options.ClusterInfo.String() || local != localPath || remote != "/etc/pulcy" || !upload {
+		t.Fatalf("Unexpected parse result %s %s %s %v", host, local, remote, upload)
+	}
+	for _, r := range instances.CopyTo(ctx, log, local, remote, providers.CopyOptions{Sudo: true}) {
+		if r.Err != nil {
+			t.Fatalf("Copy to %s failed: %v", r.Instance.Name, r.Err)
+		}
+		m, _ := executor.Machine(r.Instance.Name)
+		if m.Files["/etc/pulcy/extra.env"] != "FOO=bar" {
+			t.Errorf("Expected extra.env to be copied into /etc/pulcy on %s, got %q", r.Instance.Name, m.Files["/etc/pulcy/extra.env"])
+		}
+		if m.Modes["/etc/pulcy/extra.env"] != "0600" {
+			t.Errorf("Expected mode 0600 for extra.env on %s, got %q", r.Instance.Name, m.Modes["/etc/pulcy/extra.env"])
+		}
+	}
+
+	// Downloads are stored per instance
+	for _, r := range instances.CopyFrom(ctx, log, "/etc/pulcy", tmp, providers.CopyOptions{Recursive: true, Sudo: true}) {
+		if r.Err != nil {
+			t.Fatalf("Copy from %s failed: %v", r.Instance.Name, r.Err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(tmp, r.Instance.Name, "pulcy", "cluster-id"))
+		if err != nil || string(data) != options.ID {
+			t.Errorf("Expected cluster-id of %s to be downloaded, got %q (%v)", r.Instance.Name, string(data), err)
+		}
+		if info, err := os.Stat(filepath.Join(tmp, r.Instance.Name, "pulcy", "vault.env")); err != nil || info.Mode().Perm() != 0400 {
+			t.Errorf("Expected vault.env of %s to be downloaded with mode 0400, got %v", r.Instance.Name, err)
+		}
+	}
+}
+
+func TestClusterDiffRepair(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	options := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, options.ClusterInfo)
+	ctx := context.Background()
+	diffOptions := clusterDiffOptions{ClusterInfo: options.ClusterInfo}
+
+	drift, err := diffCluster(ctx, instances, diffOptions)
+	if err != nil {
+		t.Fatalf("diffCluster failed: %v", err)
+	}
+	if len(drift.Drifts) != 0 {
+		t.Fatalf("Expected no differences in a new cluster, got %v", drift.Drifts)
+	}
+
+	// Introduce drift
+	if err := instances[0].WriteFile(ctx, log, "/etc/pulcy/vault.env", "VAULT_ADDR=http://wrong", 0400); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	records, _ := testDNS.ListDnsRecords("example.com")
+	missing := records[0]
+	if err := testDNS.DeleteDnsRecord("example.com", missing.Type, missing.Name, missing.Data); err != nil {
+		t.Fatalf("DeleteDnsRecord failed: %v", err)
+	}
+	if err := testDNS.CreateDnsRecord("example.com", "A", instances[1].Name, "192.0.2.1"); err != nil {
+		t.Fatalf("CreateDnsRecord failed: %v", err)
+	}
+	// Records below the cluster name that do not belong to an instance are reported, but never removed
+	foreignName := "x." + options.ClusterInfo.String()
+	if err := testDNS.CreateDnsRecord("example.com", "A", foreignName, "192.0.2.2"); err != nil {
+		t.Fatalf("CreateDnsRecord failed: %v", err)
+	}
+	reboots := make(map[string]int)
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		reboots[i.Name] = m.Reboots
+	}
+
+	drift, err = diffCluster(ctx, instances, diffOptions)
+	if err != nil {
+		t.Fatalf("diffCluster failed: %v", err)
+	}
+	if len(drift.Drifts) != 4 {
+		t.Fatalf("Expected 4 differences, got %v", drift.Drifts)
+	}
+
+	if err := instances.Repair(ctx, log, drift.State, drift.Drifts, testDNS, options.ClusterInfo, drift.IsLoadBalancer); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	drift, err = diffCluster(ctx, instances, diffOptions)
+	if err != nil {
+		t.Fatalf("diffCluster failed: %v", err)
+	}
+	if len(drift.Drifts) != 1 || drift.Drifts[0].Kind != providers.DriftKindForeignDnsRecord || drift.Drifts[0].Target != foreignName {
+		t.Errorf("Expected only the foreign record after repair, got %v", drift.Drifts)
+	}
+	for _, i := range instances {
+		if m, _ := executor.Machine(i.Name); m.Reboots != reboots[i.Name] {
+			t.Errorf("Expected %s not to be rebooted by repair", i.Name)
+		}
+	}
+}
+
+func TestClusterUpgrade(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+	ctx := context.Background()
+
+	options := testInstanceOptions(provider, cluster, false)
+	options.ImageID = "coreos-beta"
+	replacements, err := newReplacements(ctx, provider, options, existing)
+	if err != nil {
+		t.Fatalf("newReplacements failed: %v", err)
+	}
+	if len(replacements) != 3 {
+		t.Fatalf("Expected 3 replacements, got %d", len(replacements))
+	}
+	for idx, r := range replacements {
+		if r.Instance != existing[idx].Name {
+			t.Errorf("Expected replacement %d to replace %s, got %s", idx, existing[idx].Name, r.Instance)
+		}
+		if !r.Options.RoleLoadBalancer || !r.Options.RoleCore || !r.Options.DelayClusterDNS {
+			t.Errorf("Expected roles of %s to be kept, got %+v", r.Instance, r)
+		}
+	}
+
+	// Pause after the first instance
+	op := providers.NewOperation("", providers.OperationUpgradeCluster)
+	op.Upgrade = &providers.UpgradeOptions{Replacements: replacements, Pause: true}
+	ctx = providers.WithOperation(ctx, op)
+	completed, err := replaceInstances(ctx, provider, *op.Upgrade)
+	if err != nil {
+		t.Fatalf("replaceInstances failed: %v", err)
+	}
+	if completed {
+		t.Fatal("Expected upgrade to pause")
+	}
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 3 || len(removeFromList(instances, existing[0].Name)) != 3 {
+		t.Fatalf("Expected %s to be replaced, got %v", existing[0].Name, instances)
+	}
+
+	// Continue
+	op.Upgrade.Pause = false
+	completed, err = replaceInstances(ctx, provider, *op.Upgrade)
+	if err != nil {
+		t.Fatalf("replaceInstances failed: %v", err)
+	}
+	if !completed {
+		t.Fatal("Expected upgrade to complete")
+	}
+	instances = getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+	newNames := make(map[string]bool)
+	for _, r := range replacements {
+		newNames[r.Options.InstanceName] = true
+	}
+	newIPs := make(map[string]bool)
+	for _, i := range instances {
+		if !newNames[i.Name] {
+			t.Errorf("Expected %s to be replaced", i.Name)
+		}
+		newIPs[i.LoadBalancerIPv4] = true
+		m, _ := executor.Machine(i.Name)
+		if !strings.Contains(m.FleetMetadata, "lb=true") || !strings.Contains(m.FleetMetadata, "core=true") {
+			t.Errorf("Expected roles in fleet metadata of %s, got %q", i.Name, m.FleetMetadata)
+		}
+	}
+	members := executor.EtcdMembers(cluster.ClusterInfo.String())
+	if len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %d", len(members))
+	}
+	records, _ := testDNS.ListDnsRecords("example.com")
+	if len(records) != 6 {
+		t.Errorf("Expected 6 DNS records, got %d", len(records))
+	}
+	for _, r := range records {
+		if !newIPs[r.Data] {
+			t.Errorf("Expected DNS record %s %s to point to a new instance, got %s", r.Type, r.Name, r.Data)
+		}
+	}
+}
+
+func TestClusterUpgradeTincClusterIPs(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	provider.TincClusterIPs = true
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+	ctx := context.Background()
+
+	oldIPs := make(map[string]string)
+	for _, i := range existing {
+		oldIPs[i.Name] = i.ClusterIP
+	}
+	replacements, err := newReplacements(ctx, provider, testInstanceOptions(provider, cluster, false), existing)
+	if err != nil {
+		t.Fatalf("newReplacements failed: %v", err)
+	}
+	// Every new instance needs a tinc address of its own, since it joins before the old instance is destroyed
+	for _, r := range replacements {
+		var oldIndex int
+		fmt.Sscanf(oldIPs[r.Instance], "192.168.35.%d", &oldIndex)
+		if r.Options.TincIpv4 == oldIPs[r.Instance] || r.Options.InstanceIndex%2 != oldIndex%2 {
+			t.Errorf("Expected replacement of %s (%s) to get another tinc address of the same kind, got %s", r.Instance, oldIPs[r.Instance], r.Options.TincIpv4)
+		}
+	}
+
+	completed, err := replaceInstances(ctx, provider, providers.UpgradeOptions{Replacements: replacements})
+	if err != nil {
+		t.Fatalf("replaceInstances failed: %v", err)
+	}
+	if !completed {
+		t.Fatal("Expected upgrade to complete")
+	}
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+	for _, i := range instances {
+		if _, found := oldIPs[i.Name]; found {
+			t.Errorf("Expected %s to be replaced", i.Name)
+		}
+		m, _ := executor.Machine(i.Name)
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 3 {
+			t.Errorf("Expected 3 cluster members on %s, got %d", i.Name, members)
+		}
+	}
+	if members := executor.EtcdMembers(cluster.ClusterInfo.String()); len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %d", len(members))
+	}
+}
+
+func TestClusterUpgradeRollback(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	existing := getInstances(t, provider, cluster.ClusterInfo)
+	dir, err := ioutil.TempDir("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	replacements, err := newReplacements(context.Background(), provider, testInstanceOptions(provider, cluster, false), existing)
+	if err != nil {
+		t.Fatalf("newReplacements failed: %v", err)
+	}
+
+	// Draining the old instance fails after the new instance has joined
+	executor.FailOn("systemctl stop fleet.socket", errors.New("fleet failed"))
+	op := providers.NewOperation(dir, providers.OperationUpgradeCluster)
+	op.Upgrade = &providers.UpgradeOptions{Replacements: replacements}
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithOperation(providers.WithResourceTracker(context.Background(), tracker), op)
+	_, err = replaceInstances(ctx, provider, *op.Upgrade)
+	if err == nil {
+		t.Fatal("Expected replaceInstances to fail")
+	}
+	failOperation(op, provider, tracker, err)
+
+	// The new instance is kept, next to the old one
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	if len(instances) != 4 {
+		t.Fatalf("Expected 4 instances, got %d", len(instances))
+	}
+	if _, ok := executor.Machine(replacements[0].Options.InstanceName); !ok {
+		t.Errorf("Expected new instance %s to be kept", replacements[0].Options.InstanceName)
+	}
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 4 {
+			t.Errorf("Expected 4 cluster members on %s, got %d", i.Name, members)
+		}
+	}
+}
+
+func TestClusterOSUpdate(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	ctx := context.Background()
+
+	status, locks, err := instances.GetOSStatus(ctx, log)
+	if err != nil {
+		t.Fatalf("GetOSStatus failed: %v", err)
+	}
+	if locks.Available != 1 || locks.Max != 1 {
+		t.Errorf("Expected 1 of 1 reboot locks available, got %+v", locks)
+	}
+	for _, s := range status {
+		if s.Err != nil || s.Version != "899.1.0" || s.UpdateEngine.State() != "idle" || s.UpdateConfig.RebootStrategy != defaultRebootStrategy {
+			t.Errorf("Unexpected OS status of %s: %+v", s.Instance.Name, s)
+		}
+	}
+
+	// Only the second instance finds an update
+	executor.SetOSUpdate(instances[1].Name, "1010.5.0")
+	reboots := make(map[string]int)
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		reboots[i.Name] = m.Reboots
+	}
+	config := providers.UpdateConfig{RebootStrategy: "reboot", RebootWindowStart: "Thu 04:00", RebootWindowLength: "1h"}
+	rebooted, err := updateOS(ctx, provider, instances, config, false)
+	if err != nil {
+		t.Fatalf("updateOS failed: %v", err)
+	}
+	if rebooted != 1 {
+		t.Errorf("Expected 1 instance to be rebooted, got %d", rebooted)
+	}
+	if holder := executor.RebootLockHolder(cluster.ClusterInfo.String()); holder != "" {
+		t.Errorf("Expected reboot lock to be released, held by %s", holder)
+	}
+	for idx, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		expected := reboots[i.Name]
+		if idx == 1 {
+			expected++
+		}
+		if m.Reboots != expected {
+			t.Errorf("Expected %d reboots of %s, got %d", expected, i.Name, m.Reboots)
+		}
+		if conf := m.Files["/etc/coreos/update.conf"]; !strings.Contains(conf, "GROUP=stable\n") || !strings.Contains(conf, "REBOOT_STRATEGY=reboot\n") {
+			t.Errorf("Expected reboot strategy to be changed & group to be kept on %s, got %q", i.Name, conf)
+		}
+		if m.Restarts["locksmithd.service"] != 1 {
+			t.Errorf("Expected locksmithd to be restarted on %s", i.Name)
+		}
+	}
+
+	status, _, err = instances.GetOSStatus(ctx, log)
+	if err != nil {
+		t.Fatalf("GetOSStatus failed: %v", err)
+	}
+	if status[1].Version != "1010.5.0" {
+		t.Errorf("Expected %s to run the new version, got %s", instances[1].Name, status[1].Version)
+	}
+	if window := status[0].UpdateConfig.Window(); window != "Thu 04:00 (1h)" {
+		t.Errorf("Expected reboot window 'Thu 04:00 (1h)', got '%s'", window)
+	}
+}
+
+func TestClusterOSUpdateSkipsOtherOS(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	ctx := context.Background()
+
+	// Only CoreOS instances have an update engine & locksmith
+	instances[0].OS = providers.OSNameUbuntu
+	status, _, err := instances.GetOSStatus(ctx, log)
+	if err != nil {
+		t.Fatalf("GetOSStatus failed: %v", err)
+	}
+	for idx, s := range status {
+		if s.Err != nil || s.Skipped != (idx == 0) {
+			t.Errorf("Expected OS status of %s to be skipped=%v, got %+v", s.Instance.Name, idx == 0, s)
+		}
+	}
+
+	executor.SetOSUpdate(instances[0].Name, "1010.5.0")
+	executor.SetOSUpdate(instances[1].Name, "1010.5.0")
+	m, _ := executor.Machine(instances[0].Name)
+	reboots, conf := m.Reboots, m.Files["/etc/coreos/update.conf"]
+	config := providers.UpdateConfig{RebootStrategy: "reboot"}
+	rebooted, err := updateOS(ctx, provider, instances, config, false)
+	if err != nil {
+		t.Fatalf("updateOS failed: %v", err)
+	}
+	if rebooted != 1 {
+		t.Errorf("Expected 1 instance to be rebooted, got %d", rebooted)
+	}
+	m, _ = executor.Machine(instances[0].Name)
+	if m.Reboots != reboots || m.Files["/etc/coreos/update.conf"] != conf || m.Restarts["locksmithd.service"] != 0 {
+		t.Errorf("Expected %s to be skipped", instances[0].Name)
+	}
+}
+
+func TestClusterGluonUpgrade(t *testing.T) {
+	executor, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	sort.Sort(sortByName(instances))
+	ctx := context.Background()
+
+	// The first instance was set up by an older version of quark
+	if _, err := instances[0].Exec(ctx, log, "sudo rm -f /etc/pulcy/gluon.args"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	fleetMetadata := make(map[string]string)
+	for _, i := range instances {
+		m, _ := executor.Machine(i.Name)
+		fleetMetadata[i.Name] = m.FleetMetadata
+	}
+
+	options := providers.GluonSetupOptions{
+		GluonImage:              "pulcy/gluon:0.15.0",
+		PrivateRegistryUrl:      cluster.PrivateRegistryUrl,
+		PrivateRegistryUserName: cluster.PrivateRegistryUserName,
+		PrivateRegistryPassword: cluster.PrivateRegistryPassword,
+	}
+	versions, err := upgradeGluon(ctx, instances, options)
+	if err != nil {
+		t.Fatalf("upgradeGluon failed: %v", err)
+	}
+	if len(versions) != len(instances) {
+		t.Fatalf("Expected %d versions, got %d", len(instances), len(versions))
+	}
+	for idx, i := range instances {
+		if v := versions[idx]; v.Instance.Name != i.Name || v.Version != "gluon 0.15.0, build fake" {
+			t.Errorf("Unexpected gluon version of %s: %+v", i.Name, v)
+		}
+		m, _ := executor.Machine(i.Name)
+		if m.Files["/home/core/bin/gluon"] != options.GluonImage {
+			t.Errorf("Expected new gluon to be downloaded on %s", i.Name)
+		}
+		if len(m.GluonSetups) != 2 {
+			t.Fatalf("Expected 2 gluon setups on %s, got %d", i.Name, len(m.GluonSetups))
+		}
+		for _, arg := range []string{"--gluon-image=pulcy/gluon:0.15.0", "--private-ip=" + i.ClusterIP, "--private-registry-password=secret"} {
+			if !strings.Contains(m.GluonSetups[1], arg) {
+				t.Errorf("Expected gluon setup on %s to contain %s, got %q", i.Name, arg, m.GluonSetups[1])
+			}
+		}
+		if m.FleetMetadata == "" || !sameFleetMetadata(m.FleetMetadata, fleetMetadata[i.Name]) {
+			t.Errorf("Expected fleet metadata of %s to be kept, got %q instead of %q", i.Name, m.FleetMetadata, fleetMetadata[i.Name])
+		}
+		if args := m.Files["/etc/pulcy/gluon.args"]; !strings.HasPrefix(args, "--gluon-image=pulcy/gluon:0.15.0\n") || m.Modes["/etc/pulcy/gluon.args"] != "0400" {
+			t.Errorf("Expected gluon arguments to be saved on %s, got %q (%s)", i.Name, args, m.Modes["/etc/pulcy/gluon.args"])
+		}
+		if m.Restarts["gluon.service"] == 0 {
+			t.Errorf("Expected gluon to be restarted on %s", i.Name)
+		}
+	}
+
+	// An unhealthy gluon stops the upgrade at the first instance
+	previous := providers.SetTimeouts(providers.Timeouts{Health: 10 * time.Millisecond})
+	defer providers.SetTimeouts(previous)
+	executor.FailOn("systemctl is-active gluon.service", errors.New("gluon failed"))
+	options.GluonImage = "pulcy/gluon:0.16.0"
+	if versions, err := upgradeGluon(ctx, instances, options); err == nil || len(versions) != 0 {
+		t.Fatalf("Expected upgradeGluon to fail at the first instance, got %d versions (%v)", len(versions), err)
+	}
+	if m, _ := executor.Machine(instances[1].Name); m.Files["/home/core/bin/gluon"] != "pulcy/gluon:0.15.0" {
+		t.Errorf("Expected %s not to be upgraded after a failure", instances[1].Name)
+	}
+}
+
+// sameFleetMetadata returns true if both fleet metadata strings contain the same entries.
+func sameFleetMetadata(a, b string) bool {
+	x, y := strings.Split(a, ","), strings.Split(b, ",")
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, ",") == strings.Join(y, ",")
+}
+
+func TestClusterApplyGroups(t *testing.T) {
+	_, provider, restore := setupFake()
+	defer restore()
+	cluster := createTestCluster(t, provider, 3)
+	instances := getInstances(t, provider, cluster.ClusterInfo)
+	ctx := context.Background()
+
+	spec := ClusterSpec{
+		Version: clusterSpecVersion,
+		Domain:  cluster.Domain,
+		Name:    cluster.Name,
+		InstanceGroups: []InstanceGroupSpec{
+			{Name: "core", Count: 2, Roles: []string{roleCore, roleLB}},
+			{Name: "workers", Count: 1, TypeID: "large", Roles: []string{roleEtcdProxy}},
+		},
+	}
+	state, err := newClusterApplyState(ctx, provider, spec, instances)
+	if err != nil {
+		t.Fatalf("newClusterApplyState failed: %v", err)
+	}
+	if len(state.Groups[0].Members) != 2 || state.Groups[0].Missing != 0 || state.Groups[1].Missing != 1 {
+		t.Fatalf("Unexpected groups %+v", state.Groups)
+	}
+	if len(state.Victims) != 1 || !strings.Contains(state.Reasons[state.Victims[0].Name], "surplus") {
+		t.Fatalf("Expected 1 surplus instance to be destroyed, got %v (%v)", state.Victims, state.Reasons)
+	}
+
+	// Apply the state: grow first, then shrink
+	workerOptions, err := groupInstanceOptions(provider, cluster, state.Groups[1])
+	if err != nil {
+		t.Fatalf("groupInstanceOptions failed: %v", err)
+	}
+	created, err := growCluster(ctx, provider, workerOptions, instances, state.Groups[1].Missing)
+	if err != nil {
+		t.Fatalf("growCluster failed: %v", err)
+	}
+	if len(created) != 1 || created[0].TypeID != "large" {
+		t.Errorf("Expected 1 instance of type large, got %+v", created)
+	}
+	if err := shrinkCluster(ctx, provider, cluster.ClusterInfo, state.Victims, false); err != nil {
+		t.Fatalf("shrinkCluster failed: %v", err)
+	}
+	instances = getInstances(t, provider, cluster.ClusterInfo)
+	if state, err := newClusterApplyState(ctx, provider, spec, instances); err != nil || !state.IsEmpty() {
+		t.Errorf("Expected cluster to match the spec, got %+v (%v)", state, err)
+	}
+
+	// A changed type replaces the instances of the group
+	spec.InstanceGroups[1].TypeID = "xlarge"
+	state, err = newClusterApplyState(ctx, provider, spec, instances)
+	if err != nil {
+		t.Fatalf("newClusterApplyState failed: %v", err)
+	}
+	if state.Groups[1].Missing != 1 || len(state.Victims) != 1 || state.Victims[0].Name != created[0].Name {
+		t.Fatalf("Expected %s to be replaced, got %+v", created[0].Name, state)
+	}
+	if reason := state.Reasons[created[0].Name]; !strings.Contains(reason, "type large, expected xlarge") {
+		t.Errorf("Unexpected reason '%s'", reason)
+	}
+
+	// Groups without a load-balancer role never remove the last load-balancer instance
+	spec.InstanceGroups[0].Count = 1
+	spec.InstanceGroups[1].TypeID = "large"
+	state, err = newClusterApplyState(ctx, provider, spec, instances)
+	if err != nil {
+		t.Fatalf("newClusterApplyState failed: %v", err)
+	}
+	if len(state.Victims) != 1 || state.Victims[0].Name == created[0].Name {
+		t.Errorf("Expected 1 core instance to be destroyed, got %v", state.Victims)
+	}
+}
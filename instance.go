@@ -16,6 +16,8 @@ package main
 
 import (
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
 )
 
 var (
@@ -28,3 +30,19 @@ var (
 func init() {
 	cmdMain.AddCommand(cmdInstance)
 }
+
+// findInstance returns the instance with given info, exiting when it does not exist.
+func findInstance(provider providers.CloudProvider, info providers.ClusterInstanceInfo) providers.ClusterInstance {
+	instances, err := provider.GetInstances(info.ClusterInfo)
+	if err != nil {
+		Exitf("Failed to list instances: %v\n", err)
+	}
+	fullName := info.String()
+	for _, i := range instances {
+		if i.Name == fullName {
+			return i
+		}
+	}
+	Exitf("Instance %s not found\n", fullName)
+	return providers.ClusterInstance{}
+}
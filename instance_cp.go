@@ -0,0 +1,100 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdCopyInstance = &cobra.Command{
+		Short: "Copy files to or from an instance",
+		Long:  "Copy files to or from an instance. The remote side is written as <prefix.name.domain>:<path>",
+		Use:   "cp",
+		Run:   copyInstance,
+	}
+
+	copyInstanceFlags providers.CopyOptions
+)
+
+func init() {
+	cmdCopyInstance.Flags().BoolVarP(&copyInstanceFlags.Recursive, "recursive", "r", false, "Copy directories recursively")
+	cmdCopyInstance.Flags().BoolVar(&copyInstanceFlags.Preserve, "preserve", false, "Preserve modification times and ownership")
+	cmdCopyInstance.Flags().BoolVar(&copyInstanceFlags.Sudo, "sudo", false, "Access the files on the instance as root")
+	cmdInstance.AddCommand(cmdCopyInstance)
+}
+
+func copyInstance(cmd *cobra.Command, args []string) {
+	host, localPath, remotePath, upload := parseCopyArgs(args)
+	if strings.Count(host, ".") < 2 {
+		Exitf("Please specify an instance as <prefix.name.domain>:<path>\n")
+	}
+	var info providers.ClusterInstanceInfo
+	clusterInstanceInfoFromArgs(&info, []string{host})
+
+	provider := newProvider()
+	info.ClusterInfo = provider.ClusterDefaults(info.ClusterInfo)
+	instance := findInstance(provider, info)
+
+	ctx, _ := newContext()
+	if upload {
+		if err := instance.CopyTo(ctx, log, localPath, remotePath, copyInstanceFlags); err != nil {
+			Exitf("Failed to copy %s to %s: %v\n", localPath, instance.Name, err)
+		}
+	} else {
+		if err := instance.CopyFrom(ctx, log, remotePath, localPath, copyInstanceFlags); err != nil {
+			Exitf("Failed to copy %s from %s: %v\n", remotePath, instance.Name, err)
+		}
+	}
+}
+
+// parseCopyArgs splits the source & destination arguments of a copy command into
+// the remote host, the local path and the remote path.
+// Upload is set when the destination is the remote side.
+func parseCopyArgs(args []string) (host, localPath, remotePath string, upload bool) {
+	if len(args) != 2 {
+		Exitf("Please specify a source and a destination\n")
+	}
+	srcHost, srcPath, srcRemote := parseRemotePath(args[0])
+	dstHost, dstPath, dstRemote := parseRemotePath(args[1])
+	switch {
+	case srcRemote && dstRemote:
+		Exitf("Copying between instances is not supported\n")
+	case srcRemote:
+		return srcHost, args[1], srcPath, false
+	case dstRemote:
+		return dstHost, args[0], dstPath, true
+	}
+	Exitf("Please specify the remote side as <host>:<path>\n")
+	return "", "", "", false
+}
+
+// parseRemotePath splits an argument of the form <host>:<path>.
+// Arguments without a colon, or with a slash before the colon, are local paths.
+func parseRemotePath(arg string) (host, path string, ok bool) {
+	idx := strings.Index(arg, ":")
+	if idx <= 0 || strings.Contains(arg[:idx], "/") {
+		return "", "", false
+	}
+	path = arg[idx+1:]
+	if path == "" {
+		path = "."
+	}
+	return arg[:idx], path, true
+}
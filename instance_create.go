@@ -16,6 +16,7 @@ package main
 
 import (
 	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 )
@@ -46,22 +47,24 @@ func init() {
 	cmdCreateInstance.Flags().BoolVar(&createInstanceFlags.EtcdProxy, "etcd-proxy", false, "If set, the new instance will be an ETCD proxy")
 	cmdCreateInstance.Flags().BoolVar(&createInstanceFlags.RoleCore, "role-core", false, "If set, the new instance will get `core=true` metadata")
 	cmdCreateInstance.Flags().BoolVar(&createInstanceFlags.RoleLoadBalancer, "role-lb", false, "If set, the new instance will get `lb=true` metadata and register with cluster name in DNS")
+	cmdCreateInstance.Flags().BoolVar(&createInstanceFlags.PrivateOnly, "private-only", false, "If set, the new instance gets no public IP address and is reached through a load-balancer instance or the jump host")
 	cmdCreateInstance.Flags().IntVar(&createInstanceFlags.InstanceIndex, "index", 0, "Used to create `odd=true` or `even=true` metadata")
+	addDryRunFlag(cmdCreateInstance)
+	addKeepOnFailureFlag(cmdCreateInstance)
 	cmdInstance.AddCommand(cmdCreateInstance)
 }
 
 func createInstance(cmd *cobra.Command, args []string) {
 	clusterInfoFromArgs(&createInstanceFlags.ClusterInfo, args)
 
-	provider := newProvider()
+	required := []providers.Capability{providers.CapabilityInstance}
+	if createInstanceFlags.PrivateOnly {
+		required = append(required, providers.CapabilityPrivateOnly)
+	}
+	provider := newProvider(required...)
 	createInstanceFlags = provider.CreateInstanceDefaults(createInstanceFlags)
 	createInstanceFlags.SetupNames("", createInstanceFlags.Name, createInstanceFlags.Domain)
 
-	// Validate
-	if err := createInstanceFlags.Validate(); err != nil {
-		Exitf("Create failed: %s\n", err.Error())
-	}
-
 	// See if there are already instances for the given cluster
 	instances, err := provider.GetInstances(createInstanceFlags.ClusterInfo)
 	if err != nil {
@@ -71,74 +74,164 @@ func createInstance(cmd *cobra.Command, args []string) {
 		Exitf("Cluster %s.%s does not exist.\n", createInstanceFlags.Name, createInstanceFlags.Domain)
 	}
 
+	// Show plan only
+	if dryRun {
+		if err := createInstanceFlags.InstanceConfig.Validate(); err != nil {
+			Exitf("Create failed: %s\n", err.Error())
+		}
+		plan := providers.Plan{}
+		addInstancePlan(&plan, createInstanceFlags, instances)
+		printPlan(plan)
+		return
+	}
+
+	// Create
+	op := newOperation(providers.OperationCreateInstance)
+	op.Instance = &createInstanceFlags
+	if err := op.Save(); err != nil {
+		Exitf("Failed to save operation journal: %v\n", err)
+	}
+	ctx, tracker := newContext()
+	ctx = providers.WithOperation(ctx, op)
+	if _, err := addInstance(ctx, provider, createInstanceFlags, instances); err != nil {
+		failOperation(op, provider, tracker, err)
+		Exitf("Failed to create new instance: %v\n", err)
+	}
+	finishOperation(op)
+
+	Infof("Instance created\n")
+}
+
+// addInstance creates a new instance in the cluster formed by the given (existing) instances.
+// Cluster wide settings (cluster ID & vault) are taken from the existing instances.
+// The new instance is added to ETCD (unless it is a proxy), setup and rebooted and all existing
+// members are updated.
+func addInstance(ctx context.Context, provider providers.CloudProvider, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList) (providers.ClusterInstance, error) {
+	options, err := clusterInstanceOptions(ctx, options, instances)
+	if err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+	instance, err := createClusterInstance(ctx, provider, options)
+	if err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+	if err := joinInstance(ctx, provider, options, instance, instances, nil); err != nil {
+		return instance, maskAny(err)
+	}
+	return instance, nil
+}
+
+// clusterInstanceOptions completes the given options with the cluster wide settings
+// (cluster ID & vault) of the given existing instances.
+func clusterInstanceOptions(ctx context.Context, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList) (providers.CreateInstanceOptions, error) {
 	// Fetch cluster ID
-	clusterID, err := instances[0].GetClusterID(log)
+	clusterID, err := instances[0].GetClusterID(ctx, log)
 	if err != nil {
-		Exitf("Failed to get cluster-id: %v\n", err)
+		return options, maskAny(err)
 	}
-	createInstanceFlags.ID = clusterID
+	options.ID = clusterID
 
 	// Fetch vault address
-	vaultAddr, err := instances[0].GetVaultAddr(log)
+	vaultAddr, err := instances[0].GetVaultAddr(ctx, log)
 	if err != nil {
-		Exitf("Failed to get vault-addr: %v\n", err)
+		return options, maskAny(err)
 	}
-	createInstanceFlags.VaultAddress = vaultAddr
+	options.VaultAddress = vaultAddr
 
 	// Fetch vault CA certificate
-	vaultCACert, err := instances[0].GetVaultCrt(log)
+	vaultCACert, err := instances[0].GetVaultCrt(ctx, log)
 	if err != nil {
-		Exitf("Failed to get vault-cacert: %v\n", err)
+		return options, maskAny(err)
 	}
-	createInstanceFlags.VaultCertificate = vaultCACert
+	options.VaultCertificate = vaultCACert
 
-	// Create
-	instance, err := provider.CreateInstance(log, createInstanceFlags, newDnsProvider())
+	return options, nil
+}
+
+// createClusterInstance validates the given options and creates the instance.
+func createClusterInstance(ctx context.Context, provider providers.CloudProvider, options providers.CreateInstanceOptions) (providers.ClusterInstance, error) {
+	// Validate
+	if err := options.Validate(); err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+
+	// Create (forgetting the host key of an earlier instance with the same name)
+	if err := providers.RunStep(ctx, log, options.InstanceName, "forget-host-key", func() error {
+		return maskAny(knownHosts.Forget(options.InstanceName))
+	}); err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+	instance, err := providers.CreateInstanceOnce(ctx, log, provider, options, newDnsProvider())
 	if err != nil {
-		Exitf("Failed to create new instance: %v\n", err)
+		return providers.ClusterInstance{}, maskAny(err)
 	}
+	return instance, nil
+}
 
+// joinInstance adds the given new instance to the cluster formed by the given (existing) instances.
+// The new instance is added to ETCD (unless it is a proxy), setup and rebooted and all existing
+// members are updated. It returns when the new instance has joined ETCD & fleet.
+// isEtcdProxy (can be nil) tells which of the existing instances are ETCD proxies.
+func joinInstance(ctx context.Context, provider providers.CloudProvider, options providers.CreateInstanceOptions, instance providers.ClusterInstance, instances providers.ClusterInstanceList, isEtcdProxy func(providers.ClusterInstance) bool) error {
 	// Add new instance to ETCD (if not a proxy)
-	if !createInstanceFlags.EtcdProxy {
-		newMachineID, err := instance.GetMachineID(log)
+	if !options.EtcdProxy {
+		newMachineID, err := instance.GetMachineID(ctx, log)
 		if err != nil {
-			Exitf("Failed to get machine ID: %v\n", err)
+			return maskAny(err)
 		}
-		if err := instances[0].AddEtcdMember(log, newMachineID, instance.ClusterIP); err != nil {
-			Exitf("Failed to add new instance to etcd: %v\n", err)
+		if err := instances[0].AddEtcdMember(ctx, log, newMachineID, instance.ClusterIP); err != nil {
+			return maskAny(err)
 		}
 	}
 
-	// Add new instance to list
-	instances = append(instances, instance)
-
-	// Load cluster-members data
-	isEtcdProxy := func(i providers.ClusterInstance) bool {
-		return createInstanceFlags.EtcdProxy && (i.ClusterIP == instance.ClusterIP)
+	// Load cluster-members data, including the new instance
+	all := append(append(providers.ClusterInstanceList{}, instances...), instance)
+	isProxy := func(i providers.ClusterInstance) bool {
+		if i.ClusterIP == instance.ClusterIP {
+			return options.EtcdProxy
+		}
+		return isEtcdProxy != nil && isEtcdProxy(i)
 	}
-	clusterMembers, err := instances.AsClusterMemberList(log, isEtcdProxy)
+	clusterMembers, err := all.AsClusterMemberList(ctx, log, isProxy)
 	if err != nil {
-		Exitf("Failed to convert instance list to member list: %v\n", err)
+		return maskAny(err)
 	}
 
 	// Perform initial setup on new instance
 	iso := providers.InitialSetupOptions{
 		ClusterMembers: clusterMembers,
-		FleetMetadata:  createInstanceFlags.CreateFleetMetadata(createInstanceFlags.InstanceIndex),
+		FleetMetadata:  options.CreateFleetMetadata(options.InstanceIndex),
 	}
-	if err := instance.InitialSetup(log, createInstanceFlags, iso, provider); err != nil {
-		Exitf("Failed to perform initial instance setup: %v\n", err)
+	if err := instance.InitialSetup(ctx, log, options, iso, provider); err != nil {
+		return maskAny(err)
 	}
 
 	// Update existing members
-	if err := providers.UpdateClusterMembers(log, createInstanceFlags.ClusterInfo, false, isEtcdProxy, provider); err != nil {
-		Exitf("Failed to update cluster members: %v\n", err)
+	if err := instances.UpdateClusterMembers(ctx, log, clusterMembers, providers.RebootNone, provider); err != nil {
+		return maskAny(err)
 	}
 
 	// Reboot new instance
-	if err := provider.RebootInstance(instance); err != nil {
-		Exitf("Failed to reboot new instance: %v\n", err)
+	if err := providers.RunStep(ctx, log, instance.Name, "reboot", func() error {
+		return maskAny(instance.RebootAndWait(ctx, log, options.EtcdProxy, provider))
+	}); err != nil {
+		return maskAny(err)
 	}
 
-	Infof("Instance created\n")
+	return nil
+}
+
+// addInstancePlan adds all actions performed by addInstance to the given plan.
+func addInstancePlan(plan *providers.Plan, options providers.CreateInstanceOptions, instances providers.ClusterInstanceList) {
+	iso := providers.InitialSetupOptions{
+		FleetMetadata: options.CreateFleetMetadata(options.InstanceIndex),
+	}
+	plan.AddCreateInstance(options, iso)
+	if options.EtcdProxy {
+		plan.Add(providers.PlanKindEtcd, options.InstanceName, "Join as proxy")
+	} else {
+		plan.Add(providers.PlanKindEtcd, options.InstanceName, "Add as member (via %s)", instances[0].Name)
+	}
+	plan.AddUpdateClusterMembers(instances, providers.RebootNone)
+	plan.Add(providers.PlanKindServer, options.InstanceName, "Reboot instance & wait until it joined etcd & fleet")
 }
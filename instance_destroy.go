@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 )
@@ -31,19 +32,23 @@ var (
 	}
 
 	destroyInstanceFlags providers.ClusterInstanceInfo
+	destroyInstanceForce bool
 )
 
 func init() {
 	cmdDestroyInstance.Flags().StringVar(&destroyInstanceFlags.Domain, "domain", defaultDomain(), "Cluster domain")
 	cmdDestroyInstance.Flags().StringVar(&destroyInstanceFlags.Name, "name", "", "Cluster name")
 	cmdDestroyInstance.Flags().StringVar(&destroyInstanceFlags.Prefix, "prefix", "", "Instance prefix name")
+	cmdDestroyInstance.Flags().BoolVar(&destroyInstanceForce, "force", false, "Destroy the instance even when it cannot be drained or etcd would lose its quorum")
+	addDryRunFlag(cmdDestroyInstance)
+	addConfirmClusterFlag(cmdDestroyInstance)
 	cmdInstance.AddCommand(cmdDestroyInstance)
 }
 
 func destroyInstance(cmd *cobra.Command, args []string) {
 	clusterInstanceInfoFromArgs(&destroyInstanceFlags, args)
 
-	provider := newProvider()
+	provider := newProvider(providers.CapabilityInstance)
 	destroyInstanceFlags.ClusterInfo = provider.ClusterDefaults(destroyInstanceFlags.ClusterInfo)
 
 	if destroyInstanceFlags.Domain == "" {
@@ -55,25 +60,90 @@ func destroyInstance(cmd *cobra.Command, args []string) {
 	if destroyInstanceFlags.Prefix == "" {
 		Exitf("Please specify a prefix\n")
 	}
-	if err := confirm(fmt.Sprintf("Are you sure you want to destroy %s?", destroyInstanceFlags.String())); err != nil {
+	if dryRun {
+		instances, err := provider.GetInstances(destroyInstanceFlags.ClusterInfo)
+		if err != nil {
+			Exitf("Failed to query existing instances: %v\n", err)
+		}
+		plan := providers.Plan{}
+		if err := removeInstancePlan(&plan, destroyInstanceFlags, instances); err != nil {
+			Exitf("Failed to create plan: %v\n", err)
+		}
+		printPlan(plan)
+		return
+	}
+	if err := confirmCluster(fmt.Sprintf("Are you sure you want to destroy %s?", destroyInstanceFlags.String()), destroyInstanceFlags.ClusterInfo); err != nil {
 		Exitf("%v\n", err)
 	}
 
-	/* TODO Remove instance from etcd
-	instances, err := provider.GetInstances(&destroyInstanceFlags.ClusterInfo)
+	ctx, _ := newContext()
+	if err := removeInstance(ctx, provider, destroyInstanceFlags, destroyInstanceForce); err != nil {
+		Exitf("Failed to destroy instance: %v\n", err)
+	}
+
+	Infof("Destroyed instance %s\n", destroyInstanceFlags)
+}
+
+// removeInstance drains the instance with given info from fleet and etcd, deletes it and
+// updates the cluster members on all remaining instances.
+// When force is set, the instance is deleted even when it cannot be drained or when
+// etcd would lose its quorum.
+func removeInstance(ctx context.Context, provider providers.CloudProvider, info providers.ClusterInstanceInfo, force bool) error {
+	instances, err := provider.GetInstances(info.ClusterInfo)
+	if err != nil {
+		return maskAny(err)
+	}
+	victim, remaining, err := splitVictim(info, instances)
 	if err != nil {
-		Exitf("Failed to list instances: %v\n", err)
+		return maskAny(err)
+	}
+	if err := remaining.DrainAndRemove(ctx, log, victim, force); err != nil {
+		return maskAny(err)
 	}
-	*/
 
-	if err := provider.DeleteInstance(destroyInstanceFlags, newDnsProvider()); err != nil {
-		Exitf("Failed to destroy instance: %v\n", err)
+	if err := provider.DeleteInstance(ctx, info, newDnsProvider()); err != nil {
+		return maskAny(err)
+	}
+	if err := knownHosts.Forget(info.String()); err != nil {
+		log.Warningf("Cannot forget host key of %s: %v", info, err)
 	}
 
 	// Update existing members
-	if err := providers.UpdateClusterMembers(log, destroyInstanceFlags.ClusterInfo, false, nil, provider); err != nil {
-		Exitf("Failed to update cluster members: %v\n", err)
+	if err := providers.UpdateClusterMembers(ctx, log, info.ClusterInfo, providers.RebootNone, nil, provider); err != nil {
+		return maskAny(err)
 	}
 
-	Infof("Destroyed instance %s\n", destroyInstanceFlags)
+	return nil
+}
+
+// removeInstancePlan adds all actions performed by removeInstance to the given plan.
+func removeInstancePlan(plan *providers.Plan, info providers.ClusterInstanceInfo, instances providers.ClusterInstanceList) error {
+	victim, remaining, err := splitVictim(info, instances)
+	if err != nil {
+		return maskAny(err)
+	}
+	plan.AddDrainInstance(victim, remaining)
+	plan.AddDeleteInstance(victim)
+	plan.AddUpdateClusterMembers(remaining, providers.RebootNone)
+	return nil
+}
+
+// splitVictim returns the instance with given info and all other instances from the given list.
+func splitVictim(info providers.ClusterInstanceInfo, instances providers.ClusterInstanceList) (providers.ClusterInstance, providers.ClusterInstanceList, error) {
+	fullName := info.String()
+	var victim providers.ClusterInstance
+	remaining := providers.ClusterInstanceList{}
+	found := false
+	for _, i := range instances {
+		if i.Name == fullName {
+			victim = i
+			found = true
+		} else {
+			remaining = append(remaining, i)
+		}
+	}
+	if !found {
+		return victim, nil, maskAny(fmt.Errorf("Instance %s not found", fullName))
+	}
+	return victim, remaining, nil
 }
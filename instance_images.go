@@ -15,7 +15,13 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
 )
 
 var (
@@ -30,9 +36,19 @@ func init() {
 }
 
 func showImages(cmd *cobra.Command, args []string) {
-	provider := newProvider()
-	err := provider.ShowImages()
+	provider := newProvider(providers.CapabilityImages)
+	images, err := provider.ListImages()
 	if err != nil {
 		Exitf("Failed to show images: %v\n", err)
 	}
+
+	printOutput(images, func() []string {
+		lines := []string{}
+		for _, i := range images {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s", i.ID, i.Name, i.Description, strings.Join(i.Regions, " ")))
+		}
+		sort.Strings(lines)
+		lines = append([]string{"ID | Name | Description | Regions"}, lines...)
+		return lines
+	})
 }
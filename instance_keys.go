@@ -15,7 +15,12 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
 )
 
 var (
@@ -30,9 +35,19 @@ func init() {
 }
 
 func showKeys(cmd *cobra.Command, args []string) {
-	provider := newProvider()
-	err := provider.ShowKeys()
+	provider := newProvider(providers.CapabilityKeys)
+	keys, err := provider.ListKeys()
 	if err != nil {
 		Exitf("Failed to show keys: %v\n", err)
 	}
+
+	printOutput(keys, func() []string {
+		lines := []string{}
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s", k.ID, k.Name, k.Fingerprint, k.PublicKey))
+		}
+		sort.Strings(lines)
+		lines = append([]string{"ID | Name | Fingerprint | Public-key"}, lines...)
+		return lines
+	})
 }
@@ -18,8 +18,8 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/ryanuber/columnize"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 )
@@ -52,21 +52,89 @@ func showInstances(cmd *cobra.Command, args []string) {
 	if err != nil {
 		Exitf("Failed to list instances: %v\n", err)
 	}
-	clusterMembers, err := instances.AsClusterMemberList(log, nil)
+	ctx, _ := newContext()
+	docs, err := fetchInstanceDocuments(ctx, instances)
 	if err != nil {
 		Exitf("Failed to fetch instance member data: %v\n", err)
 	}
 
-	lines := []string{"Name | Cluster IP | Public IP | Private IP | Machine ID | Options"}
+	printOutput(docs, func() []string {
+		lines := []string{"Name | Cluster IP | Public IP | Private IP | Machine ID | Roles"}
+		for _, d := range docs {
+			lbIP := strings.TrimSpace(d.LoadBalancerIPv4 + " " + d.LoadBalancerIPv6)
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s | %s | %s", d.Name, d.ClusterIP, lbIP, d.PrivateIP, d.MachineID, strings.Join(d.Roles, ",")))
+		}
+		return lines
+	})
+}
+
+// instanceDocument is the machine readable representation of an instance.
+type instanceDocument struct {
+	Name             string   `json:"name" yaml:"name"`
+	ClusterIP        string   `json:"cluster-ip" yaml:"cluster-ip"`
+	LoadBalancerIPv4 string   `json:"lb-ipv4,omitempty" yaml:"lb-ipv4,omitempty"`
+	LoadBalancerIPv6 string   `json:"lb-ipv6,omitempty" yaml:"lb-ipv6,omitempty"`
+	PrivateIP        string   `json:"private-ip" yaml:"private-ip"`
+	MachineID        string   `json:"machine-id" yaml:"machine-id"`
+	OS               string   `json:"os" yaml:"os"`
+	Roles            []string `json:"roles" yaml:"roles"`
+}
+
+// fetchInstanceDocuments creates instance documents for the given instances, fetching
+// their cluster member and fleet machine data.
+func fetchInstanceDocuments(ctx context.Context, instances providers.ClusterInstanceList) ([]instanceDocument, error) {
+	clusterMembers, err := instances.AsClusterMemberList(ctx, log, nil)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var machines []providers.FleetMachine
+	if len(instances) > 0 {
+		machines, err = instances[0].ListFleetMachines(ctx, log)
+		if err != nil {
+			log.Warningf("Failed to fetch fleet machines: %v", err)
+		}
+	}
+
+	docs := []instanceDocument{}
 	for _, i := range instances {
 		cm, _ := clusterMembers.Find(i) // ignore errors
-		options := []string{}
-		if cm.EtcdProxy {
-			options = append(options, "etcd-proxy")
+		m, _ := providers.FindFleetMachine(machines, cm.MachineID)
+		docs = append(docs, newInstanceDocument(i, cm, m))
+	}
+	return docs, nil
+}
+
+// HasRole returns true if the instance has the given role.
+func (d instanceDocument) HasRole(role string) bool {
+	for _, r := range d.Roles {
+		if r == role {
+			return true
 		}
-		lbIP := strings.TrimSpace(i.LoadBalancerIPv4 + " " + i.LoadBalancerIPv6)
-		lines = append(lines, fmt.Sprintf("%s | %s | %s | %s | %s | %s", i.Name, i.ClusterIP, lbIP, i.PrivateIP, cm.MachineID, strings.Join(options, ",")))
 	}
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
+	return false
+}
+
+// newInstanceDocument creates an instance document from the given instance, its cluster member
+// data and its fleet machine data.
+func newInstanceDocument(i providers.ClusterInstance, cm providers.ClusterMember, m providers.FleetMachine) instanceDocument {
+	roles := []string{}
+	if m.HasMetadata("core", "true") {
+		roles = append(roles, roleCore)
+	}
+	if m.HasMetadata("lb", "true") {
+		roles = append(roles, roleLB)
+	}
+	if cm.EtcdProxy {
+		roles = append(roles, roleEtcdProxy)
+	}
+	return instanceDocument{
+		Name:             i.Name,
+		ClusterIP:        i.ClusterIP,
+		LoadBalancerIPv4: i.LoadBalancerIPv4,
+		LoadBalancerIPv6: i.LoadBalancerIPv6,
+		PrivateIP:        i.PrivateIP,
+		MachineID:        cm.MachineID,
+		OS:               string(i.OS),
+		Roles:            roles,
+	}
 }
@@ -15,7 +15,13 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
 )
 
 var (
@@ -30,9 +36,19 @@ func init() {
 }
 
 func showRegions(cmd *cobra.Command, args []string) {
-	provider := newProvider()
-	err := provider.ShowRegions()
+	provider := newProvider(providers.CapabilityRegions)
+	regions, err := provider.ListRegions()
 	if err != nil {
 		Exitf("Failed to show regions: %v\n", err)
 	}
+
+	printOutput(regions, func() []string {
+		lines := []string{}
+		for _, r := range regions {
+			lines = append(lines, fmt.Sprintf("%s | %s | %v | %s", r.ID, r.Name, r.Available, strings.Join(r.Features, " ")))
+		}
+		sort.Sort(linesByID(lines))
+		lines = append([]string{"ID | Name | Available | Features"}, lines...)
+		return lines
+	})
 }
@@ -0,0 +1,110 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdRekeyInstance = &cobra.Command{
+		Short: "Pin the current SSH host key of an instance",
+		Long:  "Pin the current SSH host key of an instance, replacing the pinned key. Use this after an instance has been reinstalled.",
+		Use:   "rekey",
+		Run:   rekeyInstance,
+	}
+
+	rekeyInstanceFlags struct {
+		providers.ClusterInstanceInfo
+		Fingerprint string
+	}
+)
+
+func init() {
+	cmdRekeyInstance.Flags().StringVar(&rekeyInstanceFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdRekeyInstance.Flags().StringVar(&rekeyInstanceFlags.Name, "name", "", "Cluster name")
+	cmdRekeyInstance.Flags().StringVar(&rekeyInstanceFlags.Prefix, "prefix", "", "Instance prefix name")
+	cmdRekeyInstance.Flags().StringVar(&rekeyInstanceFlags.Fingerprint, "fingerprint", "", "Expected fingerprint (SHA256:...) of the new host key, pins the key without asking")
+	cmdInstance.AddCommand(cmdRekeyInstance)
+}
+
+func rekeyInstance(cmd *cobra.Command, args []string) {
+	clusterInstanceInfoFromArgs(&rekeyInstanceFlags.ClusterInstanceInfo, args)
+
+	provider := newProvider()
+	rekeyInstanceFlags.ClusterInfo = provider.ClusterDefaults(rekeyInstanceFlags.ClusterInfo)
+
+	if rekeyInstanceFlags.Domain == "" {
+		Exitf("Please specify a domain\n")
+	}
+	if rekeyInstanceFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	if rekeyInstanceFlags.Prefix == "" {
+		Exitf("Please specify a prefix\n")
+	}
+	instance := findInstance(provider, rekeyInstanceFlags.ClusterInstanceInfo)
+
+	ctx, _ := newContext()
+	key, err := sshExecutor.FetchHostKey(ctx, log, instance)
+	if err != nil {
+		Exitf("Failed to fetch host key of %s: %v\n", instance, err)
+	}
+	fingerprint := providers.HostKeyFingerprint(key)
+	pinned, err := knownHosts.Lookup(instance.Name)
+	if err != nil {
+		Exitf("Failed to load pinned host key of %s: %v\n", instance, err)
+	}
+	for _, p := range pinned {
+		if providers.HostKeyFingerprint(p) == fingerprint {
+			Infof("Host key of %s has not changed (%s)\n", instance, fingerprint)
+			return
+		}
+	}
+
+	if rekeyInstanceFlags.Fingerprint != "" {
+		if rekeyInstanceFlags.Fingerprint != fingerprint {
+			Exitf("Host key of %s is %s, expected %s\n", instance, fingerprint, rekeyInstanceFlags.Fingerprint)
+		}
+	} else {
+		for _, p := range pinned {
+			Infof("Pinned host key: %s\n", providers.HostKeyFingerprint(p))
+		}
+		Infof("New host key:    %s\n", fingerprint)
+		if err := confirm(fmt.Sprintf("Are you sure %s has been reinstalled and has this new host key?", instance)); err != nil {
+			Exitf("%v\n", err)
+		}
+	}
+	if err := sshExecutor.PinHostKey(instance, key); err != nil {
+		Exitf("Failed to pin host key of %s: %v\n", instance, err)
+	}
+	Infof("Pinned host key %s of %s\n", fingerprint, instance)
+}
+
+// forgetHostKeys removes the pinned host keys of the given (destroyed) instances of the given cluster.
+func forgetHostKeys(info providers.ClusterInfo, instances providers.ClusterInstanceList) {
+	for _, i := range instances {
+		if err := knownHosts.Forget(i.Name); err != nil {
+			log.Warningf("Cannot forget host key of %s: %v", i, err)
+		}
+	}
+	if err := knownHosts.ForgetCluster(info); err != nil {
+		log.Warningf("Cannot forget host keys of %s: %v", info, err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdSSHInstance = &cobra.Command{
+		Short: "Open an interactive SSH session on an instance",
+		Long:  "Open an interactive SSH session on an instance, or run the command given after -- on it",
+		Use:   "ssh",
+		Run:   sshInstance,
+	}
+
+	sshInstanceFlags providers.ClusterInstanceInfo
+)
+
+func init() {
+	cmdSSHInstance.Flags().StringVar(&sshInstanceFlags.Domain, "domain", defaultDomain(), "Cluster domain")
+	cmdSSHInstance.Flags().StringVar(&sshInstanceFlags.Name, "name", "", "Cluster name")
+	cmdSSHInstance.Flags().StringVar(&sshInstanceFlags.Prefix, "prefix", "", "Instance prefix name")
+	cmdInstance.AddCommand(cmdSSHInstance)
+}
+
+func sshInstance(cmd *cobra.Command, args []string) {
+	var command []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		args, command = args[:dash], args[dash:]
+	}
+	clusterInstanceInfoFromArgs(&sshInstanceFlags, args)
+
+	provider := newProvider()
+	sshInstanceFlags.ClusterInfo = provider.ClusterDefaults(sshInstanceFlags.ClusterInfo)
+
+	if sshInstanceFlags.Domain == "" {
+		Exitf("Please specify a domain\n")
+	}
+	if sshInstanceFlags.Name == "" {
+		Exitf("Please specify a name\n")
+	}
+	if sshInstanceFlags.Prefix == "" {
+		Exitf("Please specify a prefix\n")
+	}
+	instance := findInstance(provider, sshInstanceFlags)
+
+	// Ctrl-C is passed to the remote session, so the session is not canceled on SIGINT
+	if err := sshExecutor.Shell(context.Background(), log, instance, strings.Join(command, " "), os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if cmdErr, ok := errgo.Cause(err).(*providers.RemoteCommandError); ok {
+			os.Exit(cmdErr.ExitStatus)
+		}
+		Exitf("SSH to %s failed: %v\n", instance.Name, err)
+	}
+}
@@ -15,7 +15,12 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
 )
 
 var (
@@ -30,9 +35,19 @@ func init() {
 }
 
 func showInstanceTypes(cmd *cobra.Command, args []string) {
-	provider := newProvider()
-	err := provider.ShowInstanceTypes()
+	provider := newProvider(providers.CapabilityInstanceTypes)
+	types, err := provider.ListInstanceTypes()
 	if err != nil {
 		Exitf("Failed to show instance types: %v\n", err)
 	}
+
+	printOutput(types, func() []string {
+		lines := []string{}
+		for _, t := range types {
+			lines = append(lines, fmt.Sprintf("%s | %s | %d | %d MB | %d GB | %.2f | %v", t.ID, t.Name, t.CPU, t.RAM, t.Disk, t.Price, t.Available))
+		}
+		sort.Sort(linesByID(lines))
+		lines = append([]string{"ID | Name | CPU | RAM | Disk | Price | Available"}, lines...)
+		return lines
+	})
 }
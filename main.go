@@ -16,19 +16,21 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/juju/errgo"
 	"github.com/op/go-logging"
 	"github.com/spf13/cobra"
 
 	"github.com/pulcy/quark/providers"
-	"github.com/pulcy/quark/providers/cloudflare"
-	"github.com/pulcy/quark/providers/digitalocean"
-	"github.com/pulcy/quark/providers/scaleway"
-	"github.com/pulcy/quark/providers/vagrant"
-	"github.com/pulcy/quark/providers/vultr"
+	_ "github.com/pulcy/quark/providers/cloudflare"
+	_ "github.com/pulcy/quark/providers/digitalocean"
+	_ "github.com/pulcy/quark/providers/scaleway"
+	_ "github.com/pulcy/quark/providers/vagrant"
+	_ "github.com/pulcy/quark/providers/vultr"
 )
 
 var (
@@ -49,30 +51,52 @@ var (
 		PersistentPreRun: loadDefaults,
 	}
 
-	provider             string
-	digitalOceanToken    string
-	cloudflareApiKey     string
-	cloudflareEmail      string
-	scalewayOrganization string
-	scalewayToken        string
-	vagrantFolder        string
-	vultrApiKey          string
-	logLevel             string
+	configPath         string
+	profileName        string
+	provider           string
+	dnsProvider        string
+	credentials        = make(map[string]*string) // Credential flag values, keyed by credential name
+	logLevel           string
+	outputFormat       string
+	assumeYes          bool
+	confirmClusterName string
+	timeouts           providers.Timeouts
+	rebootParallelism  int
+	sshOptions         providers.SSHOptions
+	knownHostsDir      string
+	stateDir           string
+	knownHosts         = providers.NewKnownHosts("")
+	sshExecutor        *providers.SSHExecutor
 
-	log = logging.MustGetLogger(projectName)
+	log     = logging.MustGetLogger(projectName)
+	maskAny = errgo.MaskFunc(errgo.Any)
 )
 
 func init() {
 	logging.SetFormatter(logging.MustStringFormatter("[%{level:-5s}] %{message}"))
 	cmdMain.PersistentFlags().StringVar(&logLevel, "log-level", defaultLogLevel, "Log level (debug|info|warning|error)")
-	cmdMain.PersistentFlags().StringVarP(&provider, "provider", "p", "", "Provider used for creating clusters [digitalocean|scaleway|vagrant|vultr]")
-	cmdMain.PersistentFlags().StringVarP(&digitalOceanToken, "digitalocean-token", "t", "", "Digital Ocean token")
-	cmdMain.PersistentFlags().StringVarP(&cloudflareApiKey, "cloudflare-apikey", "k", "", "Cloudflare API key")
-	cmdMain.PersistentFlags().StringVarP(&cloudflareEmail, "cloudflare-email", "e", "", "Cloudflare email address")
-	cmdMain.PersistentFlags().StringVarP(&scalewayOrganization, "scaleway-organization", "", "", "Scaleway organization ID")
-	cmdMain.PersistentFlags().StringVarP(&scalewayToken, "scaleway-token", "", "", "Scaleway token")
-	cmdMain.PersistentFlags().StringVarP(&vagrantFolder, "vagrant-folder", "f", defaultVagrantFolder(), "Directory containing vagrant files")
-	cmdMain.PersistentFlags().StringVarP(&vultrApiKey, "vultr-apikey", "", "", "Vultr API key")
+	cmdMain.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format (table|json|yaml)")
+	cmdMain.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", defaultAssumeYes(), "Assume 'yes' as answer to all confirmation questions")
+	cmdMain.PersistentFlags().StringVar(&configPath, "config", defaultConfigPath(), "Path of the configuration file")
+	cmdMain.PersistentFlags().StringVar(&profileName, "profile", defaultProfile(), "Name of the configuration profile to use")
+	cmdMain.PersistentFlags().StringVarP(&provider, "provider", "p", "", fmt.Sprintf("Provider used for creating clusters [%s]", strings.Join(providers.CloudProviderNames(), "|")))
+	cmdMain.PersistentFlags().DurationVar(&timeouts.Create, "create-timeout", providers.DefaultCreateTimeout, "Maximum time to wait for a new or rebooted instance to become available")
+	cmdMain.PersistentFlags().DurationVar(&timeouts.SSH, "ssh-timeout", providers.DefaultSSHTimeout, "Maximum duration of a single command executed on an instance")
+	cmdMain.PersistentFlags().DurationVar(&timeouts.Health, "health-timeout", providers.DefaultHealthTimeout, "Maximum time to wait for a rebooted instance to rejoin etcd & fleet")
+	cmdMain.PersistentFlags().IntVar(&rebootParallelism, "reboot-parallelism", providers.DefaultRebootParallelism, "Number of instances rebooted at the same time by cluster create & cluster reboot")
+	cmdMain.PersistentFlags().StringSliceVar(&sshOptions.KeyFiles, "ssh-identity", defaultSshIdentity(), "Private key files used to connect to instances (default ~/.ssh/id_rsa, ~/.ssh/id_ecdsa, ~/.ssh/id_dsa)")
+	cmdMain.PersistentFlags().BoolVar(&sshOptions.ForwardAgent, "ssh-forward-agent", false, "If set, the local SSH agent is forwarded to instances")
+	cmdMain.PersistentFlags().StringVar(&sshOptions.JumpHost, "ssh-jump-host", defaultSshJumpHost(), "Host ([user@]host[:port]) through which instances without a public address are reached (default a load-balancer instance of the cluster)")
+	cmdMain.PersistentFlags().StringVar(&knownHostsDir, "ssh-known-hosts", defaultKnownHostsDir(), "Directory holding the pinned SSH host keys of all clusters")
+	cmdMain.PersistentFlags().StringVar(&stateDir, "state-dir", defaultStateDir(), "Directory holding the journals of resumable operations")
+	cmdMain.PersistentFlags().StringVar(&dnsProvider, "dns-provider", defaultDnsProvider, fmt.Sprintf("Provider used for DNS records [%s]", strings.Join(providers.DnsProviderNames(), "|")))
+
+	// Add credential flags of all registered providers
+	for _, info := range providers.RegisteredProviders() {
+		for _, c := range info.Credentials {
+			credentials[c.Name] = cmdMain.PersistentFlags().StringP(c.Name, c.Shorthand, "", c.Usage)
+		}
+	}
 }
 
 func main() {
@@ -84,17 +108,35 @@ func showUsage(cmd *cobra.Command, args []string) {
 }
 
 func loadDefaults(cmd *cobra.Command, args []string) {
-	if digitalOceanToken == "" {
-		digitalOceanToken = os.Getenv("DIGITALOCEAN_TOKEN")
+	if err := validateOutputFormat(); err != nil {
+		outputFormat = outputTable
+		Exitf("%v\n", err)
+	}
+
+	// Apply profile (flag > env > profile > provider default)
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		Exitf("Cannot load config from %s: %v\n", configPath, err)
 	}
-	if cloudflareApiKey == "" {
-		cloudflareApiKey = os.Getenv("CLOUDFLARE_APIKEY")
+	profile, err := cfg.Profile(cfg.ProfileName(profileName))
+	if err != nil && cmd.Parent() != cmdConfig {
+		// The config commands must be usable to create a new profile
+		Exitf("%v\n", err)
 	}
-	if cloudflareEmail == "" {
-		cloudflareEmail = os.Getenv("CLOUDFLARE_EMAIL")
+	if err := profile.Validate(); err != nil {
+		Exitf("Invalid profile: %v\n", err)
 	}
-	if vultrApiKey == "" {
-		vultrApiKey = os.Getenv("VULTR_APIKEY")
+	if err := profile.Apply(cmd.Flags()); err != nil {
+		Exitf("Cannot apply profile: %v\n", err)
+	}
+
+	// Use environment variables for all credentials that are not set
+	for _, info := range providers.RegisteredProviders() {
+		for _, c := range info.Credentials {
+			if value := credentials[c.Name]; *value == "" && c.EnvVar != "" {
+				*value = os.Getenv(c.EnvVar)
+			}
+		}
 	}
 
 	// Set loglevel
@@ -103,64 +145,79 @@ func loadDefaults(cmd *cobra.Command, args []string) {
 		Exitf("Invalid log-level '%s': %#v", logLevel, err)
 	}
 	logging.SetLevel(level, projectName)
+
+	providers.SetTimeouts(timeouts)
+	if rebootParallelism < 1 {
+		Exitf("Invalid reboot-parallelism %d, must be at least 1\n", rebootParallelism)
+	}
+	providers.SetRebootParallelism(rebootParallelism)
+	knownHosts = providers.NewKnownHosts(knownHostsDir)
+	sshOptions.KnownHosts = knownHosts
+	sshExecutor = providers.NewSSHExecutor(sshOptions)
+	providers.SetRemoteExecutor(sshExecutor)
 }
 
-func newProvider() providers.CloudProvider {
-	switch provider {
-	case "digitalocean":
-		if digitalOceanToken == "" {
-			Exitf("Please specify a token\n")
-		}
-		return digitalocean.NewProvider(log, digitalOceanToken)
-	case "scaleway":
-		if scalewayOrganization == "" || scalewayToken == "" {
-			rc, err := scaleway.ReadRC()
-			if err != nil {
-				Exitf("Cannot read .scwrc: %#v\n", err)
-			}
-			scalewayOrganization = rc.Organization
-			scalewayToken = rc.Token
-		}
-		if scalewayOrganization == "" {
-			Exitf("Please specify a scaleway-organization\n")
-		}
-		if scalewayToken == "" {
-			Exitf("Please specify a scaleway-token\n")
-		}
-		provider, err := scaleway.NewProvider(log, scalewayOrganization, scalewayToken)
-		if err != nil {
-			Exitf("NewProvider failed: %#v\n", err)
-		}
-		return provider
-	case "vagrant":
-		if vagrantFolder == "" {
-			Exitf("Please specify a vagrant-folder\n")
-		}
-		return vagrant.NewProvider(log, vagrantFolder)
-	case "vultr":
-		if vultrApiKey == "" {
-			Exitf("Please specify a vultr-apikey\n")
-		}
-		return vultr.NewProvider(log, vultrApiKey)
-	default:
+// newProvider creates the cloud provider selected with --provider.
+// It fails when the provider does not support all of the given capabilities.
+func newProvider(required ...providers.Capability) providers.CloudProvider {
+	info, found := providers.LookupProvider(provider)
+	if !found || info.NewCloudProvider == nil {
 		Exitf("Unknown provider '%s'\n", provider)
-		return nil
 	}
+	for _, c := range required {
+		if !info.HasCapability(c) {
+			Exitf("Provider '%s' does not support %s\n", provider, c)
+		}
+	}
+	creds, err := info.CompleteCredentials(providerCredentials(info))
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+	p, err := info.NewCloudProvider(log, creds)
+	if err != nil {
+		Exitf("NewProvider failed: %#v\n", err)
+	}
+	return p
 }
 
+// newDnsProvider creates the DNS provider selected with --dns-provider.
 func newDnsProvider() providers.DnsProvider {
-	if cloudflareApiKey == "" {
-		Exitf("Please specify a cloudflare-apikey\n")
+	info, found := providers.LookupProvider(dnsProvider)
+	if !found || info.NewDnsProvider == nil {
+		Exitf("Unknown DNS provider '%s'\n", dnsProvider)
 	}
-	if cloudflareEmail == "" {
-		Exitf("Please specify a cloudflare-email\n")
+	creds, err := info.CompleteCredentials(providerCredentials(info))
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+	p, err := info.NewDnsProvider(log, creds)
+	if err != nil {
+		Exitf("NewDnsProvider failed: %#v\n", err)
+	}
+	return p
+}
+
+// providerCredentials collects the values of the credential flags of the given provider.
+func providerCredentials(info providers.ProviderInfo) providers.Credentials {
+	result := providers.Credentials{}
+	for _, c := range info.Credentials {
+		result[c.Name] = *credentials[c.Name]
 	}
-	return cloudflare.NewProvider(log, cloudflareApiKey, cloudflareEmail)
+	return result
 }
 
+// confirm asks the user to confirm the given question.
+// With --yes the question is skipped. When stdin is not a terminal, an error is returned
+// instead of waiting for an answer that never comes.
 func confirm(question string) error {
+	if assumeYes {
+		return nil
+	}
+	if !providers.StdinIsTerminal() {
+		return maskAny(errors.New("Cannot ask for confirmation, stdin is not a terminal. Use --yes to confirm."))
+	}
 	for {
-		fmt.Printf("%s [yes|no]", question)
+		fmt.Fprintf(infoWriter(), "%s [yes|no]", question)
 		bufStdin := bufio.NewReader(os.Stdin)
 		line, _, err := bufStdin.ReadLine()
 		if err != nil {
@@ -170,17 +227,34 @@ func confirm(question string) error {
 		if string(line) == "yes" || string(line) == "y" {
 			return nil
 		}
-		fmt.Println("Please enter 'yes' to confirm.")
+		fmt.Fprintln(infoWriter(), "Please enter 'yes' to confirm.")
+	}
+}
+
+// addConfirmClusterFlag adds the --confirm-cluster flag to the given (destructive) command.
+func addConfirmClusterFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&confirmClusterName, "confirm-cluster", "", "Confirm without asking by passing the full name (name.domain) of the target cluster")
+}
+
+// confirmCluster asks the user to confirm a destructive operation on the given cluster.
+// If --confirm-cluster is given, it must match the target cluster and no question is asked.
+func confirmCluster(question string, cluster providers.ClusterInfo) error {
+	if confirmClusterName != "" {
+		if confirmClusterName != cluster.String() {
+			return maskAny(fmt.Errorf("--confirm-cluster=%s does not match target cluster %s", confirmClusterName, cluster))
+		}
+		return nil
 	}
+	return confirm(question)
 }
 
 func Exitf(format string, args ...interface{}) {
-	fmt.Printf(format, args...)
+	printError(fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
 func Infof(format string, args ...interface{}) {
-	fmt.Printf(format, args...)
+	fmt.Fprintf(infoWriter(), format, args...)
 }
 
 // clusterInfoFromArgs fills the given cluster info from a command line argument
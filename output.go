@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ryanuber/columnize"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pulcy/quark/providers"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// Terminal colors used in tables. All colors have the same length, so colored columns stay aligned.
+const (
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorDefault = "\x1b[39m"
+	colorReset   = "\x1b[0m"
+)
+
+// errorDocument is printed (in JSON or YAML) when a command fails.
+type errorDocument struct {
+	Error errorDetails `json:"error" yaml:"error"`
+}
+
+type errorDetails struct {
+	Message string `json:"message" yaml:"message"`
+}
+
+// validateOutputFormat checks the value of the --output flag.
+func validateOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return maskAny(fmt.Errorf("Unknown output format '%s', expected %s|%s|%s", outputFormat, outputTable, outputJSON, outputYAML))
+	}
+}
+
+// isMachineOutput returns true if the output must be machine readable (JSON or YAML).
+func isMachineOutput() bool {
+	return outputFormat == outputJSON || outputFormat == outputYAML
+}
+
+// infoWriter returns the writer used for informational messages.
+// When the output is machine readable, these go to stderr to keep stdout parseable.
+func infoWriter() io.Writer {
+	if isMachineOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printOutput prints the given document in the selected output format.
+// For table output, the given function is called to build the (columnized) lines of the table.
+func printOutput(doc interface{}, table func() []string) {
+	switch outputFormat {
+	case outputJSON:
+		raw, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			Exitf("Cannot encode output: %v\n", err)
+		}
+		fmt.Println(string(raw))
+	case outputYAML:
+		raw, err := yaml.Marshal(doc)
+		if err != nil {
+			Exitf("Cannot encode output: %v\n", err)
+		}
+		fmt.Print(string(raw))
+	default:
+		fmt.Println(columnize.SimpleFormat(table()))
+	}
+}
+
+// colorize returns the given text in the given color, when stdout is a terminal.
+func colorize(text, color string) string {
+	if !providers.StdoutIsTerminal() {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// printError prints the given error message in the selected output format.
+func printError(message string) {
+	message = strings.TrimSpace(message)
+	doc := errorDocument{Error: errorDetails{Message: message}}
+	switch outputFormat {
+	case outputJSON:
+		raw, _ := json.MarshalIndent(doc, "", "  ")
+		fmt.Println(string(raw))
+	case outputYAML:
+		raw, _ := yaml.Marshal(doc)
+		fmt.Print(string(raw))
+	default:
+		fmt.Println(message)
+	}
+}
+
+// linesByID sorts table lines by their first column (the ID). Numeric IDs are sorted by value.
+type linesByID []string
+
+func (l linesByID) Len() int      { return len(l) }
+func (l linesByID) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l linesByID) Less(i, j int) bool {
+	a, b := strings.TrimSpace(strings.SplitN(l[i], "|", 2)[0]), strings.TrimSpace(strings.SplitN(l[j], "|", 2)[0])
+	x, errX := strconv.Atoi(a)
+	y, errY := strconv.Atoi(b)
+	if errX == nil && errY == nil && x != y {
+		return x < y
+	}
+	return l[i] < l[j]
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	dryRun bool
+)
+
+// addDryRunFlag adds the --dry-run flag to the given command.
+func addDryRunFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "If set, show the plan without creating, changing or destroying anything")
+}
+
+// printPlan shows the given plan in the selected output format.
+func printPlan(plan providers.Plan) {
+	printOutput(plan, func() []string {
+		lines := []string{"Kind | Target | Action"}
+		for _, a := range plan.Actions {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s", a.Kind, a.Target, a.Action))
+		}
+		return lines
+	})
+}
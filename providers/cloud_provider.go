@@ -24,6 +24,7 @@ import (
 	"github.com/dchest/uniuri"
 	"github.com/juju/errgo"
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -36,17 +37,17 @@ const (
 
 // DnsProvider holds all functions to be implemented by DNS providers
 type DnsProvider interface {
-	ShowDomainRecords(domain string) error
+	ListDnsRecords(domain string) ([]DnsRecord, error)
 	CreateDnsRecord(domain, recordTpe, name, data string) error
 	DeleteDnsRecord(domain, recordType, name, data string) error
 }
 
 // CloudProvider holds all functions to be implemented by cloud providers
 type CloudProvider interface {
-	ShowRegions() error
-	ShowImages() error
-	ShowKeys() error
-	ShowInstanceTypes() error
+	ListRegions() ([]Region, error)
+	ListImages() ([]Image, error)
+	ListKeys() ([]SSHKey, error)
+	ListInstanceTypes() ([]InstanceType, error)
 
 	// Apply defaults for the given options
 	ClusterDefaults(options ClusterInfo) ClusterInfo
@@ -58,24 +59,24 @@ type CloudProvider interface {
 	CreateClusterDefaults(options CreateClusterOptions) CreateClusterOptions
 
 	// Create a machine instance
-	CreateInstance(log *logging.Logger, options CreateInstanceOptions, dnsProvider DnsProvider) (ClusterInstance, error)
+	CreateInstance(ctx context.Context, log *logging.Logger, options CreateInstanceOptions, dnsProvider DnsProvider) (ClusterInstance, error)
 
 	// Create an entire cluster
-	CreateCluster(log *logging.Logger, options CreateClusterOptions, dnsProvider DnsProvider) error
+	CreateCluster(ctx context.Context, log *logging.Logger, options CreateClusterOptions, dnsProvider DnsProvider) error
 
 	// Get names of instances of a cluster
 	GetInstances(info ClusterInfo) (ClusterInstanceList, error)
 
 	// Remove all instances of a cluster
-	DeleteCluster(info ClusterInfo, dnsProvider DnsProvider) error
+	DeleteCluster(ctx context.Context, info ClusterInfo, dnsProvider DnsProvider) error
 
 	// Remove a single instance of a cluster
-	DeleteInstance(info ClusterInstanceInfo, dnsProvider DnsProvider) error
+	DeleteInstance(ctx context.Context, info ClusterInstanceInfo, dnsProvider DnsProvider) error
 
 	// Perform a reboot of the given instance
-	RebootInstance(instance ClusterInstance) error
+	RebootInstance(ctx context.Context, instance ClusterInstance) error
 
-	ShowDomainRecords(domain string) error
+	ListDnsRecords(domain string) ([]DnsRecord, error)
 }
 
 // ClusterInfo describes a cluster
@@ -119,25 +120,29 @@ type CreateClusterOptions struct {
 	InstanceCount           int      // Number of instances to start
 	GluonImage              string   // Docker image containing gluon
 	RebootStrategy          string
-	PrivateRegistryUrl      string // URL of private docker registry
-	PrivateRegistryUserName string // Username of private docker registry
-	PrivateRegistryPassword string // Password of private docker registry
-	VaultAddress            string // URL of the vault
-	VaultCertificatePath    string // Path of the vault ca-cert file
-
-	instancePrefixes []string
+	PrivateRegistryUrl      string   // URL of private docker registry
+	PrivateRegistryUserName string   // Username of private docker registry
+	PrivateRegistryPassword string   // Password of private docker registry
+	VaultAddress            string   // URL of the vault
+	VaultCertificatePath    string   // Path of the vault ca-cert file
+	InstancePrefixes        []string // Name prefixes of all instances (generated when empty)
 }
 
-// NewCreateInstanceOptions creates a new CreateInstanceOptions instances with all
-// values inherited from the given CreateClusterOptions
-func (o *CreateClusterOptions) NewCreateInstanceOptions(isCore, isLB bool, instanceIndex int) (CreateInstanceOptions, error) {
-	if len(o.instancePrefixes) == 0 {
+// SetupInstancePrefixes generates the name prefixes of all instances, unless they already exist.
+func (o *CreateClusterOptions) SetupInstancePrefixes() {
+	if len(o.InstancePrefixes) == 0 {
 		for i := 0; i < o.InstanceCount; i++ {
 			prefix := strings.ToLower(uniuri.NewLen(6))
-			o.instancePrefixes = append(o.instancePrefixes, prefix)
+			o.InstancePrefixes = append(o.InstancePrefixes, prefix)
 		}
-		sort.Strings(o.instancePrefixes)
+		sort.Strings(o.InstancePrefixes)
 	}
+}
+
+// NewCreateInstanceOptions creates a new CreateInstanceOptions instances with all
+// values inherited from the given CreateClusterOptions
+func (o *CreateClusterOptions) NewCreateInstanceOptions(isCore, isLB bool, instanceIndex int) (CreateInstanceOptions, error) {
+	o.SetupInstancePrefixes()
 
 	raw, err := ioutil.ReadFile(o.VaultCertificatePath)
 	if err != nil {
@@ -161,7 +166,7 @@ func (o *CreateClusterOptions) NewCreateInstanceOptions(isCore, isLB bool, insta
 		VaultCertificate:        vaultCertificate,
 		TincIpv4:                fmt.Sprintf(tincAddressTemplate, instanceIndex),
 	}
-	io.SetupNames(o.instancePrefixes[instanceIndex-1], o.Name, o.Domain)
+	io.SetupNames(o.InstancePrefixes[instanceIndex-1], o.Name, o.Domain)
 	return io, nil
 }
 
@@ -185,6 +190,8 @@ type CreateInstanceOptions struct {
 	VaultAddress            string // URL of the vault
 	VaultCertificate        string // Contents of the vault ca-cert
 	TincIpv4                string // IP addres of tun0 (tinc) on this instance
+	PrivateOnly             bool   // If set, this instance gets no public IP address and is reached through a jump host
+	DelayClusterDNS         bool   // If set, a load-balancer instance is registered with cluster name in DNS later, by the caller
 }
 
 // SetupNames configured the ClusterName and InstanceName of the given options
@@ -197,6 +204,17 @@ func (o *CreateInstanceOptions) SetupNames(prefix, clusterName, domain string) {
 	o.InstanceName = fmt.Sprintf("%s.%s.%s", prefix, clusterName, domain)
 }
 
+// RegisterClusterName returns true if the instance must be registered with cluster name in DNS when it is created.
+func (o CreateInstanceOptions) RegisterClusterName() bool {
+	return o.RoleLoadBalancer && !o.DelayClusterDNS
+}
+
+// SetupIndex configures the InstanceIndex and the matching TincIpv4 of the given options.
+func (o *CreateInstanceOptions) SetupIndex(instanceIndex int) {
+	o.InstanceIndex = instanceIndex
+	o.TincIpv4 = fmt.Sprintf(tincAddressTemplate, instanceIndex)
+}
+
 // NewCloudConfigOptions creates a new CloudConfigOptions instances with all
 // values inherited from the given CreateInstanceOptions
 func (o *CreateInstanceOptions) NewCloudConfigOptions() CloudConfigOptions {
@@ -230,6 +248,7 @@ type CloudConfigOptions struct {
 	PrivateIPv4    string
 	SshKeys        []string
 	RebootStrategy string
+	HostKey        *HostKey // If set, this host key is installed instead of a generated one
 }
 
 // Validate the given options
@@ -316,5 +335,8 @@ func (cio CreateInstanceOptions) Validate() error {
 	if cio.VaultCertificate == "" {
 		return errors.New("Please specify a vault-cacert")
 	}
+	if cio.PrivateOnly && cio.RoleLoadBalancer {
+		return errors.New("A load-balancer instance needs a public address")
+	}
 	return nil
 }
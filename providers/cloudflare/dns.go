@@ -16,10 +16,10 @@ package cloudflare
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/juju/errgo"
-	"github.com/ryanuber/columnize"
+
+	"github.com/pulcy/quark/providers"
 )
 
 type CfZone struct {
@@ -52,7 +52,7 @@ func (p *cfProvider) zoneID(domain string) (string, error) {
 			return z.ID, nil
 		}
 	}
-	return "", maskAny(errgo.WithCausef(nil, DomainNotFoundError, domain))
+	return "", maskAny(errgo.WithCausef(nil, DomainNotFoundError, "%s", domain))
 }
 
 type CfDnsRecord struct {
@@ -60,47 +60,38 @@ type CfDnsRecord struct {
 	Type    string `json:"type"`
 	Name    string `json:"name"`
 	Content string `json:"content"`
-	TTL     int    `json:"ttl,omitempty'`
+	TTL     int    `json:"ttl,omitempty"`
 }
 
-func (p *cfProvider) ShowDomainRecords(domain string) error {
+func (p *cfProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
 	id, err := p.zoneID(domain)
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
 	url := apiUrl + fmt.Sprintf("zones/%s/dns_records", id)
 	res, err := p.get(url, "application/json")
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
 	records := []CfDnsRecord{}
 	if err := res.UnmarshalResult(&records); err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"Type | Name | Data",
-	}
+	result := []providers.DnsRecord{}
 	for _, r := range records {
-		line := fmt.Sprintf("%s | %s | %s", r.Type, trimLength(r.Name, 20), trimLength(r.Content, 60))
-		lines = append(lines, line)
+		result = append(result, providers.DnsRecord{
+			ID:   r.ID,
+			Type: r.Type,
+			Name: r.Name,
+			Data: r.Content,
+			TTL:  r.TTL,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
-}
-
-func trimLength(s string, maxLen int) string {
-	if len(s) > maxLen {
-		return s[:maxLen] + "..."
-	} else {
-		return s
-	}
+	return result, nil
 }
 
 func (p *cfProvider) CreateDnsRecord(domain, recordType, name, data string) error {
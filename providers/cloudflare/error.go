@@ -20,5 +20,6 @@ import (
 
 var (
 	DomainNotFoundError = errgo.New("domain not found")
+	RequestFailedError  = errgo.New("request failed")
 	maskAny             = errgo.MaskFunc(errgo.Any)
 )
@@ -17,10 +17,13 @@ package cloudflare
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
+	"github.com/juju/errgo"
 	"github.com/op/go-logging"
 
 	"github.com/pulcy/quark/providers"
@@ -28,12 +31,32 @@ import (
 
 const (
 	apiUrl = "https://api.cloudflare.com/client/v4/"
+
+	apiKeyCredential = "cloudflare-apikey"
+	emailCredential  = "cloudflare-email"
 )
 
 type cfProvider struct {
 	Logger *logging.Logger
 	apiKey string
 	email  string
+	client *http.Client
+}
+
+func init() {
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name: "cloudflare",
+		Credentials: []providers.Credential{
+			{Name: apiKeyCredential, Shorthand: "k", EnvVar: "CLOUDFLARE_APIKEY", Usage: "Cloudflare API key", Required: true, Secret: true},
+			{Name: emailCredential, Shorthand: "e", EnvVar: "CLOUDFLARE_EMAIL", Usage: "Cloudflare email address", Required: true},
+		},
+		Capabilities: []providers.Capability{
+			providers.CapabilityDNS,
+		},
+		NewDnsProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.DnsProvider, error) {
+			return NewProvider(log, credentials.Get(apiKeyCredential), credentials.Get(emailCredential)), nil
+		},
+	})
 }
 
 func NewProvider(logger *logging.Logger, apiKey, email string) providers.DnsProvider {
@@ -41,12 +64,28 @@ func NewProvider(logger *logging.Logger, apiKey, email string) providers.DnsProv
 		Logger: logger,
 		apiKey: apiKey,
 		email:  email,
+		client: providers.NewHTTPClient(logger, providers.DefaultRetryPolicy),
 	}
 }
 
 type cfResponse struct {
 	Result  json.RawMessage `json:"result,omitempty"`
 	Success bool            `json:"success"`
+	Errors  []cfError       `json:"errors,omitempty"`
+}
+
+type cfError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns all error messages of the response.
+func (r *cfResponse) Error() string {
+	messages := []string{}
+	for _, e := range r.Errors {
+		messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+	}
+	return strings.Join(messages, ", ")
 }
 
 func (r *cfResponse) UnmarshalResult(v interface{}) error {
@@ -68,10 +107,11 @@ func (p *cfProvider) request(method, url string, payload io.Reader, headers map[
 	req.Header.Set("X-Auth-Key", p.apiKey)
 	req.Header.Set("X-Auth-Email", p.email)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := p.client.Do(req)
 	if err != nil {
 		return nil, maskAny(err)
 	}
+	defer res.Body.Close()
 
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -80,8 +120,14 @@ func (p *cfProvider) request(method, url string, payload io.Reader, headers map[
 
 	var resp cfResponse
 	if err := json.Unmarshal(data, &resp); err != nil {
+		if res.StatusCode >= 300 {
+			return nil, maskAny(errgo.WithCausef(nil, RequestFailedError, "%s %s: %s", method, url, res.Status))
+		}
 		return nil, maskAny(err)
 	}
+	if res.StatusCode >= 300 || !resp.Success {
+		return nil, maskAny(errgo.WithCausef(nil, RequestFailedError, "%s %s: %s %s", method, url, res.Status, resp.Error()))
+	}
 
 	return &resp, nil
 }
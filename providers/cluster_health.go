@@ -0,0 +1,334 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// Names of the health checks performed on every instance
+const (
+	HealthCheckSSH     = "ssh"
+	HealthCheckEtcd    = "etcd"
+	HealthCheckFleet   = "fleet"
+	HealthCheckGluon   = "gluon"
+	HealthCheckTinc    = "tinc"
+	HealthCheckOS      = "os"
+	HealthCheckMembers = "members"
+)
+
+var (
+	// HealthChecks lists the names of all health checks, in the order they are performed.
+	HealthChecks = []string{
+		HealthCheckSSH,
+		HealthCheckEtcd,
+		HealthCheckFleet,
+		HealthCheckGluon,
+		HealthCheckTinc,
+		HealthCheckOS,
+		HealthCheckMembers,
+	}
+)
+
+// HealthCheck is the result of a single health check of an instance.
+type HealthCheck struct {
+	Name    string // Name of the check (one of HealthChecks)
+	OK      bool   // Set if the check succeeded
+	Message string // Details of the result (what is wrong when the check failed)
+}
+
+// InstanceHealth is the result of all health checks of a single instance.
+type InstanceHealth struct {
+	Instance ClusterInstance
+	Checks   []HealthCheck
+}
+
+// Healthy returns true if all checks of the instance succeeded.
+func (h InstanceHealth) Healthy() bool {
+	for _, c := range h.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns the result of the check with given name.
+func (h InstanceHealth) Check(name string) (HealthCheck, bool) {
+	for _, c := range h.Checks {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return HealthCheck{}, false
+}
+
+// clusterHealthData holds the cluster wide state used by the health checks of all instances.
+type clusterHealthData struct {
+	EtcdHealth    []EtcdMemberHealth
+	EtcdMembers   []EtcdMemberInfo
+	EtcdErr       error
+	FleetMachines []FleetMachine
+	FleetErr      error
+}
+
+// CheckHealth performs all health checks on all instances of the list, which must hold all instances
+// of the cluster as known by the provider. The OS of every instance must be at least the given version.
+// Failing checks are no error, an error is only returned when the given context is canceled.
+func (cil ClusterInstanceList) CheckHealth(ctx context.Context, log *logging.Logger, minOSVersion semver.Version) ([]InstanceHealth, error) {
+	result := make([]InstanceHealth, len(cil))
+	machineIDs := make([]string, len(cil))
+
+	// Check SSH access, all other checks need it
+	cil.checkParallel(func(idx int, i ClusterInstance) {
+		result[idx].Instance = i
+		check := HealthCheck{Name: HealthCheckSSH}
+		if id, err := i.GetMachineID(ctx, log); err != nil {
+			check.Message = fmt.Sprintf("unreachable: %v", err)
+		} else {
+			check.OK, check.Message, machineIDs[idx] = true, "reachable", id
+		}
+		result[idx].Checks = append(result[idx].Checks, check)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+
+	// Fetch the cluster wide state through the first reachable instance
+	var data clusterHealthData
+	data.EtcdErr = fmt.Errorf("no instance is reachable")
+	data.FleetErr = data.EtcdErr
+	for idx, i := range cil {
+		if machineIDs[idx] == "" {
+			continue
+		}
+		data.EtcdHealth, data.EtcdErr = i.EtcdClusterHealth(ctx, log)
+		if data.EtcdErr == nil {
+			data.EtcdMembers, data.EtcdErr = i.ListEtcdMembers(ctx, log)
+		}
+		data.FleetMachines, data.FleetErr = i.ListFleetMachines(ctx, log)
+		break
+	}
+
+	// Check all reachable instances
+	cil.checkParallel(func(idx int, i ClusterInstance) {
+		if machineIDs[idx] == "" {
+			for _, name := range HealthChecks[1:] {
+				result[idx].Checks = append(result[idx].Checks, HealthCheck{Name: name, Message: "skipped"})
+			}
+			return
+		}
+		result[idx].Checks = append(result[idx].Checks,
+			i.checkEtcd(ctx, log, data),
+			i.checkFleet(machineIDs[idx], data),
+			i.checkGluon(ctx, log),
+			i.checkTinc(ctx, log, cil),
+			i.checkOS(ctx, log, minOSVersion),
+			i.checkClusterMembers(ctx, log, cil),
+		)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// checkParallel calls the given function for all instances of the list in parallel and waits until all calls have returned.
+func (cil ClusterInstanceList) checkParallel(f func(idx int, i ClusterInstance)) {
+	wg := sync.WaitGroup{}
+	for idx, i := range cil {
+		wg.Add(1)
+		go func(idx int, i ClusterInstance) {
+			defer wg.Done()
+			f(idx, i)
+		}(idx, i)
+	}
+	wg.Wait()
+}
+
+// checkEtcd checks that the instance is a healthy ETCD member, or can reach a healthy member when it is a proxy.
+func (i ClusterInstance) checkEtcd(ctx context.Context, log *logging.Logger, data clusterHealthData) HealthCheck {
+	check := HealthCheck{Name: HealthCheckEtcd}
+	if data.EtcdErr != nil {
+		check.Message = fmt.Sprintf("cannot fetch cluster health: %v", data.EtcdErr)
+		return check
+	}
+	proxy, err := i.IsEtcdProxy(ctx, log)
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot fetch proxy status: %v", err)
+		return check
+	}
+	if proxy {
+		for _, m := range data.EtcdHealth {
+			if m.Healthy {
+				check.OK, check.Message = true, "proxy"
+				return check
+			}
+		}
+		check.Message = "proxy, no healthy members"
+		return check
+	}
+	info, found := FindEtcdMemberInfo(data.EtcdMembers, i.ClusterIP)
+	if !found {
+		check.Message = "not a member"
+		return check
+	}
+	for _, m := range data.EtcdHealth {
+		if m.ID == info.ID && m.Healthy {
+			check.OK, check.Message = true, "follower"
+			if info.IsLeader {
+				check.Message = "leader"
+			}
+			return check
+		}
+	}
+	check.Message = "unhealthy"
+	return check
+}
+
+// checkFleet checks that the instance is registered in fleet with region & odd/even metadata.
+func (i ClusterInstance) checkFleet(machineID string, data clusterHealthData) HealthCheck {
+	check := HealthCheck{Name: HealthCheckFleet}
+	if data.FleetErr != nil {
+		check.Message = fmt.Sprintf("cannot fetch machines: %v", data.FleetErr)
+		return check
+	}
+	m, found := FindFleetMachine(data.FleetMachines, machineID)
+	if !found {
+		check.Message = "not registered"
+		return check
+	}
+	keys := []string{}
+	for k, v := range m.Metadata {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	metadata := strings.Join(keys, ",")
+	switch {
+	case m.Metadata["region"] == "":
+		check.Message = fmt.Sprintf("no region in metadata '%s'", metadata)
+	case !m.HasMetadata("odd", "true") && !m.HasMetadata("even", "true"):
+		check.Message = fmt.Sprintf("no odd/even in metadata '%s'", metadata)
+	default:
+		check.OK, check.Message = true, metadata
+	}
+	return check
+}
+
+// checkGluon checks that gluon.service is active on the instance.
+func (i ClusterInstance) checkGluon(ctx context.Context, log *logging.Logger) HealthCheck {
+	check := HealthCheck{Name: HealthCheckGluon}
+	state, err := i.GetUnitState(ctx, log, "is-active", "gluon.service")
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot fetch state: %v", err)
+		return check
+	}
+	check.OK, check.Message = state == "active", state
+	return check
+}
+
+// checkTinc checks that all other instances of the given list can be reached through the tinc tunnel,
+// when tinc is used on the instance.
+func (i ClusterInstance) checkTinc(ctx context.Context, log *logging.Logger, all ClusterInstanceList) HealthCheck {
+	check := HealthCheck{Name: HealthCheckTinc}
+	state, err := i.GetUnitState(ctx, log, "is-enabled", "tinc.service")
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot fetch state: %v", err)
+		return check
+	}
+	if state != "enabled" {
+		check.OK, check.Message = true, "not used"
+		return check
+	}
+	unreachable := []string{}
+	peers := 0
+	for _, peer := range all {
+		if peer.Name == i.Name {
+			continue
+		}
+		peers++
+		if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("ping -c 1 -W 2 %s", peer.ClusterIP), "", true); err != nil {
+			unreachable = append(unreachable, peer.ClusterIP)
+		}
+	}
+	if len(unreachable) > 0 {
+		check.Message = fmt.Sprintf("cannot reach %s", strings.Join(unreachable, ", "))
+		return check
+	}
+	check.OK, check.Message = true, fmt.Sprintf("%d peers reachable", peers)
+	return check
+}
+
+// checkOS checks that the OS of the instance has at least the given version.
+// Only CoreOS versions are checked, other operating systems are reported as "n/a".
+func (i ClusterInstance) checkOS(ctx context.Context, log *logging.Logger, minOSVersion semver.Version) HealthCheck {
+	check := HealthCheck{Name: HealthCheckOS}
+	if i.OS != OSNameCoreOS {
+		check.OK, check.Message = true, "n/a"
+		return check
+	}
+	version, err := i.GetOSRelease(ctx, log)
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot fetch release: %v", err)
+		return check
+	}
+	if version.LessThan(minOSVersion) {
+		check.Message = fmt.Sprintf("%s, %s required", version, minOSVersion)
+		return check
+	}
+	check.OK, check.Message = true, version.String()
+	return check
+}
+
+// checkClusterMembers checks that the cluster members configured on the instance are exactly the given instances.
+func (i ClusterInstance) checkClusterMembers(ctx context.Context, log *logging.Logger, all ClusterInstanceList) HealthCheck {
+	check := HealthCheck{Name: HealthCheckMembers}
+	members, err := i.GetClusterMembers(ctx, log)
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot fetch cluster-members: %v", err)
+		return check
+	}
+	problems := []string{}
+	for _, x := range all {
+		if _, err := members.Find(x); err != nil {
+			problems = append(problems, fmt.Sprintf("%s missing", x.ClusterIP))
+		}
+	}
+	for _, cm := range members {
+		found := false
+		for _, x := range all {
+			if x.ClusterIP == cm.ClusterIP {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s unknown", cm.ClusterIP))
+		}
+	}
+	if len(problems) > 0 {
+		check.Message = strings.Join(problems, ", ")
+		return check
+	}
+	check.OK, check.Message = true, fmt.Sprintf("%d members", len(members))
+	return check
+}
@@ -16,6 +16,7 @@ package providers
 
 import (
 	"fmt"
+	"strings"
 )
 
 type ClusterMember struct {
@@ -39,6 +40,29 @@ func (cml ClusterMemberList) Render() string {
 	return data
 }
 
+// ParseClusterMemberList parses the content of /etc/pulcy/cluster-members, as created by Render.
+func ParseClusterMemberList(data string) ClusterMemberList {
+	result := ClusterMemberList{}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		parts := strings.SplitN(fields[0], "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		cm := ClusterMember{MachineID: parts[0], ClusterIP: parts[1]}
+		for _, option := range fields[1:] {
+			if option == "etcd-proxy" {
+				cm.EtcdProxy = true
+			}
+		}
+		result = append(result, cm)
+	}
+	return result
+}
+
 func (cml ClusterMemberList) Find(instance ClusterInstance) (ClusterMember, error) {
 	for _, cm := range cml {
 		if cm.ClusterIP == instance.ClusterIP {
@@ -47,3 +71,29 @@ func (cml ClusterMemberList) Find(instance ClusterInstance) (ClusterMember, erro
 	}
 	return ClusterMember{}, maskAny(NotFoundError)
 }
+
+// isEtcdProxy returns true if the given instance is an ETCD proxy according to the cluster members.
+func (cml ClusterMemberList) isEtcdProxy(i ClusterInstance) bool {
+	for _, m := range cml {
+		if m.ClusterIP == i.ClusterIP {
+			return m.EtcdProxy
+		}
+	}
+	return false
+}
+
+// etcdMemberCount returns the number of cluster members that are ETCD members (not proxies).
+func (cml ClusterMemberList) etcdMemberCount() int {
+	count := 0
+	for _, m := range cml {
+		if !m.EtcdProxy {
+			count++
+		}
+	}
+	return count
+}
+
+// quorum returns the number of ETCD members that form a quorum of the ETCD cluster.
+func (cml ClusterMemberList) quorum() int {
+	return cml.etcdMemberCount()/2 + 1
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultCreateTimeout is the default maximum time to wait for an instance to become available.
+	DefaultCreateTimeout = 10 * time.Minute
+	// DefaultSSHTimeout is the default maximum duration of a single remote command.
+	DefaultSSHTimeout = 10 * time.Minute
+	// DefaultHealthTimeout is the default maximum time to wait for a rebooted instance to rejoin etcd & fleet.
+	DefaultHealthTimeout = 5 * time.Minute
+
+	waitInterval = time.Second * 5
+)
+
+// Timeouts holds the per-step deadlines of long running operations.
+type Timeouts struct {
+	Create time.Duration // Maximum time to wait for an instance to become available
+	SSH    time.Duration // Maximum duration of a single remote command
+	Health time.Duration // Maximum time to wait for a rebooted instance to rejoin etcd & fleet
+}
+
+var (
+	timeouts = Timeouts{
+		Create: DefaultCreateTimeout,
+		SSH:    DefaultSSHTimeout,
+		Health: DefaultHealthTimeout,
+	}
+)
+
+// SetTimeouts replaces the per-step deadlines and returns the previous ones.
+// A zero duration disables the corresponding deadline.
+func SetTimeouts(t Timeouts) Timeouts {
+	previous := timeouts
+	timeouts = t
+	return previous
+}
+
+// withTimeout returns a child context of ctx with given timeout (if any).
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// IsCanceled returns true if the cause of the given error is a canceled or expired context.
+func IsCanceled(err error) bool {
+	cause := errgo.Cause(err)
+	return cause == context.Canceled || cause == context.DeadlineExceeded
+}
+
+// Sleep waits for the given duration, or until the given context is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return maskAny(ctx.Err())
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// WaitUntil calls ready every few seconds until it returns true or an error.
+// It gives up when the given context is done or when the create timeout has expired.
+func WaitUntil(ctx context.Context, what string, ready func(ctx context.Context) (bool, error)) error {
+	return maskAny(waitUntil(ctx, what, timeouts.Create, ready))
+}
+
+// waitUntil calls ready every few seconds until it returns true or an error.
+// It gives up when the given context is done or when the given timeout has expired.
+func waitUntil(ctx context.Context, what string, timeout time.Duration, ready func(ctx context.Context) (bool, error)) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		ok, err := ready(ctx)
+		if err != nil {
+			return maskAny(err)
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return maskAny(errgo.WithCausef(nil, ctx.Err(), "gave up waiting for %s: %v", what, ctx.Err()))
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+// Kinds of resources recorded in a ResourceTracker
+const (
+	ResourceServer     = "server"
+	ResourceIP         = "ip"
+	ResourceDnsRecord  = "dns-record"
+	ResourceEtcdMember = "etcd-member"
+)
+
+// UndoFunc removes a created resource again.
+type UndoFunc func(ctx context.Context) error
+
+// Resource is a resource created during an operation.
+type Resource struct {
+	Kind string `json:"kind" yaml:"kind"`
+	ID   string `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+
+	undo UndoFunc
+}
+
+// ResourceTracker is a journal of all resources created during an operation, so they can
+// be rolled back or reported when the operation fails or is interrupted.
+type ResourceTracker struct {
+	mutex     sync.Mutex
+	resources []Resource
+}
+
+// Resources returns all resources recorded so far.
+func (t *ResourceTracker) Resources() []Resource {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]Resource{}, t.resources...)
+}
+
+func (t *ResourceTracker) add(r Resource) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.resources = append(t.resources, r)
+}
+
+// Rollback removes all recorded resources, in reverse order of creation.
+// Resources that are removed are no longer recorded. It returns the removed resources
+// and the resources that could not be removed (which are still recorded).
+func (t *ResourceTracker) Rollback(ctx context.Context, log *logging.Logger) (removed, remaining []Resource) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for idx := len(t.resources) - 1; idx >= 0; idx-- {
+		r := t.resources[idx]
+		if r.undo == nil {
+			log.Warningf("Cannot remove %s %s (%s) automatically", r.Kind, r.Name, r.ID)
+			remaining = append(remaining, r)
+			continue
+		}
+		log.Infof("Removing %s %s (%s)", r.Kind, r.Name, r.ID)
+		if err := r.undo(ctx); err != nil {
+			log.Errorf("Failed to remove %s %s (%s): %v", r.Kind, r.Name, r.ID, err)
+			remaining = append(remaining, r)
+			continue
+		}
+		removed = append(removed, r)
+	}
+	// Keep the remaining resources in order of creation
+	t.resources = nil
+	for idx := len(remaining) - 1; idx >= 0; idx-- {
+		t.resources = append(t.resources, remaining[idx])
+	}
+	return removed, append([]Resource{}, t.resources...)
+}
+
+// Commit stops recording all resources recorded so far, so they are no longer rolled back or reported.
+func (t *ResourceTracker) Commit() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.resources = nil
+}
+
+type resourceTrackerKey struct{}
+
+// WithResourceTracker returns a child context of ctx that records created resources in the given tracker.
+func WithResourceTracker(ctx context.Context, t *ResourceTracker) context.Context {
+	return context.WithValue(ctx, resourceTrackerKey{}, t)
+}
+
+// TrackResource records a created resource in the tracker of the given context (if any).
+// The given undo function (can be nil) is used to remove the resource on a rollback.
+func TrackResource(ctx context.Context, kind, id, name string, undo UndoFunc) {
+	if t, ok := ctx.Value(resourceTrackerKey{}).(*ResourceTracker); ok && t != nil {
+		t.add(Resource{Kind: kind, ID: id, Name: name, undo: undo})
+	}
+}
+
+// AdoptResources moves all resources recorded in the given tracker to the tracker of the given context (if any).
+// It is used by operations that record the resources of a part in a separate tracker, so they can be committed
+// independently, when the part failed.
+func AdoptResources(ctx context.Context, from *ResourceTracker) {
+	t, ok := ctx.Value(resourceTrackerKey{}).(*ResourceTracker)
+	if !ok || t == nil || t == from {
+		return
+	}
+	from.mutex.Lock()
+	resources := from.resources
+	from.resources = nil
+	from.mutex.Unlock()
+	for _, r := range resources {
+		t.add(r)
+	}
+}
+
+// CommitResources commits all resources recorded in the tracker of the given context (if any).
+// It is used by operations that consist of multiple parts, once a part has succeeded.
+func CommitResources(ctx context.Context) {
+	if t, ok := ctx.Value(resourceTrackerKey{}).(*ResourceTracker); ok && t != nil {
+		t.Commit()
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// RemoteFileSystem gives access to the files of an instance.
+// The os.FileInfo values it returns have a FileOwner as Sys().
+type RemoteFileSystem interface {
+	// Stat returns information about the given path, following symbolic links.
+	Stat(path string) (os.FileInfo, error)
+	// ReadDir returns information about the entries of the given directory, without following symbolic links.
+	ReadDir(path string) ([]os.FileInfo, error)
+	// Open opens the given file for reading.
+	Open(path string) (io.ReadCloser, error)
+	// Create creates or truncates the given file for writing.
+	Create(path string, mode os.FileMode) (io.WriteCloser, error)
+	// Mkdir creates the given directory.
+	Mkdir(path string, mode os.FileMode) error
+	// Chmod changes the permissions of the given path.
+	Chmod(path string, mode os.FileMode) error
+	// Chown changes the numeric owner and group of the given path.
+	Chown(path string, uid, gid int) error
+	// Chtimes changes the access and modification time of the given path.
+	Chtimes(path string, atime, mtime time.Time) error
+	// Close releases the connection to the instance.
+	Close() error
+}
+
+// FileOwner holds the numeric owner and group of a remote file.
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// CopyOptions specifies how files are copied between the local machine and an instance.
+// Permissions are always copied.
+type CopyOptions struct {
+	Recursive bool // Copy directories with all their content
+	Preserve  bool // Preserve modification times and (numeric) ownership
+	Sudo      bool // Access the files on the instance as root
+}
+
+// CopyTo copies the local file or directory at localPath to remotePath on the instance.
+// When remotePath is an existing directory, the file or directory is copied into it.
+func (i ClusterInstance) CopyTo(ctx context.Context, log *logging.Logger, localPath, remotePath string, options CopyOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return maskAny(err)
+	}
+	fs, err := remoteExecutor.OpenFileSystem(ctx, log, i, options.Sudo)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer fs.Close()
+
+	if target, err := fs.Stat(remotePath); err == nil && target.IsDir() {
+		remotePath = path.Join(remotePath, filepath.Base(localPath))
+	}
+	c := copier{ctx: ctx, log: log, fs: fs, options: options}
+	if err := c.upload(localPath, remotePath, info); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// CopyFrom copies the file or directory at remotePath on the instance to localPath.
+// When localPath is an existing directory, the file or directory is copied into it.
+func (i ClusterInstance) CopyFrom(ctx context.Context, log *logging.Logger, remotePath, localPath string, options CopyOptions) error {
+	fs, err := remoteExecutor.OpenFileSystem(ctx, log, i, options.Sudo)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer fs.Close()
+
+	info, err := fs.Stat(remotePath)
+	if err != nil {
+		return maskAny(err)
+	}
+	if target, err := os.Stat(localPath); err == nil && target.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+	c := copier{ctx: ctx, log: log, fs: fs, options: options}
+	if err := c.download(remotePath, localPath, info); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// WriteFile writes the given content as root to the given path on the instance and sets its mode.
+// Missing parent directories are created.
+func (i ClusterInstance) WriteFile(ctx context.Context, log *logging.Logger, filePath, content string, mode os.FileMode) error {
+	ctx, cancel := withTimeout(ctx, timeouts.SSH)
+	defer cancel()
+	fs, err := remoteExecutor.OpenFileSystem(ctx, log, i, true)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer fs.Close()
+
+	if err := mkdirAll(fs, path.Dir(filePath)); err != nil {
+		return maskAny(err)
+	}
+	w, err := fs.Create(filePath, mode)
+	if err != nil {
+		return maskAny(err)
+	}
+	if _, err := io.Copy(w, strings.NewReader(content)); err != nil {
+		w.Close()
+		return maskAny(err)
+	}
+	if err := w.Close(); err != nil {
+		return maskAny(err)
+	}
+	// The mode given to Create is not used for existing files
+	if err := fs.Chmod(filePath, mode); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ReadFile reads the content of the given path on the instance as root.
+func (i ClusterInstance) ReadFile(ctx context.Context, log *logging.Logger, filePath string) (string, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.SSH)
+	defer cancel()
+	fs, err := remoteExecutor.OpenFileSystem(ctx, log, i, true)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	defer fs.Close()
+
+	r, err := fs.Open(filePath)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	return string(content), nil
+}
+
+// mkdirAll creates the given directory on the remote file system, including missing parents.
+func mkdirAll(fs RemoteFileSystem, dir string) error {
+	if info, err := fs.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return maskAny(fmt.Errorf("%s exists and is not a directory", dir))
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := mkdirAll(fs, parent); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := fs.Mkdir(dir, 0755); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// copier copies files and directories between the local machine and a remote file system.
+type copier struct {
+	ctx     context.Context
+	log     *logging.Logger
+	fs      RemoteFileSystem
+	options CopyOptions
+}
+
+// upload copies the local file or directory with given info to the remote file system.
+func (c copier) upload(localPath, remotePath string, info os.FileInfo) error {
+	if err := c.ctx.Err(); err != nil {
+		return maskAny(err)
+	}
+	switch {
+	case info.IsDir():
+		if !c.options.Recursive {
+			return maskAny(fmt.Errorf("%s is a directory, use a recursive copy", localPath))
+		}
+		if existing, err := c.fs.Stat(remotePath); err != nil {
+			// Make sure we can write the content, the real permissions are set afterwards
+			if err := c.fs.Mkdir(remotePath, info.Mode().Perm()|0700); err != nil {
+				return maskAny(err)
+			}
+		} else if !existing.IsDir() {
+			return maskAny(fmt.Errorf("%s exists and is not a directory", remotePath))
+		}
+		entries, err := ioutil.ReadDir(localPath)
+		if err != nil {
+			return maskAny(err)
+		}
+		for _, entry := range entries {
+			entryPath := filepath.Join(localPath, entry.Name())
+			if entry.Mode()&os.ModeSymlink != 0 {
+				// Copy the content of the link
+				if entry, err = os.Stat(entryPath); err != nil {
+					return maskAny(err)
+				}
+				if entry.IsDir() {
+					// Following it could loop forever
+					c.log.Warningf("Skipping %s, it is a link to a directory", entryPath)
+					continue
+				}
+			}
+			if err := c.upload(entryPath, path.Join(remotePath, entry.Name()), entry); err != nil {
+				return maskAny(err)
+			}
+		}
+	case info.Mode().IsRegular():
+		c.log.Debugf("Uploading %s to %s", localPath, remotePath)
+		f, err := os.Open(localPath)
+		if err != nil {
+			return maskAny(err)
+		}
+		defer f.Close()
+		w, err := c.fs.Create(remotePath, info.Mode().Perm())
+		if err != nil {
+			return maskAny(err)
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			w.Close()
+			return maskAny(err)
+		}
+		if err := w.Close(); err != nil {
+			return maskAny(err)
+		}
+	default:
+		c.log.Warningf("Skipping %s, it is not a regular file or directory", localPath)
+		return nil
+	}
+
+	if err := c.fs.Chmod(remotePath, permissions(info.Mode())); err != nil {
+		return maskAny(err)
+	}
+	if c.options.Preserve {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := c.fs.Chown(remotePath, int(stat.Uid), int(stat.Gid)); err != nil {
+				if c.options.Sudo {
+					return maskAny(err)
+				}
+				// Only root can give files away
+				c.log.Debugf("Cannot change owner of %s: %v", remotePath, err)
+			}
+		}
+		if err := c.fs.Chtimes(remotePath, info.ModTime(), info.ModTime()); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// download copies the remote file or directory with given info to the local file system.
+func (c copier) download(remotePath, localPath string, info os.FileInfo) error {
+	if err := c.ctx.Err(); err != nil {
+		return maskAny(err)
+	}
+	switch {
+	case info.IsDir():
+		if !c.options.Recursive {
+			return maskAny(fmt.Errorf("%s is a directory, use a recursive copy", remotePath))
+		}
+		if existing, err := os.Stat(localPath); err != nil {
+			// Make sure we can write the content, the real permissions are set afterwards
+			if err := os.Mkdir(localPath, info.Mode().Perm()|0700); err != nil {
+				return maskAny(err)
+			}
+		} else if !existing.IsDir() {
+			return maskAny(fmt.Errorf("%s exists and is not a directory", localPath))
+		}
+		entries, err := c.fs.ReadDir(remotePath)
+		if err != nil {
+			return maskAny(err)
+		}
+		for _, entry := range entries {
+			entryPath := path.Join(remotePath, entry.Name())
+			if entry.Mode()&os.ModeSymlink != 0 {
+				// Copy the content of the link
+				if entry, err = c.fs.Stat(entryPath); err != nil {
+					return maskAny(err)
+				}
+				if entry.IsDir() {
+					// Following it could loop forever
+					c.log.Warningf("Skipping %s, it is a link to a directory", entryPath)
+					continue
+				}
+			}
+			if err := c.download(entryPath, filepath.Join(localPath, path.Base(entryPath)), entry); err != nil {
+				return maskAny(err)
+			}
+		}
+	case info.Mode().IsRegular():
+		c.log.Debugf("Downloading %s to %s", remotePath, localPath)
+		r, err := c.fs.Open(remotePath)
+		if err != nil {
+			return maskAny(err)
+		}
+		defer r.Close()
+		f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return maskAny(err)
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return maskAny(err)
+		}
+		if err := f.Close(); err != nil {
+			return maskAny(err)
+		}
+	default:
+		c.log.Warningf("Skipping %s, it is not a regular file or directory", remotePath)
+		return nil
+	}
+
+	if err := os.Chmod(localPath, permissions(info.Mode())); err != nil {
+		return maskAny(err)
+	}
+	if c.options.Preserve {
+		if owner, ok := info.Sys().(FileOwner); ok {
+			if err := os.Lchown(localPath, owner.UID, owner.GID); err != nil {
+				if os.Getuid() == 0 {
+					return maskAny(err)
+				}
+				// Only root can give files away
+				c.log.Debugf("Cannot change owner of %s: %v", localPath, err)
+			}
+		}
+		if err := os.Chtimes(localPath, info.ModTime(), info.ModTime()); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// permissions returns the permission bits (including setuid, setgid & sticky) of the given mode.
+func permissions(mode os.FileMode) os.FileMode {
+	return mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// IsNotExist returns true if the given error is caused by a file that does not exist.
+func IsNotExist(err error) bool {
+	return os.IsNotExist(errgo.Cause(err))
+}
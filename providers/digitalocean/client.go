@@ -15,6 +15,8 @@
 package digitalocean
 
 import (
+	"net/http"
+
 	"github.com/digitalocean/godo"
 	"golang.org/x/oauth2"
 )
@@ -30,11 +32,17 @@ func (t *TokenSource) Token() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func NewDOClient(token string) *godo.Client {
+// NewDOClient creates a DigitalOcean API client that sends its requests using the given base transport.
+func NewDOClient(token string, base http.RoundTripper) *godo.Client {
 	tokenSource := &TokenSource{
 		AccessToken: token,
 	}
-	oauthClient := oauth2.NewClient(oauth2.NoContext, tokenSource)
+	oauthClient := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: tokenSource,
+			Base:   base,
+		},
+	}
 	client := godo.NewClient(oauthClient)
 	return client
 }
@@ -199,3 +207,35 @@ func DomainRecordList(client *godo.Client, domain string) ([]godo.DomainRecord,
 
 	return list, nil
 }
+
+func SizeList(client *godo.Client) ([]godo.Size, error) {
+	// create a list to hold our sizes
+	list := []godo.Size{}
+
+	// create options. initially, these will be blank
+	opt := &godo.ListOptions{}
+	for {
+		sizes, resp, err := client.Sizes.List(opt)
+		if err != nil {
+			return list, err
+		}
+
+		// append the current page's sizes to our list
+		list = append(list, sizes...)
+
+		// if we are at the last page, break out the for loop
+		if resp.Links.IsLastPage() {
+			break
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return list, err
+		}
+
+		// set the page we want for the next request
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
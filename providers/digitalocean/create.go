@@ -16,11 +16,13 @@ package digitalocean
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"sync"
-	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 	"github.com/pulcy/quark/templates"
@@ -36,7 +38,7 @@ type instanceData struct {
 	FleetMetadata         string
 }
 
-func (dp *doProvider) CreateCluster(log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
+func (dp *doProvider) CreateCluster(ctx context.Context, log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, options.InstanceCount)
 	instanceDatas := make(chan instanceData, options.InstanceCount)
@@ -51,7 +53,7 @@ func (dp *doProvider) CreateCluster(log *logging.Logger, options providers.Creat
 				errors <- maskAny(err)
 				return
 			}
-			instance, err := dp.CreateInstance(log, instanceOptions, dnsProvider)
+			instance, err := providers.CreateInstanceOnce(ctx, log, dp, instanceOptions, dnsProvider)
 			if err != nil {
 				errors <- maskAny(err)
 			} else {
@@ -78,19 +80,19 @@ func (dp *doProvider) CreateCluster(log *logging.Logger, options providers.Creat
 		instanceList = append(instanceList, data.ClusterInstance)
 	}
 
-	clusterMembers, err := instanceList.AsClusterMemberList(log, nil)
+	clusterMembers, err := instanceList.AsClusterMemberList(ctx, log, nil)
 	if err != nil {
 		return maskAny(err)
 	}
 
-	if err := dp.setupInstances(log, instances, clusterMembers); err != nil {
+	if err := dp.setupInstances(ctx, log, instances, clusterMembers); err != nil {
 		return maskAny(err)
 	}
 
 	return nil
 }
 
-func (dp *doProvider) setupInstances(log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
+func (dp *doProvider) setupInstances(ctx context.Context, log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, len(instances))
 	for _, instance := range instances {
@@ -101,7 +103,7 @@ func (dp *doProvider) setupInstances(log *logging.Logger, instances []instanceDa
 				ClusterMembers: clusterMembers,
 				FleetMetadata:  instance.FleetMetadata,
 			}
-			if err := instance.ClusterInstance.InitialSetup(log, instance.CreateInstanceOptions, iso, dp); err != nil {
+			if err := instance.ClusterInstance.InitialSetup(ctx, log, instance.CreateInstanceOptions, iso, dp); err != nil {
 				errors <- maskAny(err)
 				return
 			}
@@ -117,8 +119,8 @@ func (dp *doProvider) setupInstances(log *logging.Logger, instances []instanceDa
 	return nil
 }
 
-func (dp *doProvider) CreateInstance(log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
-	client := NewDOClient(dp.token)
+func (dp *doProvider) CreateInstance(ctx context.Context, log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+	client := dp.newClient()
 
 	keys := []godo.DropletCreateSSHKey{}
 	listedKeys, err := KeyList(client)
@@ -133,8 +135,13 @@ func (dp *doProvider) CreateInstance(log *logging.Logger, options providers.Crea
 		keys = append(keys, godo.DropletCreateSSHKey{ID: k.ID})
 	}
 
+	hostKey, err := providers.NewHostKey()
+	if err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
 	opts := options.NewCloudConfigOptions()
 	opts.PrivateIPv4 = "$private_ipv4"
+	opts.HostKey = hostKey
 
 	cloudConfig, err := templates.Render(cloudConfigTemplate, opts)
 	if err != nil {
@@ -155,43 +162,53 @@ func (dp *doProvider) CreateInstance(log *logging.Logger, options providers.Crea
 
 	// Create droplet
 	dp.Logger.Infof("Creating droplet: %s, %s, %s", request.Region, request.Size, options.ImageID)
-	dp.Logger.Debugf(cloudConfig)
 	createDroplet, _, err := client.Droplets.Create(request)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
+	providers.TrackResource(ctx, providers.ResourceServer, strconv.Itoa(createDroplet.ID), createDroplet.Name, func(ctx context.Context) error {
+		_, err := client.Droplets.Delete(createDroplet.ID)
+		return maskAny(err)
+	})
 
 	// Wait for active
 	dp.Logger.Infof("Waiting for droplet '%s'", createDroplet.Name)
-	droplet, err := dp.waitUntilDropletActive(createDroplet.ID)
+	droplet, err := dp.waitUntilDropletActive(ctx, createDroplet.ID)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
 
 	publicIpv4 := getIpv4(*droplet, "public")
 	publicIpv6 := getIpv6(*droplet, "public")
-	if err := providers.RegisterInstance(dp.Logger, dnsProvider, options, createDroplet.Name, options.RoleLoadBalancer, publicIpv4, publicIpv6); err != nil {
+	if err := providers.RegisterInstance(ctx, dp.Logger, dnsProvider, options, createDroplet.Name, options.RegisterClusterName(), publicIpv4, publicIpv6); err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+
+	// Pin the host key we injected, before anyone connects to the droplet
+	instance := dp.clusterInstance(*droplet)
+	if err := providers.PinHostKey(instance, hostKey.PublicKey); err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
 
 	dp.Logger.Infof("Droplet '%s' is ready", createDroplet.Name)
 
-	return dp.clusterInstance(*droplet), nil
+	return instance, nil
 }
 
-func (dp *doProvider) waitUntilDropletActive(id int) (*godo.Droplet, error) {
-	client := NewDOClient(dp.token)
-	for {
-		droplet, _, err := client.Droplets.Get(id)
+func (dp *doProvider) waitUntilDropletActive(ctx context.Context, id int) (*godo.Droplet, error) {
+	client := dp.newClient()
+	var droplet *godo.Droplet
+	if err := providers.WaitUntil(ctx, fmt.Sprintf("droplet %d to become active", id), func(ctx context.Context) (bool, error) {
+		var err error
+		droplet, _, err = client.Droplets.Get(id)
 		if err != nil {
-			return nil, err
-		}
-		if droplet.Status != "new" {
-			return droplet, nil
+			return false, maskAny(err)
 		}
-		// Wait a while
-		time.Sleep(time.Second * 5)
+		return droplet.Status != "new", nil
+	}); err != nil {
+		return nil, maskAny(err)
 	}
+	return droplet, nil
 }
 
 func findKeyID(key string, listedKeys []godo.Key) *godo.Key {
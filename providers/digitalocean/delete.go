@@ -15,16 +15,21 @@
 package digitalocean
 
 import (
+	"golang.org/x/net/context"
+
 	"github.com/pulcy/quark/providers"
 )
 
-func (this *doProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
+func (this *doProvider) DeleteCluster(ctx context.Context, info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
 	droplets, err := this.getInstances(info)
 	if err != nil {
 		return err
 	}
-	client := NewDOClient(this.token)
+	client := this.newClient()
 	for _, d := range droplets {
+		if err := ctx.Err(); err != nil {
+			return maskAny(err)
+		}
 		// Delete DNS instance records
 		instance := this.clusterInstance(d)
 		if err := providers.UnRegisterInstance(this.Logger, dnsProvider, instance, info.Domain); err != nil {
@@ -41,13 +46,13 @@ func (this *doProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider pr
 	return nil
 }
 
-func (dp *doProvider) DeleteInstance(info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
+func (dp *doProvider) DeleteInstance(ctx context.Context, info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
 	fullName := info.String()
 	droplets, err := dp.getInstances(info.ClusterInfo)
 	if err != nil {
 		return err
 	}
-	client := NewDOClient(dp.token)
+	client := dp.newClient()
 	for _, d := range droplets {
 		if d.Name == fullName {
 			// Delete DNS instance records
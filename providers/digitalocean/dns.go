@@ -15,46 +15,37 @@
 package digitalocean
 
 import (
-	"fmt"
-	"sort"
+	"strconv"
 
 	"github.com/digitalocean/godo"
-	"github.com/ryanuber/columnize"
+
+	"github.com/pulcy/quark/providers"
 )
 
-func (this *doProvider) ShowDomainRecords(domain string) error {
-	// Load images
-	client := NewDOClient(this.token)
+func (this *doProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	// Load records
+	client := this.newClient()
 	records, err := DomainRecordList(client, domain)
 	if err != nil {
-		return err
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"Type | Name | Data | Priority | Weight | Port",
-	}
+	result := []providers.DnsRecord{}
 	for _, r := range records {
-		line := fmt.Sprintf("%s | %s | %s | %v | %v | %v", r.Type, trimLength(r.Name, 20), trimLength(r.Data, 60), r.Priority, r.Weight, r.Port)
-		lines = append(lines, line)
+		result = append(result, providers.DnsRecord{
+			ID:       strconv.Itoa(r.ID),
+			Type:     r.Type,
+			Name:     r.Name,
+			Data:     r.Data,
+			Priority: r.Priority,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
-}
-
-func trimLength(s string, maxLen int) string {
-	if len(s) > maxLen {
-		return s[:maxLen] + "..."
-	} else {
-		return s
-	}
+	return result, nil
 }
 
 func (this *doProvider) CreateDnsRecord(domain, _type, name, data string) error {
-	client := NewDOClient(this.token)
+	client := this.newClient()
 	record := &godo.DomainRecordEditRequest{
 		Type: _type,
 		Name: name,
@@ -68,7 +59,7 @@ func (this *doProvider) CreateDnsRecord(domain, _type, name, data string) error
 }
 
 func (this *doProvider) DeleteDnsRecord(domain, _type, name, data string) error {
-	client := NewDOClient(this.token)
+	client := this.newClient()
 	records, err := DomainRecordList(client, domain)
 	if err != nil {
 		return err
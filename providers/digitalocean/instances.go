@@ -37,7 +37,7 @@ func (dp *doProvider) GetInstances(info providers.ClusterInfo) (providers.Cluste
 }
 
 func (dp *doProvider) getInstances(info providers.ClusterInfo) ([]godo.Droplet, error) {
-	client := NewDOClient(dp.token)
+	client := dp.newClient()
 	droplets, err := DropletList(client)
 	if err != nil {
 		return nil, err
@@ -64,6 +64,10 @@ func (dp *doProvider) clusterInstance(d godo.Droplet) providers.ClusterInstance
 		LoadBalancerIPv6: getIpv6(d, "public"),
 		ClusterDevice:    privateClusterDevice,
 		OS:               providers.OSNameCoreOS,
+		TypeID:           d.SizeSlug,
+	}
+	if d.Image != nil {
+		info.ImageID = d.Image.Slug
 	}
 	return info
 }
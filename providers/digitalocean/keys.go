@@ -15,31 +15,28 @@
 package digitalocean
 
 import (
-	"fmt"
-	"sort"
+	"strconv"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (this *doProvider) ShowKeys() error {
-	// Load images
-	client := NewDOClient(this.token)
+func (this *doProvider) ListKeys() ([]providers.SSHKey, error) {
+	// Load keys
+	client := this.newClient()
 	keys, err := KeyList(client)
 	if err != nil {
-		return err
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"ID | Name | FingerPrint | Public-key",
-	}
+	result := []providers.SSHKey{}
 	for _, r := range keys {
-		line := fmt.Sprintf("%v | %s | %s | %s", r.ID, r.Name, r.Fingerprint, r.PublicKey)
-		lines = append(lines, line)
+		result = append(result, providers.SSHKey{
+			ID:          strconv.Itoa(r.ID),
+			Name:        r.Name,
+			Fingerprint: r.Fingerprint,
+			PublicKey:   r.PublicKey,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
@@ -15,23 +15,53 @@
 package digitalocean
 
 import (
+	"net/http"
+
+	"github.com/digitalocean/godo"
 	"github.com/op/go-logging"
 
 	"github.com/pulcy/quark/providers"
 )
 
 type doProvider struct {
-	Logger *logging.Logger
-	token  string
+	Logger    *logging.Logger
+	token     string
+	transport http.RoundTripper // Shared by all API clients of this provider
+}
+
+const (
+	tokenCredential = "digitalocean-token"
+)
+
+func init() {
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name: "digitalocean",
+		Credentials: []providers.Credential{
+			{Name: tokenCredential, Shorthand: "t", EnvVar: "DIGITALOCEAN_TOKEN", Usage: "Digital Ocean token", Required: true, Secret: true},
+		},
+		Capabilities: []providers.Capability{
+			providers.CapabilityCluster,
+			providers.CapabilityInstance,
+			providers.CapabilityRegions,
+			providers.CapabilityImages,
+			providers.CapabilityKeys,
+			providers.CapabilityInstanceTypes,
+		},
+		NewCloudProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.CloudProvider, error) {
+			return NewProvider(log, credentials.Get(tokenCredential)), nil
+		},
+	})
 }
 
 func NewProvider(logger *logging.Logger, token string) providers.CloudProvider {
 	return &doProvider{
-		Logger: logger,
-		token:  token,
+		Logger:    logger,
+		token:     token,
+		transport: providers.NewTransport(logger, nil, providers.DefaultRetryPolicy),
 	}
 }
 
-func (vp *doProvider) ShowInstanceTypes() error {
-	return maskAny(NotImplementedError)
+// newClient creates a DigitalOcean API client that uses the shared transport of the provider.
+func (dp *doProvider) newClient() *godo.Client {
+	return NewDOClient(dp.token, dp.transport)
 }
@@ -15,35 +15,26 @@
 package digitalocean
 
 import (
-	"fmt"
-	"sort"
-	"strings"
-
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (this *doProvider) ShowRegions() error {
+func (this *doProvider) ListRegions() ([]providers.Region, error) {
 	// Load regions
-	client := NewDOClient(this.token)
+	client := this.newClient()
 	regions, err := RegionList(client)
 	if err != nil {
-		return err
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"Slug | Name | Features | Size",
-	}
+	result := []providers.Region{}
 	for _, r := range regions {
-		if !r.Available {
-			continue
-		}
-		line := fmt.Sprintf("%s | %s | %s | %s", r.Slug, r.Name, strings.Join(r.Features, " "), strings.Join(r.Sizes, " "))
-		lines = append(lines, line)
+		result = append(result, providers.Region{
+			ID:        r.Slug,
+			Name:      r.Name,
+			Available: r.Available,
+			Features:  r.Features,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
@@ -0,0 +1,44 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package digitalocean
+
+import (
+	"github.com/pulcy/quark/providers"
+)
+
+func (this *doProvider) ListInstanceTypes() ([]providers.InstanceType, error) {
+	// Load sizes
+	client := this.newClient()
+	sizes, err := SizeList(client)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	result := []providers.InstanceType{}
+	for _, s := range sizes {
+		result = append(result, providers.InstanceType{
+			ID:        s.Slug,
+			Name:      s.Slug,
+			CPU:       s.Vcpus,
+			RAM:       s.Memory,
+			Disk:      s.Disk,
+			Price:     s.PriceMonthly,
+			Regions:   s.Regions,
+			Available: s.Available,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// IsQuorumError returns true if the cause of the given error is an operation
+// that would make ETCD lose its quorum.
+func IsQuorumError(err error) bool {
+	return errgo.Cause(err) == QuorumError
+}
+
+// DrainAndRemove prepares the given instance for deletion from the cluster formed by the
+// remaining instances. It stops fleet on the instance, waits until its units have moved
+// to other machines and removes it from ETCD through one of the remaining instances.
+// Unless force is set, it refuses to remove an ETCD member when the remaining healthy
+// members would not form a quorum.
+func (remaining ClusterInstanceList) DrainAndRemove(ctx context.Context, log *logging.Logger, victim ClusterInstance, force bool) error {
+	if len(remaining) == 0 {
+		log.Infof("%s is the last instance of the cluster, nothing to drain", victim)
+		return nil
+	}
+
+	// Find a remaining instance that can reach ETCD
+	var peer ClusterInstance
+	var health []EtcdMemberHealth
+	found := false
+	for _, i := range remaining {
+		members, err := i.EtcdClusterHealth(ctx, log)
+		if err != nil {
+			log.Warningf("Cannot fetch etcd health on %s: %v", i, err)
+			continue
+		}
+		peer, health, found = i, members, true
+		break
+	}
+	if !found {
+		if !force {
+			return maskAny(fmt.Errorf("none of the remaining instances can reach etcd, use --force to remove %s anyway", victim))
+		}
+		log.Warningf("None of the remaining instances can reach etcd, deleting %s without draining it", victim)
+		return nil
+	}
+
+	victimMember, isMember := FindEtcdMemberHealth(health, victim.ClusterIP)
+	if isMember {
+		healthy := 0
+		for _, m := range health {
+			if m.Healthy && m.ID != victimMember.ID {
+				healthy++
+			}
+		}
+		quorum := (len(health)-1)/2 + 1
+		if healthy < quorum {
+			msg := fmt.Sprintf("only %d healthy etcd members remain after removing %s, %d needed", healthy, victim, quorum)
+			if !force {
+				return maskAny(errgo.WithCausef(nil, QuorumError, "%s, use --force to remove it anyway", msg))
+			}
+			log.Warningf("%s, removing it anyway", msg)
+		}
+	}
+
+	if err := victim.drainFleet(ctx, log, peer); err != nil {
+		if !force {
+			return maskAny(err)
+		}
+		log.Warningf("Cannot drain %s from fleet: %v", victim, err)
+	}
+
+	if isMember {
+		if err := peer.RemoveEtcdMember(ctx, log, victim.Name, victim.ClusterIP); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// drainFleet stops fleet on the instance and waits until the given peer no longer
+// sees any units scheduled on it.
+func (i ClusterInstance) drainFleet(ctx context.Context, log *logging.Logger, peer ClusterInstance) error {
+	machineID, err := i.GetMachineID(ctx, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Stopping fleet on %s", i)
+	if _, err := i.runRemoteCommand(ctx, log, "sudo systemctl stop fleet.socket fleet.service", "", false); err != nil {
+		return maskAny(err)
+	}
+	if err := waitUntil(ctx, fmt.Sprintf("units to move away from %s", i), timeouts.Health, func(ctx context.Context) (bool, error) {
+		units, err := peer.ListFleetUnits(ctx, log)
+		if err != nil {
+			log.Debugf("Cannot list fleet units on %s: %v", peer, err)
+			return false, nil
+		}
+		for _, u := range units {
+			if u.MachineID == machineID {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// Kinds of drift
+const (
+	DriftKindFile             = "file"
+	DriftKindDnsRecord        = "dns-record"
+	DriftKindForeignDnsRecord = "foreign-dns-record" // Record below the cluster name that quark did not create
+)
+
+const (
+	clusterIDPath      = "/etc/pulcy/cluster-id"
+	clusterMembersPath = "/etc/pulcy/cluster-members"
+	vaultEnvPath       = "/etc/pulcy/vault.env"
+	vaultCrtPath       = "/etc/pulcy/vault.crt"
+)
+
+// Drift is a difference between the state quark would generate for a cluster and its actual state.
+type Drift struct {
+	Kind     string // DriftKindFile, DriftKindDnsRecord or DriftKindForeignDnsRecord
+	Target   string // Name of the instance (file) or of the DNS record
+	Item     string // Path of the file, or type of the DNS record
+	Expected string // Desired content (empty when the item must not exist)
+	Actual   string // Actual content (empty when the item does not exist)
+}
+
+// Description returns a short, single line description of the drift.
+func (d Drift) Description() string {
+	switch {
+	case d.Kind == DriftKindForeignDnsRecord:
+		return fmt.Sprintf("not managed by quark (%s), left alone", d.Actual)
+	case d.Actual == "":
+		if d.Kind == DriftKindDnsRecord {
+			return fmt.Sprintf("missing (%s)", d.Expected)
+		}
+		return "missing"
+	case d.Expected == "":
+		if d.Kind == DriftKindDnsRecord {
+			return fmt.Sprintf("unexpected (%s)", d.Actual)
+		}
+		return "unexpected"
+	case d.Kind == DriftKindFile && d.Item == clusterIDPath:
+		// Do not show the cluster ID, it is used to authenticate with vault
+		return "differs"
+	}
+	expected, actual := strings.Split(d.Expected, "\n"), strings.Split(d.Actual, "\n")
+	for idx := range expected {
+		if idx >= len(actual) {
+			return fmt.Sprintf("line %d missing: '%s'", idx+1, expected[idx])
+		}
+		if expected[idx] != actual[idx] {
+			return fmt.Sprintf("line %d: expected '%s', got '%s'", idx+1, expected[idx], actual[idx])
+		}
+	}
+	return fmt.Sprintf("line %d unexpected: '%s'", len(expected)+1, actual[len(expected)])
+}
+
+// Repairable returns true if Repair re-applies the drift.
+func (d Drift) Repairable() bool {
+	return d.Kind != DriftKindForeignDnsRecord
+}
+
+// ClusterState is the state quark generates on all instances of a cluster.
+type ClusterState struct {
+	ClusterID        string            // Content of /etc/pulcy/cluster-id
+	VaultAddress     string            // VAULT_ADDR in /etc/pulcy/vault.env
+	VaultCertificate string            // Content of /etc/pulcy/vault.crt
+	Members          ClusterMemberList // Content of /etc/pulcy/cluster-members
+}
+
+// files returns the content of all files quark generates from the state, keyed by path.
+// Files for which the state holds no value are left out.
+func (s ClusterState) files() map[string]string {
+	result := map[string]string{
+		clusterMembersPath: s.Members.Render(),
+	}
+	if s.ClusterID != "" {
+		result[clusterIDPath] = s.ClusterID
+	}
+	if s.VaultAddress != "" {
+		result[vaultEnvPath] = vaultEnv(s.VaultAddress)
+	}
+	if s.VaultCertificate != "" {
+		result[vaultCrtPath] = s.VaultCertificate
+	}
+	return result
+}
+
+// DesiredClusterState creates the state quark would generate for the cluster formed by the instances of the list.
+// The cluster members are derived from the instances. The cluster ID & vault settings are those found on most instances,
+// they can be overridden by the caller.
+func (cil ClusterInstanceList) DesiredClusterState(ctx context.Context, log *logging.Logger) (ClusterState, error) {
+	members := make(ClusterMemberList, len(cil))
+	clusterIDs := make([]string, len(cil))
+	vaultAddresses := make([]string, len(cil))
+	vaultCertificates := make([]string, len(cil))
+	errors := make(chan error, len(cil))
+	cil.checkParallel(func(idx int, i ClusterInstance) {
+		machineID, err := i.GetMachineID(ctx, log)
+		if err != nil {
+			errors <- maskAny(err)
+			return
+		}
+		etcdProxy, err := i.IsEtcdProxy(ctx, log)
+		if err != nil {
+			errors <- maskAny(err)
+			return
+		}
+		members[idx] = ClusterMember{MachineID: machineID, ClusterIP: i.ClusterIP, EtcdProxy: etcdProxy}
+		// Missing values do not count
+		clusterIDs[idx], _ = i.GetClusterID(ctx, log)
+		vaultAddresses[idx], _ = i.GetVaultAddr(ctx, log)
+		vaultCertificates[idx], _ = i.GetVaultCrt(ctx, log)
+	})
+	close(errors)
+	if err := <-errors; err != nil {
+		return ClusterState{}, maskAny(err)
+	}
+	return ClusterState{
+		ClusterID:        mostCommon(clusterIDs),
+		VaultAddress:     mostCommon(vaultAddresses),
+		VaultCertificate: mostCommon(vaultCertificates),
+		Members:          members,
+	}, nil
+}
+
+// mostCommon returns the non-empty value that occurs most often in the given list.
+// Of values that occur equally often, the first one is returned.
+func mostCommon(values []string) string {
+	counts := make(map[string]int)
+	result := ""
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		counts[v]++
+		if counts[v] > counts[result] {
+			result = v
+		}
+	}
+	return result
+}
+
+// DiffFiles compares the files quark generates from the given state with the actual files on all instances of the list.
+func (cil ClusterInstanceList) DiffFiles(ctx context.Context, log *logging.Logger, state ClusterState) ([]Drift, error) {
+	files := state.files()
+	paths := []string{}
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	drifts := make([][]Drift, len(cil))
+	cil.checkParallel(func(idx int, i ClusterInstance) {
+		for _, p := range paths {
+			// A file that cannot be read is considered missing
+			actual, _ := i.runRemoteCommand(ctx, log, "sudo cat "+p, "", true)
+			expected := files[p]
+			if p == clusterMembersPath {
+				// The order of the members is not relevant
+				expected, actual = sortLines(expected), sortLines(actual)
+			}
+			expected, actual = strings.TrimSpace(expected), strings.TrimSpace(actual)
+			if expected != actual {
+				drifts[idx] = append(drifts[idx], Drift{Kind: DriftKindFile, Target: i.Name, Item: p, Expected: expected, Actual: actual})
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	result := []Drift{}
+	for _, list := range drifts {
+		result = append(result, list...)
+	}
+	return result, nil
+}
+
+// sortLines returns the given text with its lines sorted.
+func sortLines(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// DiffDnsRecords compares the DNS records RegisterInstance creates for all given instances of the given cluster
+// with the actual records. isLoadBalancer tells which instances are registered under the cluster name.
+// Only A & AAAA records of the cluster name and of the instance names are considered. Other A & AAAA records
+// directly below the cluster name are reported as DriftKindForeignDnsRecord, all other records are ignored.
+func DiffDnsRecords(dnsProvider DnsProvider, info ClusterInfo, instances ClusterInstanceList, isLoadBalancer func(ClusterInstance) bool) ([]Drift, error) {
+	desired := []DnsRecord{}
+	names := map[string]bool{info.String(): true}
+	for _, i := range instances {
+		options := CreateInstanceOptions{ClusterInfo: info, ClusterName: info.String(), InstanceName: i.Name}
+		desired = append(desired, RegisterInstanceRecords(options, isLoadBalancer(i), i.LoadBalancerIPv4, i.LoadBalancerIPv6)...)
+		names[i.Name] = true
+	}
+	actual, err := dnsProvider.ListDnsRecords(info.Domain)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	managed := []DnsRecord{}
+	foreign := []DnsRecord{}
+	for _, r := range actual {
+		if !isClusterRecord(r, info) {
+			continue
+		}
+		if names[r.Name] {
+			managed = append(managed, r)
+		} else {
+			foreign = append(foreign, r)
+		}
+	}
+
+	result := []Drift{}
+	for _, r := range desired {
+		if !containsDnsRecord(managed, r) {
+			result = append(result, Drift{Kind: DriftKindDnsRecord, Target: r.Name, Item: r.Type, Expected: r.Data})
+		}
+	}
+	for _, r := range managed {
+		if !containsDnsRecord(desired, r) {
+			result = append(result, Drift{Kind: DriftKindDnsRecord, Target: r.Name, Item: r.Type, Actual: r.Data})
+		}
+	}
+	for _, r := range foreign {
+		result = append(result, Drift{Kind: DriftKindForeignDnsRecord, Target: r.Name, Item: r.Type, Actual: r.Data})
+	}
+	return result, nil
+}
+
+// isClusterRecord returns true if the given record is an A or AAAA record of the given cluster or of
+// a name directly below it.
+func isClusterRecord(r DnsRecord, info ClusterInfo) bool {
+	if r.Type != "A" && r.Type != "AAAA" {
+		return false
+	}
+	clusterName := info.String()
+	if r.Name == clusterName {
+		return true
+	}
+	prefix := strings.TrimSuffix(r.Name, "."+clusterName)
+	return prefix != r.Name && prefix != "" && !strings.Contains(prefix, ".")
+}
+
+// Repair re-applies all given drifts of the cluster formed by the instances of the list, using the given state.
+// Files are rewritten with the functions used by InitialSetup & UpdateClusterMembers, missing DNS records
+// are created by RegisterInstance and unexpected DNS records of the cluster & its instances are removed.
+// Foreign DNS records are left alone. Instances are not rebooted.
+func (cil ClusterInstanceList) Repair(ctx context.Context, log *logging.Logger, state ClusterState, drifts []Drift, dnsProvider DnsProvider, info ClusterInfo, isLoadBalancer func(ClusterInstance) bool) error {
+	paths := make(map[string][]string) // Paths of drifted files, keyed by instance name
+	missingRecords := make(map[string]bool)
+	for _, d := range drifts {
+		switch d.Kind {
+		case DriftKindFile:
+			paths[d.Target] = append(paths[d.Target], d.Item)
+		case DriftKindDnsRecord:
+			if d.Expected != "" {
+				missingRecords[d.Expected] = true
+			}
+		}
+	}
+
+	// Repair files on all instances in parallel
+	wg := sync.WaitGroup{}
+	errors := make(chan error, len(cil))
+	for _, i := range cil {
+		if len(paths[i.Name]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i ClusterInstance) {
+			defer wg.Done()
+			if err := i.repairFiles(ctx, log, state, paths[i.Name]); err != nil {
+				errors <- maskAny(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errors)
+	if err := <-errors; err != nil {
+		return maskAny(err)
+	}
+
+	// Create missing DNS records of every instance that has one of the missing addresses
+	for _, i := range cil {
+		if !missingRecords[i.LoadBalancerIPv4] && !missingRecords[i.LoadBalancerIPv6] {
+			continue
+		}
+		options := CreateInstanceOptions{ClusterInfo: info, ClusterName: info.String(), InstanceName: i.Name}
+		if err := RegisterInstance(ctx, log, dnsProvider, options, i.Name, isLoadBalancer(i), i.LoadBalancerIPv4, i.LoadBalancerIPv6); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	// Remove unexpected DNS records
+	for _, d := range drifts {
+		// Foreign DNS records have a different kind, they are only reported
+		if d.Kind != DriftKindDnsRecord || d.Expected != "" {
+			continue
+		}
+		log.Infof("Removing DNS record %s %s %s", d.Item, d.Target, d.Actual)
+		if err := dnsProvider.DeleteDnsRecord(info.Domain, d.Item, d.Target, d.Actual); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// repairFiles rewrites the given files on the instance from the given state.
+func (i ClusterInstance) repairFiles(ctx context.Context, log *logging.Logger, state ClusterState, paths []string) error {
+	cio := CreateInstanceOptions{
+		VaultAddress:     state.VaultAddress,
+		VaultCertificate: state.VaultCertificate,
+	}
+	iso := InitialSetupOptions{ClusterMembers: state.Members}
+	for _, p := range paths {
+		log.Infof("Repairing %s on %s", p, i)
+		var err error
+		switch p {
+		case clusterMembersPath:
+			// Restarts gluon, so the new members are used
+			err = i.UpdateClusterMembers(ctx, log, state.Members)
+		case vaultEnvPath:
+			err = i.RunSetupStep(ctx, log, "vault-env", cio, iso, nil)
+		case vaultCrtPath:
+			err = i.RunSetupStep(ctx, log, "vault-crt", cio, iso, nil)
+		case clusterIDPath:
+			err = i.WriteFile(ctx, log, clusterIDPath, state.ClusterID, 0400)
+		default:
+			err = fmt.Errorf("cannot repair %s", p)
+		}
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
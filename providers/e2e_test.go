@@ -0,0 +1,687 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+	"github.com/pulcy/quark/providers/fake"
+)
+
+const (
+	testVaultCertificate = "-----BEGIN CERTIFICATE-----\ntest\n-----END CERTIFICATE-----"
+)
+
+var (
+	log = logging.MustGetLogger("test")
+)
+
+func init() {
+	logging.SetLevel(logging.CRITICAL, "test")
+}
+
+// testCluster is a cluster created with the fake provider.
+type testCluster struct {
+	Executor *fake.Executor
+	Provider *fake.Provider
+	DNS      *fake.DnsProvider
+	Options  providers.CreateClusterOptions
+
+	previousExecutor providers.RemoteExecutor
+	certPath         string
+}
+
+// newTestCluster creates a cluster with given number of instances using the fake provider.
+// The returned cluster must be closed at the end of the test.
+func newTestCluster(t *testing.T, instanceCount int) testCluster {
+	executor := fake.NewExecutor()
+	certPath := writeTempFile(t, testVaultCertificate)
+	c := testCluster{
+		previousExecutor: providers.SetRemoteExecutor(executor),
+		certPath:         certPath,
+		Executor:         executor,
+		Provider:         fake.NewProvider(log, executor),
+		DNS:              fake.NewDnsProvider(),
+	}
+	c.Options = c.Provider.CreateClusterDefaults(providers.CreateClusterOptions{
+		ClusterInfo: providers.ClusterInfo{
+			ID:     "testclusterid",
+			Domain: "example.com",
+			Name:   "c1",
+		},
+		InstanceConfig:          providers.InstanceConfig{MinOSVersion: "835.13.0"},
+		SSHKeyNames:             []string{"test"},
+		SSHKeyGithubAccount:     "test",
+		InstanceCount:           instanceCount,
+		GluonImage:              "pulcy/gluon:test",
+		RebootStrategy:          "etcd-lock",
+		PrivateRegistryUrl:      "https://registry.example.com",
+		PrivateRegistryUserName: "user",
+		PrivateRegistryPassword: "secret",
+		VaultAddress:            "https://vault.example.com:8200",
+		VaultCertificatePath:    certPath,
+	})
+	if err := c.Options.Validate(); err != nil {
+		c.Close()
+		t.Fatalf("Invalid options: %v", err)
+	}
+	c.Options.SetupInstancePrefixes()
+	if err := c.Provider.CreateCluster(context.Background(), log, c.Options, c.DNS); err != nil {
+		c.Close()
+		t.Fatalf("CreateCluster failed: %v", err)
+	}
+	return c
+}
+
+// newRunningTestCluster creates a cluster like newTestCluster and bootstraps it like `quark cluster create`,
+// so etcd & fleet are running on all instances.
+func newRunningTestCluster(t *testing.T, instanceCount int) testCluster {
+	c := newTestCluster(t, instanceCount)
+	if err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootBootstrap, nil, c.Provider); err != nil {
+		c.Close()
+		t.Fatalf("Bootstrap reboot failed: %v", err)
+	}
+	return c
+}
+
+// Close restores the remote executor and removes temporary files.
+func (c testCluster) Close() {
+	providers.SetRemoteExecutor(c.previousExecutor)
+	os.Remove(c.certPath)
+}
+
+// Instances returns all instances of the cluster.
+func (c testCluster) Instances(t *testing.T) providers.ClusterInstanceList {
+	instances, err := c.Provider.GetInstances(c.Options.ClusterInfo)
+	if err != nil {
+		t.Fatalf("GetInstances failed: %v", err)
+	}
+	return instances
+}
+
+// Machine returns the simulated state of the given instance.
+func (c testCluster) Machine(t *testing.T, i providers.ClusterInstance) fake.Machine {
+	m, ok := c.Executor.Machine(i.Name)
+	if !ok {
+		t.Fatalf("No machine for %s", i.Name)
+	}
+	return m
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+	return f.Name()
+}
+
+func TestCreateCluster(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+
+	for _, i := range instances {
+		m := c.Machine(t, i)
+		if m.Files["/etc/pulcy/vault.crt"] != testVaultCertificate {
+			t.Errorf("Unexpected vault.crt on %s: %q", i.Name, m.Files["/etc/pulcy/vault.crt"])
+		}
+		if !strings.Contains(m.Files["/etc/pulcy/vault.env"], "VAULT_ADDR=https://vault.example.com:8200") {
+			t.Errorf("Unexpected vault.env on %s: %q", i.Name, m.Files["/etc/pulcy/vault.env"])
+		}
+		for _, p := range []string{"/etc/pulcy/vault.env", "/etc/pulcy/vault.crt"} {
+			if m.Modes[p] != "0400" {
+				t.Errorf("Expected mode 0400 for %s on %s, got %q", p, i.Name, m.Modes[p])
+			}
+		}
+		if m.Files["/home/core/bin/gluon"] != "pulcy/gluon:test" {
+			t.Errorf("Expected gluon to be downloaded on %s", i.Name)
+		}
+		if len(m.GluonSetups) != 1 {
+			t.Fatalf("Expected 1 gluon setup on %s, got %d", i.Name, len(m.GluonSetups))
+		}
+		for _, arg := range []string{"--private-ip=" + i.ClusterIP, "--private-registry-url=https://registry.example.com"} {
+			if !strings.Contains(m.GluonSetups[0], arg) {
+				t.Errorf("Expected gluon setup on %s to contain %s, got %q", i.Name, arg, m.GluonSetups[0])
+			}
+		}
+		if !strings.Contains(m.FleetMetadata, "core=true") || !strings.Contains(m.FleetMetadata, "lb=true") {
+			t.Errorf("Unexpected fleet metadata on %s: %q", i.Name, m.FleetMetadata)
+		}
+		if members := strings.Count(m.Files["/etc/pulcy/cluster-members"], "\n"); members != 3 {
+			t.Errorf("Expected 3 cluster members on %s, got %d", i.Name, members)
+		}
+	}
+
+	if members := c.Executor.EtcdMembers("c1.example.com"); len(members) != 3 {
+		t.Errorf("Expected 3 etcd members, got %d", len(members))
+	}
+
+	records, _ := c.DNS.ListDnsRecords("example.com")
+	if len(records) != 6 {
+		t.Errorf("Expected 6 DNS records (3 instance, 3 cluster), got %d: %v", len(records), records)
+	}
+	for _, i := range instances {
+		if !hasRecord(records, "A", i.Name, i.LoadBalancerIPv4) {
+			t.Errorf("Missing instance A record for %s", i.Name)
+		}
+		if !hasRecord(records, "A", "c1.example.com", i.LoadBalancerIPv4) {
+			t.Errorf("Missing cluster A record for %s", i.Name)
+		}
+	}
+}
+
+func TestUpdateClusterMembers(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+	proxy := instances[2]
+	isEtcdProxy := func(i providers.ClusterInstance) bool { return i.Name == proxy.Name }
+
+	if err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootBootstrap, isEtcdProxy, c.Provider); err != nil {
+		t.Fatalf("UpdateClusterMembers failed: %v", err)
+	}
+
+	for _, i := range instances {
+		m := c.Machine(t, i)
+		members := m.Files["/etc/pulcy/cluster-members"]
+		if !strings.Contains(members, fmt.Sprintf("=%s etcd-proxy\n", proxy.ClusterIP)) {
+			t.Errorf("Expected %s to be an etcd-proxy in cluster-members of %s, got %q", proxy.Name, i.Name, members)
+		}
+		if strings.Count(members, "etcd-proxy") != 1 {
+			t.Errorf("Expected exactly 1 etcd-proxy in cluster-members of %s, got %q", i.Name, members)
+		}
+		if m.Restarts["gluon.service"] != 1 {
+			t.Errorf("Expected gluon to be restarted once on %s, got %d", i.Name, m.Restarts["gluon.service"])
+		}
+		for _, unit := range []string{"etcd2.service", "fleet.service", "fleet.socket", "ip4tables.service", "ip6tables.service"} {
+			if !m.Enabled[unit] {
+				t.Errorf("Expected %s to be enabled on %s", unit, i.Name)
+			}
+		}
+		if m.Reboots != 1 {
+			t.Errorf("Expected 1 reboot of %s, got %d", i.Name, m.Reboots)
+		}
+		if !m.IsActive("etcd2.service") || !m.IsActive("fleet.service") {
+			t.Errorf("Expected etcd & fleet to be started by the reboot of %s", i.Name)
+		}
+	}
+}
+
+func TestRollingRebootNewCluster(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+
+	// etcd is enabled, but only started by the first reboot, so the quorum cannot be checked
+	err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootRolling, nil, c.Provider)
+	if err == nil {
+		t.Fatal("Expected rolling reboot of a new cluster to fail")
+	}
+	for _, i := range instances {
+		if m := c.Machine(t, i); m.Reboots != 0 {
+			t.Errorf("Expected no reboot of %s, got %d", i.Name, m.Reboots)
+		}
+	}
+}
+
+func TestRollingReboot(t *testing.T) {
+	c := newRunningTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+	executed := len(c.Executor.Commands())
+
+	if err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootRolling, nil, c.Provider); err != nil {
+		t.Fatalf("UpdateClusterMembers failed: %v", err)
+	}
+
+	// Every instance must be healthy again before the next one is rebooted
+	rebooting := ""
+	healthChecked := true
+	for _, cmd := range c.Executor.Commands()[executed:] {
+		parts := strings.SplitN(cmd, ": ", 2)
+		switch {
+		case parts[1] == "sudo shutdown -r now":
+			if !healthChecked {
+				t.Errorf("%s rebooted before %s was healthy again", parts[0], rebooting)
+			}
+			rebooting, healthChecked = parts[0], false
+		case parts[0] == rebooting && strings.HasPrefix(parts[1], "fleetctl list-machines"):
+			healthChecked = true
+		}
+	}
+	if !healthChecked {
+		t.Errorf("Expected %s to be checked after its reboot", rebooting)
+	}
+	for _, i := range instances {
+		if m := c.Machine(t, i); m.Reboots != 2 {
+			t.Errorf("Expected 2 reboots (bootstrap & rolling) of %s, got %d", i.Name, m.Reboots)
+		}
+	}
+}
+
+func TestRollingRebootQuorum(t *testing.T) {
+	c := newRunningTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+	cluster := c.Machine(t, instances[0]).Cluster
+
+	// Rebooting 2 out of 3 members at once loses the quorum
+	previous := providers.SetRebootParallelism(2)
+	err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootRolling, nil, c.Provider)
+	providers.SetRebootParallelism(previous)
+	if err == nil {
+		t.Errorf("Expected reboot of 2 members at once to fail")
+	}
+
+	// Rebooting a healthy member while another one is unhealthy loses the quorum
+	c.Executor.SetEtcdMemberHealthy(cluster, instances[1].Name, false)
+	if err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootRolling, nil, c.Provider); err == nil {
+		t.Errorf("Expected reboot with an unhealthy member to fail")
+	}
+	for _, i := range instances {
+		if m := c.Machine(t, i); m.Reboots != 1 {
+			t.Errorf("Expected only the bootstrap reboot of %s, got %d", i.Name, m.Reboots)
+		}
+	}
+}
+
+func TestRollingRebootHealthTimeout(t *testing.T) {
+	c := newRunningTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+	previous := providers.SetTimeouts(providers.Timeouts{Health: 10 * time.Millisecond})
+	defer providers.SetTimeouts(previous)
+
+	// The first instance does not become healthy after its reboot
+	c.Executor.SetEtcdMemberHealthy(c.Machine(t, instances[0]).Cluster, instances[0].Name, false)
+	err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootRolling, nil, c.Provider)
+	if !providers.IsCanceled(err) {
+		t.Fatalf("Expected health wait to time out, got %v", err)
+	}
+	if m := c.Machine(t, instances[1]); m.Reboots != 1 {
+		t.Errorf("Expected no reboot of %s after the failed health check, got %d", instances[1].Name, m.Reboots-1)
+	}
+}
+
+func TestUpdateClusterMembersFailure(t *testing.T) {
+	c := newTestCluster(t, 2)
+	defer c.Close()
+	c.Executor.FailOn("systemctl restart gluon.service", errors.New("gluon failed"))
+
+	if err := providers.UpdateClusterMembers(context.Background(), log, c.Options.ClusterInfo, providers.RebootNone, nil, c.Provider); err == nil {
+		t.Fatal("Expected UpdateClusterMembers to fail")
+	}
+}
+
+func TestReconfigureTincCluster(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+
+	if err := providers.ReconfigureTincCluster(context.Background(), log, c.Options.ClusterInfo, c.Provider); err != nil {
+		t.Fatalf("ReconfigureTincCluster failed: %v", err)
+	}
+
+	for _, i := range instances {
+		m := c.Machine(t, i)
+		conf := m.Files["/etc/tinc/pulcy/tinc.conf"]
+		if !strings.Contains(conf, "Name = "+tincName(i)) {
+			t.Errorf("Unexpected tinc.conf on %s: %q", i.Name, conf)
+		}
+		for _, x := range instances {
+			connectTo := "ConnectTo = " + tincName(x)
+			if x.Name == i.Name && strings.Contains(conf, connectTo) {
+				t.Errorf("tinc.conf on %s must not connect to itself", i.Name)
+			} else if x.Name != i.Name && !strings.Contains(conf, connectTo) {
+				t.Errorf("Expected tinc.conf on %s to connect to %s, got %q", i.Name, x.Name, conf)
+			}
+
+			// Every host must know the (public key of) all other hosts
+			hosts := m.Files["/etc/tinc/pulcy/hosts/"+tincName(x)]
+			if !strings.Contains(hosts, fmt.Sprintf("Subnet = %s/32", x.ClusterIP)) || !strings.Contains(hosts, "BEGIN RSA PUBLIC KEY") {
+				t.Errorf("Unexpected hosts file of %s on %s: %q", x.Name, i.Name, hosts)
+			}
+		}
+		if !strings.Contains(m.Files["/etc/tinc/pulcy/tinc-up"], i.ClusterIP) {
+			t.Errorf("Unexpected tinc-up on %s", i.Name)
+		}
+		if m.Modes["/etc/tinc/pulcy/tinc-up"] != "0755" {
+			t.Errorf("Expected tinc-up to be executable on %s", i.Name)
+		}
+		if !m.Enabled["tinc.service"] {
+			t.Errorf("Expected tinc.service to be enabled on %s", i.Name)
+		}
+	}
+}
+
+func TestReconfigureTincClusterFailure(t *testing.T) {
+	c := newTestCluster(t, 2)
+	defer c.Close()
+	c.Executor.FailOn("tincd", errors.New("tincd failed"))
+
+	if err := providers.ReconfigureTincCluster(context.Background(), log, c.Options.ClusterInfo, c.Provider); err == nil {
+		t.Fatal("Expected ReconfigureTincCluster to fail")
+	}
+}
+
+func TestDeleteCluster(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+	instances := c.Instances(t)
+
+	if err := c.Provider.DeleteCluster(context.Background(), c.Options.ClusterInfo, c.DNS); err != nil {
+		t.Fatalf("DeleteCluster failed: %v", err)
+	}
+	if left := c.Instances(t); len(left) != 0 {
+		t.Errorf("Expected no instances, got %d", len(left))
+	}
+	if records, _ := c.DNS.ListDnsRecords("example.com"); len(records) != 0 {
+		t.Errorf("Expected no DNS records, got %v", records)
+	}
+	for _, i := range instances {
+		if _, ok := c.Executor.Machine(i.Name); ok {
+			t.Errorf("Expected machine %s to be removed", i.Name)
+		}
+	}
+}
+
+func TestUpdateClusterMembersCanceled(t *testing.T) {
+	c := newTestCluster(t, 2)
+	defer c.Close()
+	executed := len(c.Executor.Commands())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := providers.UpdateClusterMembers(ctx, log, c.Options.ClusterInfo, providers.RebootNone, nil, c.Provider)
+	if !providers.IsCanceled(err) {
+		t.Fatalf("Expected UpdateClusterMembers to be canceled, got %v", err)
+	}
+	if commands := c.Executor.Commands(); len(commands) != executed {
+		t.Errorf("Expected no commands after cancel, got %v", commands[executed:])
+	}
+}
+
+func TestWaitUntilTimeout(t *testing.T) {
+	previous := providers.SetTimeouts(providers.Timeouts{Create: 10 * time.Millisecond})
+	defer providers.SetTimeouts(previous)
+
+	start := time.Now()
+	err := providers.WaitUntil(context.Background(), "nothing", func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	if !providers.IsCanceled(err) {
+		t.Fatalf("Expected WaitUntil to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected WaitUntil to give up quickly, took %s", elapsed)
+	}
+}
+
+func TestTrackResources(t *testing.T) {
+	c := newTestCluster(t, 1)
+	defer c.Close()
+	if err := c.Provider.DeleteCluster(context.Background(), c.Options.ClusterInfo, c.DNS); err != nil {
+		t.Fatalf("DeleteCluster failed: %v", err)
+	}
+
+	options, err := c.Options.NewCreateInstanceOptions(true, true, 1)
+	if err != nil {
+		t.Fatalf("NewCreateInstanceOptions failed: %v", err)
+	}
+	tracker := &providers.ResourceTracker{}
+	ctx := providers.WithResourceTracker(context.Background(), tracker)
+	instance, err := c.Provider.CreateInstance(ctx, log, options, c.DNS)
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	expected := []providers.Resource{
+		{Kind: providers.ResourceServer, ID: instance.ID, Name: instance.Name},
+		{Kind: providers.ResourceDnsRecord, ID: instance.LoadBalancerIPv4, Name: "A " + instance.Name},
+		{Kind: providers.ResourceDnsRecord, ID: instance.LoadBalancerIPv4, Name: "A " + c.Options.ClusterInfo.String()},
+	}
+	resources := tracker.Resources()
+	if len(resources) != len(expected) {
+		t.Fatalf("Expected %d resources, got %v", len(expected), resources)
+	}
+	for i, r := range resources {
+		if r.Kind != expected[i].Kind || r.ID != expected[i].ID || r.Name != expected[i].Name {
+			t.Errorf("Expected resource %v, got %v", expected[i], r)
+		}
+	}
+
+	// Rollback removes everything, in reverse order
+	removed, remaining := tracker.Rollback(context.Background(), log)
+	if len(remaining) != 0 {
+		t.Errorf("Expected no remaining resources, got %v", remaining)
+	}
+	if len(removed) != len(expected) || removed[0].Kind != providers.ResourceDnsRecord || removed[len(removed)-1].Kind != providers.ResourceServer {
+		t.Errorf("Expected all resources to be removed in reverse order, got %v", removed)
+	}
+	if resources := tracker.Resources(); len(resources) != 0 {
+		t.Errorf("Expected no recorded resources after rollback, got %v", resources)
+	}
+	if instances := c.Instances(t); len(instances) != 0 {
+		t.Errorf("Expected no instances after rollback, got %d", len(instances))
+	}
+	records, _ := c.DNS.ListDnsRecords(c.Options.Domain)
+	if len(records) != 0 {
+		t.Errorf("Expected no DNS records after rollback, got %v", records)
+	}
+}
+
+// tincName mirrors the naming of hosts in tinc.
+func tincName(i providers.ClusterInstance) string {
+	return strings.Replace(strings.Replace(i.Name, ".", "_", -1), "-", "_", -1)
+}
+
+func mustVersion(t *testing.T, version string) semver.Version {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		t.Fatalf("Invalid version %s: %v", version, err)
+	}
+	return *v
+}
+
+func hasRecord(records []providers.DnsRecord, recordType, name, data string) bool {
+	for _, r := range records {
+		if r.Type == recordType && r.Name == name && r.Data == data {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOperationJournal(t *testing.T) {
+	c := newTestCluster(t, 1)
+	defer c.Close()
+	dir, err := ioutil.TempDir("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	op := providers.NewOperation(dir, providers.OperationCreateCluster)
+	op.Cluster = &c.Options
+	if err := op.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	runs := 0
+	step := func() error {
+		runs++
+		return nil
+	}
+	ctx := providers.WithOperation(context.Background(), op)
+	if err := providers.RunStep(ctx, log, "target", "step", step); err != nil {
+		t.Fatalf("RunStep failed: %v", err)
+	}
+	if err := providers.RunStep(ctx, log, "target", "failing", func() error { return errors.New("step failed") }); err == nil {
+		t.Fatal("Expected failing step to fail")
+	}
+
+	// Resume: completed steps are skipped, failed steps run again
+	if _, err := providers.LoadOperation(dir, "unknown"); !providers.IsNotFound(err) {
+		t.Errorf("Expected NotFoundError for unknown operation, got %v", err)
+	}
+	resumed, err := providers.LoadOperation(dir, op.ID)
+	if err != nil {
+		t.Fatalf("LoadOperation failed: %v", err)
+	}
+	if !resumed.Resumed() || resumed.Cluster == nil || resumed.Cluster.ClusterInfo != c.Options.ClusterInfo {
+		t.Errorf("Expected resumed operation for %s, got %#v", c.Options.ClusterInfo, resumed)
+	}
+	ctx = providers.WithOperation(context.Background(), resumed)
+	if err := providers.RunStep(ctx, log, "target", "step", step); err != nil {
+		t.Fatalf("RunStep failed: %v", err)
+	}
+	if runs != 1 {
+		t.Errorf("Expected completed step to be skipped, ran %d times", runs)
+	}
+	if resumed.IsCompleted("target", "failing") {
+		t.Error("Expected failed step not to be completed")
+	}
+
+	// An instance that already exists is not created again, nor are its DNS records
+	instance := c.Instances(t)[0]
+	options, err := c.Options.NewCreateInstanceOptions(true, true, 1)
+	if err != nil {
+		t.Fatalf("NewCreateInstanceOptions failed: %v", err)
+	}
+	recordsBefore, _ := c.DNS.ListDnsRecords(c.Options.Domain)
+	created, err := providers.CreateInstanceOnce(ctx, log, c.Provider, options, c.DNS)
+	if err != nil {
+		t.Fatalf("CreateInstanceOnce failed: %v", err)
+	}
+	if created.ID != instance.ID {
+		t.Errorf("Expected existing instance %s, got %s", instance.ID, created.ID)
+	}
+	if instances := c.Instances(t); len(instances) != 1 {
+		t.Errorf("Expected 1 instance, got %d", len(instances))
+	}
+	if records, _ := c.DNS.ListDnsRecords(c.Options.Domain); len(records) != len(recordsBefore) {
+		t.Errorf("Expected no duplicate DNS records, got %v", records)
+	}
+
+	ops, err := providers.ListOperations(dir)
+	if err != nil || len(ops) != 1 || ops[0].ID != op.ID {
+		t.Errorf("Expected operation %s to be listed, got %v (%v)", op.ID, ops, err)
+	}
+	if err := resumed.Remove(); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if ops, _ := providers.ListOperations(dir); len(ops) != 0 {
+		t.Errorf("Expected no operations after Remove, got %v", ops)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	c := newRunningTestCluster(t, 3)
+	defer c.Close()
+	ctx := context.Background()
+	if err := providers.ReconfigureTincCluster(ctx, log, c.Options.ClusterInfo, c.Provider); err != nil {
+		t.Fatalf("ReconfigureTincCluster failed: %v", err)
+	}
+	instances := c.Instances(t)
+	minOSVersion := mustVersion(t, "835.13.0")
+
+	health, err := instances.CheckHealth(ctx, log, minOSVersion)
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	leaders := 0
+	for _, h := range health {
+		if !h.Healthy() {
+			t.Errorf("Expected %s to be healthy, got %v", h.Instance.Name, h.Checks)
+		}
+		if len(h.Checks) != len(providers.HealthChecks) {
+			t.Errorf("Expected %d checks of %s, got %v", len(providers.HealthChecks), h.Instance.Name, h.Checks)
+		}
+		if c, _ := h.Check(providers.HealthCheckEtcd); c.Message == "leader" {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected 1 etcd leader, got %d", leaders)
+	}
+
+	// Only the OS version of CoreOS instances is checked
+	mixed := append(providers.ClusterInstanceList{}, instances...)
+	mixed[1].OS = providers.OSNameUbuntu
+	health, err = mixed.CheckHealth(ctx, log, mustVersion(t, "9999.0.0"))
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	for idx, h := range health {
+		check, _ := h.Check(providers.HealthCheckOS)
+		if idx == 1 && (!check.OK || check.Message != "n/a") {
+			t.Errorf("Expected OS check of %s to be skipped, got %v", h.Instance.Name, check)
+		} else if idx != 1 && check.OK {
+			t.Errorf("Expected OS check of %s to fail, got %v", h.Instance.Name, check)
+		}
+	}
+
+	// Break a different part of every instance
+	cluster := c.Machine(t, instances[0]).Cluster
+	c.Executor.SetEtcdMemberHealthy(cluster, instances[0].Name, false)
+	c.Executor.FailOn("ping -c 1 -W 2 "+instances[2].ClusterIP, errors.New("unreachable"))
+	if err := instances[2].WriteFile(ctx, log, "/etc/pulcy/cluster-members", "x=10.9.9.9\n", 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	health, err = instances.CheckHealth(ctx, log, minOSVersion)
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	expectFailed := []string{providers.HealthCheckEtcd, providers.HealthCheckTinc, providers.HealthCheckMembers}
+	for idx, h := range health {
+		for _, name := range providers.HealthChecks {
+			check, _ := h.Check(name)
+			shouldFail := name == expectFailed[idx] || (name == providers.HealthCheckTinc && idx != 2)
+			if check.OK == shouldFail {
+				t.Errorf("Expected check %s of %s to fail=%v, got %v", name, h.Instance.Name, shouldFail, check)
+			}
+		}
+	}
+
+	// Instances that cannot be reached are not checked any further
+	c.Executor.FailOn("cat /etc/machine-id", errors.New("connection refused"))
+	health, err = instances.CheckHealth(ctx, log, mustVersion(t, "9999.0.0"))
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	for _, h := range health {
+		if h.Healthy() {
+			t.Errorf("Expected unreachable %s to be unhealthy", h.Instance.Name)
+		}
+	}
+}
@@ -19,5 +19,12 @@ import (
 )
 
 var (
-	NotFoundError = errgo.New("not-found")
+	NotFoundError        = errgo.New("not-found")
+	HostKeyMismatchError = errgo.New("host key mismatch")
+	QuorumError          = errgo.New("etcd quorum would be lost")
 )
+
+// IsNotFound returns true if the cause of the given error is a missing resource.
+func IsNotFound(err error) bool {
+	return errgo.Cause(err) == NotFoundError
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// RemoteExecutor runs commands on instances.
+type RemoteExecutor interface {
+	// Run executes the given command on the given instance, passing it the given stdin (if not empty).
+	// It returns the standard output of the command, without trailing newline.
+	// The command must be aborted when the given context is done.
+	Run(ctx context.Context, log *logging.Logger, instance ClusterInstance, command, stdin string, quiet bool) (string, error)
+	// Output executes the given command on the given instance, without logging failures.
+	// It returns the standard output (without trailing newline) and the standard error of the command,
+	// also when the command fails.
+	// The command must be aborted when the given context is done.
+	Output(ctx context.Context, log *logging.Logger, instance ClusterInstance, command string) (string, string, error)
+	// OpenFileSystem gives access to the files of the given instance, as root if sudo is set.
+	// The file system must be closed when the given context is done.
+	OpenFileSystem(ctx context.Context, log *logging.Logger, instance ClusterInstance, sudo bool) (RemoteFileSystem, error)
+}
+
+var (
+	remoteExecutor RemoteExecutor = NewSSHExecutor(SSHOptions{})
+)
+
+// SetRemoteExecutor replaces the executor used to run commands on all instances and returns the previous one.
+func SetRemoteExecutor(executor RemoteExecutor) RemoteExecutor {
+	previous := remoteExecutor
+	remoteExecutor = executor
+	return previous
+}
+
+// RunCommand runs the given command, killing it when the given context is done.
+func RunCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return maskAny(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return maskAny(ctx.Err())
+	}
+}
+
+// RemoteCommandError is returned by a RemoteExecutor when a command exits with a non-zero status.
+type RemoteCommandError struct {
+	Host       string // Name of the instance
+	Command    string // The command that failed
+	ExitStatus int    // Exit status of the command
+	Stdout     string // Captured standard output
+	Stderr     string // Captured standard error
+}
+
+// Error implements the error interface.
+func (e *RemoteCommandError) Error() string {
+	return fmt.Sprintf("'%s' on %s failed with exit status %d: %s", e.Command, e.Host, e.ExitStatus, strings.TrimSpace(e.Stderr))
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"sync"
+
+	"github.com/pulcy/quark/providers"
+)
+
+// DnsProvider is an in-memory providers.DnsProvider.
+type DnsProvider struct {
+	mutex   sync.Mutex
+	records map[string][]providers.DnsRecord // Records keyed by domain
+}
+
+// NewDnsProvider creates a DNS provider without any records.
+func NewDnsProvider() *DnsProvider {
+	return &DnsProvider{
+		records: make(map[string][]providers.DnsRecord),
+	}
+}
+
+// ListDnsRecords returns all records of the given domain.
+func (p *DnsProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]providers.DnsRecord{}, p.records[domain]...), nil
+}
+
+// CreateDnsRecord adds a record to the given domain.
+func (p *DnsProvider) CreateDnsRecord(domain, recordType, name, data string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.records[domain] = append(p.records[domain], providers.DnsRecord{
+		Type: recordType,
+		Name: name,
+		Data: data,
+	})
+	return nil
+}
+
+// DeleteDnsRecord removes all records with given type and name from the given domain.
+// If data is not empty, only records with that data are removed.
+func (p *DnsProvider) DeleteDnsRecord(domain, recordType, name, data string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	remaining := []providers.DnsRecord{}
+	for _, r := range p.records[domain] {
+		if r.Type == recordType && r.Name == name && (data == "" || r.Data == data) {
+			continue
+		}
+		remaining = append(remaining, r)
+	}
+	p.records[domain] = remaining
+	return nil
+}
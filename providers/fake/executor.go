@@ -0,0 +1,778 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	etcdMemberIDByIPPattern = regexp.MustCompile(`^sh -c 'etcdctl member list \| grep (\S+) \| cut -d: -f1'$`)
+)
+
+const (
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+// Machine is the simulated state of a single instance.
+type Machine struct {
+	Name          string
+	Cluster       string            // Full name of the cluster the machine belongs to
+	ClusterIP     string            // IP address used for private communication in the cluster
+	Files         map[string]string // Content of files, keyed by path
+	Dirs          map[string]bool   // Created directories
+	Modes         map[string]string // File modes set with chmod or over SFTP (e.g. "0400"), keyed by path
+	Enabled       map[string]bool   // Enabled systemd units, they are started by the next reboot
+	Started       map[string]bool   // Systemd units started by a restart or a reboot
+	Restarts      map[string]int    // Number of restarts, keyed by systemd unit
+	Stopped       map[string]bool   // Systemd units stopped with `systemctl stop`
+	Reboots       int               // Number of reboots
+	GluonSetups   []string          // Arguments of all `gluon setup` calls
+	FleetMetadata string            // Fleet metadata, as passed to the last `gluon setup`
+	FleetUnits    []string          // Names of the fleet units scheduled on the machine
+	Created       int64             // Creation time (unix seconds), reported as modification time of /etc/machine-id
+	OSUpdate      string            // Version of an OS update that the update engine finds (empty when up to date)
+	UpdateOp      string            // Current operation of the update engine (empty means idle)
+	LastChecked   int64             // Time of the last check for updates, reported by the update engine
+}
+
+// EtcdMember is a member of the simulated ETCD cluster.
+type EtcdMember struct {
+	ID        string
+	Name      string
+	PeerURL   string
+	Unhealthy bool // Reported as unhealthy by `etcdctl cluster-health`
+}
+
+// Executor is a providers.RemoteExecutor that simulates the commands quark runs on instances
+// against in-memory machines, instead of running them over SSH.
+type Executor struct {
+	mutex      sync.Mutex
+	machines   map[string]*Machine     // Machines keyed by instance name
+	etcd       map[string][]EtcdMember // ETCD members keyed by cluster name
+	rebootLock map[string]string       // Machine ID holding the locksmith reboot lock, keyed by cluster name
+	nextEtcdID int
+	created    int64
+	failures   map[string]error // Errors to return for commands starting with the key
+	skips      map[string]int   // Number of commands starting with the key that succeed before failures applies
+	commands   []string
+}
+
+// NewExecutor creates a new executor without any machines.
+func NewExecutor() *Executor {
+	return &Executor{
+		machines:   make(map[string]*Machine),
+		etcd:       make(map[string][]EtcdMember),
+		rebootLock: make(map[string]string),
+		failures:   make(map[string]error),
+		skips:      make(map[string]int),
+	}
+}
+
+// AddMachine adds a machine for the given instance, containing the given files.
+func (e *Executor) AddMachine(instance providers.ClusterInstance, cluster string, files map[string]string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.created++
+	m := &Machine{
+		Name:      instance.Name,
+		Created:   e.created,
+		Cluster:   cluster,
+		ClusterIP: instance.ClusterIP,
+		Files:     make(map[string]string),
+		Dirs:      make(map[string]bool),
+		Modes:     make(map[string]string),
+		Enabled:   make(map[string]bool),
+		Started:   make(map[string]bool),
+		Restarts:  make(map[string]int),
+		Stopped:   make(map[string]bool),
+	}
+	for k, v := range files {
+		m.Files[k] = v
+	}
+	e.machines[instance.Name] = m
+}
+
+// RemoveMachine removes the machine with given name.
+func (e *Executor) RemoveMachine(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.machines, name)
+}
+
+// Machine returns a copy of the state of the machine with given name.
+func (e *Executor) Machine(name string) (Machine, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	m, ok := e.machines[name]
+	if !ok {
+		return Machine{}, false
+	}
+	result := *m
+	result.Files = copyStrings(m.Files)
+	result.Modes = copyStrings(m.Modes)
+	result.Dirs = make(map[string]bool)
+	for k, v := range m.Dirs {
+		result.Dirs[k] = v
+	}
+	result.Enabled = make(map[string]bool)
+	for k, v := range m.Enabled {
+		result.Enabled[k] = v
+	}
+	result.Started = make(map[string]bool)
+	for k, v := range m.Started {
+		result.Started[k] = v
+	}
+	result.Restarts = make(map[string]int)
+	for k, v := range m.Restarts {
+		result.Restarts[k] = v
+	}
+	result.Stopped = make(map[string]bool)
+	for k, v := range m.Stopped {
+		result.Stopped[k] = v
+	}
+	result.GluonSetups = append([]string{}, m.GluonSetups...)
+	result.FleetUnits = append([]string{}, m.FleetUnits...)
+	return result, true
+}
+
+// AddFleetUnit schedules a fleet unit with given name on the machine with given name.
+func (e *Executor) AddFleetUnit(machineName, unit string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if m, ok := e.machines[machineName]; ok {
+		m.FleetUnits = append(m.FleetUnits, unit)
+	}
+}
+
+// AddEtcdMember adds a member to the ETCD cluster of the given cluster directly,
+// like the discovery of the initial members of a new cluster does.
+// A member with the same peer URL that already exists is kept.
+func (e *Executor) AddEtcdMember(cluster, name, peerURL string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, member := range e.etcd[cluster] {
+		if member.PeerURL == peerURL {
+			return
+		}
+	}
+	e.addEtcdMember(cluster, name, peerURL)
+}
+
+// EtcdMembers returns the members of the ETCD cluster of the given cluster.
+func (e *Executor) EtcdMembers(cluster string) []EtcdMember {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]EtcdMember{}, e.etcd[cluster]...)
+}
+
+// SetEtcdMemberHealthy changes the health of the ETCD member with given name in the given cluster.
+func (e *Executor) SetEtcdMemberHealthy(cluster, name string, healthy bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for i, member := range e.etcd[cluster] {
+		if member.Name == name {
+			e.etcd[cluster][i].Unhealthy = !healthy
+		}
+	}
+}
+
+// SetOSUpdate makes the update engine of the machine with given name find an update to the given version.
+func (e *Executor) SetOSUpdate(name, version string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if m, ok := e.machines[name]; ok {
+		m.OSUpdate = version
+	}
+}
+
+// RebootLockHolder returns the machine ID holding the locksmith reboot lock of the given cluster (empty if not locked).
+func (e *Executor) RebootLockHolder(cluster string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.rebootLock[cluster]
+}
+
+// FailOn makes all commands that start with the given prefix (after `sudo`) fail with the given error.
+func (e *Executor) FailOn(commandPrefix string, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failures[commandPrefix] = err
+}
+
+// FailOnAfter is like FailOn, but the first skip commands that start with the given prefix succeed.
+func (e *Executor) FailOnAfter(commandPrefix string, skip int, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failures[commandPrefix] = err
+	e.skips[commandPrefix] = skip
+}
+
+// ClearFailures removes all failures set with FailOn.
+func (e *Executor) ClearFailures() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failures = make(map[string]error)
+	e.skips = make(map[string]int)
+}
+
+// Commands returns all commands executed so far, formatted as "<instance name>: <command>".
+func (e *Executor) Commands() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]string{}, e.commands...)
+}
+
+// Run implements providers.RemoteExecutor.
+// Commands are not executed when the given context is already done.
+func (e *Executor) Run(ctx context.Context, log *logging.Logger, instance providers.ClusterInstance, command, stdin string, quiet bool) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", maskAny(err)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.commands = append(e.commands, fmt.Sprintf("%s: %s", instance.Name, command))
+	m, ok := e.machines[instance.Name]
+	if !ok {
+		return "", maskAny(errgo.WithCausef(nil, NotFoundError, "no machine for instance %s", instance.Name))
+	}
+	command = strings.TrimPrefix(command, "sudo ")
+	for prefix, err := range e.failures {
+		if strings.HasPrefix(command, prefix) {
+			if e.skips[prefix] > 0 {
+				e.skips[prefix]--
+				continue
+			}
+			return "", maskAny(err)
+		}
+	}
+	out, err := e.run(m, command, stdin)
+	if err != nil {
+		if !quiet {
+			log.Errorf("Fake command failed on %s: %s", instance.Name, command)
+		}
+		return "", maskAny(err)
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+// Output implements providers.RemoteExecutor.
+// Fake commands only write to standard error when they fail.
+func (e *Executor) Output(ctx context.Context, log *logging.Logger, instance providers.ClusterInstance, command string) (string, string, error) {
+	stdout, err := e.Run(ctx, log, instance, command, "", true)
+	if err != nil {
+		if cmdErr, ok := errgo.Cause(err).(*providers.RemoteCommandError); ok {
+			return cmdErr.Stdout, cmdErr.Stderr, maskAny(err)
+		}
+		return "", "", maskAny(err)
+	}
+	return stdout, "", nil
+}
+
+// run simulates a single command on the given machine.
+func (e *Executor) run(m *Machine, command, stdin string) (string, error) {
+	if match := etcdMemberIDByIPPattern.FindStringSubmatch(command); match != nil {
+		ids := []string{}
+		for _, member := range e.etcd[m.Cluster] {
+			if strings.Contains(member.PeerURL, match[1]) {
+				ids = append(ids, member.ID)
+			}
+		}
+		return strings.Join(ids, "\n"), nil
+	}
+
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", maskAny(UnknownCommandError)
+	}
+	switch path.Base(args[0]) {
+	case "cat":
+		if len(args) != 2 {
+			break
+		}
+		if args[1] == bootIDPath {
+			return fmt.Sprintf("%s-%d", m.Files["/etc/machine-id"], m.Reboots), nil
+		}
+		content, ok := m.Files[args[1]]
+		if !ok {
+			return "", maskAny(errgo.WithCausef(nil, NotFoundError, "cat: %s: No such file or directory", args[1]))
+		}
+		return content, nil
+	case "stat":
+		// stat -c %Y <path>
+		if len(args) != 4 || args[1] != "-c" || args[2] != "%Y" {
+			break
+		}
+		if _, ok := m.Files[args[3]]; !ok {
+			return "", maskAny(errgo.WithCausef(nil, NotFoundError, "stat: cannot stat '%s'", args[3]))
+		}
+		return fmt.Sprintf("%d", m.Created), nil
+	case "tee":
+		if len(args) != 2 {
+			break
+		}
+		m.Files[args[1]] = stdin
+		return stdin, nil
+	case "rm":
+		if len(args) < 3 || args[1] != "-f" {
+			break
+		}
+		for _, p := range args[2:] {
+			delete(m.Files, p)
+			delete(m.Modes, p)
+		}
+		return "", nil
+	case "mkdir":
+		if len(args) != 3 || args[1] != "-p" {
+			break
+		}
+		m.Dirs[args[2]] = true
+		return "", nil
+	case "chmod":
+		if len(args) < 3 {
+			break
+		}
+		for _, p := range args[2:] {
+			if _, ok := m.Files[p]; !ok {
+				return "", maskAny(errgo.WithCausef(nil, NotFoundError, "chmod: cannot access '%s'", p))
+			}
+			m.Modes[p] = args[1]
+		}
+		return "", nil
+	case "systemctl":
+		return e.systemctl(m, args)
+	case "etcdctl":
+		return e.etcdctl(m, args)
+	case "docker":
+		// docker run --rm -v <dir>:/destination/ <gluon-image>
+		if len(args) != 6 || args[1] != "run" || args[3] != "-v" {
+			break
+		}
+		dir := strings.SplitN(args[4], ":", 2)[0]
+		m.Files[path.Join(dir, "gluon")] = args[5]
+		return "", nil
+	case "gluon":
+		if len(args) < 2 {
+			break
+		}
+		image, ok := m.Files[args[0]]
+		if !ok {
+			return "", maskAny(errgo.WithCausef(nil, NotFoundError, "%s: command not found", args[0]))
+		}
+		if len(args) == 2 && args[1] == "version" {
+			// The binary holds the name of the image it was downloaded from
+			return fmt.Sprintf("gluon %s, build fake\n", image[strings.LastIndex(image, ":")+1:]), nil
+		}
+		if args[1] != "setup" {
+			break
+		}
+		m.GluonSetups = append(m.GluonSetups, strings.Join(args[2:], " "))
+		for _, arg := range args[2:] {
+			if strings.HasPrefix(arg, "--fleet-metadata=") {
+				m.FleetMetadata = strings.TrimPrefix(arg, "--fleet-metadata=")
+			}
+		}
+		// Gluon enables the cluster services, they are started by the next reboot
+		for _, unit := range []string{"gluon.service", "etcd2.service", "fleet.service"} {
+			m.Enabled[unit] = true
+		}
+		return "", nil
+	case "fleetctl":
+		if len(args) < 2 {
+			break
+		}
+		if !e.hasQuorum(m.Cluster) {
+			return "", maskAny(&providers.RemoteCommandError{
+				Host:       m.Name,
+				Command:    strings.Join(args, " "),
+				ExitStatus: 1,
+				Stderr:     "Error retrieving list of active machines: googleapi: Error 503: fleet server unable to communicate with etcd\n",
+			})
+		}
+		switch args[1] {
+		case "list-machines":
+			return e.fleetMachines(m.Cluster), nil
+		case "list-units":
+			return e.fleetUnits(m.Cluster), nil
+		}
+	case "tincd":
+		// tincd -n <vpn> -K
+		if len(args) != 4 || args[1] != "-n" || args[3] != "-K" {
+			break
+		}
+		return e.tincGenerateKey(m, args[2])
+	case "ping":
+		// ping -c 1 -W 2 <ip>
+		if len(args) != 6 {
+			break
+		}
+		for _, x := range e.machines {
+			if x.Cluster == m.Cluster && x.ClusterIP == args[5] {
+				return "1 packets transmitted, 1 received", nil
+			}
+		}
+		return "", maskAny(&providers.RemoteCommandError{
+			Host:       m.Name,
+			Command:    command,
+			ExitStatus: 1,
+			Stdout:     "1 packets transmitted, 0 received",
+		})
+	case "shutdown":
+		m.Reboots++
+		// Only the enabled units run after the reboot
+		m.Started = make(map[string]bool)
+		m.Stopped = make(map[string]bool)
+		for unit, enabled := range m.Enabled {
+			m.Started[unit] = enabled
+		}
+		if m.UpdateOp == providers.UpdateStatusUpdatedNeedReboot {
+			m.Files["/etc/lsb-release"] = fmt.Sprintf("DISTRIB_ID=CoreOS\nDISTRIB_RELEASE=%s\n", m.OSUpdate)
+			m.OSUpdate, m.UpdateOp = "", ""
+		}
+		return "", nil
+	case "update_engine_client":
+		return e.updateEngine(m, args)
+	case "locksmithctl":
+		return e.locksmith(m, args, command)
+	case "sync":
+		return "", nil
+	}
+	return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", command))
+}
+
+// updateEngine simulates `update_engine_client -status|-check_for_update|-update`.
+// A check finds the update set with SetOSUpdate and downloads it immediately.
+func (e *Executor) updateEngine(m *Machine, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", strings.Join(args, " ")))
+	}
+	switch args[1] {
+	case "-status":
+		op, version := m.UpdateOp, m.OSUpdate
+		if op == "" {
+			op, version = providers.UpdateStatusIdle, "0.0.0.0"
+		}
+		return fmt.Sprintf("LAST_CHECKED_TIME=%d\nPROGRESS=0.000000\nCURRENT_OP=%s\nNEW_VERSION=%s\nNEW_SIZE=0\n", m.LastChecked, op, version), nil
+	case "-check_for_update":
+		m.LastChecked++
+		if m.OSUpdate != "" {
+			m.UpdateOp = providers.UpdateStatusUpdatedNeedReboot
+		}
+		return "", nil
+	case "-update":
+		return "", nil
+	}
+	return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", strings.Join(args, " ")))
+}
+
+// locksmith simulates `locksmithctl status|lock|unlock` with a reboot semaphore of a single lock per cluster.
+func (e *Executor) locksmith(m *Machine, args []string, command string) (string, error) {
+	if len(args) != 2 {
+		return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", command))
+	}
+	machineID := m.Files["/etc/machine-id"]
+	holder := e.rebootLock[m.Cluster]
+	fail := func(msg string) (string, error) {
+		return "", maskAny(&providers.RemoteCommandError{Host: m.Name, Command: command, ExitStatus: 1, Stderr: msg})
+	}
+	switch args[1] {
+	case "status":
+		if holder == "" {
+			return "Available: 1\nMax: 1\n", nil
+		}
+		return fmt.Sprintf("Available: 0\nMax: 1\n\nMACHINE ID\n%s\n", holder), nil
+	case "lock":
+		if holder == machineID {
+			return fail("Error locking: lock already held")
+		} else if holder != "" {
+			return fail("Error locking: semaphore is at 0")
+		}
+		e.rebootLock[m.Cluster] = machineID
+		return "", nil
+	case "unlock":
+		if holder != machineID {
+			return fail("Error unlocking: lock not held")
+		}
+		delete(e.rebootLock, m.Cluster)
+		return "", nil
+	}
+	return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", command))
+}
+
+// systemctl simulates `systemctl cat|enable|restart|is-active|is-enabled <unit>` and `systemctl stop <unit>...`.
+// A unit is active when it is enabled or has been restarted, and has not been stopped since.
+// Stopping fleet.service moves all fleet units of the machine to another machine of the cluster.
+func (e *Executor) systemctl(m *Machine, args []string) (string, error) {
+	if len(args) > 3 && args[1] == "stop" {
+		for _, unit := range args[2:] {
+			e.stopUnit(m, unit)
+		}
+		return "", nil
+	}
+	if len(args) != 3 {
+		return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", strings.Join(args, " ")))
+	}
+	unit := args[2]
+	switch args[1] {
+	case "cat":
+		content, ok := m.Files[path.Join("/etc/systemd/system", unit)]
+		if !ok {
+			return "", maskAny(errgo.WithCausef(nil, NotFoundError, "No files found for %s", unit))
+		}
+		return content, nil
+	case "enable":
+		m.Enabled[unit] = true
+		return "", nil
+	case "is-active":
+		if m.IsActive(unit) {
+			return "active", nil
+		}
+		return "", maskAny(&providers.RemoteCommandError{Host: m.Name, Command: strings.Join(args, " "), ExitStatus: 3, Stdout: "inactive\n"})
+	case "is-enabled":
+		if m.Enabled[unit] {
+			return "enabled", nil
+		}
+		return "", maskAny(&providers.RemoteCommandError{Host: m.Name, Command: strings.Join(args, " "), ExitStatus: 1, Stdout: "disabled\n"})
+	case "restart":
+		m.Restarts[unit]++
+		m.Started[unit] = true
+		delete(m.Stopped, unit)
+		return "", nil
+	case "stop":
+		e.stopUnit(m, unit)
+		return "", nil
+	}
+	return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", strings.Join(args, " ")))
+}
+
+// IsActive returns true if the given systemd unit is running on the machine.
+func (m *Machine) IsActive(unit string) bool {
+	return m.Started[unit] && !m.Stopped[unit]
+}
+
+// runsFleet returns true if the machine is set up by gluon and runs fleet.
+func (m *Machine) runsFleet() bool {
+	return len(m.GluonSetups) > 0 && m.IsActive("fleet.service")
+}
+
+// isHealthy returns true if the given ETCD member is not marked unhealthy and its machine runs ETCD.
+func (e *Executor) isHealthy(cluster string, member EtcdMember) bool {
+	if member.Unhealthy {
+		return false
+	}
+	for _, m := range e.machines {
+		if m.Cluster == cluster && strings.Contains(member.PeerURL, "//"+m.ClusterIP+":") {
+			return m.IsActive("etcd2.service")
+		}
+	}
+	return false
+}
+
+// hasQuorum returns true if a quorum of the ETCD members of the given cluster runs ETCD.
+func (e *Executor) hasQuorum(cluster string) bool {
+	members := e.etcd[cluster]
+	running := 0
+	for _, member := range members {
+		for _, m := range e.machines {
+			if m.Cluster == cluster && strings.Contains(member.PeerURL, "//"+m.ClusterIP+":") && m.IsActive("etcd2.service") {
+				running++
+			}
+		}
+	}
+	return running >= len(members)/2+1
+}
+
+// etcdctl simulates `etcdctl member add|list|remove` and `etcdctl cluster-health`.
+// The first healthy member is reported as leader.
+// Like on a real cluster, it fails when ETCD does not run locally or has no quorum.
+func (e *Executor) etcdctl(m *Machine, args []string) (string, error) {
+	if !m.IsActive("etcd2.service") || !e.hasQuorum(m.Cluster) {
+		return "", maskAny(&providers.RemoteCommandError{
+			Host:       m.Name,
+			Command:    strings.Join(args, " "),
+			ExitStatus: 4,
+			Stderr:     "Error:  client: etcd cluster is unavailable or misconfigured\n",
+		})
+	}
+	switch {
+	case len(args) == 3 && args[1] == "member" && args[2] == "list":
+		lines := []string{}
+		hasLeader := false
+		for _, member := range e.etcd[m.Cluster] {
+			isLeader := !hasLeader && e.isHealthy(m.Cluster, member)
+			hasLeader = hasLeader || isLeader
+			clientURL := strings.Replace(member.PeerURL, ":2380", ":2379", 1)
+			lines = append(lines, fmt.Sprintf("%s: name=%s peerURLs=%s clientURLs=%s isLeader=%v", member.ID, member.Name, member.PeerURL, clientURL, isLeader))
+		}
+		return strings.Join(lines, "\n"), nil
+	case len(args) == 2 && args[1] == "cluster-health":
+		lines := []string{}
+		healthy := true
+		for _, member := range e.etcd[m.Cluster] {
+			clientURL := strings.Replace(member.PeerURL, ":2380", ":2379", 1)
+			if !e.isHealthy(m.Cluster, member) {
+				healthy = false
+				lines = append(lines, fmt.Sprintf("member %s is unhealthy: got unhealthy result from %s", member.ID, clientURL))
+			} else {
+				lines = append(lines, fmt.Sprintf("member %s is healthy: got healthy result from %s", member.ID, clientURL))
+			}
+		}
+		if !healthy {
+			lines = append(lines, "cluster is unhealthy")
+			return "", maskAny(&providers.RemoteCommandError{
+				Host:       m.Name,
+				Command:    strings.Join(args, " "),
+				ExitStatus: 5,
+				Stdout:     strings.Join(lines, "\n") + "\n",
+			})
+		}
+		lines = append(lines, "cluster is healthy")
+		return strings.Join(lines, "\n"), nil
+	case len(args) == 5 && args[1] == "member" && args[2] == "add":
+		for _, member := range e.etcd[m.Cluster] {
+			if member.PeerURL == args[4] {
+				return "", maskAny(fmt.Errorf("etcdctl: peerURL %s exists", args[4]))
+			}
+		}
+		id := e.addEtcdMember(m.Cluster, args[3], args[4])
+		return fmt.Sprintf("Added member named %s with ID %s to cluster", args[3], id), nil
+	case len(args) == 4 && args[1] == "member" && args[2] == "remove":
+		members := e.etcd[m.Cluster]
+		for i, member := range members {
+			if member.ID == args[3] {
+				e.etcd[m.Cluster] = append(members[:i:i], members[i+1:]...)
+				return fmt.Sprintf("Removed member %s from cluster", args[3]), nil
+			}
+		}
+		return "", maskAny(errgo.WithCausef(nil, NotFoundError, "etcdctl: member %s not found", args[3]))
+	}
+	return "", maskAny(errgo.WithCausef(nil, UnknownCommandError, "%s", strings.Join(args, " ")))
+}
+
+func (e *Executor) stopUnit(m *Machine, unit string) {
+	m.Stopped[unit] = true
+	if unit != "fleet.service" || len(m.FleetUnits) == 0 {
+		return
+	}
+	names := []string{}
+	for name, other := range e.machines {
+		if other != m && other.Cluster == m.Cluster && other.runsFleet() {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	target := e.machines[names[0]]
+	target.FleetUnits = append(target.FleetUnits, m.FleetUnits...)
+	m.FleetUnits = nil
+}
+
+func (e *Executor) addEtcdMember(cluster, name, peerURL string) string {
+	e.nextEtcdID++
+	id := fmt.Sprintf("%016x", e.nextEtcdID)
+	e.etcd[cluster] = append(e.etcd[cluster], EtcdMember{ID: id, Name: name, PeerURL: peerURL})
+	return id
+}
+
+// fleetMachines simulates the output of `fleetctl list-machines --full --no-legend --fields=machine,ip,metadata`.
+func (e *Executor) fleetMachines(cluster string) string {
+	lines := []string{}
+	for _, m := range e.machines {
+		if m.Cluster != cluster || !m.runsFleet() {
+			continue
+		}
+		metadata := m.FleetMetadata
+		if metadata == "" {
+			metadata = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s", m.Files["/etc/machine-id"], m.ClusterIP, metadata))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// fleetUnits simulates the output of `fleetctl list-units --full --no-legend --fields=unit,machine`.
+func (e *Executor) fleetUnits(cluster string) string {
+	lines := []string{}
+	for _, m := range e.machines {
+		if m.Cluster != cluster {
+			continue
+		}
+		for _, unit := range m.FleetUnits {
+			lines = append(lines, fmt.Sprintf("%s\t%s/%s", unit, m.Files["/etc/machine-id"], m.ClusterIP))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// tincGenerateKey simulates `tincd -n <vpn> -K`, which appends a private key to rsa_key.priv
+// and a public key to the hosts file of the host.
+func (e *Executor) tincGenerateKey(m *Machine, vpnName string) (string, error) {
+	confDir := path.Join("/etc/tinc", vpnName)
+	conf, ok := m.Files[path.Join(confDir, "tinc.conf")]
+	if !ok {
+		return "", maskAny(errgo.WithCausef(nil, NotFoundError, "tincd: cannot read tinc.conf"))
+	}
+	name := ""
+	for _, line := range strings.Split(conf, "\n") {
+		if strings.HasPrefix(line, "Name = ") {
+			name = strings.TrimPrefix(line, "Name = ")
+		}
+	}
+	if name == "" {
+		return "", maskAny(fmt.Errorf("tincd: no Name in tinc.conf"))
+	}
+	hostsPath := path.Join(confDir, "hosts", name)
+	keyPath := path.Join(confDir, "rsa_key.priv")
+	m.Files[keyPath] = m.Files[keyPath] + fmt.Sprintf("PRIVATE KEY OF %s\n", name)
+	m.Files[hostsPath] = m.Files[hostsPath] + fmt.Sprintf("\n-----BEGIN RSA PUBLIC KEY-----\n%s\n-----END RSA PUBLIC KEY-----\n", name)
+	return "", nil
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
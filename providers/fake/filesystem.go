@@ -0,0 +1,258 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+// OpenFileSystem implements providers.RemoteExecutor.
+// Every file system operation is recorded as a command of the form "sftp <operation> <path>".
+// Directories exist when they are created explicitly or contain files.
+func (e *Executor) OpenFileSystem(ctx context.Context, log *logging.Logger, instance providers.ClusterInstance, sudo bool) (providers.RemoteFileSystem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.machines[instance.Name]; !ok {
+		return nil, maskAny(errgo.WithCausef(nil, NotFoundError, "no machine for instance %s", instance.Name))
+	}
+	return &fileSystem{ctx: ctx, executor: e, name: instance.Name}, nil
+}
+
+// fileSystem is a providers.RemoteFileSystem on the files of a machine.
+type fileSystem struct {
+	ctx      context.Context
+	executor *Executor
+	name     string
+}
+
+// do runs the given operation on the machine, with the executor locked.
+func (fs *fileSystem) do(op, p string, f func(m *Machine) error) error {
+	if err := fs.ctx.Err(); err != nil {
+		return maskAny(err)
+	}
+	e := fs.executor
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.commands = append(e.commands, fmt.Sprintf("%s: sftp %s %s", fs.name, op, p))
+	m, ok := e.machines[fs.name]
+	if !ok {
+		return maskAny(errgo.WithCausef(nil, NotFoundError, "no machine for instance %s", fs.name))
+	}
+	for prefix, err := range e.failures {
+		if strings.HasPrefix("sftp "+op+" "+p, prefix) {
+			return maskAny(err)
+		}
+	}
+	return f(m)
+}
+
+func (fs *fileSystem) Stat(p string) (os.FileInfo, error) {
+	var result os.FileInfo
+	err := fs.do("stat", p, func(m *Machine) error {
+		result = m.stat(p)
+		if result == nil {
+			return &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+func (fs *fileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	var result []os.FileInfo
+	err := fs.do("readdir", p, func(m *Machine) error {
+		if info := m.stat(p); info == nil || !info.IsDir() {
+			return &os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist}
+		}
+		names := make(map[string]bool)
+		prefix := strings.TrimSuffix(p, "/") + "/"
+		for _, entries := range []map[string]bool{m.fileSet(), m.Dirs} {
+			for entry := range entries {
+				if strings.HasPrefix(entry, prefix) && len(entry) > len(prefix) {
+					names[strings.SplitN(entry[len(prefix):], "/", 2)[0]] = true
+				}
+			}
+		}
+		sorted := []string{}
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		for _, name := range sorted {
+			result = append(result, m.stat(prefix+name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+func (fs *fileSystem) Open(p string) (io.ReadCloser, error) {
+	var content string
+	err := fs.do("open", p, func(m *Machine) error {
+		var ok bool
+		if content, ok = m.Files[p]; !ok {
+			return &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func (fs *fileSystem) Create(p string, mode os.FileMode) (io.WriteCloser, error) {
+	err := fs.do("create", p, func(m *Machine) error {
+		if _, ok := m.Files[p]; !ok {
+			m.Modes[p] = fmt.Sprintf("%04o", mode.Perm())
+		}
+		m.Files[p] = ""
+		return nil
+	})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return &fileWriter{fs: fs, path: p}, nil
+}
+
+func (fs *fileSystem) Mkdir(p string, mode os.FileMode) error {
+	return maskAny(fs.do("mkdir", p, func(m *Machine) error {
+		if m.stat(p) != nil {
+			return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrExist}
+		}
+		m.Dirs[p] = true
+		return nil
+	}))
+}
+
+func (fs *fileSystem) Chmod(p string, mode os.FileMode) error {
+	return maskAny(fs.do("chmod", p, func(m *Machine) error {
+		if m.stat(p) == nil {
+			return &os.PathError{Op: "chmod", Path: p, Err: os.ErrNotExist}
+		}
+		m.Modes[p] = fmt.Sprintf("%04o", mode.Perm())
+		return nil
+	}))
+}
+
+func (fs *fileSystem) Chown(p string, uid, gid int) error {
+	return maskAny(fs.do("chown", p, func(m *Machine) error {
+		if m.stat(p) == nil {
+			return &os.PathError{Op: "chown", Path: p, Err: os.ErrNotExist}
+		}
+		return nil
+	}))
+}
+
+func (fs *fileSystem) Chtimes(p string, atime, mtime time.Time) error {
+	return maskAny(fs.do("chtimes", p, func(m *Machine) error {
+		if m.stat(p) == nil {
+			return &os.PathError{Op: "chtimes", Path: p, Err: os.ErrNotExist}
+		}
+		return nil
+	}))
+}
+
+func (fs *fileSystem) Close() error {
+	return nil
+}
+
+// fileWriter collects the content of a file and stores it in the machine when closed.
+type fileWriter struct {
+	bytes.Buffer
+	fs   *fileSystem
+	path string
+}
+
+func (w *fileWriter) Close() error {
+	return maskAny(w.fs.do("write", w.path, func(m *Machine) error {
+		m.Files[w.path] = w.String()
+		return nil
+	}))
+}
+
+// stat returns information about the given path, or nil if it does not exist.
+func (m *Machine) stat(p string) os.FileInfo {
+	mode := func(defaultMode os.FileMode) os.FileMode {
+		if v, err := strconv.ParseUint(m.Modes[p], 8, 32); err == nil {
+			return os.FileMode(v)
+		}
+		return defaultMode
+	}
+	if content, ok := m.Files[p]; ok {
+		return fileInfo{name: path.Base(p), size: int64(len(content)), mode: mode(0644)}
+	}
+	if p == "/" || m.Dirs[p] {
+		return fileInfo{name: path.Base(p), mode: mode(0755) | os.ModeDir}
+	}
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for _, entries := range []map[string]bool{m.fileSet(), m.Dirs} {
+		for entry := range entries {
+			if strings.HasPrefix(entry, prefix) {
+				return fileInfo{name: path.Base(p), mode: mode(0755) | os.ModeDir}
+			}
+		}
+	}
+	return nil
+}
+
+// fileSet returns the paths of all files of the machine.
+func (m *Machine) fileSet() map[string]bool {
+	result := make(map[string]bool)
+	for p := range m.Files {
+		result[p] = true
+	}
+	return result
+}
+
+// fileInfo implements os.FileInfo for files of a machine, which are all owned by root.
+type fileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return providers.FileOwner{} }
@@ -0,0 +1,275 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+const (
+	clusterDevice = "eth1"
+	osRelease     = "899.1.0"
+)
+
+// Provider is an in-memory providers.CloudProvider.
+// Instances created by it are added as machines to its executor.
+type Provider struct {
+	Logger         *logging.Logger
+	Executor       *Executor
+	TincClusterIPs bool // If set, instances use their tinc address as cluster IP (like on Scaleway)
+
+	mutex     sync.Mutex
+	instances map[string]providers.ClusterInstance // Instances keyed by name
+	lastIP    int
+}
+
+// NewProvider creates a provider without any instances, that adds machines to the given executor.
+func NewProvider(logger *logging.Logger, executor *Executor) *Provider {
+	return &Provider{
+		Logger:    logger,
+		Executor:  executor,
+		instances: make(map[string]providers.ClusterInstance),
+	}
+}
+
+func (p *Provider) ListRegions() ([]providers.Region, error) {
+	return []providers.Region{{ID: "fake-1", Name: "Fake region", Available: true}}, nil
+}
+
+func (p *Provider) ListImages() ([]providers.Image, error) {
+	return []providers.Image{{ID: "coreos-stable", Name: "CoreOS stable"}}, nil
+}
+
+func (p *Provider) ListKeys() ([]providers.SSHKey, error) {
+	return []providers.SSHKey{{ID: "1", Name: "fake"}}, nil
+}
+
+func (p *Provider) ListInstanceTypes() ([]providers.InstanceType, error) {
+	return []providers.InstanceType{{ID: "small", Name: "Small", CPU: 1, RAM: 1024, Disk: 20, Available: true}}, nil
+}
+
+func (p *Provider) ClusterDefaults(options providers.ClusterInfo) providers.ClusterInfo {
+	return options
+}
+
+func (p *Provider) CreateInstanceDefaults(options providers.CreateInstanceOptions) providers.CreateInstanceOptions {
+	options.InstanceConfig = instanceConfigDefaults(options.InstanceConfig)
+	return options
+}
+
+func (p *Provider) CreateClusterDefaults(options providers.CreateClusterOptions) providers.CreateClusterOptions {
+	options.InstanceConfig = instanceConfigDefaults(options.InstanceConfig)
+	return options
+}
+
+func instanceConfigDefaults(ic providers.InstanceConfig) providers.InstanceConfig {
+	if ic.ImageID == "" {
+		ic.ImageID = "coreos-stable"
+	}
+	if ic.RegionID == "" {
+		ic.RegionID = "fake-1"
+	}
+	if ic.TypeID == "" {
+		ic.TypeID = "small"
+	}
+	return ic
+}
+
+// CreateInstance creates a new machine and registers it in DNS.
+// The machine gets the files that cloud-config would create on a real instance.
+func (p *Provider) CreateInstance(ctx context.Context, log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+	if err := ctx.Err(); err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+	p.mutex.Lock()
+	if _, found := p.instances[options.InstanceName]; found {
+		p.mutex.Unlock()
+		return providers.ClusterInstance{}, maskAny(fmt.Errorf("instance %s already exists", options.InstanceName))
+	}
+	p.lastIP++
+	clusterIP := fmt.Sprintf("10.0.0.%d", p.lastIP)
+	if p.TincClusterIPs {
+		clusterIP = options.TincIpv4
+		for _, x := range p.instances {
+			if x.ClusterIP == clusterIP {
+				p.mutex.Unlock()
+				return providers.ClusterInstance{}, maskAny(fmt.Errorf("cluster IP %s is already used by %s", clusterIP, x.Name))
+			}
+		}
+	}
+	instance := providers.ClusterInstance{
+		ID:               fmt.Sprintf("fake-%d", p.lastIP),
+		Name:             options.InstanceName,
+		ClusterIP:        clusterIP,
+		PrivateIP:        fmt.Sprintf("10.0.0.%d", p.lastIP),
+		LoadBalancerIPv4: fmt.Sprintf("198.51.100.%d", p.lastIP),
+		ClusterDevice:    clusterDevice,
+		OS:               providers.OSNameCoreOS,
+		TypeID:           options.TypeID,
+		ImageID:          options.ImageID,
+	}
+	machineID := fmt.Sprintf("%032x", p.lastIP)
+	p.instances[instance.Name] = instance
+	p.mutex.Unlock()
+	providers.TrackResource(ctx, providers.ResourceServer, instance.ID, instance.Name, func(ctx context.Context) error {
+		p.mutex.Lock()
+		delete(p.instances, instance.Name)
+		p.mutex.Unlock()
+		p.Executor.RemoveMachine(instance.Name)
+		return nil
+	})
+
+	etcdUnit := "[Service]\nExecStart=/usr/bin/etcd2\n"
+	if options.EtcdProxy {
+		etcdUnit += "Environment=ETCD_PROXY=on\n"
+	}
+	p.Executor.AddMachine(instance, options.ClusterName, map[string]string{
+		"/etc/machine-id":                   machineID,
+		"/etc/lsb-release":                  fmt.Sprintf("DISTRIB_ID=CoreOS\nDISTRIB_RELEASE=%s\n", osRelease),
+		"/etc/pulcy/cluster-id":             options.ID,
+		"/etc/systemd/system/etcd2.service": etcdUnit,
+		"/etc/coreos/update.conf":           fmt.Sprintf("GROUP=stable\nREBOOT_STRATEGY=%s\n", options.RebootStrategy),
+	})
+
+	if err := providers.RegisterInstance(ctx, p.Logger, dnsProvider, options, instance.Name, options.RegisterClusterName(), instance.LoadBalancerIPv4, instance.LoadBalancerIPv6); err != nil {
+		return instance, maskAny(err)
+	}
+	return instance, nil
+}
+
+// CreateCluster creates all instances of a new cluster, adds them to ETCD and performs their initial setup.
+func (p *Provider) CreateCluster(ctx context.Context, log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
+	type instanceData struct {
+		options  providers.CreateInstanceOptions
+		instance providers.ClusterInstance
+	}
+	created := []instanceData{}
+	instanceList := providers.ClusterInstanceList{}
+	for i := 1; i <= options.InstanceCount; i++ {
+		isCore := true
+		isLB := true
+		instanceOptions, err := options.NewCreateInstanceOptions(isCore, isLB, i)
+		if err != nil {
+			return maskAny(err)
+		}
+		instance, err := providers.CreateInstanceOnce(ctx, log, p, instanceOptions, dnsProvider)
+		if err != nil {
+			return maskAny(err)
+		}
+		// Initial members are found using discovery on a real cluster
+		p.Executor.AddEtcdMember(instanceOptions.ClusterName, instance.Name, fmt.Sprintf("http://%s:2380", instance.ClusterIP))
+		created = append(created, instanceData{options: instanceOptions, instance: instance})
+		instanceList = append(instanceList, instance)
+	}
+
+	clusterMembers, err := instanceList.AsClusterMemberList(ctx, log, nil)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, data := range created {
+		iso := providers.InitialSetupOptions{
+			ClusterMembers: clusterMembers,
+			FleetMetadata:  data.options.CreateFleetMetadata(data.options.InstanceIndex),
+		}
+		if err := data.instance.InitialSetup(ctx, log, data.options, iso, p); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// GetInstances returns all instances of the given cluster, sorted by name.
+func (p *Provider) GetInstances(info providers.ClusterInfo) (providers.ClusterInstanceList, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	suffix := "." + info.String()
+	result := providers.ClusterInstanceList{}
+	for name, instance := range p.instances {
+		if strings.HasSuffix(name, suffix) {
+			result = append(result, instance)
+		}
+	}
+	sort.Sort(byName(result))
+	return result, nil
+}
+
+// DeleteCluster removes all instances of the given cluster and their DNS records.
+func (p *Provider) DeleteCluster(ctx context.Context, info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
+	instances, err := p.GetInstances(info)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, instance := range instances {
+		if err := ctx.Err(); err != nil {
+			return maskAny(err)
+		}
+		if err := p.deleteInstance(instance, info.Domain, dnsProvider); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// DeleteInstance removes a single instance and its DNS records.
+func (p *Provider) DeleteInstance(ctx context.Context, info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
+	p.mutex.Lock()
+	instance, found := p.instances[info.String()]
+	p.mutex.Unlock()
+	if !found {
+		return maskAny(NotFoundError)
+	}
+	if err := p.deleteInstance(instance, info.Domain, dnsProvider); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (p *Provider) deleteInstance(instance providers.ClusterInstance, domain string, dnsProvider providers.DnsProvider) error {
+	if err := providers.UnRegisterInstance(p.Logger, dnsProvider, instance, domain); err != nil {
+		return maskAny(err)
+	}
+	p.mutex.Lock()
+	delete(p.instances, instance.Name)
+	p.mutex.Unlock()
+	p.Executor.RemoveMachine(instance.Name)
+	return nil
+}
+
+// RebootInstance reboots the machine of the given instance.
+func (p *Provider) RebootInstance(ctx context.Context, instance providers.ClusterInstance) error {
+	if _, err := instance.Exec(ctx, p.Logger, "sudo shutdown -r now"); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (p *Provider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	return nil, maskAny(NotImplementedError)
+}
+
+type byName providers.ClusterInstanceList
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,117 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// FleetMachine describes a machine as registered in fleet
+type FleetMachine struct {
+	MachineID string            // Full ID of the machine (/etc/machine-id)
+	IP        string            // Public IP of the machine as known by fleet
+	Metadata  map[string]string // Fleet metadata of the machine
+}
+
+// HasMetadata returns true if the machine has a metadata entry with given key & value.
+func (m FleetMachine) HasMetadata(key, value string) bool {
+	v, ok := m.Metadata[key]
+	return ok && v == value
+}
+
+// MetadataString returns the metadata of the machine in the format used by the fleet-metadata option.
+func (m FleetMachine) MetadataString() string {
+	list := []string{}
+	for k, v := range m.Metadata {
+		list = append(list, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// ListFleetMachines calls fleetctl to list all machines registered in fleet
+func (i ClusterInstance) ListFleetMachines(ctx context.Context, log *logging.Logger) ([]FleetMachine, error) {
+	log.Debugf("Fetching fleet machines on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "fleetctl list-machines --full --no-legend --fields=machine,ip,metadata", "", false)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	machines := []FleetMachine{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		m := FleetMachine{
+			MachineID: fields[0],
+			IP:        fields[1],
+			Metadata:  make(map[string]string),
+		}
+		if len(fields) > 2 && fields[2] != "-" {
+			for _, kv := range strings.Split(fields[2], ",") {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) == 2 {
+					m.Metadata[parts[0]] = parts[1]
+				}
+			}
+		}
+		machines = append(machines, m)
+	}
+	return machines, nil
+}
+
+// FindFleetMachine returns the machine with given machine ID from the given list.
+func FindFleetMachine(machines []FleetMachine, machineID string) (FleetMachine, bool) {
+	for _, m := range machines {
+		if m.MachineID == machineID {
+			return m, true
+		}
+	}
+	return FleetMachine{}, false
+}
+
+// FleetUnit describes a unit as scheduled by fleet
+type FleetUnit struct {
+	Name      string // Name of the unit
+	MachineID string // Full ID of the machine the unit is scheduled on (empty when not scheduled)
+}
+
+// ListFleetUnits calls fleetctl to list all units scheduled in fleet
+func (i ClusterInstance) ListFleetUnits(ctx context.Context, log *logging.Logger) ([]FleetUnit, error) {
+	log.Debugf("Fetching fleet units on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "fleetctl list-units --full --no-legend --fields=unit,machine", "", false)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	units := []FleetUnit{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// The machine is formatted as <machine-id>/<ip>, or "-" when not scheduled
+		u := FleetUnit{Name: fields[0]}
+		if fields[1] != "-" {
+			u.MachineID = strings.SplitN(fields[1], "/", 2)[0]
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
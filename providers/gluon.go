@@ -0,0 +1,153 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	// gluonArgsPath holds the arguments of the last `gluon setup`, one per line.
+	// It contains the private registry password, so it is only readable by root.
+	gluonArgsPath    = "/etc/pulcy/gluon.args"
+	gluonImageFlag   = "--gluon-image="
+	gluonServiceUnit = "gluon.service"
+)
+
+// GluonSetupOptions contains the arguments of `gluon setup` that cannot be derived from the instance itself.
+type GluonSetupOptions struct {
+	GluonImage              string
+	PrivateRegistryUrl      string
+	PrivateRegistryUserName string
+	PrivateRegistryPassword string
+	FleetMetadata           string
+}
+
+// GluonSetupArgs creates the arguments of `gluon setup` on the instance.
+func (i ClusterInstance) GluonSetupArgs(options GluonSetupOptions) []string {
+	return []string{
+		gluonImageFlag + options.GluonImage,
+		fmt.Sprintf("--docker-ip=%s", i.ClusterIP),
+		fmt.Sprintf("--private-ip=%s", i.ClusterIP),
+		fmt.Sprintf("--private-cluster-device=%s", i.ClusterDevice),
+		fmt.Sprintf("--private-registry-url=%s", options.PrivateRegistryUrl),
+		fmt.Sprintf("--private-registry-username=%s", options.PrivateRegistryUserName),
+		fmt.Sprintf("--private-registry-password=%s", options.PrivateRegistryPassword),
+		fmt.Sprintf("--fleet-metadata=%s", options.FleetMetadata),
+	}
+}
+
+// GetGluonSetupArgs returns the arguments of the last `gluon setup` on the instance.
+// It returns false when they have not been saved, which is the case for instances created
+// by older versions of quark.
+func (i ClusterInstance) GetGluonSetupArgs(ctx context.Context, log *logging.Logger) ([]string, bool) {
+	log.Debugf("Fetching gluon setup arguments on %s", i)
+	content, err := i.runRemoteCommand(ctx, log, "sudo cat "+gluonArgsPath, "", true)
+	if err != nil {
+		log.Debugf("Cannot read %s on %s: %v", gluonArgsPath, i, err)
+		return nil, false
+	}
+	args := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			args = append(args, line)
+		}
+	}
+	return args, len(args) > 0
+}
+
+// GetGluonVersion returns the version of the gluon binary installed on the instance.
+func (i ClusterInstance) GetGluonVersion(ctx context.Context, log *logging.Logger) (string, error) {
+	log.Debugf("Fetching gluon version on %s", i)
+	output, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("%s version", i.gluonPath()), "", true)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	return strings.TrimSpace(strings.SplitN(output, "\n", 2)[0]), nil
+}
+
+// UpgradeGluon downloads gluon from the given image and runs `gluon setup` with the given arguments,
+// using the new image. It then restarts gluon.service and waits until it is active again.
+func (i ClusterInstance) UpgradeGluon(ctx context.Context, log *logging.Logger, gluonImage string, args []string) error {
+	args = replaceGluonImage(args, gluonImage)
+	if err := i.downloadGluon(ctx, log, gluonImage); err != nil {
+		return maskAny(err)
+	}
+	if err := i.runGluonSetup(ctx, log, args); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Restarting gluon on %s", i)
+	if _, err := i.runRemoteCommand(ctx, log, "sudo systemctl restart "+gluonServiceUnit, "", false); err != nil {
+		return maskAny(err)
+	}
+	what := fmt.Sprintf("%s to become active on %s", gluonServiceUnit, i)
+	if err := waitUntil(ctx, what, timeouts.Health, func(ctx context.Context) (bool, error) {
+		state, err := i.GetUnitState(ctx, log, "is-active", gluonServiceUnit)
+		if err != nil {
+			log.Debugf("Cannot fetch state of %s on %s: %v", gluonServiceUnit, i, err)
+			return false, nil
+		}
+		return state == "active", nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// gluonPath returns the path of the gluon binary on the instance.
+func (i ClusterInstance) gluonPath() string {
+	return path.Join(i.Home(), "bin", "gluon")
+}
+
+// downloadGluon extracts the gluon binary from the given image into the bin directory of the instance.
+func (i ClusterInstance) downloadGluon(ctx context.Context, log *logging.Logger, gluonImage string) error {
+	binDir := path.Dir(i.gluonPath())
+	log.Infof("Downloading gluon on %s", i)
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo /usr/bin/mkdir -p %s", binDir), "", false); err != nil {
+		return maskAny(err)
+	}
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("docker run --rm -v %s:/destination/ %s", binDir, gluonImage), "", false); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// runGluonSetup runs `gluon setup` with the given arguments and saves them for later upgrades.
+func (i ClusterInstance) runGluonSetup(ctx context.Context, log *logging.Logger, args []string) error {
+	log.Infof("Running gluon on %s", i)
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo %s setup %s", i.gluonPath(), strings.Join(args, " ")), "", false); err != nil {
+		return maskAny(err)
+	}
+	if err := i.WriteFile(ctx, log, gluonArgsPath, strings.Join(args, "\n"), 0400); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// replaceGluonImage returns a copy of the given `gluon setup` arguments with the gluon image set to the given image.
+func replaceGluonImage(args []string, gluonImage string) []string {
+	result := []string{gluonImageFlag + gluonImage}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, gluonImageFlag) {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
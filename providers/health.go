@@ -0,0 +1,177 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// EtcdMemberHealth is the health of a single ETCD member, as reported by `etcdctl cluster-health`.
+type EtcdMemberHealth struct {
+	ID        string // ID of the member
+	ClientURL string // Client URL of the member (empty when unreachable)
+	Healthy   bool
+}
+
+// EtcdClusterHealth calls etcdctl to fetch the health of all members of the ETCD cluster.
+// An unhealthy cluster is not an error.
+func (i ClusterInstance) EtcdClusterHealth(ctx context.Context, log *logging.Logger) ([]EtcdMemberHealth, error) {
+	log.Debugf("Fetching etcd cluster health on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "etcdctl cluster-health", "", true)
+	if err != nil {
+		// etcdctl exits with a non-zero status when the cluster is unhealthy
+		cmdErr, ok := errgo.Cause(err).(*RemoteCommandError)
+		if !ok || !strings.Contains(cmdErr.Stdout, "cluster is unhealthy") {
+			return nil, maskAny(err)
+		}
+		out = cmdErr.Stdout
+	}
+	return parseEtcdClusterHealth(out), nil
+}
+
+// parseEtcdClusterHealth parses the output of `etcdctl cluster-health`, which has lines like:
+// "member ce2a822cea30bfca is healthy: got healthy result from http://10.0.0.1:2379",
+// "member ce2a822cea30bfca is unhealthy: got unhealthy result from http://10.0.0.1:2379" and
+// "member ce2a822cea30bfca is unreachable: [http://10.0.0.1:2379] are all unreachable".
+func parseEtcdClusterHealth(out string) []EtcdMemberHealth {
+	result := []EtcdMemberHealth{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "member" || fields[2] != "is" {
+			continue
+		}
+		member := EtcdMemberHealth{
+			ID:      fields[1],
+			Healthy: fields[3] == "healthy:",
+		}
+		if idx := strings.Index(line, " result from "); idx >= 0 {
+			member.ClientURL = strings.TrimSpace(line[idx+len(" result from "):])
+		}
+		result = append(result, member)
+	}
+	return result
+}
+
+// FindEtcdMemberHealth returns the health of the member with given cluster IP from the given list.
+func FindEtcdMemberHealth(members []EtcdMemberHealth, clusterIP string) (EtcdMemberHealth, bool) {
+	for _, m := range members {
+		if strings.Contains(m.ClientURL, fmt.Sprintf("//%s:", clusterIP)) {
+			return m, true
+		}
+	}
+	return EtcdMemberHealth{}, false
+}
+
+// EtcdMemberInfo describes a single ETCD member, as listed by `etcdctl member list`.
+type EtcdMemberInfo struct {
+	ID       string // ID of the member
+	Name     string // Name of the member
+	PeerURL  string // First peer URL of the member
+	IsLeader bool   // Set if the member is the current leader of the ETCD cluster
+}
+
+// ListEtcdMembers calls etcdctl to list all members of the ETCD cluster.
+func (i ClusterInstance) ListEtcdMembers(ctx context.Context, log *logging.Logger) ([]EtcdMemberInfo, error) {
+	log.Debugf("Fetching etcd members on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "etcdctl member list", "", false)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return parseEtcdMemberList(out), nil
+}
+
+// parseEtcdMemberList parses the output of `etcdctl member list`, which has lines like:
+// "ce2a822cea30bfca: name=abc peerURLs=http://10.0.0.1:2380 clientURLs=http://10.0.0.1:2379 isLeader=true".
+func parseEtcdMemberList(out string) []EtcdMemberInfo {
+	result := []EtcdMemberInfo{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		member := EtcdMemberInfo{ID: strings.TrimSuffix(fields[0], ":")}
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "name":
+				member.Name = parts[1]
+			case "peerURLs":
+				member.PeerURL = strings.Split(parts[1], ",")[0]
+			case "isLeader":
+				member.IsLeader = parts[1] == "true"
+			}
+		}
+		result = append(result, member)
+	}
+	return result
+}
+
+// FindEtcdMemberInfo returns the member with given cluster IP from the given list.
+func FindEtcdMemberInfo(members []EtcdMemberInfo, clusterIP string) (EtcdMemberInfo, bool) {
+	for _, m := range members {
+		if strings.Contains(m.PeerURL, fmt.Sprintf("//%s:", clusterIP)) {
+			return m, true
+		}
+	}
+	return EtcdMemberInfo{}, false
+}
+
+// waitUntilHealthy waits until the instance is a healthy member of ETCD (or can reach a healthy ETCD cluster
+// when it is an ETCD proxy) and is registered in fleet.
+func (i ClusterInstance) waitUntilHealthy(ctx context.Context, log *logging.Logger, etcdProxy bool) error {
+	machineID, err := i.GetMachineID(ctx, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := waitUntil(ctx, fmt.Sprintf("%s to become a healthy etcd member", i), timeouts.Health, func(ctx context.Context) (bool, error) {
+		members, err := i.EtcdClusterHealth(ctx, log)
+		if err != nil {
+			log.Debugf("Cannot fetch etcd health on %s: %v", i, err)
+			return false, nil
+		}
+		if etcdProxy {
+			for _, m := range members {
+				if m.Healthy {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		m, found := FindEtcdMemberHealth(members, i.ClusterIP)
+		return found && m.Healthy, nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	if err := waitUntil(ctx, fmt.Sprintf("%s to register in fleet", i), timeouts.Health, func(ctx context.Context) (bool, error) {
+		machines, err := i.ListFleetMachines(ctx, log)
+		if err != nil {
+			log.Debugf("Cannot list fleet machines on %s: %v", i, err)
+			return false, nil
+		}
+		_, found := FindFleetMachine(machines, machineID)
+		return found, nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKey is an SSH host key generated by quark, so it can be injected into a new instance
+// (using cloud-config) and pinned before the first connection to that instance.
+type HostKey struct {
+	PrivateKey string        // PEM encoded private key
+	PublicKey  ssh.PublicKey // Public part of the key
+}
+
+// HostKeyPinner is implemented by remote executors that verify host keys.
+type HostKeyPinner interface {
+	// PinHostKey replaces the pinned host key of the given instance with the given key.
+	PinHostKey(i ClusterInstance, key ssh.PublicKey) error
+}
+
+// NewHostKey generates a new ECDSA (P-256) host key.
+func NewHostKey() (*HostKey, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return &HostKey{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// PrivateKeyPath returns the path of the private key file on the instance.
+func (k *HostKey) PrivateKeyPath() string {
+	return "/etc/ssh/ssh_host_ecdsa_key"
+}
+
+// PublicKeyPath returns the path of the public key file on the instance.
+func (k *HostKey) PublicKeyPath() string {
+	return k.PrivateKeyPath() + ".pub"
+}
+
+// AuthorizedKey returns the public key in the format of an OpenSSH .pub file.
+func (k *HostKey) AuthorizedKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.PublicKey)))
+}
+
+// PinHostKey pins the given host key for the given (new) instance, when the remote executor verifies host keys.
+func PinHostKey(i ClusterInstance, key ssh.PublicKey) error {
+	pinner, ok := remoteExecutor.(HostKeyPinner)
+	if !ok {
+		return nil
+	}
+	if err := pinner.PinHostKey(i, key); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
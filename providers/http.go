@@ -0,0 +1,203 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+)
+
+// RetryPolicy specifies how API requests of a provider are retried and limited.
+type RetryPolicy struct {
+	MaxRetries  int           // Maximum number of retries of a single request (0 means no retries)
+	MinBackoff  time.Duration // Delay before the first retry, doubled for every next retry
+	MaxBackoff  time.Duration // Maximum delay between two attempts (also caps Retry-After)
+	MaxParallel int           // Maximum number of concurrent requests (0 means unlimited)
+}
+
+var (
+	errRequestCanceled = errgo.New("request canceled")
+
+	// DefaultRetryPolicy is a reasonable policy for most provider API's.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxRetries:  5,
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Second * 30,
+		MaxParallel: 4,
+	}
+)
+
+// Backoff returns the delay before the given retry (0 is the first retry).
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// NewLimiter returns a channel that can be used as semaphore to limit concurrency according to the policy.
+// It returns nil when concurrency is unlimited.
+func (p RetryPolicy) NewLimiter() chan struct{} {
+	if p.MaxParallel <= 0 {
+		return nil
+	}
+	return make(chan struct{}, p.MaxParallel)
+}
+
+// NewHTTPClient creates an HTTP client that retries and limits its requests according to the given policy.
+func NewHTTPClient(log *logging.Logger, policy RetryPolicy) *http.Client {
+	return &http.Client{Transport: NewTransport(log, nil, policy)}
+}
+
+// NewTransport creates an http.RoundTripper that sends requests using the given base transport
+// (http.DefaultTransport if nil). Failed requests are retried with exponential backoff when that is safe.
+// 429 (too many requests) and 503 (service unavailable) responses have not been processed, so they are
+// retried for all methods, honoring the Retry-After header. Other 5xx responses and network errors are
+// only retried for idempotent methods, unless the connection could not be established at all.
+// All clients that share the returned transport share its concurrency limit.
+func NewTransport(log *logging.Logger, base http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{
+		log:     log,
+		base:    base,
+		policy:  policy,
+		limiter: policy.NewLimiter(),
+	}
+}
+
+type retryTransport struct {
+	log     *logging.Logger
+	base    http.RoundTripper
+	policy  RetryPolicy
+	limiter chan struct{}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Buffer the body, so it can be sent again
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		attempt := *req
+		if body != nil {
+			attempt.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.roundTrip(&attempt)
+		if retry >= t.policy.MaxRetries {
+			return resp, err
+		}
+		delay, ok := shouldRetry(req.Method, resp, err)
+		if !ok {
+			return resp, err
+		}
+		if delay <= 0 {
+			delay = t.policy.Backoff(retry)
+		} else if t.policy.MaxBackoff > 0 && delay > t.policy.MaxBackoff {
+			delay = t.policy.MaxBackoff
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			t.log.Debugf("%s %s returned %d, retrying in %s", req.Method, req.URL.Host, resp.StatusCode, delay)
+		} else {
+			t.log.Debugf("%s %s failed (%v), retrying in %s", req.Method, req.URL.Host, err, delay)
+		}
+		select {
+		case <-req.Cancel:
+			return nil, maskAny(errRequestCanceled)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// roundTrip performs a single attempt, honoring the concurrency limit.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.limiter != nil {
+		select {
+		case t.limiter <- struct{}{}:
+		case <-req.Cancel:
+			return nil, maskAny(errRequestCanceled)
+		}
+		defer func() { <-t.limiter }()
+	}
+	return t.base.RoundTrip(req)
+}
+
+// shouldRetry decides if a request with given method that resulted in given response or error can be retried.
+// It returns the delay requested by the server (if any).
+func shouldRetry(method string, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+			// The request has never been sent
+			return 0, true
+		}
+		return 0, isIdempotent(method)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return retryAfter(resp), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return 0, isIdempotent(method)
+	default:
+		return 0, false
+	}
+}
+
+// isIdempotent returns true if requests with the given method can safely be sent more than once.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter parses the Retry-After header of the given response (in seconds or as HTTP date).
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now())
+	}
+	return 0
+}
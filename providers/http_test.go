@@ -0,0 +1,166 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	testRetryPolicy = providers.RetryPolicy{
+		MaxRetries:  3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond * 10,
+		MaxParallel: 2,
+	}
+)
+
+// newTestServer starts a server that responds with the given status codes (in order),
+// followed by 200 for all other requests. It returns the server and the request counter.
+func newTestServer(statusCodes ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := int(atomic.AddInt32(&calls, 1)) - 1
+		if index < len(statusCodes) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statusCodes[index])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return server, &calls
+}
+
+func TestTransportRetriesRateLimitedPost(t *testing.T) {
+	server, calls := newTestServer(http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	client := providers.NewHTTPClient(log, testRetryPolicy)
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", *calls)
+	}
+}
+
+func TestTransportDoesNotRetryFailedPost(t *testing.T) {
+	server, calls := newTestServer(http.StatusInternalServerError)
+	defer server.Close()
+
+	client := providers.NewHTTPClient(log, testRetryPolicy)
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", resp.StatusCode)
+	}
+	if *calls != 1 {
+		t.Errorf("Expected 1 call, got %d", *calls)
+	}
+}
+
+func TestTransportRetriesFailedGet(t *testing.T) {
+	server, calls := newTestServer(http.StatusInternalServerError, http.StatusBadGateway)
+	defer server.Close()
+
+	client := providers.NewHTTPClient(log, testRetryPolicy)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", *calls)
+	}
+}
+
+func TestTransportGivesUp(t *testing.T) {
+	server, calls := newTestServer(429, 429, 429, 429, 429)
+	defer server.Close()
+
+	client := providers.NewHTTPClient(log, testRetryPolicy)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", resp.StatusCode)
+	}
+	if expected := int32(testRetryPolicy.MaxRetries + 1); *calls != expected {
+		t.Errorf("Expected %d calls, got %d", expected, *calls)
+	}
+}
+
+func TestTransportLimitsConcurrency(t *testing.T) {
+	var active, maxActive int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+	}))
+	defer server.Close()
+
+	client := providers.NewHTTPClient(log, testRetryPolicy)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := client.Get(server.URL); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive > int32(testRetryPolicy.MaxParallel) {
+		t.Errorf("Expected at most %d concurrent requests, got %d", testRetryPolicy.MaxParallel, maxActive)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := providers.RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Second * 5}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for retry, d := range expected {
+		if b := policy.Backoff(retry); b != d {
+			t.Errorf("Backoff(%d): expected %s, got %s", retry, d, b)
+		}
+	}
+}
@@ -15,16 +15,16 @@
 package providers
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/juju/errgo"
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -41,15 +41,18 @@ const (
 
 // ClusterInstance describes a single instance
 type ClusterInstance struct {
-	ID               string // Provider specific ID of the server (only used by provider, can be empty)
-	Name             string // Name of the instance as known by the provider
-	ClusterIP        string // IP address of the instance used for all private communication in the cluster
-	LoadBalancerIPv4 string // IPv4 address of the instance on which the load-balancer is listening (can be empty)
-	LoadBalancerIPv6 string // IPv6 address of the instance on which the load-balancer is listening (can be empty)
-	ClusterDevice    string // Device name of the nic that is configured for the ClusterIP
-	PrivateIP        string // IP address of the instance's private network (can be same as ClusterIP)
-	UserName         string // Account name used to SSH into this instance. (empty defaults to 'core')
-	OS               OSName // Name of the OS on the instance
+	ID               string           // Provider specific ID of the server (only used by provider, can be empty)
+	Name             string           // Name of the instance as known by the provider
+	ClusterIP        string           // IP address of the instance used for all private communication in the cluster
+	LoadBalancerIPv4 string           // IPv4 address of the instance on which the load-balancer is listening (can be empty)
+	LoadBalancerIPv6 string           // IPv6 address of the instance on which the load-balancer is listening (can be empty)
+	ClusterDevice    string           // Device name of the nic that is configured for the ClusterIP
+	PrivateIP        string           // IP address of the instance's private network (can be same as ClusterIP)
+	UserName         string           // Account name used to SSH into this instance. (empty defaults to 'core')
+	OS               OSName           // Name of the OS on the instance
+	TypeID           string           // ID of the type of the instance, as reported by the provider (empty when unknown)
+	ImageID          string           // ID of the image the instance was created from, as reported by the provider (empty when unknown)
+	JumpHost         *ClusterInstance // Instance through which SSH connections are made when this instance has no public address (can be nil)
 }
 
 // String returns a human readable representation of the given instance
@@ -81,57 +84,77 @@ func (i ClusterInstance) Home() string {
 	}
 }
 
-func (i ClusterInstance) runRemoteCommand(log *logging.Logger, command, stdin string, quiet bool) (string, error) {
-	hostAddress := i.LoadBalancerIPv4
-	if hostAddress == "" {
-		hostAddress = i.LoadBalancerIPv6
-	}
-	if hostAddress == "" {
-		return "", maskAny(fmt.Errorf("don't have any address to communicate with instance %s", i.Name))
-	}
-	cmd := exec.Command("ssh", "-o", "StrictHostKeyChecking=no", i.User()+"@"+hostAddress, command)
-	var stdOut, stdErr bytes.Buffer
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if stdin != "" {
-		cmd.Stdin = strings.NewReader(stdin)
-	}
-
-	if err := cmd.Run(); err != nil {
-		if !quiet {
-			log.Errorf("SSH failed: %s %s", cmd.Path, strings.Join(cmd.Args, " "))
-		}
-		return "", errgo.NoteMask(err, stdErr.String())
-	}
-
-	out := stdOut.String()
-	out = strings.TrimSuffix(out, "\n")
-	return out, nil
+func (i ClusterInstance) runRemoteCommand(ctx context.Context, log *logging.Logger, command, stdin string, quiet bool) (string, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.SSH)
+	defer cancel()
+	return remoteExecutor.Run(ctx, log, i, command, stdin, quiet)
 }
 
-func (i ClusterInstance) GetClusterID(log *logging.Logger) (string, error) {
+func (i ClusterInstance) GetClusterID(ctx context.Context, log *logging.Logger) (string, error) {
 	log.Debugf("Fetching cluster-id on %s", i)
-	id, err := i.runRemoteCommand(log, "sudo cat /etc/pulcy/cluster-id", "", false)
+	id, err := i.runRemoteCommand(ctx, log, "sudo cat /etc/pulcy/cluster-id", "", false)
 	return id, maskAny(err)
 }
 
-func (i ClusterInstance) GetMachineID(log *logging.Logger) (string, error) {
+func (i ClusterInstance) GetMachineID(ctx context.Context, log *logging.Logger) (string, error) {
 	log.Debugf("Fetching machine-id on %s", i)
-	id, err := i.runRemoteCommand(log, "cat /etc/machine-id", "", false)
+	id, err := i.runRemoteCommand(ctx, log, "cat /etc/machine-id", "", false)
 	return id, maskAny(err)
 }
 
-func (i ClusterInstance) GetVaultCrt(log *logging.Logger) (string, error) {
+// GetClusterMembers reads the cluster members as configured on the instance (/etc/pulcy/cluster-members).
+func (i ClusterInstance) GetClusterMembers(ctx context.Context, log *logging.Logger) (ClusterMemberList, error) {
+	log.Debugf("Fetching cluster-members on %s", i)
+	data, err := i.runRemoteCommand(ctx, log, "cat /etc/pulcy/cluster-members", "", false)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return ParseClusterMemberList(data), nil
+}
+
+// GetUnitState calls `systemctl <query> <unit>` (query is is-active or is-enabled) and returns the state it prints.
+// A state other than active/enabled is not an error.
+func (i ClusterInstance) GetUnitState(ctx context.Context, log *logging.Logger, query, unit string) (string, error) {
+	log.Debugf("Fetching %s of %s on %s", query, unit, i)
+	state, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("systemctl %s %s", query, unit), "", true)
+	if err != nil {
+		// systemctl exits with a non-zero status when the unit is not active/enabled
+		cmdErr, ok := errgo.Cause(err).(*RemoteCommandError)
+		if !ok {
+			return "", maskAny(err)
+		}
+		state = strings.TrimSpace(cmdErr.Stdout)
+		if state == "" {
+			state = "unknown"
+		}
+	}
+	return state, nil
+}
+
+// GetCreatedAt returns the time the instance was first booted, which is when /etc/machine-id is written.
+func (i ClusterInstance) GetCreatedAt(ctx context.Context, log *logging.Logger) (time.Time, error) {
+	log.Debugf("Fetching creation time on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "stat -c %Y /etc/machine-id", "", false)
+	if err != nil {
+		return time.Time{}, maskAny(err)
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, maskAny(err)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func (i ClusterInstance) GetVaultCrt(ctx context.Context, log *logging.Logger) (string, error) {
 	log.Debugf("Fetching vault.crt on %s", i)
-	id, err := i.runRemoteCommand(log, "sudo cat /etc/pulcy/vault.crt", "", false)
+	id, err := i.runRemoteCommand(ctx, log, "sudo cat /etc/pulcy/vault.crt", "", false)
 	return id, maskAny(err)
 }
 
-func (i ClusterInstance) GetVaultAddr(log *logging.Logger) (string, error) {
+func (i ClusterInstance) GetVaultAddr(ctx context.Context, log *logging.Logger) (string, error) {
 	const prefix = "VAULT_ADDR="
 	log.Debugf("Fetching vault-addr on %s", i)
-	env, err := i.runRemoteCommand(log, "sudo cat /etc/pulcy/vault.env", "", false)
+	env, err := i.runRemoteCommand(ctx, log, "sudo cat /etc/pulcy/vault.env", "", false)
 	if err != nil {
 		return "", maskAny(err)
 	}
@@ -144,10 +167,10 @@ func (i ClusterInstance) GetVaultAddr(log *logging.Logger) (string, error) {
 	return "", maskAny(errgo.New("VAULT_ADDR not found in /etc/pulcy/vault.env"))
 }
 
-func (i ClusterInstance) GetOSRelease(log *logging.Logger) (semver.Version, error) {
+func (i ClusterInstance) GetOSRelease(ctx context.Context, log *logging.Logger) (semver.Version, error) {
 	const prefix = "DISTRIB_RELEASE="
 	log.Debugf("Fetching OS release on %s", i)
-	env, err := i.runRemoteCommand(log, "cat /etc/lsb-release", "", false)
+	env, err := i.runRemoteCommand(ctx, log, "cat /etc/lsb-release", "", false)
 	if err != nil {
 		return semver.Version{}, maskAny(err)
 	}
@@ -164,14 +187,23 @@ func (i ClusterInstance) GetOSRelease(log *logging.Logger) (semver.Version, erro
 	return semver.Version{}, maskAny(errgo.Newf("%s not found in /etc/lsb-release", prefix))
 }
 
-func (i ClusterInstance) IsEtcdProxy(log *logging.Logger) (bool, error) {
+func (i ClusterInstance) IsEtcdProxy(ctx context.Context, log *logging.Logger) (bool, error) {
 	log.Debugf("Fetching etcd proxy status on %s", i)
-	cat, err := i.runRemoteCommand(log, "systemctl cat etcd2.service", "", false)
+	cat, err := i.runRemoteCommand(ctx, log, "systemctl cat etcd2.service", "", false)
 	return strings.Contains(cat, "ETCD_PROXY"), maskAny(err)
 }
 
 // AddEtcdMember calls etcdctl to add a member to ETCD
-func (i ClusterInstance) AddEtcdMember(log *logging.Logger, name, clusterIP string) error {
+func (i ClusterInstance) AddEtcdMember(ctx context.Context, log *logging.Logger, name, clusterIP string) error {
+	id, err := i.etcdMemberID(ctx, log, clusterIP)
+	if err != nil {
+		return maskAny(err)
+	}
+	if id != "" {
+		// Adding a member is not idempotent, etcdctl fails for an existing peer URL
+		log.Infof("%s(%s) is already an etcd member", name, clusterIP)
+		return nil
+	}
 	log.Infof("Adding %s(%s) to etcd on %s", name, clusterIP, i)
 	cmd := []string{
 		"etcdctl",
@@ -180,41 +212,55 @@ func (i ClusterInstance) AddEtcdMember(log *logging.Logger, name, clusterIP stri
 		name,
 		fmt.Sprintf("http://%s:2380", clusterIP),
 	}
-	if _, err := i.runRemoteCommand(log, strings.Join(cmd, " "), "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, strings.Join(cmd, " "), "", false); err != nil {
 		return maskAny(err)
 	}
+	TrackResource(ctx, ResourceEtcdMember, clusterIP, name, func(ctx context.Context) error {
+		return maskAny(i.RemoveEtcdMember(ctx, log, name, clusterIP))
+	})
 	return nil
 }
 
+// etcdMemberID calls etcdctl to find the ID of the ETCD member with given cluster IP.
+// It returns an empty ID when there is no such member.
+func (i ClusterInstance) etcdMemberID(ctx context.Context, log *logging.Logger, clusterIP string) (string, error) {
+	id, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sh -c 'etcdctl member list | grep http://%s:2380 | cut -d: -f1'", clusterIP), "", false)
+	return id, maskAny(err)
+}
+
 // RemoveEtcdMember calls etcdctl to remove a member from ETCD
-func (i ClusterInstance) RemoveEtcdMember(log *logging.Logger, name, clusterIP string) error {
+func (i ClusterInstance) RemoveEtcdMember(ctx context.Context, log *logging.Logger, name, clusterIP string) error {
 	log.Infof("Removing %s(%s) from etcd on %s", name, clusterIP, i)
-	id, err := i.runRemoteCommand(log, fmt.Sprintf("sh -c 'etcdctl member list | grep %s | cut -d: -f1'", clusterIP), "", false)
+	id, err := i.etcdMemberID(ctx, log, clusterIP)
 	if err != nil {
 		return maskAny(err)
 	}
+	if id == "" {
+		log.Infof("%s(%s) is not an etcd member", name, clusterIP)
+		return nil
+	}
 	cmd := []string{
 		"etcdctl",
 		"member",
 		"remove",
 		id,
 	}
-	if _, err := i.runRemoteCommand(log, strings.Join(cmd, " "), "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, strings.Join(cmd, " "), "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
-func (i ClusterInstance) AsClusterMember(log *logging.Logger) (ClusterMember, error) {
-	clusterID, err := i.GetClusterID(log)
+func (i ClusterInstance) AsClusterMember(ctx context.Context, log *logging.Logger) (ClusterMember, error) {
+	clusterID, err := i.GetClusterID(ctx, log)
 	if err != nil {
 		return ClusterMember{}, maskAny(err)
 	}
-	machineID, err := i.GetMachineID(log)
+	machineID, err := i.GetMachineID(ctx, log)
 	if err != nil {
 		return ClusterMember{}, maskAny(err)
 	}
-	etcdProxy, err := i.IsEtcdProxy(log)
+	etcdProxy, err := i.IsEtcdProxy(ctx, log)
 	if err != nil {
 		return ClusterMember{}, maskAny(err)
 	}
@@ -231,21 +277,21 @@ type InitialSetupOptions struct {
 	FleetMetadata  string
 }
 
-func (i ClusterInstance) waitUntilActive(log *logging.Logger) error {
-	for {
+func (i ClusterInstance) waitUntilActive(ctx context.Context, log *logging.Logger) error {
+	return maskAny(WaitUntil(ctx, fmt.Sprintf("%s to become active", i), func(ctx context.Context) (bool, error) {
 		// Attempt an SSH connection
-		if _, err := i.GetMachineID(log); err == nil {
-			// Success
-			return nil
+		_, err := i.GetMachineID(ctx, log)
+		if IsHostKeyMismatch(err) {
+			// Waiting will not help
+			return false, maskAny(err)
 		}
-		// Wait a while
-		time.Sleep(time.Second * 5)
-	}
+		return err == nil, nil
+	}))
 }
 
 // osSetup updates the OS of the instance (if needed)
-func (i ClusterInstance) osSetup(log *logging.Logger, minOSVersion semver.Version, provider CloudProvider) error {
-	v, err := i.GetOSRelease(log)
+func (i ClusterInstance) osSetup(ctx context.Context, log *logging.Logger, minOSVersion semver.Version, provider CloudProvider) error {
+	v, err := i.GetOSRelease(ctx, log)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -256,103 +302,170 @@ func (i ClusterInstance) osSetup(log *logging.Logger, minOSVersion semver.Versio
 	}
 	// Run update
 	log.Infof("Updating OS on %s...", i)
-	if _, err := i.runRemoteCommand(log, "sudo update_engine_client -update", "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, "sudo update_engine_client -update", "", false); err != nil {
 		return maskAny(err)
 	}
-	if err := provider.RebootInstance(i); err != nil {
+	if err := provider.RebootInstance(ctx, i); err != nil {
 		// This may likely fail
 		log.Debugf("Reboot failed (likely): %#v", err)
 	}
-	time.Sleep(time.Second * 5)
+	if err := Sleep(ctx, time.Second*5); err != nil {
+		return maskAny(err)
+	}
 	// Wait until available
-	if err := i.waitUntilActive(log); err != nil {
+	if err := i.waitUntilActive(ctx, log); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
+// setupStep is a single step of the initial setup of an instance
+type setupStep struct {
+	Key         string // Identifies the step in an operation journal
+	Description string
+	Run         func() error
+}
+
 // InitialSetup creates initial files and calls gluon for the first time
-func (i ClusterInstance) InitialSetup(log *logging.Logger, cio CreateInstanceOptions, iso InitialSetupOptions, provider CloudProvider) error {
-	if i.OS == OSNameCoreOS {
-		minOSVersion, err := semver.NewVersion(cio.MinOSVersion)
-		if err != nil {
-			return maskAny(err)
-		}
-		if err := i.osSetup(log, *minOSVersion, provider); err != nil {
+func (i ClusterInstance) InitialSetup(ctx context.Context, log *logging.Logger, cio CreateInstanceOptions, iso InitialSetupOptions, provider CloudProvider) error {
+	for _, step := range i.initialSetupSteps(ctx, log, cio, iso, provider) {
+		log.Debugf("%s on %s", step.Description, i)
+		if err := RunStep(ctx, log, i.Name, "setup-"+step.Key, step.Run); err != nil {
 			return maskAny(err)
 		}
 	}
+	return nil
+}
 
-	if _, err := i.runRemoteCommand(log, "sudo /usr/bin/mkdir -p /etc/pulcy", "", false); err != nil {
-		return maskAny(err)
-	}
-	data := iso.ClusterMembers.Render()
-	if _, err := i.runRemoteCommand(log, "sudo tee /etc/pulcy/cluster-members", data, false); err != nil {
-		return maskAny(err)
+// InitialSetupPlan returns a description of all steps performed by InitialSetup, without performing them.
+func (i ClusterInstance) InitialSetupPlan(cio CreateInstanceOptions, iso InitialSetupOptions) []string {
+	result := []string{}
+	for _, step := range i.initialSetupSteps(context.Background(), nil, cio, iso, nil) {
+		result = append(result, step.Description)
 	}
+	return result
+}
 
-	vaultEnv := []string{
-		fmt.Sprintf("VAULT_ADDR=%s", cio.VaultAddress),
+// initialSetupSteps creates the list of steps performed by InitialSetup
+func (i ClusterInstance) initialSetupSteps(ctx context.Context, log *logging.Logger, cio CreateInstanceOptions, iso InitialSetupOptions, provider CloudProvider) []setupStep {
+	steps := []setupStep{}
+
+	if i.OS == OSNameCoreOS || i.OS == "" {
+		steps = append(steps, setupStep{
+			Key:         "os-update",
+			Description: fmt.Sprintf("Update OS to at least %s (CoreOS only)", cio.MinOSVersion),
+			Run: func() error {
+				if i.OS != OSNameCoreOS {
+					return nil
+				}
+				minOSVersion, err := semver.NewVersion(cio.MinOSVersion)
+				if err != nil {
+					return maskAny(err)
+				}
+				if err := i.osSetup(ctx, log, *minOSVersion, provider); err != nil {
+					return maskAny(err)
+				}
+				return nil
+			},
+		})
+	}
+
+	steps = append(steps, setupStep{
+		Key:         "cluster-members",
+		Description: "Write /etc/pulcy/cluster-members",
+		Run: func() error {
+			data := iso.ClusterMembers.Render()
+			if err := i.WriteFile(ctx, log, "/etc/pulcy/cluster-members", data, 0644); err != nil {
+				return maskAny(err)
+			}
+			return nil
+		},
+	})
+
+	steps = append(steps, setupStep{
+		Key:         "vault-env",
+		Description: "Write /etc/pulcy/vault.env",
+		Run: func() error {
+			if err := i.WriteFile(ctx, log, "/etc/pulcy/vault.env", vaultEnv(cio.VaultAddress), 0400); err != nil {
+				return maskAny(err)
+			}
+			return nil
+		},
+	})
+
+	steps = append(steps, setupStep{
+		Key:         "vault-crt",
+		Description: "Write /etc/pulcy/vault.crt",
+		Run: func() error {
+			if err := i.WriteFile(ctx, log, "/etc/pulcy/vault.crt", cio.VaultCertificate, 0400); err != nil {
+				return maskAny(err)
+			}
+			return nil
+		},
+	})
+
+	steps = append(steps, setupStep{
+		Key:         "gluon-download",
+		Description: fmt.Sprintf("Download gluon from %s into %s", cio.GluonImage, path.Dir(i.gluonPath())),
+		Run: func() error {
+			return maskAny(i.downloadGluon(ctx, log, cio.GluonImage))
+		},
+	})
+
+	steps = append(steps, setupStep{
+		Key:         "gluon-setup",
+		Description: fmt.Sprintf("Run gluon setup with fleet metadata '%s' & save its arguments in %s", iso.FleetMetadata, gluonArgsPath),
+		Run: func() error {
+			args := i.GluonSetupArgs(GluonSetupOptions{
+				GluonImage:              cio.GluonImage,
+				PrivateRegistryUrl:      cio.PrivateRegistryUrl,
+				PrivateRegistryUserName: cio.PrivateRegistryUserName,
+				PrivateRegistryPassword: cio.PrivateRegistryPassword,
+				FleetMetadata:           iso.FleetMetadata,
+			})
+			return maskAny(i.runGluonSetup(ctx, log, args))
+		},
+	})
+
+	return steps
+}
+
+// vaultEnv creates the content of /etc/pulcy/vault.env for the given vault address.
+func vaultEnv(vaultAddress string) string {
+	lines := []string{
+		fmt.Sprintf("VAULT_ADDR=%s", vaultAddress),
 		fmt.Sprintf("VAULT_CACERT=/etc/pulcy/vault.crt"),
 	}
-	if _, err := i.runRemoteCommand(log, "sudo tee /etc/pulcy/vault.env", strings.Join(vaultEnv, "\n"), false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, "sudo chmod 0400 /etc/pulcy/vault.env", "", false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, "sudo tee /etc/pulcy/vault.crt", cio.VaultCertificate, false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, "sudo chmod 0400 /etc/pulcy/vault.crt", "", false); err != nil {
-		return maskAny(err)
-	}
+	return strings.Join(lines, "\n")
+}
 
-	log.Infof("Downloading gluon on %s", i)
-	binDir := path.Join(i.Home(), "bin")
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo /usr/bin/mkdir -p %s", binDir), "", false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("docker run --rm -v %s:/destination/ %s", binDir, cio.GluonImage), "", false); err != nil {
-		return maskAny(err)
-	}
-	log.Infof("Running gluon on %s", i)
-	gluonArgs := []string{
-		fmt.Sprintf("--gluon-image=%s", cio.GluonImage),
-		fmt.Sprintf("--docker-ip=%s", i.ClusterIP),
-		fmt.Sprintf("--private-ip=%s", i.ClusterIP),
-		fmt.Sprintf("--private-cluster-device=%s", i.ClusterDevice),
-		fmt.Sprintf("--private-registry-url=%s", cio.PrivateRegistryUrl),
-		fmt.Sprintf("--private-registry-username=%s", cio.PrivateRegistryUserName),
-		fmt.Sprintf("--private-registry-password=%s", cio.PrivateRegistryPassword),
-		fmt.Sprintf("--fleet-metadata=%s", iso.FleetMetadata),
-	}
-	gluonPath := path.Join(binDir, "gluon")
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo %s setup %s", gluonPath, strings.Join(gluonArgs, " ")), "", false); err != nil {
-		return maskAny(err)
+// RunSetupStep runs a single step (identified by its key) of InitialSetup on the instance.
+func (i ClusterInstance) RunSetupStep(ctx context.Context, log *logging.Logger, key string, cio CreateInstanceOptions, iso InitialSetupOptions, provider CloudProvider) error {
+	for _, step := range i.initialSetupSteps(ctx, log, cio, iso, provider) {
+		if step.Key == key {
+			log.Debugf("%s on %s", step.Description, i)
+			return maskAny(step.Run())
+		}
 	}
-	return nil
+	return maskAny(errgo.WithCausef(nil, NotFoundError, "no setup step '%s'", key))
 }
 
 // UpdateClusterMembers updates /etc/pulcy/cluster-members on the given instance
-func (i ClusterInstance) UpdateClusterMembers(log *logging.Logger, members ClusterMemberList) error {
-	if _, err := i.runRemoteCommand(log, "sudo /usr/bin/mkdir -p /etc/pulcy", "", false); err != nil {
-		return maskAny(err)
-	}
+func (i ClusterInstance) UpdateClusterMembers(ctx context.Context, log *logging.Logger, members ClusterMemberList) error {
 	data := members.Render()
-	if _, err := i.runRemoteCommand(log, "sudo tee /etc/pulcy/cluster-members", data, false); err != nil {
+	if err := i.WriteFile(ctx, log, "/etc/pulcy/cluster-members", data, 0644); err != nil {
 		return maskAny(err)
 	}
 
 	log.Infof("Restarting gluon on %s", i)
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo systemctl restart gluon.service"), "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo systemctl restart gluon.service"), "", false); err != nil {
 		return maskAny(err)
 	}
 
 	log.Infof("Enabling services on %s", i)
 	services := []string{"etcd2.service", "fleet.service", "fleet.socket", "ip4tables.service", "ip6tables.service"}
 	for _, service := range services {
-		if err := i.EnableService(log, service); err != nil {
+		if err := i.EnableService(ctx, log, service); err != nil {
 			return maskAny(err)
 		}
 	}
@@ -360,16 +473,16 @@ func (i ClusterInstance) UpdateClusterMembers(log *logging.Logger, members Clust
 }
 
 // Sync the filesystems on the instance
-func (i ClusterInstance) Sync(log *logging.Logger) error {
-	if _, err := i.runRemoteCommand(log, "sudo sync", "", false); err != nil {
+func (i ClusterInstance) Sync(ctx context.Context, log *logging.Logger) error {
+	if _, err := i.runRemoteCommand(ctx, log, "sudo sync", "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // Exec executes a command on the instance
-func (i ClusterInstance) Exec(log *logging.Logger, command string) (string, error) {
-	stdout, err := i.runRemoteCommand(log, command, "", false)
+func (i ClusterInstance) Exec(ctx context.Context, log *logging.Logger, command string) (string, error) {
+	stdout, err := i.runRemoteCommand(ctx, log, command, "", false)
 	if err != nil {
 		return stdout, maskAny(err)
 	}
@@ -377,22 +490,19 @@ func (i ClusterInstance) Exec(log *logging.Logger, command string) (string, erro
 }
 
 // EnableService calls `systemctl enable <name>`
-func (i ClusterInstance) EnableService(log *logging.Logger, name string) error {
-	if _, err := i.runRemoteCommand(log, "sudo systemctl enable "+name, "", false); err != nil {
+func (i ClusterInstance) EnableService(ctx context.Context, log *logging.Logger, name string) error {
+	if _, err := i.runRemoteCommand(ctx, log, "sudo systemctl enable "+name, "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // RunScript uploads a script with given content and executes it
-func (i ClusterInstance) RunScript(log *logging.Logger, scriptContent, scriptPath string) error {
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", scriptPath), scriptContent, false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo chmod +x %s", scriptPath), "", false); err != nil {
+func (i ClusterInstance) RunScript(ctx context.Context, log *logging.Logger, scriptContent, scriptPath string) error {
+	if err := i.WriteFile(ctx, log, scriptPath, scriptContent, 0755); err != nil {
 		return maskAny(err)
 	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo %s", scriptPath), "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo %s", scriptPath), "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
@@ -15,14 +15,18 @@
 package providers
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 type ClusterInstanceList []ClusterInstance
 
-func (cil ClusterInstanceList) AsClusterMemberList(log *logging.Logger, isEtcdProxy func(ClusterInstance) bool) (ClusterMemberList, error) {
+func (cil ClusterInstanceList) AsClusterMemberList(ctx context.Context, log *logging.Logger, isEtcdProxy func(ClusterInstance) bool) (ClusterMemberList, error) {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, len(cil))
 	memberChan := make(chan ClusterMember, len(cil))
@@ -30,7 +34,7 @@ func (cil ClusterInstanceList) AsClusterMemberList(log *logging.Logger, isEtcdPr
 		wg.Add(1)
 		go func(instance ClusterInstance) {
 			defer wg.Done()
-			member, err := instance.AsClusterMember(log)
+			member, err := instance.AsClusterMember(ctx, log)
 			if err != nil {
 				errors <- maskAny(err)
 				return
@@ -56,3 +60,150 @@ func (cil ClusterInstanceList) AsClusterMemberList(log *logging.Logger, isEtcdPr
 
 	return members, nil
 }
+
+// FreeInstanceIndexes returns count instance indexes (starting at 1) that are not used by
+// any instance of the list. Used indexes are derived from the tinc addresses of the instances.
+// When the instances do not use tinc addresses as cluster IP, the indexes up to the length
+// of the list are considered used.
+func (cil ClusterInstanceList) FreeInstanceIndexes(count int) []int {
+	used := cil.usedInstanceIndexes()
+	result := []int{}
+	for index := 1; len(result) < count; index++ {
+		if !used[index] {
+			result = append(result, index)
+		}
+	}
+	return result
+}
+
+// freeInstanceIndex returns the lowest instance index (starting at 1) that is not used by any instance of the list
+// and that has the given remainder when divided by 2.
+func (cil ClusterInstanceList) freeInstanceIndex(remainder int) int {
+	used := cil.usedInstanceIndexes()
+	index := 2 - remainder
+	for used[index] {
+		index += 2
+	}
+	return index
+}
+
+// usedInstanceIndexes returns the instance indexes used by the instances of the list, see FreeInstanceIndexes.
+func (cil ClusterInstanceList) usedInstanceIndexes() map[int]bool {
+	used := make(map[int]bool)
+	for _, i := range cil {
+		if index, ok := tincAddressIndex(i.ClusterIP); ok {
+			used[index] = true
+		}
+	}
+	if len(used) == 0 {
+		for index := 1; index <= len(cil); index++ {
+			used[index] = true
+		}
+	}
+	return used
+}
+
+// tincAddressIndex returns the instance index of the given address if it is a tinc address.
+func tincAddressIndex(address string) (int, bool) {
+	var index int
+	if _, err := fmt.Sscanf(address, tincAddressTemplate, &index); err == nil && fmt.Sprintf(tincAddressTemplate, index) == address {
+		return index, true
+	}
+	return 0, false
+}
+
+// JumpHost returns the first instance of the list that has a public address, or nil if there is none.
+func (cil ClusterInstanceList) JumpHost() *ClusterInstance {
+	for _, i := range cil {
+		if i.LoadBalancerIPv4 != "" || i.LoadBalancerIPv6 != "" {
+			jumpHost := i
+			return &jumpHost
+		}
+	}
+	return nil
+}
+
+// AssignJumpHosts sets the jump host of all instances without a public address to the
+// first instance of the list that has one, so they can be reached over SSH.
+func (cil ClusterInstanceList) AssignJumpHosts() {
+	jumpHost := cil.JumpHost()
+	for idx, i := range cil {
+		if i.LoadBalancerIPv4 == "" && i.LoadBalancerIPv6 == "" {
+			cil[idx].JumpHost = jumpHost
+		}
+	}
+}
+
+// ExecResult is the outcome of a command executed on a single instance.
+type ExecResult struct {
+	Instance ClusterInstance
+	Stdout   string
+	Stderr   string
+	Err      error // Cause is a *RemoteCommandError when the command exited with a non-zero status
+}
+
+// Exec executes the given command on all instances in parallel.
+// The results are returned in the order of the instances.
+func (cil ClusterInstanceList) Exec(ctx context.Context, log *logging.Logger, command string) []ExecResult {
+	results := make([]ExecResult, len(cil))
+	wg := sync.WaitGroup{}
+	for idx, instance := range cil {
+		wg.Add(1)
+		go func(idx int, instance ClusterInstance) {
+			defer wg.Done()
+			ctx, cancel := withTimeout(ctx, timeouts.SSH)
+			defer cancel()
+			stdout, stderr, err := remoteExecutor.Output(ctx, log, instance, command)
+			results[idx] = ExecResult{
+				Instance: instance,
+				Stdout:   stdout,
+				Stderr:   stderr,
+				Err:      err,
+			}
+		}(idx, instance)
+	}
+	wg.Wait()
+	return results
+}
+
+// CopyResult is the outcome of a copy to or from a single instance.
+type CopyResult struct {
+	Instance ClusterInstance
+	Err      error
+}
+
+// CopyTo copies the given local file or directory to all instances in parallel.
+// The results are returned in the order of the instances.
+func (cil ClusterInstanceList) CopyTo(ctx context.Context, log *logging.Logger, localPath, remotePath string, options CopyOptions) []CopyResult {
+	return cil.forEachParallel(func(i ClusterInstance) error {
+		return i.CopyTo(ctx, log, localPath, remotePath, options)
+	})
+}
+
+// CopyFrom copies the given file or directory from all instances in parallel,
+// into a sub-directory of localDir that is named after the instance.
+// The results are returned in the order of the instances.
+func (cil ClusterInstanceList) CopyFrom(ctx context.Context, log *logging.Logger, remotePath, localDir string, options CopyOptions) []CopyResult {
+	return cil.forEachParallel(func(i ClusterInstance) error {
+		instanceDir := filepath.Join(localDir, i.Name)
+		if err := os.MkdirAll(instanceDir, 0755); err != nil {
+			return maskAny(err)
+		}
+		return i.CopyFrom(ctx, log, remotePath, instanceDir, options)
+	})
+}
+
+// forEachParallel calls the given function for all instances in parallel.
+func (cil ClusterInstanceList) forEachParallel(f func(i ClusterInstance) error) []CopyResult {
+	results := make([]CopyResult, len(cil))
+	wg := sync.WaitGroup{}
+	for idx, instance := range cil {
+		wg.Add(1)
+		go func(idx int, instance ClusterInstance) {
+			defer wg.Done()
+			results[idx] = CopyResult{Instance: instance, Err: f(instance)}
+		}(idx, instance)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+// Kinds of resumable operations
+const (
+	OperationCreateCluster  = "create-cluster"
+	OperationCreateInstance = "create-instance"
+	OperationUpgradeCluster = "upgrade-cluster"
+)
+
+const (
+	journalDirMode  = os.FileMode(0700)
+	journalFileMode = os.FileMode(0600) // Journals contain credentials
+	journalExt      = ".json"
+
+	stepCreate = "create"
+)
+
+// Operation is the journal of a single resumable operation.
+// It records the options of the operation and the steps that have been completed,
+// so an interrupted operation can be resumed where it stopped.
+type Operation struct {
+	ID          string                 `json:"id"`
+	Kind        string                 `json:"kind"`
+	Provider    string                 `json:"provider"`
+	DnsProvider string                 `json:"dns-provider"`
+	Started     time.Time              `json:"started"`
+	Cluster     *CreateClusterOptions  `json:"cluster,omitempty"`
+	Instance    *CreateInstanceOptions `json:"instance,omitempty"`
+	Upgrade     *UpgradeOptions        `json:"upgrade,omitempty"`
+	Completed   map[string][]string    `json:"completed"` // Completed steps, keyed by target (instance or cluster name)
+	Error       string                 `json:"error,omitempty"`
+
+	mutex   sync.Mutex
+	dir     string // Directory the journal is stored in (empty means memory only)
+	resumed bool
+}
+
+// NewOperation creates a new operation of given kind, journaled in the given directory.
+// An empty directory keeps the journal in memory only.
+func NewOperation(dir, kind string) *Operation {
+	return &Operation{
+		ID:        fmt.Sprintf("%s-%s", kind, strings.ToLower(uniuri.NewLen(8))),
+		Kind:      kind,
+		Started:   time.Now(),
+		Completed: make(map[string][]string),
+		dir:       dir,
+	}
+}
+
+// LoadOperation loads the journal of the operation with given ID from the given directory,
+// in order to resume the operation.
+func LoadOperation(dir, id string) (*Operation, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, id+journalExt))
+	if os.IsNotExist(err) {
+		return nil, maskAny(errgo.WithCausef(nil, NotFoundError, "operation %s not found", id))
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	o := &Operation{}
+	if err := json.Unmarshal(raw, o); err != nil {
+		return nil, maskAny(err)
+	}
+	if o.Completed == nil {
+		o.Completed = make(map[string][]string)
+	}
+	o.dir = dir
+	o.resumed = true
+	return o, nil
+}
+
+// ListOperations loads the journals of all operations in the given directory, oldest first.
+func ListOperations(dir string) ([]*Operation, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := []*Operation{}
+	for _, name := range names {
+		o, err := LoadOperation(dir, strings.TrimSuffix(filepath.Base(name), journalExt))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, o)
+	}
+	sort.Sort(operationsByStart(result))
+	return result, nil
+}
+
+// Resumed returns true if the operation was loaded from its journal to resume it.
+func (o *Operation) Resumed() bool {
+	return o.resumed
+}
+
+// IsCompleted returns true if the given step has been completed for the given target.
+func (o *Operation) IsCompleted(target, step string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, s := range o.Completed[target] {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete records the given step as completed for the given target and saves the journal.
+func (o *Operation) Complete(target, step string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Completed[target] = append(o.Completed[target], step)
+	return maskAny(o.save())
+}
+
+// Forget removes all completed steps of the given target and saves the journal.
+// It is used when the target (instance) has been removed by a rollback.
+func (o *Operation) Forget(target string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.Completed, target)
+	return maskAny(o.save())
+}
+
+// Fail records the error that stopped the operation and saves the journal.
+func (o *Operation) Fail(err error) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Error = err.Error()
+	return maskAny(o.save())
+}
+
+// Save writes the journal to disk.
+func (o *Operation) Save() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return maskAny(o.save())
+}
+
+// Remove deletes the journal from disk, once the operation has finished or has been rolled back.
+func (o *Operation) Remove() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.dir == "" {
+		return nil
+	}
+	if err := os.Remove(o.path()); err != nil && !os.IsNotExist(err) {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (o *Operation) path() string {
+	return filepath.Join(o.dir, o.ID+journalExt)
+}
+
+func (o *Operation) save() error {
+	if o.dir == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := os.MkdirAll(o.dir, journalDirMode); err != nil {
+		return maskAny(err)
+	}
+	// Write to a temporary file first, so an interrupted write never loses completed steps
+	tmpPath := o.path() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, raw, journalFileMode); err != nil {
+		return maskAny(err)
+	}
+	if err := os.Rename(tmpPath, o.path()); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+type operationKey struct{}
+
+// WithOperation returns a child context of ctx that journals completed steps in the given operation.
+func WithOperation(ctx context.Context, o *Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, o)
+}
+
+func operationFromContext(ctx context.Context) *Operation {
+	o, _ := ctx.Value(operationKey{}).(*Operation)
+	return o
+}
+
+// RunStep runs the given step for the given target (instance or cluster name), unless the operation
+// of the given context records it as completed. Once the step succeeds, it is recorded as completed.
+// Without an operation in the context, the step is always run.
+func RunStep(ctx context.Context, log *logging.Logger, target, step string, run func() error) error {
+	o := operationFromContext(ctx)
+	if o != nil && o.IsCompleted(target, step) {
+		log.Infof("Skipping '%s' on %s, completed before", step, target)
+		return nil
+	}
+	if err := run(); err != nil {
+		return maskAny(err)
+	}
+	if o != nil {
+		if err := o.Complete(target, step); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// CreateInstanceOnce creates an instance with the given provider. When resuming an operation,
+// an instance with the same name may already exist. In that case the existing instance is used
+// and only its (missing) DNS records are registered.
+func CreateInstanceOnce(ctx context.Context, log *logging.Logger, provider CloudProvider, options CreateInstanceOptions, dnsProvider DnsProvider) (ClusterInstance, error) {
+	o := operationFromContext(ctx)
+	if o != nil && o.resumed {
+		instances, err := provider.GetInstances(options.ClusterInfo)
+		if err != nil {
+			return ClusterInstance{}, maskAny(err)
+		}
+		for _, i := range instances {
+			if i.Name != options.InstanceName {
+				continue
+			}
+			log.Infof("Instance %s already exists, resuming its setup", i.Name)
+			if err := i.waitUntilActive(ctx, log); err != nil {
+				return ClusterInstance{}, maskAny(err)
+			}
+			if err := RegisterInstance(ctx, log, dnsProvider, options, i.Name, options.RegisterClusterName(), i.LoadBalancerIPv4, i.LoadBalancerIPv6); err != nil {
+				return ClusterInstance{}, maskAny(err)
+			}
+			return i, nil
+		}
+	}
+	instance, err := provider.CreateInstance(ctx, log, options, dnsProvider)
+	if err != nil {
+		return ClusterInstance{}, maskAny(err)
+	}
+	if o != nil {
+		if err := o.Complete(instance.Name, stepCreate); err != nil {
+			return instance, maskAny(err)
+		}
+	}
+	return instance, nil
+}
+
+type operationsByStart []*Operation
+
+func (l operationsByStart) Len() int           { return len(l) }
+func (l operationsByStart) Less(i, j int) bool { return l[i].Started.Before(l[j].Started) }
+func (l operationsByStart) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
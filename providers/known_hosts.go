@@ -0,0 +1,259 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/juju/errgo"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	knownHostsDirMode  = os.FileMode(0700)
+	knownHostsFileMode = os.FileMode(0600)
+
+	// defaultKnownHostsCluster is the file used for hosts whose name does not contain a cluster domain.
+	defaultKnownHostsCluster = "default"
+)
+
+// KnownHosts holds the pinned SSH host keys of instances.
+// The keys of each cluster are stored in a separate file (in OpenSSH known_hosts format)
+// in a directory that is managed by quark.
+// When created without a directory, the keys are only kept in memory.
+type KnownHosts struct {
+	dir string
+
+	mutex  sync.Mutex
+	memory map[string][]byte // File content per cluster, used when there is no directory
+}
+
+// knownHost is a single entry of a known_hosts file.
+type knownHost struct {
+	hosts []string
+	key   ssh.PublicKey
+}
+
+// NewKnownHosts creates a KnownHosts that stores its files in the given directory.
+// If dir is empty, keys are not persisted.
+func NewKnownHosts(dir string) *KnownHosts {
+	return &KnownHosts{
+		dir:    dir,
+		memory: make(map[string][]byte),
+	}
+}
+
+// IsHostKeyMismatch returns true if the cause of the given error is a host key
+// that does not match the pinned key.
+func IsHostKeyMismatch(err error) bool {
+	return errgo.Cause(err) == HostKeyMismatchError
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of the given key, formatted like OpenSSH does.
+func HostKeyFingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Path returns the path of the file holding the keys of the given cluster.
+// It returns an empty string when keys are not persisted.
+func (k *KnownHosts) Path(info ClusterInfo) string {
+	if k.dir == "" {
+		return ""
+	}
+	return filepath.Join(k.dir, info.String())
+}
+
+// Lookup returns the keys pinned for the instance with given name.
+func (k *KnownHosts) Lookup(name string) ([]ssh.PublicKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	entries, err := k.load(knownHostsCluster(name))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var keys []ssh.PublicKey
+	for _, e := range entries {
+		if e.matches(name) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys, nil
+}
+
+// Pin stores the given key as the only key of the instance with given name.
+// The address is stored with it, so the file can be used by OpenSSH too.
+func (k *KnownHosts) Pin(name, address string, key ssh.PublicKey) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	cluster := knownHostsCluster(name)
+	entries, err := k.load(cluster)
+	if err != nil {
+		return maskAny(err)
+	}
+	hosts := []string{name}
+	if address != "" {
+		hosts = append(hosts, address)
+	}
+	entries = append(withoutHost(entries, name), knownHost{hosts: hosts, key: key})
+	if err := k.save(cluster, entries); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Forget removes the keys of the instance with given name.
+func (k *KnownHosts) Forget(name string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	cluster := knownHostsCluster(name)
+	entries, err := k.load(cluster)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := k.save(cluster, withoutHost(entries, name)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ForgetCluster removes the keys of all instances of the given cluster.
+func (k *KnownHosts) ForgetCluster(info ClusterInfo) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if err := k.save(info.String(), nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// load reads all entries of the given cluster.
+// The caller must hold the mutex.
+func (k *KnownHosts) load(cluster string) ([]knownHost, error) {
+	var content []byte
+	if k.dir == "" {
+		content = k.memory[cluster]
+	} else {
+		var err error
+		content, err = ioutil.ReadFile(filepath.Join(k.dir, cluster))
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	var entries []knownHost
+	rest := content
+	for {
+		marker, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, maskAny(errgo.Notef(err, "cannot parse known hosts of %s", cluster))
+		}
+		if marker == "" {
+			entries = append(entries, knownHost{hosts: hosts, key: key})
+		}
+		rest = next
+	}
+	return entries, nil
+}
+
+// save replaces all entries of the given cluster.
+// The caller must hold the mutex.
+func (k *KnownHosts) save(cluster string, entries []knownHost) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(strings.Join(e.hosts, ","))
+		buf.WriteString(" ")
+		buf.Write(ssh.MarshalAuthorizedKey(e.key))
+	}
+	if k.dir == "" {
+		if len(entries) == 0 {
+			delete(k.memory, cluster)
+		} else {
+			k.memory[cluster] = buf.Bytes()
+		}
+		return nil
+	}
+
+	path := filepath.Join(k.dir, cluster)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return maskAny(err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(k.dir, knownHostsDirMode); err != nil {
+		return maskAny(err)
+	}
+	// Write to a temporary file first, so an interrupted write never loses pinned keys
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), knownHostsFileMode); err != nil {
+		return maskAny(err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// matches returns true if the given host name is one of the hosts of the entry.
+func (e knownHost) matches(name string) bool {
+	for _, h := range e.hosts {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHost returns all given entries that do not match the given host name.
+func withoutHost(entries []knownHost, name string) []knownHost {
+	var result []knownHost
+	for _, e := range entries {
+		if !e.matches(name) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// knownHostsCluster returns the name of the cluster (name.domain) of the instance with given name.
+// Hosts known by their IP address (e.g. jump hosts) are stored in the default file.
+func knownHostsCluster(name string) string {
+	if net.ParseIP(name) != nil {
+		return defaultKnownHostsCluster
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return defaultKnownHostsCluster
+	}
+	return parts[1]
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	updateConfPath = "/etc/coreos/update.conf"
+
+	// Operations of the update engine, as reported by `update_engine_client -status`
+	UpdateStatusIdle              = "UPDATE_STATUS_IDLE"
+	UpdateStatusUpdatedNeedReboot = "UPDATE_STATUS_UPDATED_NEED_REBOOT"
+	UpdateStatusReportingError    = "UPDATE_STATUS_REPORTING_ERROR_EVENT"
+
+	noUpdateVersion = "0.0.0.0"
+)
+
+// RebootStrategies contains all reboot strategies supported by locksmith
+var RebootStrategies = []string{"best-effort", "etcd-lock", "reboot", "off"}
+
+// IsValidRebootStrategy returns true if the given strategy is supported by locksmith.
+func IsValidRebootStrategy(strategy string) bool {
+	for _, s := range RebootStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateEngineStatus is the status of the update engine of an instance.
+type UpdateEngineStatus struct {
+	LastChecked int64  // Unix time of the last check for updates
+	CurrentOp   string // Current operation, e.g. UpdateStatusIdle
+	NewVersion  string // Version of the downloaded update (empty when there is none)
+}
+
+// NeedsReboot returns true if an update has been downloaded and is activated by a reboot.
+func (s UpdateEngineStatus) NeedsReboot() bool {
+	return s.CurrentOp == UpdateStatusUpdatedNeedReboot
+}
+
+// State returns a short, human readable form of the current operation, e.g. "updated-need-reboot".
+func (s UpdateEngineStatus) State() string {
+	if s.CurrentOp == "" {
+		return "unknown"
+	}
+	return strings.ToLower(strings.Replace(strings.TrimPrefix(s.CurrentOp, "UPDATE_STATUS_"), "_", "-", -1))
+}
+
+// GetUpdateEngineStatus calls update_engine_client to fetch the status of the update engine.
+func (i ClusterInstance) GetUpdateEngineStatus(ctx context.Context, log *logging.Logger) (UpdateEngineStatus, error) {
+	log.Debugf("Fetching update engine status on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "update_engine_client -status", "", true)
+	if err != nil {
+		return UpdateEngineStatus{}, maskAny(err)
+	}
+	return parseUpdateEngineStatus(out), nil
+}
+
+// parseUpdateEngineStatus parses the output of `update_engine_client -status`.
+func parseUpdateEngineStatus(out string) UpdateEngineStatus {
+	s := UpdateEngineStatus{}
+	for key, value := range parseEnv(out) {
+		switch key {
+		case "LAST_CHECKED_TIME":
+			s.LastChecked, _ = strconv.ParseInt(value, 10, 64)
+		case "CURRENT_OP":
+			s.CurrentOp = value
+		case "NEW_VERSION":
+			if value != noUpdateVersion {
+				s.NewVersion = value
+			}
+		}
+	}
+	return s
+}
+
+// LocksmithStatus is the state of the locksmith reboot semaphore of a cluster.
+type LocksmithStatus struct {
+	Available int      // Number of available reboot locks
+	Max       int      // Maximum number of instances that reboot at the same time
+	Holders   []string // Machine IDs of the instances holding a reboot lock
+}
+
+// IsHolder returns true if the machine with given ID holds a reboot lock.
+func (s LocksmithStatus) IsHolder(machineID string) bool {
+	for _, id := range s.Holders {
+		if id == machineID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLocksmithStatus calls locksmithctl to fetch the state of the reboot semaphore of the cluster.
+func (i ClusterInstance) GetLocksmithStatus(ctx context.Context, log *logging.Logger) (LocksmithStatus, error) {
+	log.Debugf("Fetching locksmith status on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "locksmithctl status", "", true)
+	if err != nil {
+		return LocksmithStatus{}, maskAny(err)
+	}
+	return parseLocksmithStatus(out), nil
+}
+
+// parseLocksmithStatus parses the output of `locksmithctl status`: the available & maximum number
+// of locks ("Available: 0", "Max: 1"), followed by the machine IDs of the holders below "MACHINE ID".
+func parseLocksmithStatus(out string) LocksmithStatus {
+	s := LocksmithStatus{}
+	holders := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "Available:"):
+			s.Available, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Available:")))
+		case strings.HasPrefix(line, "Max:"):
+			s.Max, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Max:")))
+		case line == "MACHINE ID":
+			holders = true
+		case holders:
+			s.Holders = append(s.Holders, line)
+		}
+	}
+	return s
+}
+
+// UpdateConfig contains the settings of /etc/coreos/update.conf that control reboots after an OS update.
+type UpdateConfig struct {
+	RebootStrategy     string // One of RebootStrategies
+	RebootWindowStart  string // Start of the maintenance window, e.g. "Thu 04:00" (empty means any time)
+	RebootWindowLength string // Length of the maintenance window, e.g. "1h"
+}
+
+// IsEmpty returns true if none of the settings is set.
+func (c UpdateConfig) IsEmpty() bool {
+	return c.RebootStrategy == "" && c.RebootWindowStart == "" && c.RebootWindowLength == ""
+}
+
+// Window returns a human readable form of the maintenance window, or "" if there is none.
+func (c UpdateConfig) Window() string {
+	if c.RebootWindowStart == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s)", c.RebootWindowStart, c.RebootWindowLength)
+}
+
+// GetUpdateConfig reads the reboot settings from /etc/coreos/update.conf.
+func (i ClusterInstance) GetUpdateConfig(ctx context.Context, log *logging.Logger) (UpdateConfig, error) {
+	log.Debugf("Fetching update config on %s", i)
+	out, err := i.runRemoteCommand(ctx, log, "cat "+updateConfPath, "", false)
+	if err != nil {
+		return UpdateConfig{}, maskAny(err)
+	}
+	env := parseEnv(out)
+	return UpdateConfig{
+		RebootStrategy:     env["REBOOT_STRATEGY"],
+		RebootWindowStart:  env["REBOOT_WINDOW_START"],
+		RebootWindowLength: env["REBOOT_WINDOW_LENGTH"],
+	}, nil
+}
+
+// SetUpdateConfig writes all non-empty settings of the given config to /etc/coreos/update.conf,
+// keeping all other settings, and restarts locksmithd so it uses them.
+func (i ClusterInstance) SetUpdateConfig(ctx context.Context, log *logging.Logger, config UpdateConfig) error {
+	log.Infof("Updating %s on %s", updateConfPath, i)
+	content, err := i.runRemoteCommand(ctx, log, "cat "+updateConfPath, "", false)
+	if err != nil {
+		return maskAny(err)
+	}
+	content = setEnv(content, "REBOOT_STRATEGY", config.RebootStrategy)
+	content = setEnv(content, "REBOOT_WINDOW_START", config.RebootWindowStart)
+	content = setEnv(content, "REBOOT_WINDOW_LENGTH", config.RebootWindowLength)
+	if err := i.WriteFile(ctx, log, updateConfPath, content, 0644); err != nil {
+		return maskAny(err)
+	}
+	if _, err := i.runRemoteCommand(ctx, log, "sudo systemctl restart locksmithd.service", "", false); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// parseEnv parses KEY=VALUE lines, ignoring all other lines. Quotes around values are removed.
+func parseEnv(content string) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " #") {
+			continue
+		}
+		env[parts[0]] = strings.Trim(parts[1], "\"'")
+	}
+	return env
+}
+
+// setEnv replaces the value of the given key in the given KEY=VALUE lines, or adds it.
+// An empty value leaves the content unchanged.
+func setEnv(content, key, value string) string {
+	if value == "" {
+		return content
+	}
+	lines := []string{}
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), key+"=") {
+			if found {
+				continue
+			}
+			line, found = key+"="+value, true
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// OSStatus is the state of the OS of a single instance.
+type OSStatus struct {
+	Instance     ClusterInstance
+	MachineID    string
+	Version      string             // Version of the running OS (from /etc/lsb-release)
+	UpdateEngine UpdateEngineStatus // Status of the update engine
+	UpdateConfig UpdateConfig       // Reboot settings
+	Skipped      bool               // Set when the instance does not run CoreOS, its status is not fetched
+	Err          error              // Set when the status cannot be fetched
+}
+
+// GetOSStatus fetches the OS status of all instances of the list in parallel, and the state of the
+// locksmith reboot semaphore through the first reachable instance. Instances that do not run CoreOS are skipped.
+// Unreachable instances are no error, their status contains the error. An error is only returned when
+// the given context is canceled.
+func (cil ClusterInstanceList) GetOSStatus(ctx context.Context, log *logging.Logger) ([]OSStatus, LocksmithStatus, error) {
+	result := make([]OSStatus, len(cil))
+	cil.checkParallel(func(idx int, i ClusterInstance) {
+		result[idx] = i.getOSStatus(ctx, log)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, LocksmithStatus{}, maskAny(err)
+	}
+	var locks LocksmithStatus
+	for _, s := range result {
+		if s.Err != nil || s.Skipped {
+			continue
+		}
+		var err error
+		if locks, err = s.Instance.GetLocksmithStatus(ctx, log); err != nil {
+			log.Warningf("Cannot fetch locksmith status on %s: %v", s.Instance, err)
+			continue
+		}
+		break
+	}
+	return result, locks, nil
+}
+
+// getOSStatus fetches the OS status of the instance.
+func (i ClusterInstance) getOSStatus(ctx context.Context, log *logging.Logger) OSStatus {
+	s := OSStatus{Instance: i}
+	if i.OS != OSNameCoreOS {
+		// Only CoreOS has an update engine & locksmith
+		s.Skipped = true
+		return s
+	}
+	if s.MachineID, s.Err = i.GetMachineID(ctx, log); s.Err != nil {
+		return s
+	}
+	v, err := i.GetOSRelease(ctx, log)
+	if err != nil {
+		s.Err = maskAny(err)
+		return s
+	}
+	s.Version = v.String()
+	if s.UpdateEngine, s.Err = i.GetUpdateEngineStatus(ctx, log); s.Err != nil {
+		return s
+	}
+	s.UpdateConfig, s.Err = i.GetUpdateConfig(ctx, log)
+	return s
+}
+
+// UpdateOS lets the update engine of the instance check for an OS update. When an update has been
+// downloaded, it takes a locksmith reboot lock (waiting until one is available), reboots the instance,
+// waits until it has rejoined ETCD & fleet and releases the lock again.
+// It returns true if the instance has been rebooted.
+func (i ClusterInstance) UpdateOS(ctx context.Context, log *logging.Logger, etcdProxy bool, provider CloudProvider) (bool, error) {
+	status, err := i.GetUpdateEngineStatus(ctx, log)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	if !status.NeedsReboot() {
+		log.Infof("Checking for OS updates on %s", i)
+		lastChecked := status.LastChecked
+		if _, err := i.runRemoteCommand(ctx, log, "sudo update_engine_client -check_for_update", "", false); err != nil {
+			return false, maskAny(err)
+		}
+		if err := WaitUntil(ctx, fmt.Sprintf("update check on %s", i), func(ctx context.Context) (bool, error) {
+			s, err := i.GetUpdateEngineStatus(ctx, log)
+			if err != nil {
+				log.Debugf("Cannot fetch update engine status on %s: %v", i, err)
+				return false, nil
+			}
+			switch s.CurrentOp {
+			case UpdateStatusReportingError:
+				return false, maskAny(fmt.Errorf("update engine on %s reports an error", i))
+			case UpdateStatusIdle:
+				// Idle before the check has started
+				if s.LastChecked == lastChecked {
+					return false, nil
+				}
+			case UpdateStatusUpdatedNeedReboot:
+			default:
+				// Checking, downloading, verifying...
+				return false, nil
+			}
+			status = s
+			return true, nil
+		}); err != nil {
+			return false, maskAny(err)
+		}
+	}
+	if !status.NeedsReboot() {
+		log.Infof("OS on %s is up to date", i)
+		return false, nil
+	}
+
+	// Other instances may be rebooting, wait for a reboot lock
+	machineID, err := i.GetMachineID(ctx, log)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	log.Infof("Update to %s downloaded on %s, waiting for a reboot lock", status.NewVersion, i)
+	if err := WaitUntil(ctx, fmt.Sprintf("reboot lock for %s", i), func(ctx context.Context) (bool, error) {
+		locks, err := i.GetLocksmithStatus(ctx, log)
+		if err != nil {
+			log.Debugf("Cannot fetch locksmith status on %s: %v", i, err)
+			return false, nil
+		}
+		if locks.IsHolder(machineID) {
+			// Taken by locksmithd on the instance itself
+			return true, nil
+		}
+		if locks.Available <= 0 {
+			return false, nil
+		}
+		if _, err := i.runRemoteCommand(ctx, log, "locksmithctl lock", "", false); err != nil {
+			// Another instance may have been faster
+			log.Debugf("Cannot take reboot lock for %s: %v", i, err)
+			return false, nil
+		}
+		return true, nil
+	}); err != nil {
+		return false, maskAny(err)
+	}
+
+	// The lock is kept when the instance does not come back, so no other instance reboots
+	if err := i.RebootAndWait(ctx, log, etcdProxy, provider); err != nil {
+		return true, maskAny(err)
+	}
+
+	// With the etcd-lock strategy, locksmithd releases the lock itself after the reboot
+	locks, err := i.GetLocksmithStatus(ctx, log)
+	if err != nil {
+		return true, maskAny(err)
+	}
+	if locks.IsHolder(machineID) {
+		if _, err := i.runRemoteCommand(ctx, log, "locksmithctl unlock", "", false); err != nil {
+			return true, maskAny(err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+)
+
+const (
+	PlanKindServer = "server"
+	PlanKindDNS    = "dns"
+	PlanKindEtcd   = "etcd"
+	PlanKindSSH    = "ssh"
+
+	// Placeholders for values that are only known once an instance has been created
+	PlanPublicIPv4 = "<public-ipv4>"
+	PlanPublicIPv6 = "<public-ipv6>"
+)
+
+// Plan is a list of actions that an operation would perform.
+// A plan is built in dry-run mode, without touching any provider, DNS or instance.
+type Plan struct {
+	Actions []PlanAction `json:"actions" yaml:"actions"`
+}
+
+// PlanAction is a single action in a plan.
+type PlanAction struct {
+	Kind   string `json:"kind" yaml:"kind"`     // server|dns|etcd|ssh
+	Target string `json:"target" yaml:"target"` // Name of the instance or DNS record the action applies to
+	Action string `json:"action" yaml:"action"`
+}
+
+// Add appends a single action to the plan.
+func (p *Plan) Add(kind, target, format string, args ...interface{}) {
+	p.Actions = append(p.Actions, PlanAction{
+		Kind:   kind,
+		Target: target,
+		Action: fmt.Sprintf(format, args...),
+	})
+}
+
+// AddCreateInstance adds the creation of a new instance to the plan, including its DNS records
+// and the steps of its initial setup.
+func (p *Plan) AddCreateInstance(options CreateInstanceOptions, iso InitialSetupOptions) {
+	p.Add(PlanKindServer, options.InstanceName, "Create instance (%s)", options.InstanceConfig)
+	for _, r := range RegisterInstanceRecords(options, options.RegisterClusterName(), PlanPublicIPv4, PlanPublicIPv6) {
+		p.Add(PlanKindDNS, r.Name, "Create %s record -> %s", r.Type, r.Data)
+	}
+	instance := ClusterInstance{Name: options.InstanceName}
+	for _, step := range instance.InitialSetupPlan(options, iso) {
+		p.Add(PlanKindSSH, options.InstanceName, "%s", step)
+	}
+}
+
+// AddDeleteInstance adds the removal of an existing instance to the plan, including its DNS records.
+func (p *Plan) AddDeleteInstance(instance ClusterInstance) {
+	for _, r := range UnRegisterInstanceRecords(instance) {
+		if r.Data == "" {
+			p.Add(PlanKindDNS, r.Name, "Delete all %s records", r.Type)
+		} else {
+			p.Add(PlanKindDNS, r.Name, "Delete %s record -> %s", r.Type, r.Data)
+		}
+	}
+	p.Add(PlanKindServer, instance.Name, "Delete instance")
+}
+
+// AddDrainInstance adds the actions that drain the given instance from fleet and etcd to the plan.
+func (p *Plan) AddDrainInstance(victim ClusterInstance, remaining ClusterInstanceList) {
+	if len(remaining) == 0 {
+		return
+	}
+	p.Add(PlanKindSSH, victim.Name, "Stop fleet & wait until its units moved to other machines")
+	p.Add(PlanKindEtcd, victim.Name, "Remove etcd member through %s (if member, refused when quorum would be lost)", remaining[0].Name)
+}
+
+// AddUpdateClusterMembers adds an update of /etc/pulcy/cluster-members on all given instances to the plan,
+// followed by a reboot of the given mode.
+func (p *Plan) AddUpdateClusterMembers(instances ClusterInstanceList, reboot RebootMode) {
+	for _, i := range instances {
+		p.Add(PlanKindSSH, i.Name, "Update /etc/pulcy/cluster-members, restart gluon & enable services")
+	}
+	switch reboot {
+	case RebootBootstrap:
+		for _, i := range instances {
+			p.Add(PlanKindServer, i.Name, "Reboot instance (%d at a time) & wait until it started etcd, or joined etcd & fleet once etcd has a quorum", rebootParallelism)
+		}
+	case RebootRolling:
+		p.AddRollingReboot(instances)
+	}
+}
+
+// AddRollingReboot adds a rolling reboot of the given instances to the plan.
+func (p *Plan) AddRollingReboot(instances ClusterInstanceList) {
+	for _, i := range instances {
+		p.Add(PlanKindServer, i.Name, "Reboot instance (%d at a time) & wait until it rejoined etcd & fleet", rebootParallelism)
+	}
+}
+
+// AddRepair adds the actions that re-apply the given drifts to the plan.
+func (p *Plan) AddRepair(drifts []Drift) {
+	for _, d := range drifts {
+		switch {
+		case d.Kind == DriftKindFile && d.Item == clusterMembersPath:
+			p.Add(PlanKindSSH, d.Target, "Rewrite %s & restart gluon", d.Item)
+		case d.Kind == DriftKindFile:
+			p.Add(PlanKindSSH, d.Target, "Rewrite %s", d.Item)
+		case !d.Repairable():
+			continue
+		case d.Expected != "":
+			p.Add(PlanKindDNS, d.Target, "Create %s record -> %s", d.Item, d.Expected)
+		default:
+			p.Add(PlanKindDNS, d.Target, "Delete %s record -> %s", d.Item, d.Actual)
+		}
+	}
+}
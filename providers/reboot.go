@@ -0,0 +1,309 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultRebootParallelism is the default number of instances that are rebooted at the same time.
+	DefaultRebootParallelism = 1
+
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+var (
+	rebootParallelism = DefaultRebootParallelism
+)
+
+// RebootMode specifies if and how instances are rebooted after their cluster members have been updated.
+type RebootMode int
+
+const (
+	// RebootNone leaves the instances running.
+	RebootNone RebootMode = iota
+	// RebootBootstrap reboots the instances of a new cluster a few at a time, see BootstrapReboot.
+	// Used to bootstrap a new cluster, its ETCD & fleet are only started by this reboot.
+	RebootBootstrap
+	// RebootRolling reboots the instances of a running cluster a few at a time, see RollingReboot.
+	RebootRolling
+)
+
+// SetRebootParallelism sets the number of instances that are rebooted at the same time during
+// a rolling reboot and returns the previous value.
+func SetRebootParallelism(n int) int {
+	previous := rebootParallelism
+	rebootParallelism = n
+	return previous
+}
+
+// GetBootID returns the ID of the current boot of the instance, which changes on every reboot.
+func (i ClusterInstance) GetBootID(ctx context.Context, log *logging.Logger) (string, error) {
+	id, err := i.runRemoteCommand(ctx, log, "cat "+bootIDPath, "", true)
+	return id, maskAny(err)
+}
+
+// RebootAndWait reboots the instance and waits until it is back and has rejoined ETCD & fleet.
+func (i ClusterInstance) RebootAndWait(ctx context.Context, log *logging.Logger, etcdProxy bool, provider CloudProvider) error {
+	if err := i.reboot(ctx, log, provider); err != nil {
+		return maskAny(err)
+	}
+	if err := i.waitUntilHealthy(ctx, log, etcdProxy); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("%s is back and healthy", i)
+	return nil
+}
+
+// reboot reboots the instance and waits until it can be reached over SSH again.
+func (i ClusterInstance) reboot(ctx context.Context, log *logging.Logger, provider CloudProvider) error {
+	bootID, err := i.GetBootID(ctx, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Rebooting %s", i)
+	if err := provider.RebootInstance(ctx, i); err != nil {
+		return maskAny(err)
+	}
+	if err := WaitUntil(ctx, fmt.Sprintf("%s to reboot", i), func(ctx context.Context) (bool, error) {
+		id, err := i.GetBootID(ctx, log)
+		if IsHostKeyMismatch(err) {
+			// Waiting will not help
+			return false, maskAny(err)
+		}
+		return err == nil && id != bootID, nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// waitUntilEtcdStarted waits until ETCD is running on the instance.
+// Unlike waitUntilHealthy, this does not need an ETCD quorum.
+func (i ClusterInstance) waitUntilEtcdStarted(ctx context.Context, log *logging.Logger) error {
+	if err := waitUntil(ctx, fmt.Sprintf("%s to start etcd", i), timeouts.Health, func(ctx context.Context) (bool, error) {
+		state, err := i.GetUnitState(ctx, log, "is-active", "etcd2.service")
+		if err != nil {
+			log.Debugf("Cannot fetch state of etcd2.service on %s: %v", i, err)
+			return false, nil
+		}
+		return state == "active", nil
+	}); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("%s is back and has started etcd", i)
+	return nil
+}
+
+// BootstrapReboot reboots the instances of a new cluster a few at a time (see SetRebootParallelism), ETCD members first.
+// ETCD & fleet are only started by this reboot, so until the rebooted ETCD members form a quorum, the next batch is started
+// once the previous one can be reached over SSH and has started ETCD. As soon as the quorum exists, it waits until all
+// rebooted instances have joined ETCD & fleet, and all following batches are gated like in RollingReboot.
+func (instances ClusterInstanceList) BootstrapReboot(ctx context.Context, log *logging.Logger, clusterMembers ClusterMemberList, provider CloudProvider) error {
+	isProxy := clusterMembers.isEtcdProxy
+	quorum := clusterMembers.quorum()
+	ordered := ClusterInstanceList{}
+	for _, i := range instances {
+		if !isProxy(i) {
+			ordered = append(ordered, i)
+		}
+	}
+	for _, i := range instances {
+		if isProxy(i) {
+			ordered = append(ordered, i)
+		}
+	}
+
+	rebooted := ClusterInstanceList{}
+	for _, batch := range ordered.batches() {
+		hasQuorum := rebooted.countMembers(isProxy) >= quorum
+		if hasQuorum {
+			if err := instances.checkQuorum(ctx, log, batch, isProxy, quorum); err != nil {
+				return maskAny(err)
+			}
+		}
+		if err := batch.inParallel(func(i ClusterInstance) error {
+			if hasQuorum {
+				return maskAny(i.RebootAndWait(ctx, log, isProxy(i), provider))
+			}
+			if err := i.reboot(ctx, log, provider); err != nil {
+				return maskAny(err)
+			}
+			return maskAny(i.waitUntilEtcdStarted(ctx, log))
+		}); err != nil {
+			return maskAny(err)
+		}
+		rebooted = append(rebooted, batch...)
+		if !hasQuorum && rebooted.countMembers(isProxy) >= quorum {
+			log.Infof("etcd has a quorum, waiting for all rebooted instances to join etcd & fleet")
+			if err := rebooted.inParallel(func(i ClusterInstance) error {
+				return maskAny(i.waitUntilHealthy(ctx, log, isProxy(i)))
+			}); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+	return nil
+}
+
+// RollingReboot reboots the given instances of a running cluster a few at a time (see SetRebootParallelism).
+// Every batch is only started when the previous one is back and has rejoined ETCD & fleet.
+// It aborts with a QuorumError before rebooting a batch that would make the ETCD cluster lose its quorum,
+// which includes every reboot of a member of a cluster with less than 3 ETCD members, unless force is set.
+// The given cluster members are used to determine which instances are ETCD members.
+func (instances ClusterInstanceList) RollingReboot(ctx context.Context, log *logging.Logger, clusterMembers ClusterMemberList, provider CloudProvider, force bool) error {
+	isProxy := clusterMembers.isEtcdProxy
+	etcdMembers := clusterMembers.etcdMemberCount()
+	quorum := clusterMembers.quorum()
+
+	batches := instances.batches()
+	if !force {
+		for _, batch := range batches {
+			down := batch.countMembers(isProxy)
+			if down == 0 || etcdMembers-down >= quorum {
+				continue
+			}
+			if etcdMembers < 3 {
+				return maskAny(errgo.WithCausef(nil, QuorumError, "a cluster of %d etcd members loses its quorum while a member reboots, use --force to reboot anyway", etcdMembers))
+			}
+			return maskAny(errgo.WithCausef(nil, QuorumError, "rebooting %d etcd members at the same time would lose the quorum of %d out of %d members, lower the reboot parallelism", down, quorum, etcdMembers))
+		}
+	}
+
+	for _, batch := range batches {
+		if !force {
+			if err := instances.checkQuorum(ctx, log, batch, isProxy, quorum); err != nil {
+				return maskAny(err)
+			}
+		}
+		if err := batch.inParallel(func(i ClusterInstance) error {
+			return maskAny(i.RebootAndWait(ctx, log, isProxy(i), provider))
+		}); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// batches splits the list into batches of instances that are rebooted at the same time.
+func (instances ClusterInstanceList) batches() []ClusterInstanceList {
+	parallelism := rebootParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	result := []ClusterInstanceList{}
+	for start := 0; start < len(instances); start += parallelism {
+		end := start + parallelism
+		if end > len(instances) {
+			end = len(instances)
+		}
+		result = append(result, instances[start:end])
+	}
+	return result
+}
+
+// inParallel calls the given function for all instances of the list in parallel and returns the first error.
+func (instances ClusterInstanceList) inParallel(f func(i ClusterInstance) error) error {
+	wg := sync.WaitGroup{}
+	errorChannel := make(chan error, len(instances))
+	for _, i := range instances {
+		wg.Add(1)
+		go func(i ClusterInstance) {
+			defer wg.Done()
+			if err := f(i); err != nil {
+				errorChannel <- maskAny(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errorChannel)
+	for err := range errorChannel {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// checkQuorum returns an error when the healthy ETCD members that are not in the given batch do not form a quorum.
+func (instances ClusterInstanceList) checkQuorum(ctx context.Context, log *logging.Logger, batch ClusterInstanceList, isProxy func(ClusterInstance) bool, quorum int) error {
+	var health []EtcdMemberHealth
+	var lastErr error
+	for _, i := range instances {
+		if batch.contains(i) || isProxy(i) {
+			continue
+		}
+		members, err := i.EtcdClusterHealth(ctx, log)
+		if err == nil {
+			health = members
+			break
+		}
+		lastErr = err
+	}
+	if health == nil {
+		return maskAny(fmt.Errorf("cannot fetch etcd cluster health: %v", lastErr))
+	}
+	healthy := 0
+	for _, m := range health {
+		if !m.Healthy {
+			continue
+		}
+		inBatch := false
+		for _, i := range batch {
+			if _, found := FindEtcdMemberHealth([]EtcdMemberHealth{m}, i.ClusterIP); found {
+				inBatch = true
+			}
+		}
+		if !inBatch {
+			healthy++
+		}
+	}
+	if healthy < quorum {
+		return maskAny(errgo.WithCausef(nil, QuorumError, "only %d healthy etcd members remain while rebooting %s, %d needed", healthy, batch.names(), quorum))
+	}
+	return nil
+}
+
+// countMembers returns the number of instances that are ETCD members (not proxies).
+func (instances ClusterInstanceList) countMembers(isProxy func(ClusterInstance) bool) int {
+	count := 0
+	for _, i := range instances {
+		if !isProxy(i) {
+			count++
+		}
+	}
+	return count
+}
+
+func (instances ClusterInstanceList) contains(i ClusterInstance) bool {
+	for _, x := range instances {
+		if x.Name == i.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (instances ClusterInstanceList) names() []string {
+	result := []string{}
+	for _, i := range instances {
+		result = append(result, i.Name)
+	}
+	return result
+}
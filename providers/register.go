@@ -18,61 +18,95 @@ import (
 	"strings"
 
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 // RegisterInstance creates DNS records for an instance
-func RegisterInstance(logger *logging.Logger, dnsProvider DnsProvider, options CreateInstanceOptions, name string, registerCluster bool, publicIpv4, publicIpv6 string) error {
+func RegisterInstance(ctx context.Context, logger *logging.Logger, dnsProvider DnsProvider, options CreateInstanceOptions, name string, registerCluster bool, publicIpv4, publicIpv6 string) error {
 	logger.Infof("%s: '%s': '%s'", name, publicIpv4, publicIpv6)
 
 	// Create DNS record for the instance
 	logger.Infof("Creating DNS records: '%s', '%s'", options.InstanceName, options.ClusterName)
-	if publicIpv4 != "" {
-		if err := dnsProvider.CreateDnsRecord(options.Domain, "A", options.InstanceName, publicIpv4); err != nil {
+	existing, err := dnsProvider.ListDnsRecords(options.Domain)
+	if err != nil {
+		// Not fatal, we only use it to avoid creating duplicate records
+		logger.Debugf("Cannot list DNS records of %s: %#v", options.Domain, err)
+	}
+	for _, r := range RegisterInstanceRecords(options, registerCluster, publicIpv4, publicIpv6) {
+		if err := ctx.Err(); err != nil {
+			return maskAny(err)
+		}
+		if containsDnsRecord(existing, r) {
+			logger.Debugf("DNS record %s %s %s already exists", r.Type, r.Name, r.Data)
+			continue
+		}
+		if err := dnsProvider.CreateDnsRecord(options.Domain, r.Type, r.Name, r.Data); err != nil {
 			return maskAny(err)
 		}
+		record := r
+		TrackResource(ctx, ResourceDnsRecord, r.Data, r.Type+" "+r.Name, func(ctx context.Context) error {
+			return maskAny(dnsProvider.DeleteDnsRecord(options.Domain, record.Type, record.Name, record.Data))
+		})
+	}
+
+	return nil
+}
+
+// containsDnsRecord returns true if the given list contains a record with the same type, name & data as r.
+func containsDnsRecord(list []DnsRecord, r DnsRecord) bool {
+	for _, x := range list {
+		if x.Type == r.Type && x.Name == r.Name && x.Data == r.Data {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterInstanceRecords returns the DNS records that RegisterInstance creates for an instance.
+func RegisterInstanceRecords(options CreateInstanceOptions, registerCluster bool, publicIpv4, publicIpv6 string) []DnsRecord {
+	records := []DnsRecord{}
+	if publicIpv4 != "" {
+		records = append(records, DnsRecord{Type: "A", Name: options.InstanceName, Data: publicIpv4})
 		if registerCluster {
-			if err := dnsProvider.CreateDnsRecord(options.Domain, "A", options.ClusterName, publicIpv4); err != nil {
-				return maskAny(err)
-			}
+			records = append(records, DnsRecord{Type: "A", Name: options.ClusterName, Data: publicIpv4})
 		}
 	}
 	if publicIpv6 != "" {
-		if err := dnsProvider.CreateDnsRecord(options.Domain, "AAAA", options.InstanceName, publicIpv6); err != nil {
-			return maskAny(err)
-		}
+		records = append(records, DnsRecord{Type: "AAAA", Name: options.InstanceName, Data: publicIpv6})
 		if registerCluster {
-			if err := dnsProvider.CreateDnsRecord(options.Domain, "AAAA", options.ClusterName, publicIpv6); err != nil {
-				return maskAny(err)
-			}
+			records = append(records, DnsRecord{Type: "AAAA", Name: options.ClusterName, Data: publicIpv6})
 		}
 	}
-
-	return nil
+	return records
 }
 
 // UnRegisterInstance removes DNS records for an instance
 func UnRegisterInstance(logger *logging.Logger, dnsProvider DnsProvider, instance ClusterInstance, domain string) error {
-	// Delete DNS instance records
-	if err := dnsProvider.DeleteDnsRecord(domain, "A", instance.Name, ""); err != nil {
-		return maskAny(err)
+	for _, r := range UnRegisterInstanceRecords(instance) {
+		if err := dnsProvider.DeleteDnsRecord(domain, r.Type, r.Name, r.Data); err != nil {
+			return maskAny(err)
+		}
 	}
-	if err := dnsProvider.DeleteDnsRecord(domain, "AAAA", instance.Name, ""); err != nil {
-		return maskAny(err)
+	return nil
+}
+
+// UnRegisterInstanceRecords returns the DNS records that UnRegisterInstance removes for an instance.
+// An empty Data field means that all records with the given type and name are removed.
+func UnRegisterInstanceRecords(instance ClusterInstance) []DnsRecord {
+	// DNS instance records
+	records := []DnsRecord{
+		{Type: "A", Name: instance.Name},
+		{Type: "AAAA", Name: instance.Name},
 	}
 
-	// Delete DNS cluster records
+	// DNS cluster records
 	parts := strings.Split(instance.Name, ".")
 	clusterName := strings.Join(parts[1:], ".")
 	if instance.LoadBalancerIPv4 != "" {
-		if err := dnsProvider.DeleteDnsRecord(domain, "A", clusterName, instance.LoadBalancerIPv4); err != nil {
-			return maskAny(err)
-		}
+		records = append(records, DnsRecord{Type: "A", Name: clusterName, Data: instance.LoadBalancerIPv4})
 	}
 	if instance.LoadBalancerIPv6 != "" {
-		if err := dnsProvider.DeleteDnsRecord(domain, "AAAA", clusterName, instance.LoadBalancerIPv6); err != nil {
-			return maskAny(err)
-		}
+		records = append(records, DnsRecord{Type: "AAAA", Name: clusterName, Data: instance.LoadBalancerIPv6})
 	}
-
-	return nil
+	return records
 }
@@ -0,0 +1,182 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+// Capability is a feature that a provider may or may not support.
+type Capability string
+
+const (
+	CapabilityCluster       Capability = "cluster"        // Create & destroy entire clusters
+	CapabilityInstance      Capability = "instance"       // Create & destroy single instances in an existing cluster
+	CapabilityRegions       Capability = "regions"        // List regions
+	CapabilityImages        Capability = "images"         // List images
+	CapabilityKeys          Capability = "keys"           // List SSH keys
+	CapabilityInstanceTypes Capability = "instance-types" // List instance types
+	CapabilityDNS           Capability = "dns"            // Manage DNS records
+	CapabilityPrivateOnly   Capability = "private-only"   // Create instances without a public IP address
+)
+
+// Credential describes a single setting that is needed to construct a provider.
+// The CLI creates a commandline flag for every credential.
+type Credential struct {
+	Name      string // Name of the commandline flag (and configuration key)
+	Shorthand string // Shorthand of the commandline flag (can be empty)
+	EnvVar    string // Environment variable used when the flag is not set (can be empty)
+	Usage     string // Help text of the commandline flag
+	Required  bool   // If set, the provider cannot be constructed without this credential
+	Secret    bool   // If set, the value is never shown
+}
+
+// Credentials contains the values of credentials, keyed by credential name.
+type Credentials map[string]string
+
+// Get returns the value of the credential with given name.
+func (c Credentials) Get(name string) string {
+	return c[name]
+}
+
+// ProviderInfo describes a provider, as registered by its package.
+type ProviderInfo struct {
+	Name         string       // Name of the provider, as used in --provider
+	Credentials  []Credential // Credentials used by the provider
+	Capabilities []Capability // Features supported by the provider
+
+	// LoadRC loads credentials from a provider specific file (e.g. ~/.scwrc).
+	// It is only called when a required credential is missing. Can be nil.
+	LoadRC func() (Credentials, error)
+
+	// NewCloudProvider creates a cloud provider. It is nil for providers that only provide DNS.
+	NewCloudProvider func(log *logging.Logger, credentials Credentials) (CloudProvider, error)
+
+	// NewDnsProvider creates a DNS provider. It is nil for providers that do not provide DNS.
+	NewDnsProvider func(log *logging.Logger, credentials Credentials) (DnsProvider, error)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]ProviderInfo)
+)
+
+// RegisterProvider adds the given provider to the registry.
+// It is intended to be called from the init function of a provider package.
+func RegisterProvider(info ProviderInfo) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, found := registry[info.Name]; found {
+		panic(fmt.Sprintf("provider '%s' registered twice", info.Name))
+	}
+	registry[info.Name] = info
+}
+
+// LookupProvider returns the registered provider with given name.
+func LookupProvider(name string) (ProviderInfo, bool) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	info, found := registry[name]
+	return info, found
+}
+
+// RegisteredProviders returns all registered providers, sorted by name.
+func RegisteredProviders() []ProviderInfo {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := []ProviderInfo{}
+	for _, name := range names {
+		result = append(result, registry[name])
+	}
+	return result
+}
+
+// CloudProviderNames returns the sorted names of all registered cloud providers.
+func CloudProviderNames() []string {
+	names := []string{}
+	for _, info := range RegisteredProviders() {
+		if info.NewCloudProvider != nil {
+			names = append(names, info.Name)
+		}
+	}
+	return names
+}
+
+// DnsProviderNames returns the sorted names of all registered DNS providers.
+func DnsProviderNames() []string {
+	names := []string{}
+	for _, info := range RegisteredProviders() {
+		if info.NewDnsProvider != nil {
+			names = append(names, info.Name)
+		}
+	}
+	return names
+}
+
+// HasCapability returns true if the provider supports the given capability.
+func (info ProviderInfo) HasCapability(c Capability) bool {
+	for _, x := range info.Capabilities {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// CompleteCredentials fills in missing required credentials using the rc file loader of the provider
+// and checks that all required credentials are set.
+func (info ProviderInfo) CompleteCredentials(credentials Credentials) (Credentials, error) {
+	result := Credentials{}
+	for k, v := range credentials {
+		result[k] = v
+	}
+	if info.LoadRC != nil && info.missingCredential(result) != "" {
+		rc, err := info.LoadRC()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		for k, v := range rc {
+			if result[k] == "" {
+				result[k] = v
+			}
+		}
+	}
+	if missing := info.missingCredential(result); missing != "" {
+		return nil, maskAny(fmt.Errorf("Please specify a %s", missing))
+	}
+	return result, nil
+}
+
+// missingCredential returns the name of the first required credential that is not set.
+func (info ProviderInfo) missingCredential(credentials Credentials) string {
+	for _, c := range info.Credentials {
+		if c.Required && credentials.Get(c.Name) == "" {
+			return c.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+// Region describes a location in which instances can be created
+type Region struct {
+	ID        string   `json:"id" yaml:"id"`                                 // Provider specific ID of the region (used as --region)
+	Name      string   `json:"name" yaml:"name"`                             // Human readable name of the region
+	Available bool     `json:"available" yaml:"available"`                   // If set, new instances can be created in this region
+	Features  []string `json:"features,omitempty" yaml:"features,omitempty"` // Provider specific features of the region (can be empty)
+}
+
+// Image describes an OS image that can be installed on new instances
+type Image struct {
+	ID          string   `json:"id" yaml:"id"`                               // Provider specific ID of the image (used as --image)
+	Name        string   `json:"name" yaml:"name"`                           // Human readable name of the image
+	Description string   `json:"description" yaml:"description"`             // Additional description (distribution, family, ...)
+	Regions     []string `json:"regions,omitempty" yaml:"regions,omitempty"` // IDs of the regions the image is available in (empty means all)
+}
+
+// InstanceType describes a type (size, plan) of instances
+type InstanceType struct {
+	ID        string   `json:"id" yaml:"id"`                               // Provider specific ID of the type (used as --type)
+	Name      string   `json:"name" yaml:"name"`                           // Human readable name of the type
+	CPU       int      `json:"cpu" yaml:"cpu"`                             // Number of (virtual) CPU's
+	RAM       int      `json:"ram" yaml:"ram"`                             // Amount of memory in MB
+	Disk      int      `json:"disk" yaml:"disk"`                           // Size of the disk in GB
+	Price     float64  `json:"price" yaml:"price"`                         // Price per month in USD
+	Regions   []string `json:"regions,omitempty" yaml:"regions,omitempty"` // IDs of the regions the type is available in (empty means all)
+	Available bool     `json:"available" yaml:"available"`                 // If set, new instances can be created with this type
+}
+
+// SSHKey describes an SSH key that is registered at the provider
+type SSHKey struct {
+	ID          string `json:"id" yaml:"id"`                   // Provider specific ID of the key
+	Name        string `json:"name" yaml:"name"`               // Name of the key (used as --ssh-key)
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"` // Fingerprint of the key (can be empty)
+	PublicKey   string `json:"public-key" yaml:"public-key"`   // Public part of the key (can be empty)
+}
+
+// DnsRecord describes a single record of a DNS domain
+type DnsRecord struct {
+	ID       string `json:"id" yaml:"id"`             // Provider specific ID of the record
+	Type     string `json:"type" yaml:"type"`         // Type of the record (A, AAAA, CNAME, ...)
+	Name     string `json:"name" yaml:"name"`         // Name of the record
+	Data     string `json:"data" yaml:"data"`         // Content of the record
+	TTL      int    `json:"ttl" yaml:"ttl"`           // Time to live in seconds (0 means provider default)
+	Priority int    `json:"priority" yaml:"priority"` // Priority of the record (MX, SRV only)
+}
@@ -15,12 +15,14 @@
 package scaleway
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/op/go-logging"
 	"github.com/scaleway/scaleway-cli/pkg/api"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 	"github.com/pulcy/quark/templates"
@@ -38,15 +40,28 @@ const (
 )
 
 // Create a machine instance
-func (vp *scalewayProvider) CreateInstance(log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+func (vp *scalewayProvider) CreateInstance(ctx context.Context, log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+	// Find an instance to reach a server without public address through
+	var jumpHost *providers.ClusterInstance
+	if options.PrivateOnly {
+		instances, err := vp.GetInstances(options.ClusterInfo)
+		if err != nil {
+			return providers.ClusterInstance{}, maskAny(err)
+		}
+		jumpHost = instances.JumpHost()
+		if jumpHost == nil {
+			return providers.ClusterInstance{}, maskAny(fmt.Errorf("cluster %s has no instance with a public address to reach %s through", options.ClusterName, options.InstanceName))
+		}
+	}
+
 	// Create server
-	id, err := vp.createServer(options)
+	id, err := vp.createServer(ctx, options, jumpHost)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
 
 	// Wait for the server to be active
-	server, err := vp.waitUntilServerActive(id, false)
+	server, err := vp.waitUntilServerActive(ctx, id, false, jumpHost)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
@@ -54,18 +69,21 @@ func (vp *scalewayProvider) CreateInstance(log *logging.Logger, options provider
 	if options.RoleLoadBalancer {
 		publicIpv4 := server.PublicAddress.IP
 		publicIpv6 := ""
-		if err := providers.RegisterInstance(vp.Logger, dnsProvider, options, server.Name, options.RoleLoadBalancer, publicIpv4, publicIpv6); err != nil {
+		if err := providers.RegisterInstance(ctx, vp.Logger, dnsProvider, options, server.Name, options.RegisterClusterName(), publicIpv4, publicIpv6); err != nil {
 			return providers.ClusterInstance{}, maskAny(err)
 		}
 	}
 
 	vp.Logger.Infof("Server '%s' is ready", server.Name)
 
-	return vp.clusterInstance(server, false), nil
+	instance := vp.clusterInstance(server, false)
+	instance.JumpHost = jumpHost
+	return instance, nil
 }
 
-// Create a single server
-func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions) (string, error) {
+// Create a single server.
+// If a jump host is given, the server gets no public IP address and is reached through the jump host.
+func (vp *scalewayProvider) createServer(ctx context.Context, options providers.CreateInstanceOptions, jumpHost *providers.ClusterInstance) (string, error) {
 	// Fetch SSH keys
 	sshKeys, err := providers.FetchSSHKeys(options.SSHKeyGithubAccount)
 	if err != nil {
@@ -99,7 +117,7 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 
 	name := options.InstanceName
 	image := &imageIdentifier.Identifier
-	dynamicIPRequired := true
+	dynamicIPRequired := jumpHost == nil
 	//bootscript := ""
 
 	volID := ""
@@ -119,10 +137,13 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 
 	publicIPIdentifier := ""
 	if options.RoleLoadBalancer {
-		ip, err := vp.getFreeIP()
+		ip, err := vp.getFreeIP(ctx)
 		if err != nil {
 			return "", maskAny(err)
 		}
+		providers.TrackResource(ctx, providers.ResourceIP, ip.ID, ip.Address, func(ctx context.Context) error {
+			return maskAny(vp.client.DeleteIP(ip.ID))
+		})
 		publicIPIdentifier = ip.ID
 	}
 
@@ -144,8 +165,12 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 		opts.Volumes["0"] = volID
 	}
 	vp.Logger.Debugf("Creating server %s: %#v\n", name, opts)
-	id, err := vp.client.PostServer(opts)
-	if err != nil {
+	var id string
+	if err := vp.retry(ctx, false, func() error {
+		var err error
+		id, err = vp.client.PostServer(opts)
+		return err
+	}); err != nil {
 		vp.Logger.Errorf("PostServer failed: %#v", err)
 		// Delete volume
 		if volID != "" {
@@ -155,15 +180,20 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 		}
 		return "", maskAny(err)
 	}
+	providers.TrackResource(ctx, providers.ResourceServer, id, name, func(ctx context.Context) error {
+		return maskAny(vp.removeServer(id))
+	})
 
 	// Start server
-	if err := vp.client.PostServerAction(id, "poweron"); err != nil {
+	if err := vp.retry(ctx, false, func() error {
+		return vp.client.PostServerAction(id, "poweron")
+	}); err != nil {
 		vp.Logger.Errorf("poweron failed: %#v", err)
 		return "", maskAny(err)
 	}
 
 	// Wait until server starts
-	server, err := vp.waitUntilServerActive(id, true)
+	server, err := vp.waitUntilServerActive(ctx, id, true, jumpHost)
 	if err != nil {
 		return "", maskAny(err)
 	}
@@ -174,19 +204,24 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 		return "", maskAny(err)
 	}
 	instance := vp.clusterInstance(server, true)
+	instance.JumpHost = jumpHost
 	vp.Logger.Infof("Running bootstrap on %s. This may take a while...", server.Name)
-	if err := instance.RunScript(vp.Logger, bootstrap, "/root/pulcy-bootstrap.sh"); err != nil {
+	if err := instance.RunScript(ctx, vp.Logger, bootstrap, "/root/pulcy-bootstrap.sh"); err != nil {
 		// Failed expected because of a reboot
 		vp.Logger.Debugf("bootstrap failed (expected): %#v", err)
 	}
 
 	vp.Logger.Infof("Done running bootstrap on %s, rebooting...", server.Name)
-	if err := vp.client.PostServerAction(id, "reboot"); err != nil {
+	if err := vp.retry(ctx, false, func() error {
+		return vp.client.PostServerAction(id, "reboot")
+	}); err != nil {
 		vp.Logger.Errorf("reboot failed: %#v", err)
 		return "", maskAny(err)
 	}
-	time.Sleep(time.Second * 5)
-	if _, err := vp.waitUntilServerActive(id, false); err != nil {
+	if err := providers.Sleep(ctx, time.Second*5); err != nil {
+		return "", maskAny(err)
+	}
+	if _, err := vp.waitUntilServerActive(ctx, id, false, jumpHost); err != nil {
 		return "", maskAny(err)
 	}
 
@@ -195,36 +230,48 @@ func (vp *scalewayProvider) createServer(options providers.CreateInstanceOptions
 	return id, nil
 }
 
-func (vp *scalewayProvider) getFreeIP() (api.ScalewayIPDefinition, error) {
-	ip, err := vp.client.NewIP()
-	if err != nil {
+func (vp *scalewayProvider) getFreeIP(ctx context.Context) (api.ScalewayIPDefinition, error) {
+	var ip *api.ScalewayGetIP
+	if err := vp.retry(ctx, false, func() error {
+		var err error
+		ip, err = vp.client.NewIP()
+		return err
+	}); err != nil {
 		return api.ScalewayIPDefinition{}, maskAny(err)
 	}
 	return ip.IP, nil
 }
 
-func (vp *scalewayProvider) waitUntilServerActive(id string, bootstrapNeeded bool) (api.ScalewayServer, error) {
-	for {
-		gateway := ""
-		server, err := api.WaitForServerReady(vp.client, id, gateway)
-		if err != nil {
-			return api.ScalewayServer{}, err
+func (vp *scalewayProvider) waitUntilServerActive(ctx context.Context, id string, bootstrapNeeded bool, jumpHost *providers.ClusterInstance) (api.ScalewayServer, error) {
+	var server *api.ScalewayServer
+	if err := providers.WaitUntil(ctx, fmt.Sprintf("server %s to become active", id), func(ctx context.Context) (bool, error) {
+		if err := vp.retry(ctx, true, func() error {
+			var err error
+			server, err = vp.client.GetServer(id)
+			return err
+		}); err != nil {
+			return false, maskAny(err)
 		}
-		if server.State == "running" {
+		switch server.State {
+		case "running":
 			// Attempt an SSH connection
 			instance := vp.clusterInstance(*server, bootstrapNeeded)
-			if _, err := instance.GetMachineID(vp.Logger); err == nil {
-				// Success
-				return *server, nil
-			}
+			instance.JumpHost = jumpHost
+			_, err := instance.GetMachineID(ctx, vp.Logger)
+			return err == nil, nil
+		case "stopped":
+			return false, maskAny(fmt.Errorf("server %s has been stopped", id))
+		default:
+			return false, nil
 		}
-		// Wait a while
-		time.Sleep(time.Second * 5)
+	}); err != nil {
+		return api.ScalewayServer{}, maskAny(err)
 	}
+	return *server, nil
 }
 
 // Create an entire cluster
-func (vp *scalewayProvider) CreateCluster(log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
+func (vp *scalewayProvider) CreateCluster(ctx context.Context, log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, options.InstanceCount)
 	instanceDatas := make(chan instanceData, options.InstanceCount)
@@ -232,7 +279,6 @@ func (vp *scalewayProvider) CreateCluster(log *logging.Logger, options providers
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			time.Sleep(time.Duration((i - 1)) * time.Second * 10)
 			isCore := true
 			isLB := true
 			instanceOptions, err := options.NewCreateInstanceOptions(isCore, isLB, i)
@@ -240,7 +286,7 @@ func (vp *scalewayProvider) CreateCluster(log *logging.Logger, options providers
 				errors <- maskAny(err)
 				return
 			}
-			instance, err := vp.CreateInstance(log, instanceOptions, dnsProvider)
+			instance, err := providers.CreateInstanceOnce(ctx, log, vp, instanceOptions, dnsProvider)
 			if err != nil {
 				errors <- maskAny(err)
 			} else {
@@ -267,17 +313,17 @@ func (vp *scalewayProvider) CreateCluster(log *logging.Logger, options providers
 		instanceList = append(instanceList, data.ClusterInstance)
 	}
 
-	clusterMembers, err := instanceList.AsClusterMemberList(log, nil)
+	clusterMembers, err := instanceList.AsClusterMemberList(ctx, log, nil)
 	if err != nil {
 		return maskAny(err)
 	}
 
 	// Create tinc network config
-	if instanceList.ReconfigureTincCluster(vp.Logger); err != nil {
+	if err := instanceList.ReconfigureTincCluster(ctx, vp.Logger); err != nil {
 		return maskAny(err)
 	}
 
-	if err := vp.setupInstances(log, instances, clusterMembers); err != nil {
+	if err := vp.setupInstances(ctx, log, instances, clusterMembers); err != nil {
 		return maskAny(err)
 	}
 
@@ -290,7 +336,7 @@ type instanceData struct {
 	FleetMetadata         string
 }
 
-func (vp *scalewayProvider) setupInstances(log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
+func (vp *scalewayProvider) setupInstances(ctx context.Context, log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, len(instances))
 	for _, instance := range instances {
@@ -302,7 +348,7 @@ func (vp *scalewayProvider) setupInstances(log *logging.Logger, instances []inst
 				FleetMetadata:  instance.FleetMetadata,
 			}
 
-			if err := instance.ClusterInstance.InitialSetup(log, instance.CreateInstanceOptions, iso, vp); err != nil {
+			if err := instance.ClusterInstance.InitialSetup(ctx, log, instance.CreateInstanceOptions, iso, vp); err != nil {
 				errors <- maskAny(err)
 				return
 			}
@@ -16,17 +16,21 @@ package scaleway
 
 import (
 	"github.com/scaleway/scaleway-cli/pkg/api"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 )
 
 // Remove all instances of a cluster
-func (vp *scalewayProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
+func (vp *scalewayProvider) DeleteCluster(ctx context.Context, info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
 	servers, err := vp.getServers(info)
 	if err != nil {
 		return err
 	}
 	for _, s := range servers {
+		if err := ctx.Err(); err != nil {
+			return maskAny(err)
+		}
 		if err := vp.deleteServer(s, dnsProvider, info.Domain); err != nil {
 			return maskAny(err)
 		}
@@ -35,7 +39,7 @@ func (vp *scalewayProvider) DeleteCluster(info providers.ClusterInfo, dnsProvide
 	return nil
 }
 
-func (vp *scalewayProvider) DeleteInstance(info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
+func (vp *scalewayProvider) DeleteInstance(ctx context.Context, info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
 	fullName := info.String()
 	servers, err := vp.getServers(info.ClusterInfo)
 	if err != nil {
@@ -54,6 +58,31 @@ func (vp *scalewayProvider) DeleteInstance(info providers.ClusterInstanceInfo, d
 	return maskAny(NotFoundError)
 }
 
+// removeServer removes the server with given ID (and its volumes), without touching DNS or IP addresses.
+func (vp *scalewayProvider) removeServer(id string) error {
+	s, err := vp.client.GetServer(id)
+	if err != nil {
+		return maskAny(err)
+	}
+	if s.State == "running" {
+		vp.Logger.Infof("Terminating server %s", s.Name)
+		if err := vp.client.PostServerAction(id, "terminate"); err != nil {
+			return maskAny(err)
+		}
+		api.WaitForServerStopped(vp.client, id)
+		return nil
+	}
+	if err := vp.client.DeleteServer(id); err != nil {
+		return maskAny(err)
+	}
+	for _, v := range s.Volumes {
+		if err := vp.client.DeleteVolume(v.Identifier); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
 func (vp *scalewayProvider) deleteServer(s api.ScalewayServer, dnsProvider providers.DnsProvider, domain string) error {
 	if s.State == "running" {
 		vp.Logger.Infof("Stopping server %s", s.Name)
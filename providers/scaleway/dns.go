@@ -14,6 +14,10 @@
 
 package scaleway
 
-func (vp *scalewayProvider) ShowDomainRecords(domain string) error {
-	return maskAny(NotImplementedError)
+import (
+	"github.com/pulcy/quark/providers"
+)
+
+func (vp *scalewayProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	return nil, maskAny(NotImplementedError)
 }
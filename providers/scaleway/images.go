@@ -15,31 +15,26 @@
 package scaleway
 
 import (
-	"fmt"
-	"sort"
 	"strings"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *scalewayProvider) ShowImages() error {
+func (vp *scalewayProvider) ListImages() ([]providers.Image, error) {
 	// Load market place images
 	images, err := vp.client.GetImages()
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"ID | Name | Description | Categories",
-	}
+	result := []providers.Image{}
 	for _, r := range *images {
-		line := fmt.Sprintf("%s | %s | %s | %s", r.ID, r.Name, r.Description, strings.Join(r.Categories, " "))
-		lines = append(lines, line)
+		result = append(result, providers.Image{
+			ID:          r.ID,
+			Name:        r.Name,
+			Description: strings.TrimSpace(r.Description + " " + strings.Join(r.Categories, " ")),
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
@@ -35,6 +35,8 @@ func (vp *scalewayProvider) GetInstances(info providers.ClusterInfo) (providers.
 		list = append(list, instance)
 
 	}
+	// Servers without a public address are reached through one that has it
+	list.AssignJumpHosts()
 	return list, nil
 }
 
@@ -69,6 +71,7 @@ func (dp *scalewayProvider) clusterInstance(s api.ScalewayServer, bootstrapNeede
 		LoadBalancerIPv6: "",
 		ClusterDevice:    privateClusterDevice,
 		OS:               providers.OSNameUbuntu,
+		TypeID:           s.CommercialType,
 	}
 	if bootstrapNeeded {
 		info.UserName = "root"
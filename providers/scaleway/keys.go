@@ -15,31 +15,33 @@
 package scaleway
 
 import (
-	"fmt"
-	"sort"
+	"strings"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *scalewayProvider) ShowKeys() error {
+func (vp *scalewayProvider) ListKeys() ([]providers.SSHKey, error) {
 	user, err := vp.client.GetUser()
 	if err != nil {
-		return maskAny(err)
-	}
-	keys := user.SSHPublicKeys
-	lines := []string{
-		"Key | Fingerprint",
-	}
-	for _, r := range keys {
-		line := fmt.Sprintf("%v | %s | %s", r.Key, r.Fingerprint)
-		lines = append(lines, line)
+		return nil, maskAny(err)
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
+	result := []providers.SSHKey{}
+	for _, r := range user.SSHPublicKeys {
+		// Scaleway keys have no name, use the comment of the key instead
+		name := ""
+		if fields := strings.Fields(r.Key); len(fields) > 2 {
+			name = fields[len(fields)-1]
+		}
+		result = append(result, providers.SSHKey{
+			ID:          r.Fingerprint,
+			Name:        name,
+			Fingerprint: r.Fingerprint,
+			PublicKey:   r.Key,
+		})
+	}
 
-	return nil
+	return result, nil
 }
 
 // Search for an SSH key with given name and return its ID
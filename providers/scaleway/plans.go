@@ -14,6 +14,10 @@
 
 package scaleway
 
-func (vp *scalewayProvider) ShowInstanceTypes() error {
-	return maskAny(NotImplementedError)
+import (
+	"github.com/pulcy/quark/providers"
+)
+
+func (vp *scalewayProvider) ListInstanceTypes() ([]providers.InstanceType, error) {
+	return nil, maskAny(NotImplementedError)
 }
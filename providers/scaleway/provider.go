@@ -25,6 +25,43 @@ type scalewayProvider struct {
 	Logger       *logging.Logger
 	client       *api.ScalewayAPI
 	organization string
+	limiter      chan struct{} // Limits the number of concurrent API calls
+}
+
+const (
+	organizationCredential = "scaleway-organization"
+	tokenCredential        = "scaleway-token"
+)
+
+func init() {
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name: "scaleway",
+		Credentials: []providers.Credential{
+			{Name: organizationCredential, Usage: "Scaleway organization ID (default from ~/.scwrc)", Required: true},
+			{Name: tokenCredential, Usage: "Scaleway token (default from ~/.scwrc)", Required: true, Secret: true},
+		},
+		Capabilities: []providers.Capability{
+			providers.CapabilityCluster,
+			providers.CapabilityInstance,
+			providers.CapabilityRegions,
+			providers.CapabilityImages,
+			providers.CapabilityKeys,
+			providers.CapabilityPrivateOnly,
+		},
+		LoadRC: func() (providers.Credentials, error) {
+			rc, err := ReadRC()
+			if err != nil {
+				return nil, maskAny(err)
+			}
+			return providers.Credentials{
+				organizationCredential: rc.Organization,
+				tokenCredential:        rc.Token,
+			}, nil
+		},
+		NewCloudProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.CloudProvider, error) {
+			return NewProvider(log, credentials.Get(organizationCredential), credentials.Get(tokenCredential))
+		},
+	})
 }
 
 // NewProvider creates a new Scaleway provider implementation
@@ -37,5 +74,6 @@ func NewProvider(logger *logging.Logger, organization, token string) (providers.
 		Logger:       logger,
 		client:       client,
 		organization: organization,
+		limiter:      retryPolicy.NewLimiter(),
 	}, nil
 }
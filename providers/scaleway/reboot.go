@@ -15,15 +15,19 @@
 package scaleway
 
 import (
+	"golang.org/x/net/context"
+
 	"github.com/pulcy/quark/providers"
 )
 
 // Perform a reboot of the given instance
-func (vp *scalewayProvider) RebootInstance(instance providers.ClusterInstance) error {
-	if err := instance.Sync(vp.Logger); err != nil {
+func (vp *scalewayProvider) RebootInstance(ctx context.Context, instance providers.ClusterInstance) error {
+	if err := instance.Sync(ctx, vp.Logger); err != nil {
 		return maskAny(err)
 	}
-	if err := vp.client.PostServerAction(instance.ID, "reboot"); err != nil {
+	if err := vp.retry(ctx, false, func() error {
+		return vp.client.PostServerAction(instance.ID, "reboot")
+	}); err != nil {
 		vp.Logger.Errorf("reboot failed: %#v", err)
 		return maskAny(err)
 	}
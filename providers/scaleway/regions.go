@@ -15,26 +15,12 @@
 package scaleway
 
 import (
-	"fmt"
-	"sort"
-
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *scalewayProvider) ShowRegions() error {
-	regions := []string{"paris"}
-
-	lines := []string{
-		"ID",
-	}
-	for _, r := range regions {
-		line := fmt.Sprintf("%s", r)
-		lines = append(lines, line)
+func (vp *scalewayProvider) ListRegions() ([]providers.Region, error) {
+	regions := []providers.Region{
+		{ID: regionParis, Name: "Paris", Available: true},
 	}
-
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return regions, nil
 }
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaleway
+
+import (
+	"net/http"
+
+	"github.com/scaleway/scaleway-cli/pkg/api"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	// retryPolicy is used for all API calls.
+	// The Scaleway API client does not accept a custom HTTP client, so calls are retried
+	// here instead of in an HTTP transport.
+	retryPolicy = providers.DefaultRetryPolicy
+)
+
+// retry calls fn until it succeeds or fails with an error that cannot be retried.
+// Calls rejected because of rate limits are always retried, other failures only when idempotent is set.
+// The number of concurrent calls is limited by the retry policy.
+func (vp *scalewayProvider) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := vp.limited(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		if retry >= retryPolicy.MaxRetries || !isRetryable(err, idempotent) {
+			return maskAny(err)
+		}
+		delay := retryPolicy.Backoff(retry)
+		vp.Logger.Debugf("Scaleway API call failed (%v), retrying in %s", err, delay)
+		if err := providers.Sleep(ctx, delay); err != nil {
+			return maskAny(err)
+		}
+	}
+}
+
+// limited calls fn, waiting until the concurrency limit allows it.
+func (vp *scalewayProvider) limited(ctx context.Context, fn func() error) error {
+	if vp.limiter != nil {
+		select {
+		case vp.limiter <- struct{}{}:
+		case <-ctx.Done():
+			return maskAny(ctx.Err())
+		}
+		defer func() { <-vp.limiter }()
+	}
+	return fn()
+}
+
+// isRetryable returns true if a call that failed with given error can be tried again.
+func isRetryable(err error, idempotent bool) bool {
+	if providers.IsCanceled(err) {
+		return false
+	}
+	if apiErr, ok := err.(api.ScalewayAPIError); ok {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		default:
+			return false
+		}
+	}
+	// Network errors & 5xx responses (these are not returned as ScalewayAPIError)
+	return idempotent
+}
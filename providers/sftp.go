@@ -0,0 +1,158 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpServerCommand starts the SFTP server of the instance as root.
+// The subsystem configured in sshd runs as the login user, so it cannot be used with sudo.
+const sftpServerCommand = `sudo sh -c 'for p in /usr/lib/openssh/sftp-server /usr/lib64/misc/sftp-server /usr/lib/misc/sftp-server /usr/libexec/openssh/sftp-server /usr/lib/ssh/sftp-server; do [ -x $p ] && exec $p; done; echo "sftp-server not found" >&2; exit 127'`
+
+// sftpFileSystem implements RemoteFileSystem using an SFTP client.
+type sftpFileSystem struct {
+	client *sftp.Client
+	closer io.Closer
+}
+
+// newSFTPFileSystem initializes an SFTP session with the server reading from w and writing to r.
+// The given closer is closed when the file system is closed.
+func newSFTPFileSystem(r io.Reader, w io.WriteCloser, closer io.Closer) (*sftpFileSystem, error) {
+	client, err := sftp.NewClientPipe(r, w)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return &sftpFileSystem{client: client, closer: closer}, nil
+}
+
+// Stat returns information about the given path, following symbolic links.
+func (fs *sftpFileSystem) Stat(path string) (os.FileInfo, error) {
+	info, err := fs.client.Stat(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return sftpFileInfo{info}, nil
+}
+
+// ReadDir returns information about all entries of the given directory, except "." and "..".
+// Symbolic links are not followed.
+func (fs *sftpFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, err := fs.client.ReadDir(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, sftpFileInfo{entry})
+	}
+	return result, nil
+}
+
+// Open opens the given file for reading.
+func (fs *sftpFileSystem) Open(path string) (io.ReadCloser, error) {
+	f, err := fs.client.Open(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return f, nil
+}
+
+// Create creates or truncates the given file for writing.
+// The given mode is only used when the file does not exist yet.
+func (fs *sftpFileSystem) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	_, err := fs.client.Lstat(path)
+	isNew := os.IsNotExist(err)
+	f, err := fs.client.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if isNew {
+		// The server creates the file with default permissions, restrict them before writing any content
+		if err := f.Chmod(unixMode(mode)); err != nil {
+			f.Close()
+			return nil, maskAny(err)
+		}
+	}
+	return f, nil
+}
+
+// Mkdir creates the given directory.
+func (fs *sftpFileSystem) Mkdir(path string, mode os.FileMode) error {
+	if err := fs.client.Mkdir(path); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(fs.Chmod(path, mode))
+}
+
+// Chmod changes the permissions of the given path.
+func (fs *sftpFileSystem) Chmod(path string, mode os.FileMode) error {
+	return maskAny(fs.client.Chmod(path, unixMode(mode)))
+}
+
+// Chown changes the owner and group of the given path.
+func (fs *sftpFileSystem) Chown(path string, uid, gid int) error {
+	return maskAny(fs.client.Chown(path, uid, gid))
+}
+
+// Chtimes changes the access and modification time of the given path.
+func (fs *sftpFileSystem) Chtimes(path string, atime, mtime time.Time) error {
+	return maskAny(fs.client.Chtimes(path, atime, mtime))
+}
+
+// Close ends the SFTP session.
+func (fs *sftpFileSystem) Close() error {
+	fs.client.Close()
+	return maskAny(fs.closer.Close())
+}
+
+// sftpFileInfo reports the owner of a remote file as FileOwner.
+type sftpFileInfo struct {
+	os.FileInfo
+}
+
+func (fi sftpFileInfo) Sys() interface{} {
+	if stat, ok := fi.FileInfo.Sys().(*sftp.FileStat); ok {
+		return FileOwner{UID: int(stat.UID), GID: int(stat.GID)}
+	}
+	return FileOwner{}
+}
+
+// Unix setuid, setgid & sticky mode bits, as used by SFTP.
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// unixMode converts the permission bits of an os.FileMode into the unix mode used by SFTP.
+// The SFTP client passes an os.FileMode as is, which loses the setuid, setgid & sticky bits.
+func unixMode(mode os.FileMode) os.FileMode {
+	result := mode.Perm()
+	if mode&os.ModeSetuid != 0 {
+		result |= unixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		result |= unixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		result |= unixSticky
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+// serveTestSFTP serves the local file system over the given channel.
+func serveTestSFTP(channel ssh.Channel) {
+	server, err := sftp.NewServer(channel)
+	if err != nil {
+		return
+	}
+	server.Serve()
+}
+
+func TestSSHExecutorCopy(t *testing.T) {
+	executor, _, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+	previous := providers.SetRemoteExecutor(executor)
+	defer providers.SetRemoteExecutor(previous)
+	ctx := context.Background()
+
+	tmp, err := ioutil.TempDir("", "quark-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	remote := filepath.Join(tmp, "remote")
+	local := filepath.Join(tmp, "local")
+	for _, dir := range []string{filepath.Join(src, "sub"), remote, local} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Cannot create %s: %v", dir, err)
+		}
+	}
+	// The script is larger than a single SFTP read or write
+	script := "#!/bin/sh\n" + strings.Repeat("echo hello\n", 10000)
+	mtime := time.Unix(1460000000, 0)
+	if err := ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0640); err != nil {
+		t.Fatalf("Cannot write a.txt: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime); err != nil {
+		t.Fatalf("Cannot set time of a.txt: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "run.sh"), []byte(script), 0755); err != nil {
+		t.Fatalf("Cannot write run.sh: %v", err)
+	}
+	// Links to files are copied as files, links to directories are skipped (this one is a cycle)
+	if err := os.Symlink("run.sh", filepath.Join(src, "sub", "link.sh")); err != nil {
+		t.Fatalf("Cannot create link.sh: %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(src, "sub", "parent")); err != nil {
+		t.Fatalf("Cannot create parent link: %v", err)
+	}
+
+	expectFile := func(p, content string, mode os.FileMode) {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatalf("Cannot read %s: %v", p, err)
+		}
+		if string(data) != content {
+			t.Errorf("Unexpected content of %s (%d bytes)", p, len(data))
+		}
+		if info, err := os.Stat(p); err != nil || info.Mode().Perm() != mode {
+			t.Errorf("Expected mode %v for %s, got %v (%v)", mode, p, info.Mode(), err)
+		}
+	}
+
+	if err := testSSHInstance.CopyTo(ctx, log, src, remote, providers.CopyOptions{}); err == nil {
+		t.Errorf("Expected copy of a directory without recursion to fail")
+	}
+
+	// Copying into an existing directory creates the source directory in it
+	if err := testSSHInstance.CopyTo(ctx, log, src, remote, providers.CopyOptions{Recursive: true, Preserve: true}); err != nil {
+		t.Fatalf("CopyTo failed: %v", err)
+	}
+	expectFile(filepath.Join(remote, "src", "a.txt"), "hello", 0640)
+	expectFile(filepath.Join(remote, "src", "sub", "run.sh"), script, 0755)
+	expectFile(filepath.Join(remote, "src", "sub", "link.sh"), script, 0755)
+	if _, err := os.Lstat(filepath.Join(remote, "src", "sub", "parent")); !os.IsNotExist(err) {
+		t.Errorf("Expected link to directory to be skipped, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(remote, "src", "a.txt")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected modification time to be preserved, got %v (%v)", info.ModTime(), err)
+	}
+
+	if err := testSSHInstance.CopyFrom(ctx, log, filepath.Join(remote, "src", "sub"), local, providers.CopyOptions{Recursive: true}); err != nil {
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+	expectFile(filepath.Join(local, "sub", "run.sh"), script, 0755)
+
+	// Same for links on the instance
+	if err := os.Symlink("..", filepath.Join(remote, "src", "sub", "parent")); err != nil {
+		t.Fatalf("Cannot create remote parent link: %v", err)
+	}
+	if err := testSSHInstance.CopyFrom(ctx, log, filepath.Join(remote, "src", "sub"), filepath.Join(local, "sub2"), providers.CopyOptions{Recursive: true}); err != nil {
+		t.Fatalf("CopyFrom with link to directory failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(local, "sub2", "parent")); !os.IsNotExist(err) {
+		t.Errorf("Expected remote link to directory to be skipped, got %v", err)
+	}
+
+	// Copying a single file to a new name
+	if err := testSSHInstance.CopyFrom(ctx, log, filepath.Join(remote, "src", "a.txt"), filepath.Join(local, "b.txt"), providers.CopyOptions{}); err != nil {
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+	expectFile(filepath.Join(local, "b.txt"), "hello", 0640)
+
+	err = testSSHInstance.CopyFrom(ctx, log, filepath.Join(remote, "missing"), local, providers.CopyOptions{})
+	if !providers.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
@@ -0,0 +1,722 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/mitchellh/go-homedir"
+	"github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/net/context"
+)
+
+const (
+	sshPort                  = "22"
+	defaultSSHConnectTimeout = 30 * time.Second
+)
+
+var (
+	errHostKeyFetched = errgo.New("host key fetched")
+
+	// defaultSSHKeyFiles are used when no key files are configured (if they exist)
+	defaultSSHKeyFiles = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_dsa"}
+)
+
+// SSHOptions configures the SSH connections to instances.
+type SSHOptions struct {
+	KeyFiles       []string      // Private key files used to authenticate (default ~/.ssh/id_rsa, ~/.ssh/id_ecdsa, ~/.ssh/id_dsa)
+	ForwardAgent   bool          // If set, the local SSH agent is forwarded to the instances
+	ConnectTimeout time.Duration // Maximum time to establish a connection (default 30s)
+	Port           string        // Port of the SSH server on the instances (default 22)
+	KnownHosts     *KnownHosts   // Pinned host keys of the instances (default kept in memory only)
+	JumpHost       string        // Host ([user@]host[:port]) through which instances without a public address are reached
+}
+
+// SSHExecutor is a RemoteExecutor that runs commands over SSH.
+// It keeps one connection per instance open, which is shared by all commands that
+// are executed on that instance (each in its own session).
+// Instances without a public address are reached through a jump host.
+// It authenticates with the local SSH agent (SSH_AUTH_SOCK) and the configured key files.
+// The host key of an instance is pinned the first time it is contacted, after which
+// connections presenting a different key are refused.
+type SSHExecutor struct {
+	options SSHOptions
+
+	initOnce sync.Once
+	auth     []ssh.AuthMethod
+	initErr  error
+
+	mutex   sync.Mutex
+	clients map[string]*ssh.Client // Open connections, keyed by their route (user@address>user@address...)
+}
+
+// NewSSHExecutor creates a new SSHExecutor with given options.
+// Keys are loaded when the first connection is made.
+func NewSSHExecutor(options SSHOptions) *SSHExecutor {
+	if options.ConnectTimeout == 0 {
+		options.ConnectTimeout = defaultSSHConnectTimeout
+	}
+	if options.Port == "" {
+		options.Port = sshPort
+	}
+	if options.KnownHosts == nil {
+		options.KnownHosts = NewKnownHosts("")
+	}
+	return &SSHExecutor{
+		options: options,
+		clients: make(map[string]*ssh.Client),
+	}
+}
+
+// Run implements RemoteExecutor.
+func (e *SSHExecutor) Run(ctx context.Context, log *logging.Logger, i ClusterInstance, command, stdin string, quiet bool) (string, error) {
+	stdout, _, err := e.run(ctx, log, i, command, stdin, quiet)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	return stdout, nil
+}
+
+// Output implements RemoteExecutor.
+func (e *SSHExecutor) Output(ctx context.Context, log *logging.Logger, i ClusterInstance, command string) (string, string, error) {
+	stdout, stderr, err := e.run(ctx, log, i, command, "", true)
+	if err != nil {
+		return stdout, stderr, maskAny(err)
+	}
+	return stdout, stderr, nil
+}
+
+// run executes the given command on the given instance and returns its standard output
+// (without trailing newline) and standard error.
+func (e *SSHExecutor) run(ctx context.Context, log *logging.Logger, i ClusterInstance, command, stdin string, quiet bool) (string, string, error) {
+	route, err := e.route(i)
+	if err != nil {
+		return "", "", maskAny(err)
+	}
+	key := routeKey(route)
+	session, client, err := e.newSession(ctx, log, route)
+	if err != nil {
+		if !quiet {
+			log.Errorf("SSH to %s failed: %v", i, err)
+		}
+		return "", "", maskAny(err)
+	}
+	defer session.Close()
+
+	if e.options.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			log.Debugf("Agent forwarding to %s failed: %v", i, err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if stdin != "" {
+		session.Stdin = strings.NewReader(stdin)
+	}
+
+	if err := session.Start(command); err != nil {
+		e.drop(key, client)
+		return "", "", maskAny(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Only terminate this command, other sessions may share the connection.
+		// Servers that do not support signals terminate the command when its session is closed.
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Debugf("Cannot signal command on %s: %v", i, err)
+		}
+		session.Close()
+		return "", "", maskAny(ctx.Err())
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			cmdErr := &RemoteCommandError{
+				Host:       i.Name,
+				Command:    command,
+				ExitStatus: exitErr.ExitStatus(),
+				Stdout:     stdout.String(),
+				Stderr:     stderr.String(),
+			}
+			if !quiet {
+				log.Errorf("SSH failed: %s", cmdErr)
+			}
+			return cmdErr.Stdout, cmdErr.Stderr, maskAny(cmdErr)
+		}
+		// The connection is lost (e.g. because of a reboot)
+		e.drop(key, client)
+		if !quiet {
+			log.Errorf("SSH to %s failed: %v", i, err)
+		}
+		return "", stderr.String(), errgo.NoteMask(err, stderr.String())
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), stderr.String(), nil
+}
+
+// Shell runs an interactive session on the given instance, using the given standard input & output.
+// If the command is empty, a login shell is started.
+// When stdin is a terminal, a pseudo terminal is requested and stdin is put in raw mode for the
+// duration of the session.
+func (e *SSHExecutor) Shell(ctx context.Context, log *logging.Logger, i ClusterInstance, command string, stdin *os.File, stdout, stderr io.Writer) error {
+	route, err := e.route(i)
+	if err != nil {
+		return maskAny(err)
+	}
+	session, _, err := e.newSession(ctx, log, route)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer session.Close()
+
+	if e.options.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			log.Debugf("Agent forwarding to %s failed: %v", i, err)
+		}
+	}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if fd := int(stdin.Fd()); terminal.IsTerminal(fd) {
+		width, height, err := terminal.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "xterm"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(term, height, width, modes); err != nil {
+			return maskAny(err)
+		}
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return maskAny(err)
+		}
+		defer terminal.Restore(fd, state)
+	}
+
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := session.Wait(); err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return maskAny(&RemoteCommandError{
+				Host:       i.Name,
+				Command:    command,
+				ExitStatus: exitErr.ExitStatus(),
+			})
+		}
+		return maskAny(err)
+	}
+	return nil
+}
+
+// OpenFileSystem implements RemoteExecutor using SFTP.
+// Without sudo the sftp subsystem of the SSH server is used, with sudo the SFTP server is started as root.
+func (e *SSHExecutor) OpenFileSystem(ctx context.Context, log *logging.Logger, i ClusterInstance, sudo bool) (RemoteFileSystem, error) {
+	route, err := e.route(i)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	session, _, err := e.newSession(ctx, log, route)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, maskAny(err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, maskAny(err)
+	}
+	if sudo {
+		err = session.Start(sftpServerCommand)
+	} else {
+		err = session.RequestSubsystem("sftp")
+	}
+	if err != nil {
+		session.Close()
+		return nil, maskAny(err)
+	}
+
+	// Closing the session aborts all pending requests
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+	fs, err := newSFTPFileSystem(r, w, closerFunc(func() error {
+		close(done)
+		return session.Close()
+	}))
+	if err != nil {
+		close(done)
+		session.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, maskAny(ctxErr)
+		}
+		return nil, maskAny(fmt.Errorf("cannot start SFTP session on %s: %v", i, err))
+	}
+	return fs, nil
+}
+
+// closerFunc implements io.Closer with a function.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// Close closes all open connections.
+func (e *SSHExecutor) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for key, client := range e.clients {
+		client.Close()
+		delete(e.clients, key)
+	}
+	return nil
+}
+
+// route returns the hosts to connect through to reach the given instance, ending with the instance itself.
+// Instances without a public address are reached on their private address, through the configured
+// jump host or else through the jump host of the instance.
+func (e *SSHExecutor) route(i ClusterInstance) ([]sshHost, error) {
+	target := sshHost{name: i.Name, user: i.User()}
+	if address, err := sshAddress(i, e.options.Port); err == nil {
+		target.address = address
+		return []sshHost{target}, nil
+	}
+	privateIP := i.PrivateIP
+	if privateIP == "" {
+		privateIP = i.ClusterIP
+	}
+	if privateIP == "" {
+		return nil, maskAny(fmt.Errorf("don't have any address to communicate with instance %s", i.Name))
+	}
+	target.address = net.JoinHostPort(privateIP, e.options.Port)
+	if e.options.JumpHost != "" {
+		jump, err := parseJumpHost(e.options.JumpHost)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return []sshHost{jump, target}, nil
+	}
+	if i.JumpHost != nil {
+		route, err := e.route(*i.JumpHost)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return append(route, target), nil
+	}
+	return nil, maskAny(fmt.Errorf("instance %s has no public address, configure a jump host to reach it", i.Name))
+}
+
+// newSession opens a new session on the connection to the last host of the given route.
+// When the connection is broken, it is re-established once.
+func (e *SSHExecutor) newSession(ctx context.Context, log *logging.Logger, route []sshHost) (*ssh.Session, *ssh.Client, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		client, err := e.client(ctx, log, route)
+		if err != nil {
+			return nil, nil, maskAny(err)
+		}
+		session, err := client.NewSession()
+		if err == nil {
+			return session, client, nil
+		}
+		// The connection is broken (e.g. because the instance rebooted), reconnect
+		e.drop(routeKey(route), client)
+		lastErr = err
+	}
+	return nil, nil, maskAny(lastErr)
+}
+
+// client returns the open connection to the last host of the given route, or creates a new one.
+func (e *SSHExecutor) client(ctx context.Context, log *logging.Logger, route []sshHost) (*ssh.Client, error) {
+	key := routeKey(route)
+	e.mutex.Lock()
+	client, ok := e.clients[key]
+	e.mutex.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	client, err := e.dial(ctx, log, route)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if existing, ok := e.clients[key]; ok {
+		// Another command connected in the meantime
+		client.Close()
+		return existing, nil
+	}
+	e.clients[key] = client
+	return client, nil
+}
+
+// drop closes the given connection and removes it from the open connections.
+func (e *SSHExecutor) drop(key string, client *ssh.Client) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.clients[key] == client {
+		delete(e.clients, key)
+	}
+	client.Close()
+}
+
+// dial creates a new connection to the last host of the given route.
+func (e *SSHExecutor) dial(ctx context.Context, log *logging.Logger, route []sshHost) (*ssh.Client, error) {
+	e.initOnce.Do(func() {
+		e.auth, e.initErr = e.authMethods()
+	})
+	if e.initErr != nil {
+		return nil, maskAny(e.initErr)
+	}
+
+	target := route[len(route)-1]
+	pinned, err := e.options.KnownHosts.Lookup(target.name)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	conn, err := e.connect(ctx, log, route)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	// The handshake does not preserve the error of the host key callback
+	var hostKeyErr error
+	config := &ssh.ClientConfig{
+		User: target.user,
+		Auth: e.auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyErr = e.checkHostKey(log, target.name, target.address, key)
+			return hostKeyErr
+		},
+	}
+	// Make the server present a key of the pinned type(s), it may have others
+	for _, key := range pinned {
+		config.HostKeyAlgorithms = append(config.HostKeyAlgorithms, key.Type())
+	}
+	client, err := e.handshake(ctx, conn, target.address, config)
+	if hostKeyErr != nil {
+		return nil, maskAny(hostKeyErr)
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+
+	if e.options.ForwardAgent {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if err := agent.ForwardToRemote(client, sock); err != nil {
+				client.Close()
+				return nil, maskAny(err)
+			}
+		}
+	}
+	return client, nil
+}
+
+// FetchHostKey connects to the given instance and returns the host key it presents,
+// without verifying or pinning it.
+func (e *SSHExecutor) FetchHostKey(ctx context.Context, log *logging.Logger, i ClusterInstance) (ssh.PublicKey, error) {
+	route, err := e.route(i)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(route) > 1 {
+		// Authentication is needed for the jump hosts
+		e.initOnce.Do(func() {
+			e.auth, e.initErr = e.authMethods()
+		})
+		if e.initErr != nil {
+			return nil, maskAny(e.initErr)
+		}
+	}
+	conn, err := e.connect(ctx, log, route)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: i.User(),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// Abort the handshake, we do not want to log in
+			return errHostKeyFetched
+		},
+	}
+	if _, err := e.handshake(ctx, conn, route[len(route)-1].address, config); hostKey == nil {
+		return nil, maskAny(err)
+	}
+	return hostKey, nil
+}
+
+// PinHostKey replaces the pinned host key of the given instance with the given key.
+// The address is only stored with the key when the instance can be routed to already.
+func (e *SSHExecutor) PinHostKey(i ClusterInstance, key ssh.PublicKey) error {
+	address := ""
+	if route, err := e.route(i); err == nil {
+		address = knownHostsAddress(route[len(route)-1].address)
+	}
+	if err := e.options.KnownHosts.Pin(i.Name, address, key); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// connect opens a connection to the last host of the given route.
+// When the route contains jump hosts, the connection is tunneled through the connection to the previous host.
+func (e *SSHExecutor) connect(ctx context.Context, log *logging.Logger, route []sshHost) (net.Conn, error) {
+	target := route[len(route)-1]
+	if len(route) == 1 {
+		dialer := net.Dialer{
+			Timeout: e.options.ConnectTimeout,
+			Cancel:  ctx.Done(),
+		}
+		conn, err := dialer.Dial("tcp", target.address)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return conn, nil
+	}
+
+	jumpRoute := route[:len(route)-1]
+	jump, err := e.client(ctx, log, jumpRoute)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	conn, err := jump.Dial("tcp", target.address)
+	if err != nil {
+		// The connection to the jump host may be broken, make sure the next attempt reconnects
+		e.drop(routeKey(jumpRoute), jump)
+		return nil, maskAny(errgo.Notef(err, "cannot reach %s through %s", target.address, jumpRoute[len(jumpRoute)-1].address))
+	}
+	return conn, nil
+}
+
+// handshake performs the SSH handshake on the given connection.
+// It gives up when the given context is done or when the connect timeout has expired.
+func (e *SSHExecutor) handshake(ctx context.Context, conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	type result struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{client: ssh.NewClient(c, chans, reqs)}
+	}()
+
+	var err error
+	select {
+	case r := <-done:
+		if r.err == nil {
+			return r.client, nil
+		}
+		conn.Close()
+		return nil, maskAny(r.err)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(e.options.ConnectTimeout):
+		err = fmt.Errorf("SSH handshake with %s timed out", address)
+	}
+	// Closing the connection aborts the handshake
+	conn.Close()
+	if r := <-done; r.client != nil {
+		r.client.Close()
+	}
+	return nil, maskAny(err)
+}
+
+// checkHostKey verifies the given host key of the instance with given name against its pinned keys.
+// When the instance has no pinned key yet, the given key is pinned (trust on first use).
+func (e *SSHExecutor) checkHostKey(log *logging.Logger, name, address string, key ssh.PublicKey) error {
+	knownHosts := e.options.KnownHosts
+	pinned, err := knownHosts.Lookup(name)
+	if err != nil {
+		return maskAny(err)
+	}
+	if len(pinned) == 0 {
+		if err := knownHosts.Pin(name, knownHostsAddress(address), key); err != nil {
+			return maskAny(err)
+		}
+		log.Infof("Pinned host key of %s: %s", name, HostKeyFingerprint(key))
+		return nil
+	}
+	for _, p := range pinned {
+		if bytes.Equal(p.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+	return maskAny(errgo.WithCausef(nil, HostKeyMismatchError,
+		"host key of %s has changed to %s, if the instance was reinstalled use 'quark instance rekey'", name, HostKeyFingerprint(key)))
+}
+
+// authMethods returns the methods used to authenticate: the SSH agent (if available)
+// followed by the configured key files.
+func (e *SSHExecutor) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	keyFiles := e.options.KeyFiles
+	explicit := len(keyFiles) > 0
+	if !explicit {
+		keyFiles = defaultSSHKeyFiles
+	}
+	var signers []ssh.Signer
+	for _, path := range keyFiles {
+		signer, err := loadSSHKey(path)
+		if err != nil {
+			if !explicit {
+				// Default keys are optional
+				continue
+			}
+			return nil, maskAny(err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, maskAny(fmt.Errorf("no SSH keys found, start an SSH agent or specify a key file"))
+	}
+	return methods, nil
+}
+
+// loadSSHKey reads an (unencrypted) private key from the given file.
+func loadSSHKey(path string) (ssh.Signer, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	signer, err := ssh.ParsePrivateKey(raw)
+	if err != nil {
+		return nil, maskAny(fmt.Errorf("cannot load SSH key %s: %v", path, err))
+	}
+	return signer, nil
+}
+
+// sshHost is a host that the executor connects to.
+type sshHost struct {
+	name    string // Name under which the host key is pinned
+	user    string // Account name used to log in
+	address string // host:port
+}
+
+// routeKey returns the key of the connection to the last host of the given route.
+func routeKey(route []sshHost) string {
+	keys := make([]string, 0, len(route))
+	for _, h := range route {
+		keys = append(keys, h.user+"@"+h.address)
+	}
+	return strings.Join(keys, ">")
+}
+
+// parseJumpHost parses a jump host specified as [user@]host[:port].
+// The user defaults to the local user.
+func parseJumpHost(spec string) (sshHost, error) {
+	h := sshHost{user: os.Getenv("USER")}
+	if idx := strings.LastIndex(spec, "@"); idx >= 0 {
+		h.user = spec[:idx]
+		spec = spec[idx+1:]
+	}
+	host, port, err := net.SplitHostPort(spec)
+	if err != nil {
+		host, port = spec, sshPort
+	}
+	if host == "" || h.user == "" {
+		return sshHost{}, maskAny(fmt.Errorf("invalid jump host '%s', expected [user@]host[:port]", spec))
+	}
+	h.name = host
+	h.address = net.JoinHostPort(host, port)
+	return h, nil
+}
+
+// knownHostsAddress returns the host pattern of the given host:port, as used in known_hosts files.
+func knownHostsAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	if port == sshPort {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// sshAddress returns the host:port used to connect to the given instance.
+func sshAddress(i ClusterInstance, port string) (string, error) {
+	hostAddress := i.LoadBalancerIPv4
+	if hostAddress == "" {
+		hostAddress = i.LoadBalancerIPv6
+	}
+	if hostAddress == "" {
+		return "", maskAny(fmt.Errorf("don't have any address to communicate with instance %s", i.Name))
+	}
+	return net.JoinHostPort(hostAddress, port), nil
+}
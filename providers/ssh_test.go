@@ -0,0 +1,524 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/juju/errgo"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+// testSSHServer is a minimal SSH server that "executes" commands of the form
+// `echo <text>` and `exit <status>`, and counts the number of accepted connections.
+// Its sftp subsystem serves the local file system.
+// It forwards TCP connections (direct-tcpip), so it can be used as a jump host.
+type testSSHServer struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	connections int32
+}
+
+// newTestSSHServer starts an SSH server on localhost that accepts the given client key.
+// It presents a generated RSA host key, next to the given host keys.
+func newTestSSHServer(t *testing.T, clientKey ssh.PublicKey, hostKeys ...ssh.Signer) *testSSHServer {
+	hostKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Cannot generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("Cannot create host signer: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+	for _, signer := range hostKeys {
+		config.AddHostKey(signer)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	s := &testSSHServer{listener: listener, config: config}
+	go s.serve()
+	return s
+}
+
+func (s *testSSHServer) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.connections, 1)
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				if newChannel.ChannelType() == "direct-tcpip" {
+					go forwardTestChannel(newChannel)
+					continue
+				}
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go handleTestSession(channel, requests)
+			}
+		}()
+	}
+}
+
+func handleTestSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type == "subsystem" && string(req.Payload[4:]) == "sftp" {
+			req.Reply(true, nil)
+			serveTestSFTP(channel)
+			return
+		}
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		command := string(req.Payload[4:])
+		status := 0
+		switch {
+		case strings.HasPrefix(command, "echo "):
+			fmt.Fprintln(channel, strings.TrimPrefix(command, "echo "))
+		case strings.HasPrefix(command, "exit "):
+			status, _ = strconv.Atoi(strings.TrimPrefix(command, "exit "))
+			fmt.Fprintln(channel.Stderr(), "failed")
+		case command == "warn":
+			fmt.Fprintln(channel, "done")
+			fmt.Fprintln(channel.Stderr(), "deprecated")
+		case command == "hang":
+			time.Sleep(time.Second * 10)
+		case command == "cat":
+			data, _ := ioutil.ReadAll(channel)
+			channel.Write(data)
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(status))
+		channel.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+// forwardTestChannel connects the given direct-tcpip channel to the requested address.
+func forwardTestChannel(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+// writeTestKey generates a client key, writes it to a temporary file and returns the path and public key.
+func writeTestKey(t *testing.T) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Cannot generate client key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Cannot create client signer: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return writeTempFile(t, string(data)), signer.PublicKey()
+}
+
+// newTestSSHExecutor starts a test server and creates an executor that connects to it.
+// If knownHosts is nil, host keys are pinned in memory.
+func newTestSSHExecutor(t *testing.T, knownHosts *providers.KnownHosts) (*providers.SSHExecutor, *testSSHServer, func()) {
+	keyPath, publicKey := writeTestKey(t)
+	server := newTestSSHServer(t, publicKey)
+	executor := providers.NewSSHExecutor(providers.SSHOptions{
+		KeyFiles:   []string{keyPath},
+		Port:       server.Port(),
+		KnownHosts: knownHosts,
+	})
+	return executor, server, func() {
+		executor.Close()
+		server.Close()
+		os.Remove(keyPath)
+	}
+}
+
+var testSSHInstance = providers.ClusterInstance{Name: "test.c1.example.com", LoadBalancerIPv4: "127.0.0.1"}
+
+func TestSSHExecutorReusesConnection(t *testing.T) {
+	executor, server, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		out, err := executor.Run(context.Background(), log, testSSHInstance, fmt.Sprintf("echo hello %d", i), "", false)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if expected := fmt.Sprintf("hello %d", i); out != expected {
+			t.Errorf("Expected output '%s', got '%s'", expected, out)
+		}
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 1 {
+		t.Errorf("Expected 1 connection, got %d", n)
+	}
+}
+
+func TestSSHExecutorStdin(t *testing.T) {
+	executor, _, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	out, err := executor.Run(context.Background(), log, testSSHInstance, "cat", "some input", false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "some input" {
+		t.Errorf("Expected output 'some input', got '%s'", out)
+	}
+}
+
+func TestSSHExecutorExitStatus(t *testing.T) {
+	executor, _, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	_, err := executor.Run(context.Background(), log, testSSHInstance, "exit 3", "", true)
+	cmdErr, ok := errgo.Cause(err).(*providers.RemoteCommandError)
+	if !ok {
+		t.Fatalf("Expected RemoteCommandError, got %#v", err)
+	}
+	if cmdErr.ExitStatus != 3 {
+		t.Errorf("Expected exit status 3, got %d", cmdErr.ExitStatus)
+	}
+	if strings.TrimSpace(cmdErr.Stderr) != "failed" {
+		t.Errorf("Expected stderr 'failed', got '%s'", cmdErr.Stderr)
+	}
+}
+
+func TestSSHExecutorOutput(t *testing.T) {
+	executor, _, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	stdout, stderr, err := executor.Output(context.Background(), log, testSSHInstance, "warn")
+	if err != nil {
+		t.Fatalf("Output failed: %v", err)
+	}
+	if stdout != "done" {
+		t.Errorf("Expected stdout 'done', got '%s'", stdout)
+	}
+	if strings.TrimSpace(stderr) != "deprecated" {
+		t.Errorf("Expected stderr 'deprecated', got '%s'", stderr)
+	}
+
+	_, stderr, err = executor.Output(context.Background(), log, testSSHInstance, "exit 2")
+	if _, ok := errgo.Cause(err).(*providers.RemoteCommandError); !ok {
+		t.Fatalf("Expected RemoteCommandError, got %#v", err)
+	}
+	if strings.TrimSpace(stderr) != "failed" {
+		t.Errorf("Expected stderr 'failed', got '%s'", stderr)
+	}
+}
+
+func TestSSHExecutorReconnects(t *testing.T) {
+	executor, server, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	if _, err := executor.Run(context.Background(), log, testSSHInstance, "echo one", "", false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Simulate a reboot of the instance
+	executor.Close()
+	if _, err := executor.Run(context.Background(), log, testSSHInstance, "echo two", "", false); err != nil {
+		t.Fatalf("Run after reconnect failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 2 {
+		t.Errorf("Expected 2 connections, got %d", n)
+	}
+}
+
+func TestSSHExecutorCanceled(t *testing.T) {
+	executor, server, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err := executor.Run(ctx, log, testSSHInstance, "hang", "", true)
+	if !providers.IsCanceled(err) {
+		t.Fatalf("Expected Run to be canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("Expected Run to stop quickly, took %s", elapsed)
+	}
+
+	// Other sessions keep using the connection
+	if out, err := executor.Run(context.Background(), log, testSSHInstance, "echo after", "", false); err != nil || out != "after" {
+		t.Fatalf("Run after cancel failed: %v (%s)", err, out)
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 1 {
+		t.Errorf("Expected the connection to be kept, got %d connections", n)
+	}
+}
+
+func TestSSHExecutorPinsHostKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quark-known-hosts")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	knownHosts := providers.NewKnownHosts(dir)
+
+	executor, server, cleanup := newTestSSHExecutor(t, knownHosts)
+	defer cleanup()
+	if _, err := executor.Run(context.Background(), log, testSSHInstance, "echo hello", "", false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	content, err := ioutil.ReadFile(knownHosts.Path(providers.ClusterInfo{Name: "c1", Domain: "example.com"}))
+	if err != nil {
+		t.Fatalf("Cannot read known hosts: %v", err)
+	}
+	if prefix := fmt.Sprintf("test.c1.example.com,[127.0.0.1]:%s ssh-rsa ", server.Port()); !strings.HasPrefix(string(content), prefix) {
+		t.Errorf("Expected known hosts to start with '%s', got '%s'", prefix, content)
+	}
+
+	// A reinstalled instance (same name, other host key) must be refused
+	other, _, otherCleanup := newTestSSHExecutor(t, providers.NewKnownHosts(dir))
+	defer otherCleanup()
+	_, err = other.Run(context.Background(), log, testSSHInstance, "echo hello", "", true)
+	if !providers.IsHostKeyMismatch(err) {
+		t.Fatalf("Expected host key mismatch, got %v", err)
+	}
+
+	// Until the new key is pinned
+	key, err := other.FetchHostKey(context.Background(), log, testSSHInstance)
+	if err != nil {
+		t.Fatalf("FetchHostKey failed: %v", err)
+	}
+	if err := other.PinHostKey(testSSHInstance, key); err != nil {
+		t.Fatalf("PinHostKey failed: %v", err)
+	}
+	if _, err := other.Run(context.Background(), log, testSSHInstance, "echo hello", "", false); err != nil {
+		t.Fatalf("Run after rekey failed: %v", err)
+	}
+	pinned, err := knownHosts.Lookup(testSSHInstance.Name)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(pinned) != 1 || !bytes.Equal(pinned[0].Marshal(), key.Marshal()) {
+		t.Errorf("Expected only the new key to be pinned, got %d keys", len(pinned))
+	}
+
+	// Forgetting the last instance removes the file
+	if err := knownHosts.Forget(testSSHInstance.Name); err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected no known hosts files, got %d", len(files))
+	}
+}
+
+func TestSSHExecutorPreGeneratedHostKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quark-known-hosts")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	knownHosts := providers.NewKnownHosts(dir)
+
+	// The server presents the injected key next to a key of its own
+	hostKey, err := providers.NewHostKey()
+	if err != nil {
+		t.Fatalf("NewHostKey failed: %v", err)
+	}
+	hostSigner, err := ssh.ParsePrivateKey([]byte(hostKey.PrivateKey))
+	if err != nil {
+		t.Fatalf("Cannot parse host key: %v", err)
+	}
+	keyPath, publicKey := writeTestKey(t)
+	defer os.Remove(keyPath)
+	server := newTestSSHServer(t, publicKey, hostSigner)
+	defer server.Close()
+	executor := providers.NewSSHExecutor(providers.SSHOptions{
+		KeyFiles:   []string{keyPath},
+		Port:       server.Port(),
+		KnownHosts: knownHosts,
+	})
+	defer executor.Close()
+
+	// Pinned before the first connection, so the server must present it
+	if err := executor.PinHostKey(testSSHInstance, hostKey.PublicKey); err != nil {
+		t.Fatalf("PinHostKey failed: %v", err)
+	}
+	if _, err := executor.Run(context.Background(), log, testSSHInstance, "echo hello", "", false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	pinned, err := knownHosts.Lookup(testSSHInstance.Name)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(pinned) != 1 || !bytes.Equal(pinned[0].Marshal(), hostKey.PublicKey.Marshal()) {
+		t.Errorf("Expected only the injected key to be pinned, got %d keys", len(pinned))
+	}
+	if expected := "ecdsa-sha2-nistp256 "; !strings.HasPrefix(hostKey.AuthorizedKey(), expected) {
+		t.Errorf("Expected public key file to start with '%s', got '%s'", expected, hostKey.AuthorizedKey())
+	}
+}
+
+func TestSSHExecutorJumpHost(t *testing.T) {
+	keyPath, publicKey := writeTestKey(t)
+	defer os.Remove(keyPath)
+	jumpHost := newTestSSHServer(t, publicKey)
+	defer jumpHost.Close()
+	server := newTestSSHServer(t, publicKey)
+	defer server.Close()
+	executor := providers.NewSSHExecutor(providers.SSHOptions{
+		KeyFiles: []string{keyPath},
+		Port:     server.Port(),
+		JumpHost: "core@127.0.0.1:" + jumpHost.Port(),
+	})
+	defer executor.Close()
+
+	// An instance without public address is reached on its private address
+	instance := providers.ClusterInstance{Name: "worker.c1.example.com", PrivateIP: "127.0.0.1"}
+	for i := 0; i < 3; i++ {
+		out, err := executor.Run(context.Background(), log, instance, "echo private", "", false)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out != "private" {
+			t.Errorf("Expected output 'private', got '%s'", out)
+		}
+	}
+	if n := atomic.LoadInt32(&jumpHost.connections); n != 1 {
+		t.Errorf("Expected 1 connection to the jump host, got %d", n)
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 1 {
+		t.Errorf("Expected 1 connection to the instance, got %d", n)
+	}
+
+	// Without private address there is no way to reach it
+	if _, err := executor.Run(context.Background(), log, providers.ClusterInstance{Name: "none.c1.example.com"}, "echo none", "", true); err == nil {
+		t.Errorf("Expected Run to fail for an instance without any address")
+	}
+}
+
+func TestAssignJumpHosts(t *testing.T) {
+	list := providers.ClusterInstanceList{
+		{Name: "worker1.c1.example.com", PrivateIP: "10.0.0.1"},
+		{Name: "lb.c1.example.com", PrivateIP: "10.0.0.2", LoadBalancerIPv4: "198.51.100.2"},
+		{Name: "worker2.c1.example.com", PrivateIP: "10.0.0.3"},
+	}
+	list.AssignJumpHosts()
+	for _, i := range list {
+		switch i.Name {
+		case "lb.c1.example.com":
+			if i.JumpHost != nil {
+				t.Errorf("Expected no jump host for %s, got %s", i.Name, i.JumpHost.Name)
+			}
+		default:
+			if i.JumpHost == nil || i.JumpHost.Name != "lb.c1.example.com" {
+				t.Errorf("Expected jump host lb.c1.example.com for %s, got %v", i.Name, i.JumpHost)
+			}
+		}
+	}
+}
+
+func TestSSHExecutorShell(t *testing.T) {
+	executor, _, cleanup := newTestSSHExecutor(t, nil)
+	defer cleanup()
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("Cannot open %s: %v", os.DevNull, err)
+	}
+	defer stdin.Close()
+
+	var stdout, stderr bytes.Buffer
+	if err := executor.Shell(context.Background(), log, testSSHInstance, "echo interactive", stdin, &stdout, &stderr); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	if stdout.String() != "interactive\n" {
+		t.Errorf("Expected output 'interactive', got '%s'", stdout.String())
+	}
+
+	err = executor.Shell(context.Background(), log, testSSHInstance, "exit 4", stdin, &stdout, &stderr)
+	cmdErr, ok := errgo.Cause(err).(*providers.RemoteCommandError)
+	if !ok {
+		t.Fatalf("Expected RemoteCommandError, got %#v", err)
+	}
+	if cmdErr.ExitStatus != 4 {
+		t.Errorf("Expected exit status 4, got %d", cmdErr.ExitStatus)
+	}
+}
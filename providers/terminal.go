@@ -0,0 +1,33 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// StdinIsTerminal returns true if stdin is connected to a terminal,
+// false when it is redirected from a file, pipe or /dev/null (e.g. when running from CI or cron).
+func StdinIsTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// StdoutIsTerminal returns true if stdout is connected to a terminal,
+// false when it is redirected to a file or pipe.
+func StdoutIsTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdout.Fd()))
+}
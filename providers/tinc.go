@@ -21,10 +21,11 @@ import (
 	"sync"
 
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 // ReconfigureTincCluster creates the tinc configuration on all instances of the given cluster.
-func ReconfigureTincCluster(log *logging.Logger, info ClusterInfo, provider CloudProvider) error {
+func ReconfigureTincCluster(ctx context.Context, log *logging.Logger, info ClusterInfo, provider CloudProvider) error {
 	// Load all instances
 	instances, err := provider.GetInstances(info)
 	if err != nil {
@@ -32,7 +33,7 @@ func ReconfigureTincCluster(log *logging.Logger, info ClusterInfo, provider Clou
 	}
 
 	// Call reconfigure-tinc-host on all instances
-	if instances.ReconfigureTincCluster(log); err != nil {
+	if err := instances.ReconfigureTincCluster(ctx, log); err != nil {
 		return maskAny(err)
 	}
 
@@ -40,7 +41,7 @@ func ReconfigureTincCluster(log *logging.Logger, info ClusterInfo, provider Clou
 }
 
 // ReconfigureTincCluster creates the tinc configuration on all given instances.
-func (instances ClusterInstanceList) ReconfigureTincCluster(log *logging.Logger) error {
+func (instances ClusterInstanceList) ReconfigureTincCluster(ctx context.Context, log *logging.Logger) error {
 	// Now update all members in parallel
 	vpnName := "pulcy"
 	wg := sync.WaitGroup{}
@@ -49,7 +50,7 @@ func (instances ClusterInstanceList) ReconfigureTincCluster(log *logging.Logger)
 		wg.Add(1)
 		go func(i ClusterInstance) {
 			defer wg.Done()
-			if err := configureTincHost(log, i, vpnName, instances); err != nil {
+			if err := configureTincHost(ctx, log, i, vpnName, instances); err != nil {
 				errorChannel <- maskAny(err)
 			}
 		}(i)
@@ -61,7 +62,7 @@ func (instances ClusterInstanceList) ReconfigureTincCluster(log *logging.Logger)
 	}
 
 	for _, i := range instances {
-		if err := distributeTincHosts(log, i, vpnName, instances); err != nil {
+		if err := distributeTincHosts(ctx, log, i, vpnName, instances); err != nil {
 			return maskAny(err)
 		}
 	}
@@ -69,27 +70,31 @@ func (instances ClusterInstanceList) ReconfigureTincCluster(log *logging.Logger)
 	return nil
 }
 
-func configureTincHost(log *logging.Logger, i ClusterInstance, vpnName string, instances ClusterInstanceList) error {
+func configureTincHost(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string, instances ClusterInstanceList) error {
 	connectTo := []string{}
 	for _, x := range instances {
 		if x.Name != i.Name {
 			connectTo = append(connectTo, tincName(x))
 		}
 	}
-	if err := createTincConf(log, i, vpnName, connectTo); err != nil {
+	if err := createTincConf(ctx, log, i, vpnName, connectTo); err != nil {
 		return maskAny(err)
 	}
-	if err := createTincHostsConf(log, i, vpnName); err != nil {
+	if err := createTincHostsConf(ctx, log, i, vpnName); err != nil {
 		return maskAny(err)
 	}
-	if err := createTincScripts(log, i, vpnName); err != nil {
+	if err := createTincScripts(ctx, log, i, vpnName); err != nil {
 		return maskAny(err)
 	}
-	if err := createTincService(log, i, vpnName); err != nil {
+	if err := createTincService(ctx, log, i, vpnName); err != nil {
 		return maskAny(err)
 	}
-	//Create key
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tincd -n %s -K", vpnName), "", false); err != nil {
+	// Create key. tincd appends to an existing private key file, so remove it first
+	// to keep this step repeatable.
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo rm -f /etc/tinc/%s/rsa_key.priv", vpnName), "", false); err != nil {
+		return maskAny(err)
+	}
+	if _, err := i.runRemoteCommand(ctx, log, fmt.Sprintf("sudo tincd -n %s -K", vpnName), "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
@@ -100,15 +105,15 @@ func tincName(i ClusterInstance) string {
 	return strings.Replace(strings.Replace(i.Name, ".", "_", -1), "-", "_", -1)
 }
 
-func distributeTincHosts(log *logging.Logger, i ClusterInstance, vpnName string, instances ClusterInstanceList) error {
-	conf, err := getTincHostsConf(log, i, vpnName)
+func distributeTincHosts(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string, instances ClusterInstanceList) error {
+	conf, err := getTincHostsConf(ctx, log, i, vpnName)
 	if err != nil {
 		return maskAny(err)
 	}
 	tincName := tincName(i)
 	for _, x := range instances {
 		if x.Name != i.Name {
-			err := setTincHostsConf(log, x, vpnName, tincName, conf)
+			err := setTincHostsConf(ctx, log, x, vpnName, tincName, conf)
 			if err != nil {
 				return maskAny(err)
 			}
@@ -118,7 +123,7 @@ func distributeTincHosts(log *logging.Logger, i ClusterInstance, vpnName string,
 }
 
 // createTincConf creates a tinc.conf for the host of the given instance
-func createTincConf(log *logging.Logger, i ClusterInstance, vpnName string, connectTo []string) error {
+func createTincConf(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string, connectTo []string) error {
 	lines := []string{
 		fmt.Sprintf("Name = %s", tincName(i)),
 		"AddressFamily = ipv4",
@@ -129,34 +134,28 @@ func createTincConf(log *logging.Logger, i ClusterInstance, vpnName string, conn
 	}
 	confDir := path.Join("/etc/tinc", vpnName)
 	confPath := path.Join(confDir, "tinc.conf")
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo mkdir -p %s", confDir), "", false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", confPath), strings.Join(lines, "\n"), false); err != nil {
+	if err := i.WriteFile(ctx, log, confPath, strings.Join(lines, "\n"), 0644); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // createTincHostsConf creates a /etc/tinc/<vpnName>/hosts/<hostName> for the host of the given instance
-func createTincHostsConf(log *logging.Logger, i ClusterInstance, vpnName string) error {
+func createTincHostsConf(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string) error {
 	lines := []string{
 		fmt.Sprintf("Address = %s", i.PrivateIP),
 		fmt.Sprintf("Subnet = %s/32", i.ClusterIP),
 	}
 	confDir := path.Join("/etc/tinc", vpnName, "hosts")
 	confPath := path.Join(confDir, tincName(i))
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo mkdir -p %s", confDir), "", false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", confPath), strings.Join(lines, "\n"), false); err != nil {
+	if err := i.WriteFile(ctx, log, confPath, strings.Join(lines, "\n"), 0644); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // createTincScripts creates a /etc/tinc/<vpnName>/tinc-up|down for the host of the given instance
-func createTincScripts(log *logging.Logger, i ClusterInstance, vpnName string) error {
+func createTincScripts(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string) error {
 	upLines := []string{
 		"#!/bin/sh",
 		fmt.Sprintf("ifconfig $INTERFACE %s netmask 255.255.255.0", i.ClusterIP),
@@ -168,26 +167,20 @@ func createTincScripts(log *logging.Logger, i ClusterInstance, vpnName string) e
 	confDir := path.Join("/etc/tinc", vpnName)
 	upPath := path.Join(confDir, "tinc-up")
 	downPath := path.Join(confDir, "tinc-down")
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo mkdir -p %s", confDir), "", false); err != nil {
+	if err := i.WriteFile(ctx, log, upPath, strings.Join(upLines, "\n"), 0755); err != nil {
 		return maskAny(err)
 	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", upPath), strings.Join(upLines, "\n"), false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", downPath), strings.Join(downLines, "\n"), false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo chmod 755 %s %s", upPath, downPath), "", false); err != nil {
+	if err := i.WriteFile(ctx, log, downPath, strings.Join(downLines, "\n"), 0755); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // getTincHostsConf reads a /etc/tinc/<vpnName>/hosts/<hostName> for the host of the given instance
-func getTincHostsConf(log *logging.Logger, i ClusterInstance, vpnName string) (string, error) {
+func getTincHostsConf(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string) (string, error) {
 	confDir := path.Join("/etc/tinc", vpnName, "hosts")
 	confPath := path.Join(confDir, tincName(i))
-	content, err := i.runRemoteCommand(log, "cat "+confPath, "", false)
+	content, err := i.ReadFile(ctx, log, confPath)
 	if err != nil {
 		return "", maskAny(err)
 	}
@@ -195,20 +188,17 @@ func getTincHostsConf(log *logging.Logger, i ClusterInstance, vpnName string) (s
 }
 
 // setTincHostsConf creates a /etc/tinc/<vpnName>/hosts/<hostName> from the given content
-func setTincHostsConf(log *logging.Logger, i ClusterInstance, vpnName, tincName, content string) error {
+func setTincHostsConf(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName, tincName, content string) error {
 	confDir := path.Join("/etc/tinc", vpnName, "hosts")
 	confPath := path.Join(confDir, tincName)
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo mkdir -p %s", confDir), "", false); err != nil {
-		return maskAny(err)
-	}
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", confPath), content, false); err != nil {
+	if err := i.WriteFile(ctx, log, confPath, content, 0644); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 // createTincService creates /etc/systemd/system/tinc.service on the given instance
-func createTincService(log *logging.Logger, i ClusterInstance, vpnName string) error {
+func createTincService(ctx context.Context, log *logging.Logger, i ClusterInstance, vpnName string) error {
 	lines := []string{
 		"[Unit]",
 		fmt.Sprintf("Description=tinc for network %s", vpnName),
@@ -228,10 +218,10 @@ func createTincService(log *logging.Logger, i ClusterInstance, vpnName string) e
 		"WantedBy=multi-user.target",
 	}
 	confPath := "/etc/systemd/system/tinc.service"
-	if _, err := i.runRemoteCommand(log, fmt.Sprintf("sudo tee %s", confPath), strings.Join(lines, "\n"), false); err != nil {
+	if err := i.WriteFile(ctx, log, confPath, strings.Join(lines, "\n"), 0644); err != nil {
 		return maskAny(err)
 	}
-	if _, err := i.runRemoteCommand(log, "sudo systemctl enable tinc.service", "", false); err != nil {
+	if _, err := i.runRemoteCommand(ctx, log, "sudo systemctl enable tinc.service", "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
@@ -18,10 +18,11 @@ import (
 	"sync"
 
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 )
 
 // UpdateClusterMembers updates /etc/cluster-members on all instances of the cluster
-func UpdateClusterMembers(log *logging.Logger, info ClusterInfo, rebootAfter bool, isEtcdProxy func(ClusterInstance) bool, provider CloudProvider) error {
+func UpdateClusterMembers(ctx context.Context, log *logging.Logger, info ClusterInfo, reboot RebootMode, isEtcdProxy func(ClusterInstance) bool, provider CloudProvider) error {
 	// Load all instances
 	instances, err := provider.GetInstances(info)
 	if err != nil {
@@ -29,13 +30,13 @@ func UpdateClusterMembers(log *logging.Logger, info ClusterInfo, rebootAfter boo
 	}
 
 	// Load cluster-members data
-	clusterMembers, err := instances.AsClusterMemberList(log, isEtcdProxy)
+	clusterMembers, err := instances.AsClusterMemberList(ctx, log, isEtcdProxy)
 	if err != nil {
 		return maskAny(err)
 	}
 
 	// Call update-member on all instances
-	if instances.UpdateClusterMembers(log, clusterMembers, rebootAfter, provider); err != nil {
+	if err := instances.UpdateClusterMembers(ctx, log, clusterMembers, reboot, provider); err != nil {
 		return maskAny(err)
 	}
 
@@ -43,7 +44,8 @@ func UpdateClusterMembers(log *logging.Logger, info ClusterInfo, rebootAfter boo
 }
 
 // UpdateClusterMembers updates /etc/cluster-members on all instances of the cluster
-func (instances ClusterInstanceList) UpdateClusterMembers(log *logging.Logger, clusterMembers ClusterMemberList, rebootAfter bool, provider CloudProvider) error {
+// and reboots them afterwards as specified by the given mode.
+func (instances ClusterInstanceList) UpdateClusterMembers(ctx context.Context, log *logging.Logger, clusterMembers ClusterMemberList, reboot RebootMode, provider CloudProvider) error {
 	// Now update all members in parallel
 	wg := sync.WaitGroup{}
 	errorChannel := make(chan error, len(instances))
@@ -51,14 +53,9 @@ func (instances ClusterInstanceList) UpdateClusterMembers(log *logging.Logger, c
 		wg.Add(1)
 		go func(i ClusterInstance) {
 			defer wg.Done()
-			if err := i.UpdateClusterMembers(log, clusterMembers); err != nil {
+			if err := i.UpdateClusterMembers(ctx, log, clusterMembers); err != nil {
 				errorChannel <- maskAny(err)
 			}
-			if rebootAfter {
-				if err := provider.RebootInstance(i); err != nil {
-					errorChannel <- maskAny(err)
-				}
-			}
 		}(i)
 	}
 	wg.Wait()
@@ -66,5 +63,16 @@ func (instances ClusterInstanceList) UpdateClusterMembers(log *logging.Logger, c
 	for err := range errorChannel {
 		return maskAny(err)
 	}
+
+	switch reboot {
+	case RebootBootstrap:
+		if err := instances.BootstrapReboot(ctx, log, clusterMembers, provider); err != nil {
+			return maskAny(err)
+		}
+	case RebootRolling:
+		if err := instances.RollingReboot(ctx, log, clusterMembers, provider, false); err != nil {
+			return maskAny(err)
+		}
+	}
 	return nil
 }
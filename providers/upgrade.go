@@ -0,0 +1,84 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+// UpgradeOptions contains the options of a rolling upgrade of a cluster, as journaled in its operation.
+type UpgradeOptions struct {
+	Replacements []Replacement `json:"replacements"` // Instances to replace, in order
+	Force        bool          `json:"force"`        // If set, replaced instances are destroyed even when they cannot be drained
+	Pause        bool          `json:"pause"`        // If set, the upgrade stops after every replaced instance
+}
+
+// Replacement describes how a single instance of a cluster is replaced by a new one.
+type Replacement struct {
+	Instance string                `json:"instance"` // Full name of the instance that is replaced
+	Options  CreateInstanceOptions `json:"options"`  // Options of the new instance
+}
+
+// NewReplacement creates the replacement of the given instance, which is registered in fleet as the given machine.
+// The new instance gets the same index & roles as the given instance, all other settings are taken from the given options.
+// When no region is given, the new instance is created in the region of the given instance.
+// The new instance joins the cluster before the given instance is destroyed, so when the given instance uses its tinc
+// address as cluster IP, the new instance gets the lowest index (of the same odd/even kind) that is not used by any
+// instance of the given cluster.
+func NewReplacement(i ClusterInstance, m FleetMachine, etcdProxy bool, options CreateInstanceOptions, cluster ClusterInstanceList) Replacement {
+	if options.RegionID == "" {
+		options.RegionID = m.Metadata["region"]
+	}
+	options.RoleCore = m.HasMetadata("core", "true")
+	options.RoleLoadBalancer = m.HasMetadata("lb", "true")
+	options.EtcdProxy = etcdProxy
+	options.PrivateOnly = i.LoadBalancerIPv4 == "" && i.LoadBalancerIPv6 == ""
+	index := InstanceIndex(i, m)
+	if _, ok := tincAddressIndex(i.ClusterIP); ok {
+		index = cluster.freeInstanceIndex(index % 2)
+	}
+	options.SetupIndex(index)
+	options.SetupNames("", options.Name, options.Domain)
+	// The cluster name moves to the new instance once it is healthy
+	options.DelayClusterDNS = options.RoleLoadBalancer
+	return Replacement{
+		Instance: i.Name,
+		Options:  options,
+	}
+}
+
+// Apply returns the given cluster as it is after the replacement: without the replaced instance and with the new one.
+// The new instance only gets a cluster IP when the replaced instance used its tinc address as cluster IP.
+func (r Replacement) Apply(cluster ClusterInstanceList) ClusterInstanceList {
+	result := ClusterInstanceList{}
+	replacement := ClusterInstance{Name: r.Options.InstanceName}
+	for _, i := range cluster {
+		if i.Name != r.Instance {
+			result = append(result, i)
+		} else if _, ok := tincAddressIndex(i.ClusterIP); ok {
+			replacement.ClusterIP = r.Options.TincIpv4
+		}
+	}
+	return append(result, replacement)
+}
+
+// InstanceIndex returns the index of the given instance, which is registered in fleet as the given machine.
+// The index is derived from the tinc address of the instance. When the instance does not use a tinc address
+// as cluster IP, the index only determines the odd/even metadata, so 1 or 2 is returned.
+func InstanceIndex(i ClusterInstance, m FleetMachine) int {
+	if index, ok := tincAddressIndex(i.ClusterIP); ok {
+		return index
+	}
+	if m.HasMetadata("even", "true") {
+		return 2
+	}
+	return 1
+}
@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 	"github.com/pulcy/quark/templates"
@@ -36,6 +37,8 @@ const (
 	configTemplate      = "templates/config.rb.tmpl"
 	configFileName      = "config.rb"
 	userDataFileName    = "user-data"
+	folderCredential    = "vagrant-folder"
+	resourceVagrant     = "vagrant-cluster"
 )
 
 var (
@@ -52,6 +55,22 @@ type vagrantProvider struct {
 	instanceCount int
 }
 
+func init() {
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name: "vagrant",
+		Credentials: []providers.Credential{
+			{Name: folderCredential, Shorthand: "f", EnvVar: "QUARK_VAGRANT_FOLDER", Usage: "Directory containing vagrant files", Required: true},
+		},
+		Capabilities: []providers.Capability{
+			providers.CapabilityCluster,
+			providers.CapabilityImages,
+		},
+		NewCloudProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.CloudProvider, error) {
+			return NewProvider(log, credentials.Get(folderCredential)), nil
+		},
+	})
+}
+
 func NewProvider(logger *logging.Logger, folder string) providers.CloudProvider {
 	return &vagrantProvider{
 		Logger:        logger,
@@ -60,30 +79,36 @@ func NewProvider(logger *logging.Logger, folder string) providers.CloudProvider
 	}
 }
 
-func (vp *vagrantProvider) ShowInstanceTypes() error {
-	return maskAny(NotImplementedError)
+func (vp *vagrantProvider) ListInstanceTypes() ([]providers.InstanceType, error) {
+	return nil, maskAny(NotImplementedError)
 }
 
-func (vp *vagrantProvider) ShowRegions() error {
-	return maskAny(NotImplementedError)
+func (vp *vagrantProvider) ListRegions() ([]providers.Region, error) {
+	return nil, maskAny(NotImplementedError)
 }
 
-func (vp *vagrantProvider) ShowImages() error {
-	fmt.Printf("Images\n%s\n", strings.Join(images, "\n"))
-	return nil
+func (vp *vagrantProvider) ListImages() ([]providers.Image, error) {
+	result := []providers.Image{}
+	for _, id := range images {
+		result = append(result, providers.Image{
+			ID:   id,
+			Name: id,
+		})
+	}
+	return result, nil
 }
 
-func (vp *vagrantProvider) ShowKeys() error {
-	return maskAny(NotImplementedError)
+func (vp *vagrantProvider) ListKeys() ([]providers.SSHKey, error) {
+	return nil, maskAny(NotImplementedError)
 }
 
 // Create a machine instance
-func (vp *vagrantProvider) CreateInstance(log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+func (vp *vagrantProvider) CreateInstance(ctx context.Context, log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
 	return providers.ClusterInstance{}, maskAny(NotImplementedError)
 }
 
 // Create an entire cluster
-func (vp *vagrantProvider) CreateCluster(log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
+func (vp *vagrantProvider) CreateCluster(ctx context.Context, log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
 	// Ensure folder exists
 	if err := os.MkdirAll(vp.folder, fileMode|os.ModeDir); err != nil {
 		return maskAny(err)
@@ -162,8 +187,14 @@ func (vp *vagrantProvider) CreateCluster(log *logging.Logger, options providers.
 	cmd.Dir = vp.folder
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
+	if providers.StdinIsTerminal() {
+		// Only pass stdin when vagrant can actually ask the user something
+		cmd.Stdin = os.Stdin
+	}
+	providers.TrackResource(ctx, resourceVagrant, vp.folder, vp.folder, func(ctx context.Context) error {
+		return maskAny(vp.DeleteCluster(ctx, options.ClusterInfo, nil))
+	})
+	if err := providers.RunCommand(ctx, cmd); err != nil {
 		return maskAny(err)
 	}
 
@@ -172,7 +203,7 @@ func (vp *vagrantProvider) CreateCluster(log *logging.Logger, options providers.
 	if err != nil {
 		return maskAny(err)
 	}
-	clusterMembers, err := instances.AsClusterMemberList(log, nil)
+	clusterMembers, err := instances.AsClusterMemberList(ctx, log, nil)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -181,7 +212,7 @@ func (vp *vagrantProvider) CreateCluster(log *logging.Logger, options providers.
 			ClusterMembers: clusterMembers,
 			FleetMetadata:  instanceOptions.CreateFleetMetadata(index),
 		}
-		if err := instance.InitialSetup(log, instanceOptions, iso, vp); err != nil {
+		if err := instance.InitialSetup(ctx, log, instanceOptions, iso, vp); err != nil {
 			return maskAny(err)
 		}
 	}
@@ -212,14 +243,17 @@ func (vp *vagrantProvider) GetInstances(info_ providers.ClusterInfo) (providers.
 }
 
 // Remove all instances of a cluster
-func (vp *vagrantProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
+func (vp *vagrantProvider) DeleteCluster(ctx context.Context, info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
 	// Start
 	cmd := exec.Command("vagrant", "destroy", "-f")
 	cmd.Dir = vp.folder
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
+	if providers.StdinIsTerminal() {
+		// Only pass stdin when vagrant can actually ask the user something
+		cmd.Stdin = os.Stdin
+	}
+	if err := providers.RunCommand(ctx, cmd); err != nil {
 		return maskAny(err)
 	}
 
@@ -228,17 +262,17 @@ func (vp *vagrantProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider
 	return nil
 }
 
-func (vp *vagrantProvider) DeleteInstance(info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
+func (vp *vagrantProvider) DeleteInstance(ctx context.Context, info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
 	return maskAny(NotImplementedError)
 }
 
-func (vp *vagrantProvider) ShowDomainRecords(domain string) error {
-	return maskAny(NotImplementedError)
+func (vp *vagrantProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	return nil, maskAny(NotImplementedError)
 }
 
 // Perform a reboot of the given instance
-func (vp *vagrantProvider) RebootInstance(instance providers.ClusterInstance) error {
-	if _, err := instance.Exec(vp.Logger, "sudo shutdown -r now"); err != nil {
+func (vp *vagrantProvider) RebootInstance(ctx context.Context, instance providers.ClusterInstance) error {
+	if _, err := instance.Exec(ctx, vp.Logger, "sudo shutdown -r now"); err != nil {
 		return maskAny(err)
 	}
 	return nil
@@ -15,13 +15,14 @@
 package vultr
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"sync"
-	"time"
 
 	"github.com/JamesClonk/vultr/lib"
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 
 	"github.com/pulcy/quark/providers"
 	"github.com/pulcy/quark/templates"
@@ -33,15 +34,23 @@ const (
 )
 
 // Create a machine instance
-func (vp *vultrProvider) CreateInstance(log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+func (vp *vultrProvider) CreateInstance(ctx context.Context, log *logging.Logger, options providers.CreateInstanceOptions, dnsProvider providers.DnsProvider) (providers.ClusterInstance, error) {
+	hostKey, err := providers.NewHostKey()
+	if err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+
 	// Create server
-	id, err := vp.createServer(options)
+	id, err := vp.createServer(options, hostKey)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
+	providers.TrackResource(ctx, providers.ResourceServer, id, options.InstanceName, func(ctx context.Context) error {
+		return maskAny(vp.client.DeleteServer(id))
+	})
 
 	// Wait for the server to be active
-	server, err := vp.waitUntilServerActive(id)
+	server, err := vp.waitUntilServerActive(ctx, id)
 	if err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
@@ -51,17 +60,23 @@ func (vp *vultrProvider) CreateInstance(log *logging.Logger, options providers.C
 	if len(server.V6Networks) > 0 {
 		publicIpv6 = server.V6Networks[0].MainIP
 	}
-	if err := providers.RegisterInstance(vp.Logger, dnsProvider, options, server.Name, options.RoleLoadBalancer, publicIpv4, publicIpv6); err != nil {
+	if err := providers.RegisterInstance(ctx, vp.Logger, dnsProvider, options, server.Name, options.RegisterClusterName(), publicIpv4, publicIpv6); err != nil {
+		return providers.ClusterInstance{}, maskAny(err)
+	}
+
+	// Pin the host key we injected, before anyone connects to the server
+	instance := vp.clusterInstance(server)
+	if err := providers.PinHostKey(instance, hostKey.PublicKey); err != nil {
 		return providers.ClusterInstance{}, maskAny(err)
 	}
 
 	vp.Logger.Infof("Server '%s' is ready", server.Name)
 
-	return vp.clusterInstance(server), nil
+	return instance, nil
 }
 
 // Create a single server
-func (vp *vultrProvider) createServer(options providers.CreateInstanceOptions) (string, error) {
+func (vp *vultrProvider) createServer(options providers.CreateInstanceOptions, hostKey *providers.HostKey) (string, error) {
 	// Find SSH key ID
 	var sshid string
 	if len(options.SSHKeyNames) > 0 {
@@ -82,6 +97,7 @@ func (vp *vultrProvider) createServer(options providers.CreateInstanceOptions) (
 	ccOpts := options.NewCloudConfigOptions()
 	ccOpts.PrivateIPv4 = "$private_ipv4"
 	ccOpts.SshKeys = sshKeys
+	ccOpts.HostKey = hostKey
 	userData, err := templates.Render(cloudConfigTemplate, ccOpts)
 	if err != nil {
 		return "", maskAny(err)
@@ -116,27 +132,29 @@ func (vp *vultrProvider) createServer(options providers.CreateInstanceOptions) (
 	return server.ID, nil
 }
 
-func (vp *vultrProvider) waitUntilServerActive(id string) (lib.Server, error) {
-	for {
-		server, err := vp.client.GetServer(id)
+func (vp *vultrProvider) waitUntilServerActive(ctx context.Context, id string) (lib.Server, error) {
+	var server lib.Server
+	if err := providers.WaitUntil(ctx, fmt.Sprintf("server %s to become active", id), func(ctx context.Context) (bool, error) {
+		var err error
+		server, err = vp.client.GetServer(id)
 		if err != nil {
-			return lib.Server{}, err
+			return false, maskAny(err)
 		}
-		if server.Status == "active" {
-			// Attempt an SSH connection
-			instance := vp.clusterInstance(server)
-			if _, err := instance.GetMachineID(vp.Logger); err == nil {
-				// Success
-				return server, nil
-			}
+		if server.Status != "active" {
+			return false, nil
 		}
-		// Wait a while
-		time.Sleep(time.Second * 5)
-	}
+		// Attempt an SSH connection
+		instance := vp.clusterInstance(server)
+		_, err = instance.GetMachineID(ctx, vp.Logger)
+		return err == nil, nil
+	}); err != nil {
+		return lib.Server{}, maskAny(err)
+	}
+	return server, nil
 }
 
 // Create an entire cluster
-func (vp *vultrProvider) CreateCluster(log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
+func (vp *vultrProvider) CreateCluster(ctx context.Context, log *logging.Logger, options providers.CreateClusterOptions, dnsProvider providers.DnsProvider) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, options.InstanceCount)
 	instanceDatas := make(chan instanceData, options.InstanceCount)
@@ -144,7 +162,6 @@ func (vp *vultrProvider) CreateCluster(log *logging.Logger, options providers.Cr
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			time.Sleep(time.Duration((i - 1)) * time.Second * 10)
 			isCore := true
 			isLB := true
 			instanceOptions, err := options.NewCreateInstanceOptions(isCore, isLB, i)
@@ -152,7 +169,7 @@ func (vp *vultrProvider) CreateCluster(log *logging.Logger, options providers.Cr
 				errors <- maskAny(err)
 				return
 			}
-			instance, err := vp.CreateInstance(log, instanceOptions, dnsProvider)
+			instance, err := providers.CreateInstanceOnce(ctx, log, vp, instanceOptions, dnsProvider)
 			if err != nil {
 				errors <- maskAny(err)
 			} else {
@@ -179,12 +196,12 @@ func (vp *vultrProvider) CreateCluster(log *logging.Logger, options providers.Cr
 		instanceList = append(instanceList, data.ClusterInstance)
 	}
 
-	clusterMembers, err := instanceList.AsClusterMemberList(log, nil)
+	clusterMembers, err := instanceList.AsClusterMemberList(ctx, log, nil)
 	if err != nil {
 		return maskAny(err)
 	}
 
-	if err := vp.setupInstances(log, instances, clusterMembers); err != nil {
+	if err := vp.setupInstances(ctx, log, instances, clusterMembers); err != nil {
 		return maskAny(err)
 	}
 
@@ -197,7 +214,7 @@ type instanceData struct {
 	FleetMetadata         string
 }
 
-func (vp *vultrProvider) setupInstances(log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
+func (vp *vultrProvider) setupInstances(ctx context.Context, log *logging.Logger, instances []instanceData, clusterMembers providers.ClusterMemberList) error {
 	wg := sync.WaitGroup{}
 	errors := make(chan error, len(instances))
 	for _, instance := range instances {
@@ -208,7 +225,7 @@ func (vp *vultrProvider) setupInstances(log *logging.Logger, instances []instanc
 				ClusterMembers: clusterMembers,
 				FleetMetadata:  instance.FleetMetadata,
 			}
-			if err := instance.ClusterInstance.InitialSetup(log, instance.CreateInstanceOptions, iso, vp); err != nil {
+			if err := instance.ClusterInstance.InitialSetup(ctx, log, instance.CreateInstanceOptions, iso, vp); err != nil {
 				errors <- maskAny(err)
 				return
 			}
@@ -15,16 +15,21 @@
 package vultr
 
 import (
+	"golang.org/x/net/context"
+
 	"github.com/pulcy/quark/providers"
 )
 
 // Remove all instances of a cluster
-func (vp *vultrProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
+func (vp *vultrProvider) DeleteCluster(ctx context.Context, info providers.ClusterInfo, dnsProvider providers.DnsProvider) error {
 	servers, err := vp.getInstances(info)
 	if err != nil {
 		return err
 	}
 	for _, s := range servers {
+		if err := ctx.Err(); err != nil {
+			return maskAny(err)
+		}
 		// Delete DNS instance records
 		instance := vp.clusterInstance(s)
 		if err := providers.UnRegisterInstance(vp.Logger, dnsProvider, instance, info.Domain); err != nil {
@@ -41,7 +46,7 @@ func (vp *vultrProvider) DeleteCluster(info providers.ClusterInfo, dnsProvider p
 	return nil
 }
 
-func (vp *vultrProvider) DeleteInstance(info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
+func (vp *vultrProvider) DeleteInstance(ctx context.Context, info providers.ClusterInstanceInfo, dnsProvider providers.DnsProvider) error {
 	fullName := info.String()
 	servers, err := vp.getInstances(info.ClusterInfo)
 	if err != nil {
@@ -14,6 +14,10 @@
 
 package vultr
 
-func (vp *vultrProvider) ShowDomainRecords(domain string) error {
-	return maskAny(NotImplementedError)
+import (
+	"github.com/pulcy/quark/providers"
+)
+
+func (vp *vultrProvider) ListDnsRecords(domain string) ([]providers.DnsRecord, error) {
+	return nil, maskAny(NotImplementedError)
 }
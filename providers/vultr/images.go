@@ -15,30 +15,27 @@
 package vultr
 
 import (
-	"fmt"
-	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *vultrProvider) ShowImages() error {
+func (vp *vultrProvider) ListImages() ([]providers.Image, error) {
 	// Load OS's
 	os, err := vp.client.GetOS()
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"ID | Name | Arch | Family",
-	}
+	result := []providers.Image{}
 	for _, r := range os {
-		line := fmt.Sprintf("%d | %s | %s | %s", r.ID, r.Name, r.Arch, r.Family)
-		lines = append(lines, line)
+		result = append(result, providers.Image{
+			ID:          strconv.Itoa(r.ID),
+			Name:        r.Name,
+			Description: strings.TrimSpace(r.Family + " " + r.Arch),
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
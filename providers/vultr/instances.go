@@ -16,6 +16,7 @@ package vultr
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/JamesClonk/vultr/lib"
@@ -69,6 +70,7 @@ func (dp *vultrProvider) clusterInstance(s lib.Server) providers.ClusterInstance
 		LoadBalancerIPv6: ipv6,
 		ClusterDevice:    privateClusterDevice,
 		OS:               providers.OSNameCoreOS,
+		TypeID:           strconv.Itoa(s.PlanID),
 	}
 	return info
 }
@@ -15,31 +15,27 @@
 package vultr
 
 import (
-	"fmt"
-	"sort"
-
 	"github.com/juju/errgo"
-	"github.com/ryanuber/columnize"
+
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *vultrProvider) ShowKeys() error {
+func (vp *vultrProvider) ListKeys() ([]providers.SSHKey, error) {
 	keys, err := vp.client.GetSSHKeys()
 	if err != nil {
-		return maskAny(err)
-	}
-	lines := []string{
-		"ID | Name | Public-key",
+		return nil, maskAny(err)
 	}
+
+	result := []providers.SSHKey{}
 	for _, r := range keys {
-		line := fmt.Sprintf("%v | %s | %s", r.ID, r.Name, r.Key)
-		lines = append(lines, line)
+		result = append(result, providers.SSHKey{
+			ID:        r.ID,
+			Name:      r.Name,
+			PublicKey: r.Key,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
 
 // Search for an SSH key with given name and return its ID
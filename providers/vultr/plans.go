@@ -15,29 +15,50 @@
 package vultr
 
 import (
-	"fmt"
-	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *vultrProvider) ShowInstanceTypes() error {
+func (vp *vultrProvider) ListInstanceTypes() ([]providers.InstanceType, error) {
 	plans, err := vp.client.GetPlans()
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"ID | Name | VCpu | RAM | Disk | Bandwidth | Price",
-	}
+	result := []providers.InstanceType{}
 	for _, p := range plans {
-		line := fmt.Sprintf("%03d | %s | %d | %s | %s | %s | %s", p.ID, p.Name, p.VCpus, p.RAM, p.Disk, p.Bandwidth, p.Price)
-		lines = append(lines, line)
+		regions := []string{}
+		for _, id := range p.Regions {
+			regions = append(regions, strconv.Itoa(id))
+		}
+		price, _ := strconv.ParseFloat(p.Price, 64)
+		result = append(result, providers.InstanceType{
+			ID:        strconv.Itoa(p.ID),
+			Name:      p.Name,
+			CPU:       p.VCpus,
+			RAM:       parseLeadingInt(p.RAM),
+			Disk:      parseLeadingInt(p.Disk),
+			Price:     price,
+			Regions:   regions,
+			Available: len(regions) > 0,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
+	return result, nil
+}
 
-	return nil
+// parseLeadingInt parses the number at the start of strings like "768 MB" or "15 GB SSD".
+// It returns 0 when there is no such number.
+func parseLeadingInt(s string) int {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	v, err := strconv.Atoi(strings.Replace(fields[0], ",", "", -1))
+	if err != nil {
+		return 0
+	}
+	return v
 }
@@ -26,9 +26,35 @@ type vultrProvider struct {
 	client *lib.Client
 }
 
+const (
+	apiKeyCredential = "vultr-apikey"
+)
+
+func init() {
+	providers.RegisterProvider(providers.ProviderInfo{
+		Name: "vultr",
+		Credentials: []providers.Credential{
+			{Name: apiKeyCredential, EnvVar: "VULTR_APIKEY", Usage: "Vultr API key", Required: true, Secret: true},
+		},
+		Capabilities: []providers.Capability{
+			providers.CapabilityCluster,
+			providers.CapabilityInstance,
+			providers.CapabilityRegions,
+			providers.CapabilityImages,
+			providers.CapabilityKeys,
+			providers.CapabilityInstanceTypes,
+		},
+		NewCloudProvider: func(log *logging.Logger, credentials providers.Credentials) (providers.CloudProvider, error) {
+			return NewProvider(log, credentials.Get(apiKeyCredential)), nil
+		},
+	})
+}
+
 // NewProvider creates a new Vultr provider implementation
 func NewProvider(logger *logging.Logger, apiKey string) providers.CloudProvider {
-	client := lib.NewClient(apiKey, nil)
+	client := lib.NewClient(apiKey, &lib.Options{
+		HTTPClient: providers.NewHTTPClient(logger, providers.DefaultRetryPolicy),
+	})
 	return &vultrProvider{
 		Logger: logger,
 		client: client,
@@ -15,12 +15,14 @@
 package vultr
 
 import (
+	"golang.org/x/net/context"
+
 	"github.com/pulcy/quark/providers"
 )
 
 // Perform a reboot of the given instance
-func (vp *vultrProvider) RebootInstance(instance providers.ClusterInstance) error {
-	if _, err := instance.Exec(vp.Logger, "sudo shutdown -r now"); err != nil {
+func (vp *vultrProvider) RebootInstance(ctx context.Context, instance providers.ClusterInstance) error {
+	if _, err := instance.Exec(ctx, vp.Logger, "sudo shutdown -r now"); err != nil {
 		return maskAny(err)
 	}
 	return nil
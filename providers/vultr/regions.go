@@ -15,29 +15,30 @@
 package vultr
 
 import (
-	"fmt"
-	"sort"
+	"strconv"
 
-	"github.com/ryanuber/columnize"
+	"github.com/pulcy/quark/providers"
 )
 
-func (vp *vultrProvider) ShowRegions() error {
+func (vp *vultrProvider) ListRegions() ([]providers.Region, error) {
 	regions, err := vp.client.GetRegions()
 	if err != nil {
-		return maskAny(err)
+		return nil, maskAny(err)
 	}
 
-	lines := []string{
-		"ID | Name | State | Country | Continent",
-	}
+	result := []providers.Region{}
 	for _, r := range regions {
-		line := fmt.Sprintf("%02d | %s | %s | %s | %s", r.ID, r.Name, r.State, r.Country, r.Continent)
-		lines = append(lines, line)
+		features := []string{}
+		if r.Ddos {
+			features = append(features, "ddos_protection")
+		}
+		result = append(result, providers.Region{
+			ID:        strconv.Itoa(r.ID),
+			Name:      r.Name,
+			Available: true,
+			Features:  features,
+		})
 	}
 
-	sort.Strings(lines[1:])
-	result := columnize.SimpleFormat(lines)
-	fmt.Println(result)
-
-	return nil
+	return result, nil
 }
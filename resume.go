@@ -0,0 +1,167 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+
+	"github.com/pulcy/quark/providers"
+)
+
+var (
+	cmdResume = &cobra.Command{
+		Use:   "resume [operation-id]",
+		Short: "Resume a failed create operation, or list the operations that can be resumed",
+		Run:   resume,
+	}
+)
+
+func init() {
+	addKeepOnFailureFlag(cmdResume)
+	cmdMain.AddCommand(cmdResume)
+}
+
+func resume(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		showOperations()
+		return
+	}
+	if len(args) > 1 {
+		Exitf("Too many arguments\n")
+	}
+	op, err := providers.LoadOperation(stateDir, args[0])
+	if providers.IsNotFound(err) {
+		Exitf("Operation %s not found in %s\n", args[0], stateDir)
+	} else if err != nil {
+		Exitf("Failed to load operation: %v\n", err)
+	}
+
+	// Use the providers of the original operation
+	if op.Provider != "" {
+		provider = op.Provider
+	}
+	if op.DnsProvider != "" {
+		dnsProvider = op.DnsProvider
+	}
+	p := newProvider()
+
+	// Confirm
+	if op.Error != "" {
+		Infof("Operation %s failed with: %s\n", op.ID, op.Error)
+	}
+	if err := confirm(fmt.Sprintf("Are you sure you want to resume %s?", op.ID)); err != nil {
+		Exitf("%v\n", err)
+	}
+
+	// Resume
+	ctx, tracker := newContext()
+	ctx = providers.WithOperation(ctx, op)
+	completed, err := resumeOperation(ctx, p, op)
+	if err != nil {
+		failOperation(op, p, tracker, err)
+		Exitf("Failed to resume %s: %v\n", op.ID, err)
+	}
+	if !completed {
+		Infof("Operation %s paused, continue with: %s resume %s\n", op.ID, projectName, op.ID)
+		return
+	}
+	finishOperation(op)
+
+	Infof("Operation %s completed\n", op.ID)
+}
+
+// resumeOperation runs the given (journaled) operation again, skipping all steps it completed before.
+// It returns false when the operation paused before it completed.
+func resumeOperation(ctx context.Context, provider providers.CloudProvider, op *providers.Operation) (bool, error) {
+	switch op.Kind {
+	case providers.OperationCreateCluster:
+		if op.Cluster == nil {
+			return false, maskAny(fmt.Errorf("operation %s has no cluster options", op.ID))
+		}
+		return true, maskAny(createNewCluster(ctx, provider, *op.Cluster))
+	case providers.OperationCreateInstance:
+		if op.Instance == nil {
+			return false, maskAny(fmt.Errorf("operation %s has no instance options", op.ID))
+		}
+		options := *op.Instance
+		instances, err := provider.GetInstances(options.ClusterInfo)
+		if err != nil {
+			return false, maskAny(err)
+		}
+		// The new instance may already exist, it is not part of the existing cluster yet
+		instances = removeFromList(instances, options.InstanceName)
+		if len(instances) == 0 {
+			return false, maskAny(fmt.Errorf("cluster %s does not exist", options.ClusterInfo))
+		}
+		_, err = addInstance(ctx, provider, options, instances)
+		return true, maskAny(err)
+	case providers.OperationUpgradeCluster:
+		if op.Upgrade == nil {
+			return false, maskAny(fmt.Errorf("operation %s has no upgrade options", op.ID))
+		}
+		completed, err := replaceInstances(ctx, provider, *op.Upgrade)
+		return completed, maskAny(err)
+	default:
+		return false, maskAny(fmt.Errorf("unknown operation kind '%s'", op.Kind))
+	}
+}
+
+// showOperations prints all operations that can be resumed.
+func showOperations() {
+	ops, err := providers.ListOperations(stateDir)
+	if err != nil {
+		Exitf("Failed to list operations: %v\n", err)
+	}
+	docs := []operationDocument{}
+	for _, op := range ops {
+		docs = append(docs, newOperationDocument(op))
+	}
+	printOutput(docs, func() []string {
+		lines := []string{"ID | Kind | Target | Started | Error"}
+		for _, d := range docs {
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s | %s", d.ID, d.Kind, d.Target, d.Started, d.Error))
+		}
+		return lines
+	})
+}
+
+// operationDocument is the machine readable representation of a resumable operation.
+type operationDocument struct {
+	ID      string `json:"id" yaml:"id"`
+	Kind    string `json:"kind" yaml:"kind"`
+	Target  string `json:"target" yaml:"target"`
+	Started string `json:"started" yaml:"started"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// newOperationDocument creates an operation document from the given operation.
+func newOperationDocument(op *providers.Operation) operationDocument {
+	target := ""
+	if op.Cluster != nil {
+		target = op.Cluster.ClusterInfo.String()
+	} else if op.Instance != nil {
+		target = op.Instance.InstanceName
+	}
+	return operationDocument{
+		ID:      op.ID,
+		Kind:    op.Kind,
+		Target:  target,
+		Started: op.Started.Format("2006-01-02 15:04:05"),
+		Error:   op.Error,
+	}
+}